@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Key access tracking remembers when every key was last read and last
+// written, so that clean-up jobs can find keys nobody touched for days:
+//
+//	OBJECT LASTACCESS key   -> [last read, last write] as Unix times
+//	OBJECT IDLETIME key     -> seconds since the key was last used
+//
+// OBJECT ENCODING key replies how the value of a key is stored, such as
+// listpack or quicklist for a list.
+//
+// Times are recorded centrally from the key positions of every command, so
+// new commands are tracked without any code of their own. Keys loaded from
+// the AOF or a snapshot count as accessed when the server started.
+
+// keyAccess holds the last read and last write time of a key in Unix
+// milliseconds, 0 when it did not happen since the server started.
+type keyAccess struct {
+	read  int64
+	write int64
+}
+
+// accessTracking enables recording key access times.
+var accessTracking atomic.Bool
+
+// accessTimes maps keys to their access times.
+var accessTimes = map[string]*keyAccess{}
+
+// accessMu guards accessTimes. It is taken after keyspaceMu.
+var accessMu = sync.Mutex{}
+
+func init() {
+	accessTracking.Store(true)
+}
+
+// setAccessTracking enables or disables access tracking. Disabling it
+// frees the recorded times.
+func setAccessTracking(enabled bool) {
+	accessTracking.Store(enabled)
+	if !enabled {
+		accessMu.Lock()
+		accessTimes = map[string]*keyAccess{}
+		accessMu.Unlock()
+	}
+}
+
+// recordAccess records the access of a command that just ran to the keys
+// it names. Writes that removed a key drop its times. Like in Redis, OBJECT
+// itself does not count as an access.
+func recordAccess(command string, args []Value) {
+	if !accessTracking.Load() || command == "OBJECT" {
+		return
+	}
+	keys := commandKeys(command, args)
+	if len(keys) == 0 {
+		return
+	}
+	write := isWriteCommand(command)
+
+	now := nowMs()
+	for _, key := range keys {
+		if !keyExists(key) {
+			if write {
+				forgetAccess(key)
+			}
+			continue
+		}
+
+		accessMu.Lock()
+		a, ok := accessTimes[key]
+		if !ok {
+			a = &keyAccess{}
+			accessTimes[key] = a
+		}
+		if write {
+			a.write = now
+		} else {
+			a.read = now
+		}
+		accessMu.Unlock()
+	}
+}
+
+// forgetAccess drops the access times of a key that left the keyspace.
+func forgetAccess(key string) {
+	accessMu.Lock()
+	delete(accessTimes, key)
+	accessMu.Unlock()
+}
+
+// keyExists reports whether key is in the keyspace.
+func keyExists(key string) bool {
+	keyspaceMu.RLock()
+	_, ok := keyspace[key]
+	keyspaceMu.RUnlock()
+	return ok
+}
+
+// lastAccess returns the last read and last write time of a key in Unix
+// milliseconds. Times not recorded since the start are the start time.
+func lastAccess(key string) (read, write int64) {
+	accessMu.Lock()
+	a, ok := accessTimes[key]
+	if ok {
+		read, write = a.read, a.write
+	}
+	accessMu.Unlock()
+
+	started := startTime.UnixMilli()
+	if read == 0 {
+		read = started
+	}
+	if write == 0 {
+		write = started
+	}
+	return read, write
+}
+
+// objectCommand handles OBJECT ENCODING key, OBJECT IDLETIME key and the
+// OBJECT LASTACCESS key extension.
+func objectCommand(args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'object|" + strings.ToLower(args[0].bulk) + "' command"}
+	}
+	key := args[1].bulk
+
+	switch strings.ToUpper(args[0].bulk) {
+	case "ENCODING":
+		keyspaceMu.RLock()
+		obj, ok := keyspace[key]
+		keyspaceMu.RUnlock()
+		if !ok || expired(key) {
+			return Value{typ: "null"}
+		}
+		return Value{typ: "bulk", bulk: obj.encoding()}
+	case "IDLETIME":
+		if !keyExists(key) {
+			return Value{typ: "null"}
+		}
+		read, write := lastAccess(key)
+		return Value{typ: "integer", num: int((nowMs() - max(read, write)) / 1000)}
+	case "LASTACCESS":
+		if !keyExists(key) {
+			return Value{typ: "null"}
+		}
+		read, write := lastAccess(key)
+		return Value{typ: "array", array: []Value{
+			{typ: "integer", num: int(read / 1000)},
+			{typ: "integer", num: int(write / 1000)},
+		}}
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try OBJECT HELP."}
+	}
+}
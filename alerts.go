@@ -0,0 +1,170 @@
+// Soft limit alerts.
+//
+// Small deployments often run without a monitoring stack. The alert loop
+// checks a few soft limits once per second and, whenever one is crossed or
+// recovers, logs a warning, publishes a JSON message on the
+// __gostore__:alerts pub/sub channel and optionally posts the same message
+// to a webhook, so operators hear about trouble before the hard limits hit.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// alertChannel is the pub/sub channel alerts are published on.
+const alertChannel = "__gostore__:alerts"
+
+// alertInterval is how often the soft limits are checked.
+const alertInterval = time.Second
+
+// alertWebhookTimeout bounds how long a webhook call may take.
+const alertWebhookTimeout = 5 * time.Second
+
+// alertMemoryPercent is the share of maxmemory, in percent, above which the
+// memory alert fires. Zero disables it.
+var alertMemoryPercent atomic.Int64
+
+// alertClientsPercent is the share of maxclients, in percent, above which
+// the connections alert fires. Zero disables it.
+var alertClientsPercent atomic.Int64
+
+// alertAofSize is the AOF size in bytes above which the AOF alert fires.
+// Zero disables it.
+var alertAofSize atomic.Int64
+
+// alertWebhook is the URL alerts are posted to as JSON, if set.
+var alertWebhook string
+
+// alertWebhookMu guards alertWebhook.
+var alertWebhookMu = sync.RWMutex{}
+
+// alert is the message sent for a soft limit that was crossed or recovered.
+type alert struct {
+	// Alert names the soft limit: memory, clients or aof-size
+	Alert string `json:"alert"`
+	// State is "firing" when the limit was crossed and "resolved" when
+	// the value went back under it
+	State string `json:"state"`
+	// Value is the measured value and Threshold the limit it was checked
+	// against, both in the unit of the limit
+	Value     int64 `json:"value"`
+	Threshold int64 `json:"threshold"`
+	// Time is the Unix time of the check
+	Time int64 `json:"time"`
+}
+
+// alertCheck is a single soft limit watched by the alert loop.
+type alertCheck struct {
+	name string
+	// measure returns the current value and the threshold; ok is false
+	// when the alert is disabled or can not be measured
+	measure func() (value, threshold int64, ok bool)
+	// firing is true while the value is above the threshold
+	firing bool
+}
+
+// alertChecks lists the soft limits watched by the alert loop.
+var alertChecks = []*alertCheck{
+	{
+		name: "memory",
+		measure: func() (int64, int64, bool) {
+			max, percent := maxmemory.Load(), alertMemoryPercent.Load()
+			if max == 0 || percent == 0 {
+				return 0, 0, false
+			}
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			return int64(ms.HeapAlloc) * 100 / max, percent, true
+		},
+	},
+	{
+		name: "clients",
+		measure: func() (int64, int64, bool) {
+			percent := alertClientsPercent.Load()
+			if percent == 0 {
+				return 0, 0, false
+			}
+			ClientsMu.RLock()
+			connected := int64(len(Clients))
+			ClientsMu.RUnlock()
+			return connected * 100 / maxclients.Load(), percent, true
+		},
+	},
+	{
+		name: "aof-size",
+		measure: func() (int64, int64, bool) {
+			threshold := alertAofSize.Load()
+			if threshold == 0 || aof == nil {
+				return 0, 0, false
+			}
+			size, err := aof.Size()
+			if err != nil {
+				return 0, 0, false
+			}
+			return size, threshold, true
+		},
+	},
+}
+
+// alertCron checks the soft limits forever.
+func alertCron() {
+	for {
+		time.Sleep(alertInterval)
+
+		for _, check := range alertChecks {
+			value, threshold, ok := check.measure()
+			firing := ok && value > threshold
+			if firing == check.firing {
+				continue
+			}
+			check.firing = firing
+
+			a := alert{Alert: check.name, State: "resolved", Value: value, Threshold: threshold, Time: time.Now().Unix()}
+			if firing {
+				a.State = "firing"
+				serverLog(logWarning, "Alert %s firing: %d is above the threshold of %d", a.Alert, a.Value, a.Threshold)
+			} else {
+				serverLog(logNotice, "Alert %s resolved", a.Alert)
+			}
+			raiseAlert(a)
+		}
+	}
+}
+
+// raiseAlert publishes a on the alert channel and posts it to the webhook.
+func raiseAlert(a alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		serverLog(logWarning, "Unable to encode alert: %v", err)
+		return
+	}
+
+	publishMessage(alertChannel, string(body), false)
+
+	alertWebhookMu.RLock()
+	url := alertWebhook
+	alertWebhookMu.RUnlock()
+	if url != "" {
+		go postAlert(url, body)
+	}
+}
+
+// postAlert sends an alert to the webhook.
+func postAlert(url string, body []byte) {
+	client := http.Client{Timeout: alertWebhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		serverLog(logWarning, "Alert webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		serverLog(logWarning, "Alert webhook returned %s", resp.Status)
+	}
+}
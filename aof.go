@@ -23,9 +23,34 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// fsync policies for the AOF, selected with the appendfsync setting
+const (
+	// fsyncAlways flushes the AOF to disk after every write
+	fsyncAlways = iota
+	// fsyncEverySec flushes the AOF to disk once per second
+	fsyncEverySec
+	// fsyncNo never flushes explicitly and leaves it to the OS
+	fsyncNo
+)
+
+// appendonly enables the AOF. When it is off writes are only persisted by
+// snapshots and the snapshot file is loaded at startup instead.
+var appendonly = true
+
+// appendfilename is the name of the AOF.
+var appendfilename = "database.aof"
+
+// appendfsync is the active fsync policy, one of the fsync constants.
+var appendfsync atomic.Int32
+
+func init() {
+	appendfsync.Store(fsyncEverySec)
+}
+
 // creates a struct to manage an Aof file
 type Aof struct {
 	file *os.File
@@ -38,8 +63,10 @@ type Aof struct {
 // It takes a file path as input and returns a pointer to the Aof struct and an error.
 func NewAof(path string) (*Aof, error) {
 	// Open or create a file at the specified path with read-write permissions (0666).
-	// If the file does not exist, it will be created
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	// If the file does not exist, it will be created. Writes always go to the
+	// end, even when another process, like the new one of a hot restart,
+	// appends to the same file
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -50,12 +77,15 @@ func NewAof(path string) (*Aof, error) {
 		rd: bufio.NewReader(f),
 	}
 
-	// Start a goroutine to sync AOF to disk every 1 second
+	// Start a goroutine to sync AOF to disk every 1 second when the
+	// everysec policy is selected
 	go func() {
 		for {
 			aof.mu.Lock()
 
-			aof.file.Sync()
+			if appendfsync.Load() == fsyncEverySec {
+				aof.file.Sync()
+			}
 
 			aof.mu.Unlock()
 
@@ -85,9 +115,34 @@ func (aof *Aof) Write(value Value) error {
 		return err
 	}
 
+	// with the always policy the write only counts once it is on disk
+	if appendfsync.Load() == fsyncAlways {
+		return aof.file.Sync()
+	}
+
 	return nil
 }
 
+// Sync flushes the AOF to disk regardless of the fsync policy.
+func (aof *Aof) Sync() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	return aof.file.Sync()
+}
+
+// Size returns the current size of the AOF in bytes.
+func (aof *Aof) Size() (int64, error) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	st, err := aof.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
 // Read reads commands from the AOF file, parses them, and invokes the provided
 // function for each command value. It ensures thread-safe access to the AOF file.
 func (aof *Aof) Read(fn func(value Value)) error {
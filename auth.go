@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/subtle"
+	"sync"
+)
+
+// requirepass is the password clients must send with AUTH before running
+// any other command. An empty password disables authentication.
+var requirepass string
+
+// requirepassMu guards requirepass.
+var requirepassMu = sync.RWMutex{}
+
+// getRequirepass returns the configured password.
+func getRequirepass() string {
+	requirepassMu.RLock()
+	defer requirepassMu.RUnlock()
+
+	return requirepass
+}
+
+// setRequirepass changes the configured password. Clients that already
+// authenticated stay authenticated, like in Redis, and so do the clients
+// that connected while no password was set, which newClient marks as
+// authenticated.
+func setRequirepass(s string) {
+	requirepassMu.Lock()
+	requirepass = s
+	requirepassMu.Unlock()
+}
+
+// passwordMatches compares password with pass in constant time, so that
+// the time AUTH takes tells nothing about how much of a guess was right.
+func passwordMatches(password, pass string) bool {
+	return subtle.ConstantTimeCompare([]byte(password), []byte(pass)) == 1
+}
+
+// authorized reports whether c may run commands other than AUTH.
+func (c *Client) authorized() bool {
+	return c.authenticated || getRequirepass() == ""
+}
+
+// auth handles AUTH [username] password. Only the "default" user exists, its
+// password is requirepass.
+func auth(c *Client, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'auth' command"}
+	}
+
+	password := args[len(args)-1].bulk
+	pass := getRequirepass()
+	if pass == "" {
+		return Value{typ: "error", str: "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"}
+	}
+	if (len(args) == 2 && args[0].bulk != "default") || !passwordMatches(password, pass) {
+		return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
+	}
+
+	c.authenticated = true
+	return Value{typ: "string", str: "OK"}
+}
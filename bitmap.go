@@ -0,0 +1,592 @@
+// Bitmaps.
+//
+// A bitmap is not a type of its own but a string addressed bit by bit,
+// which packs one flag per user id or per day into a single compact value:
+//
+//	SETBIT key offset 0|1             GETBIT key offset
+//	BITCOUNT key [start end [BYTE|BIT]]
+//	BITPOS key 0|1 [start [end [BYTE|BIT]]]
+//	BITOP AND|OR|XOR|NOT destination key [key ...]
+//	BITFIELD key [GET type offset] [SET type offset value]
+//	    [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+//
+// Bit 0 is the most significant bit of the first byte, like in Redis, so a
+// bitmap and the string GET returns for it agree with any Redis client.
+// SETBIT grows the string with zero bytes up to the byte holding the
+// offset, which must be below 2^32, replies the previous bit and keeps the
+// expiry time of the key. Since strings are stored immutable, SETBIT
+// copies the value; bitmaps of a few megabytes are fine, for bigger ones
+// split the offsets over several keys. Missing keys read as empty strings,
+// all bits 0.
+//
+// BITCOUNT counts the set bits of the whole string, or of the bytes from
+// start to end inclusive, or of the bits with BIT. Negative positions count
+// from the end, -1 being the last byte or bit. BITPOS takes the same range
+// and replies the position of the first bit set to 1 or 0, or -1. Without
+// an end the string counts as followed by zeros, so the first clear bit of
+// a string of ones is the one just past it.
+//
+// BITOP combines the bitmaps of its keys byte by byte and stores the result
+// at destination, replacing whatever it held, for instance to find the
+// users active on every day of a week. Shorter strings and missing keys
+// count as padded with zero bytes up to the longest one; NOT inverts a
+// single key. BITOP replies the length of the result, deleting destination
+// when it is empty.
+//
+// BITFIELD packs integers of any width into a string, i1 to i64 for signed
+// and u1 to u63 for unsigned ones, at any bit offset or, written "#n", at
+// the n-th field of their width. It runs its operations in order and
+// replies one result for each: GET the value, SET the previous value and
+// INCRBY the new one. OVERFLOW sets how the SET and INCRBY after it handle
+// values out of range: WRAP wraps around (the default), SAT saturates at
+// the smallest or largest value and FAIL leaves the field alone and replies
+// null.
+package main
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// bitmapMaxOffset bounds SETBIT offsets to 512 MB strings, like in Redis.
+const bitmapMaxOffset = 1<<32 - 1
+
+// parseBitOffset parses the offset of SETBIT and GETBIT.
+func parseBitOffset(arg string) (int64, Value, bool) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset > bitmapMaxOffset {
+		return 0, Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}, false
+	}
+	return offset, Value{}, true
+}
+
+// readString calls read with the string stored at key, empty when the key
+// does not exist, while holding keyspaceMu for reading. It replies
+// WRONGTYPE instead when the key holds another type. The string must not
+// be kept after read returns, it may live in slab memory.
+func readString(key string, read func(value string) Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != stringObject {
+		return wrongTypeError
+	}
+	return read(obj.str)
+}
+
+// bitAt returns bit offset of a bitmap, 0 past its end.
+func bitAt(value string, offset int64) int {
+	if offset/8 >= int64(len(value)) {
+		return 0
+	}
+	return int(value[offset/8]>>(7-offset%8)) & 1
+}
+
+// setbit handles SETBIT key offset 0|1, replying the previous bit.
+func setbit(args []Value) Value {
+	key := args[0].bulk
+	offset, errReply, ok := parseBitOffset(args[1].bulk)
+	if !ok {
+		return errReply
+	}
+	bit := args[2].bulk
+	if bit != "0" && bit != "1" {
+		return Value{typ: "error", str: "ERR bit is not an integer or out of range"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != stringObject {
+		return wrongTypeError
+	}
+	old := bitAt(obj.str, offset)
+
+	value := []byte(obj.str)
+	if size := int(offset/8) + 1; size > len(value) {
+		value = append(value, make([]byte, size-len(value))...)
+	}
+	mask := byte(0x80) >> (offset % 8)
+	if bit == "1" {
+		value[offset/8] |= mask
+	} else {
+		value[offset/8] &^= mask
+	}
+
+	// the expiry time is kept, only the value is replaced
+	if exists {
+		dropValue(obj.str)
+	}
+	setKey(key, object{typ: stringObject, str: storeValue(string(value))})
+	markKeyspaceChanged()
+	return Value{typ: "integer", num: old}
+}
+
+// getbit handles GETBIT key offset.
+func getbit(args []Value) Value {
+	offset, errReply, ok := parseBitOffset(args[1].bulk)
+	if !ok {
+		return errReply
+	}
+	return readString(args[0].bulk, func(value string) Value {
+		return Value{typ: "integer", num: bitAt(value, offset)}
+	})
+}
+
+// parseBitRange parses the optional start end [BYTE|BIT] arguments of
+// BITCOUNT and BITPOS, reporting whether the positions count bits.
+func parseBitRange(args []Value) (start, end int64, inBits bool, errReply Value, ok bool) {
+	start, err := strconv.ParseInt(args[0].bulk, 10, 64)
+	if err != nil {
+		return 0, 0, false, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+	}
+	end, err = strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return 0, 0, false, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+	}
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2].bulk) {
+		case "BYTE":
+		case "BIT":
+			inBits = true
+		default:
+			return 0, 0, false, Value{typ: "error", str: "ERR syntax error"}, false
+		}
+	}
+	return start, end, inBits, Value{}, true
+}
+
+// bitSpan turns a range of byte or bit positions of a string of size
+// bytes into the positions of its first and last bit, false when it
+// selects nothing. Like list indexes, negative positions count from the
+// end and positions past either end are clamped.
+func bitSpan(start, end int64, inBits bool, size int) (int64, int64, bool) {
+	total := int64(size)
+	if inBits {
+		total *= 8
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if !inBits {
+		start, end = start*8, end*8+7
+	}
+	return start, end, true
+}
+
+// countBits returns the number of set bits of value from bit first to
+// bit last, inclusive.
+func countBits(value string, first, last int64) int {
+	count := 0
+	for i := first / 8; i <= last/8; i++ {
+		count += bits.OnesCount8(value[i])
+	}
+	// leave out the bits of the first and last bytes outside the span
+	count -= bits.OnesCount8(value[first/8] >> (8 - first%8))
+	count -= bits.OnesCount8(value[last/8] << (last%8 + 1))
+	return count
+}
+
+// bitcount handles BITCOUNT key [start end [BYTE|BIT]], replying the
+// number of set bits.
+func bitcount(args []Value) Value {
+	var start, end int64 = 0, -1
+	inBits := false
+	switch len(args) {
+	case 1:
+	case 3, 4:
+		var errReply Value
+		var ok bool
+		start, end, inBits, errReply, ok = parseBitRange(args[1:])
+		if !ok {
+			return errReply
+		}
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	return readString(args[0].bulk, func(value string) Value {
+		first, last, ok := bitSpan(start, end, inBits, len(value))
+		if !ok {
+			return Value{typ: "integer", num: 0}
+		}
+		return Value{typ: "integer", num: countBits(value, first, last)}
+	})
+}
+
+// firstBit returns the position of the first bit of value equal to bit,
+// from bit first to bit last inclusive, or -1 when there is none.
+func firstBit(value string, first, last int64, bit int) int64 {
+	for i := first / 8; i <= last/8; i++ {
+		b := value[i]
+		if bit == 0 {
+			b = ^b
+		}
+		// leave out the bits of the first and last bytes outside the span
+		if i == first/8 {
+			b &= 0xff >> (first % 8)
+		}
+		if i == last/8 {
+			b &= 0xff << (7 - last%8)
+		}
+		if b != 0 {
+			return i*8 + int64(bits.LeadingZeros8(b))
+		}
+	}
+	return -1
+}
+
+// bitpos handles BITPOS key 0|1 [start [end [BYTE|BIT]]], replying the
+// position of the first bit set to the given value, or -1.
+func bitpos(args []Value) Value {
+	var bit int
+	switch args[1].bulk {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return Value{typ: "error", str: "ERR The bit argument must be 1 or 0."}
+	}
+	var start, end int64 = 0, -1
+	inBits := false
+	switch len(args) {
+	case 2:
+	case 3:
+		var err error
+		start, err = strconv.ParseInt(args[2].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+	case 4, 5:
+		var errReply Value
+		var ok bool
+		start, end, inBits, errReply, ok = parseBitRange(args[2:])
+		if !ok {
+			return errReply
+		}
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	endGiven := len(args) > 3
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[args[0].bulk]
+	if !ok {
+		// a missing key is an endless run of clear bits
+		if bit == 1 {
+			return Value{typ: "integer", num: -1}
+		}
+		return Value{typ: "integer", num: 0}
+	}
+	if obj.typ != stringObject {
+		return wrongTypeError
+	}
+	first, last, ok := bitSpan(start, end, inBits, len(obj.str))
+	if !ok {
+		return Value{typ: "integer", num: -1}
+	}
+	position := firstBit(obj.str, first, last, bit)
+	if position < 0 && bit == 0 && !endGiven {
+		// the string is followed by zeros
+		position = last + 1
+	}
+	return Value{typ: "integer", num: int(position)}
+}
+
+// bitop handles BITOP AND|OR|XOR|NOT destination key [key ...], replying
+// the length of the string stored at destination.
+func bitop(args []Value) Value {
+	op := strings.ToUpper(args[0].bulk)
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return Value{typ: "error", str: "ERR BITOP NOT must be called with a single source key."}
+		}
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	destination := args[1].bulk
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	sources := make([]string, 0, len(args)-2)
+	size := 0
+	for _, arg := range args[2:] {
+		obj, ok := keyspace[arg.bulk]
+		if ok && obj.typ != stringObject {
+			return wrongTypeError
+		}
+		sources = append(sources, obj.str)
+		size = max(size, len(obj.str))
+	}
+
+	result := make([]byte, size)
+	for i := range result {
+		// bytes past the end of a shorter string are zero
+		byteAt := func(s string) byte {
+			if i < len(s) {
+				return s[i]
+			}
+			return 0
+		}
+		b := byteAt(sources[0])
+		for _, s := range sources[1:] {
+			switch op {
+			case "AND":
+				b &= byteAt(s)
+			case "OR":
+				b |= byteAt(s)
+			case "XOR":
+				b ^= byteAt(s)
+			}
+		}
+		if op == "NOT" {
+			b = ^b
+		}
+		result[i] = b
+	}
+
+	// the destination may be one of the sources, so it is only replaced
+	// once the result is computed
+	deleteKey(destination)
+	if size > 0 {
+		setKey(destination, object{typ: stringObject, str: storeValue(string(result))})
+		markKeyspaceChanged()
+	}
+	return Value{typ: "integer", num: size}
+}
+
+// fieldType is the type of a BITFIELD integer, i1 to i64 or u1 to u63.
+type fieldType struct {
+	signed bool
+	width  int64
+}
+
+// parseFieldType parses a BITFIELD type.
+func parseFieldType(arg string) (fieldType, bool) {
+	if len(arg) < 2 {
+		return fieldType{}, false
+	}
+	var t fieldType
+	switch arg[0] {
+	case 'i', 'I':
+		t.signed = true
+	case 'u', 'U':
+	default:
+		return fieldType{}, false
+	}
+	width, err := strconv.ParseInt(arg[1:], 10, 64)
+	if err != nil || width < 1 || width > 64 || width == 64 && !t.signed {
+		return fieldType{}, false
+	}
+	t.width = width
+	return t, true
+}
+
+// bounds returns the smallest and largest value of the type.
+func (t fieldType) bounds() (int64, int64) {
+	if t.signed {
+		return -1 << (t.width - 1), 1<<(t.width-1) - 1
+	}
+	return 0, 1<<t.width - 1
+}
+
+// wrap keeps the low bits of n that fit the type, sign extended for
+// signed types.
+func (t fieldType) wrap(n int64) int64 {
+	shift := 64 - t.width
+	if t.signed {
+		return n << shift >> shift
+	}
+	return int64(uint64(n) << shift >> shift)
+}
+
+// add returns value + incr for a field of the type, handling results out
+// of its range with the OVERFLOW policy: WRAP wraps around, SAT saturates
+// to the smallest or largest value and FAIL reports false.
+func (t fieldType) add(value, incr int64, policy string) (int64, bool) {
+	lo, hi := t.bounds()
+	high := value > hi || incr > 0 && value > hi-incr
+	// lo-incr itself overflows when incr is very negative
+	low := value < lo || incr < 0 && (lo-incr < lo || value < lo-incr)
+	if !t.signed && value < 0 {
+		// like in Redis, a negative SET of an unsigned field is read as a
+		// huge unsigned number
+		high, low = true, false
+	}
+	switch {
+	case !high && !low:
+		return value + incr, true
+	case policy == "FAIL":
+		return 0, false
+	case policy == "SAT" && high:
+		return hi, true
+	case policy == "SAT":
+		return lo, true
+	}
+	return t.wrap(value + incr), true
+}
+
+// getField reads a field of the type at bit offset, bits past the end of
+// value being 0.
+func getField(value []byte, offset int64, t fieldType) int64 {
+	var n uint64
+	for bit := offset; bit < offset+t.width; bit++ {
+		n <<= 1
+		if bit/8 < int64(len(value)) {
+			n |= uint64(value[bit/8]>>(7-bit%8)) & 1
+		}
+	}
+	return t.wrap(int64(n))
+}
+
+// setField writes n as a field of the type at bit offset of value, which
+// must be long enough to hold it.
+func setField(value []byte, offset int64, t fieldType, n int64) {
+	for i := int64(0); i < t.width; i++ {
+		bit := offset + i
+		mask := byte(0x80) >> (bit % 8)
+		if uint64(n)>>(t.width-1-i)&1 == 1 {
+			value[bit/8] |= mask
+		} else {
+			value[bit/8] &^= mask
+		}
+	}
+}
+
+// fieldOp is a GET, SET or INCRBY operation of BITFIELD.
+type fieldOp struct {
+	op     string
+	typ    fieldType
+	offset int64
+	// arg is the value of SET or the increment of INCRBY
+	arg int64
+	// overflow is the OVERFLOW policy in effect for SET and INCRBY
+	overflow string
+}
+
+// parseFieldOps parses the operations of BITFIELD.
+func parseFieldOps(args []Value) ([]fieldOp, Value, bool) {
+	ops := []fieldOp{}
+	overflow := "WRAP"
+	for i := 0; i < len(args); {
+		op := strings.ToUpper(args[i].bulk)
+		if op == "OVERFLOW" && i+1 < len(args) {
+			overflow = strings.ToUpper(args[i+1].bulk)
+			if overflow != "WRAP" && overflow != "SAT" && overflow != "FAIL" {
+				return nil, Value{typ: "error", str: "ERR Invalid OVERFLOW type specified"}, false
+			}
+			i += 2
+			continue
+		}
+		argc := map[string]int{"GET": 2, "SET": 3, "INCRBY": 3}[op]
+		if argc == 0 || i+argc >= len(args) {
+			return nil, Value{typ: "error", str: "ERR syntax error"}, false
+		}
+
+		typ, ok := parseFieldType(args[i+1].bulk)
+		if !ok {
+			return nil, Value{typ: "error", str: "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."}, false
+		}
+		// "#n" addresses the n-th field of the type
+		offsetArg, scaled := strings.CutPrefix(args[i+2].bulk, "#")
+		offset, err := strconv.ParseInt(offsetArg, 10, 64)
+		if scaled && err == nil && offset >= 0 && offset <= bitmapMaxOffset {
+			offset *= typ.width
+		}
+		if err != nil || offset < 0 || offset > bitmapMaxOffset {
+			return nil, Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}, false
+		}
+		f := fieldOp{op: op, typ: typ, offset: offset, overflow: overflow}
+		if argc == 3 {
+			f.arg, err = strconv.ParseInt(args[i+3].bulk, 10, 64)
+			if err != nil {
+				return nil, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+			}
+		}
+		ops = append(ops, f)
+		i += argc + 1
+	}
+	return ops, Value{}, true
+}
+
+// bitfield handles BITFIELD key [GET type offset] [SET type offset value]
+// [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ..., replying
+// the result of every GET, SET and INCRBY in order.
+func bitfield(args []Value) Value {
+	key := args[0].bulk
+	ops, errReply, ok := parseFieldOps(args[1:])
+	if !ok {
+		return errReply
+	}
+	// the string grows up to the last byte written, even when an
+	// overflow makes the write fail, like in Redis
+	size := int64(0)
+	for _, f := range ops {
+		if f.op != "GET" {
+			size = max(size, (f.offset+f.typ.width-1)/8+1)
+		}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != stringObject {
+		return wrongTypeError
+	}
+
+	value := []byte(obj.str)
+	if size > int64(len(value)) {
+		value = append(value, make([]byte, size-int64(len(value)))...)
+	}
+	replies := []Value{}
+	for _, f := range ops {
+		old := getField(value, f.offset, f.typ)
+		switch f.op {
+		case "GET":
+			replies = append(replies, Value{typ: "integer", num: int(old)})
+		case "SET":
+			n, ok := f.typ.add(f.arg, 0, f.overflow)
+			if !ok {
+				replies = append(replies, Value{typ: "null"})
+				continue
+			}
+			setField(value, f.offset, f.typ, n)
+			replies = append(replies, Value{typ: "integer", num: int(old)})
+		case "INCRBY":
+			n, ok := f.typ.add(old, f.arg, f.overflow)
+			if !ok {
+				replies = append(replies, Value{typ: "null"})
+				continue
+			}
+			setField(value, f.offset, f.typ, n)
+			replies = append(replies, Value{typ: "integer", num: int(n)})
+		}
+	}
+
+	// a BITFIELD of GETs only leaves the key alone
+	if size == 0 {
+		return Value{typ: "array", array: replies}
+	}
+	if exists {
+		dropValue(obj.str)
+	}
+	setKey(key, object{typ: stringObject, str: storeValue(string(value))})
+	markKeyspaceChanged()
+	return Value{typ: "array", array: replies}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Blocking list commands wait for an element when every list they are
+// given is empty:
+//
+//	BLPOP key [key ...] timeout
+//	BRPOP key [key ...] timeout
+//	BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+//	BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+//
+// The timeout is in seconds and may be fractional, 0 waits forever. A
+// client that has to wait joins the wait queue of every key it named and
+// parks its connection. The command that pushes to one of those lists then
+// serves the waiters itself, in the order they blocked, popping an element
+// for each while it still holds the keyspace lock, so an element pushed
+// for a blocked client can not be taken by anybody else in the meantime.
+// A client that times out gets a null array, or null for BLMOVE.
+//
+// While a client waits its connection is watched, so a worker that goes
+// away is dropped from the queues instead of being handed an element that
+// would be lost. Blocking pops are logged to the AOF as the LPOP, RPOP or
+// LMOVE they turned into.
+
+// listWaiter is a client blocked on one or more lists.
+type listWaiter struct {
+	// keys are the lists the client waits on
+	keys []string
+	// serve takes what the client waits for from key, which holds a
+	// non-empty list, and returns its reply. It is called once, with
+	// keyspaceMu held for writing
+	serve func(key string) Value
+	// reply receives the reply once the client was served
+	reply chan Value
+}
+
+// listWaiters maps list keys to the clients waiting on them, in the order
+// they blocked. It is guarded by keyspaceMu, which is what makes checking
+// for an element and joining the queue atomic with respect to pushes.
+var listWaiters = map[string][]*listWaiter{}
+
+// requeueReply is returned by a blocking command woken by a hot restart
+// after leaving its wait queues. Nothing is written for it: the command
+// runs again once the hot restart is over, in the new process or in this
+// one if it failed, for what is left of its timeout.
+var requeueReply = Value{typ: "requeue"}
+
+// blockedClients is the number of clients waiting in a blocking command,
+// reported by INFO clients.
+var blockedClients atomic.Int64
+
+// parseBlockTimeout parses the timeout of a blocking command, in seconds.
+func parseBlockTimeout(arg string) (time.Duration, Value, bool) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, Value{typ: "error", str: "ERR timeout is not a float or out of range"}, false
+	}
+	if seconds < 0 {
+		return 0, Value{typ: "error", str: "ERR timeout is negative"}, false
+	}
+	return time.Duration(seconds * float64(time.Second)), Value{}, true
+}
+
+// blockOnLists serves the first of keys holding a non-empty list with
+// serve, or waits up to timeout, 0 meaning forever, for an element to be
+// pushed to one of them. It replies timeoutReply when nothing arrived in
+// time and WRONGTYPE when a key is checked that holds another type.
+func blockOnLists(c *Client, keys []string, timeout time.Duration, serve func(key string) Value, timeoutReply Value) Value {
+	keyspaceMu.Lock()
+	for _, key := range keys {
+		obj, ok := keyspace[key]
+		if !ok {
+			continue
+		}
+		if obj.typ != listObject {
+			keyspaceMu.Unlock()
+			return wrongTypeError
+		}
+		reply := serve(key)
+		keyspaceMu.Unlock()
+		return reply
+	}
+	// inside a transaction there is nothing to wait for
+	if !c.mayBlock() {
+		keyspaceMu.Unlock()
+		return timeoutReply
+	}
+
+	w := &listWaiter{keys: keys, serve: serve, reply: make(chan Value, 1)}
+	for _, key := range keys {
+		listWaiters[key] = append(listWaiters[key], w)
+	}
+	keyspaceMu.Unlock()
+
+	return awaitReply(c, timeout, w.reply, func() { removeListWaiter(w) }, timeoutReply)
+}
+
+// awaitReply parks the connection of a client that joined wait queues
+// until its reply arrives on reply, timeout passes, 0 meaning never, or
+// the client goes away. In the last two cases leave is called with
+// keyspaceMu held for writing to take the client out of the queues, and
+// timeoutReply is replied. A hot restart wakes the client the same way,
+// replying requeueReply instead.
+func awaitReply(c *Client, timeout time.Duration, reply chan Value, leave func(), timeoutReply Value) Value {
+	// a command run again after a hot restart only waits for what was
+	// left of its timeout
+	if c.retry != nil && !c.retry.IsZero() {
+		timeout = max(time.Until(*c.retry), time.Nanosecond)
+	}
+	start := time.Now()
+	interrupt := handoffInterrupt()
+
+	// a waiting client does not hold back the EXEC of other clients, which
+	// may well be what serves it
+	c.gate.leave()
+	defer c.gate.enter()
+	c.setBlocked(true)
+	defer c.setBlocked(false)
+	closed, stopWatch := c.watchClose()
+	defer stopWatch()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	interrupted := false
+	select {
+	case v := <-reply:
+		return v
+	case <-expired:
+	case <-closed:
+	case <-interrupt:
+		interrupted = true
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+	// a write may have served the client just before it gave up
+	select {
+	case v := <-reply:
+		return v
+	default:
+	}
+	leave()
+	if interrupted {
+		deadline := time.Time{}
+		if timeout > 0 {
+			deadline = start.Add(timeout)
+		}
+		c.retry = &deadline
+		return requeueReply
+	}
+	return timeoutReply
+}
+
+// removeListWaiter takes a client out of the wait queues of all its keys.
+// keyspaceMu must be held for writing.
+func removeListWaiter(w *listWaiter) {
+	for _, key := range w.keys {
+		queue := listWaiters[key]
+		kept := queue[:0]
+		for _, other := range queue {
+			if other != w {
+				kept = append(kept, other)
+			}
+		}
+		if len(kept) == 0 {
+			delete(listWaiters, key)
+		} else {
+			listWaiters[key] = kept
+		}
+	}
+}
+
+// serveListWaiters hands elements of the list at key to the clients
+// waiting on it, oldest first, until the list or the queue runs out. Every
+// command that pushes to a list calls it, with keyspaceMu held for writing.
+func serveListWaiters(key string) {
+	for len(listWaiters[key]) > 0 && keyspace[key].list().len() > 0 {
+		w := listWaiters[key][0]
+		removeListWaiter(w)
+		w.reply <- w.serve(key)
+	}
+}
+
+// setBlocked marks the client as waiting in a blocking command or not.
+func (c *Client) setBlocked(blocked bool) {
+	c.infoMu.Lock()
+	c.blocked = blocked
+	c.infoMu.Unlock()
+
+	if blocked {
+		blockedClients.Add(1)
+	} else {
+		blockedClients.Add(-1)
+	}
+}
+
+// watchClose watches the connection of a blocked client, returning a
+// channel closed when the client disconnects and a function that ends the
+// watch, which must be called before the connection is read again. Data
+// sent by the client while it is blocked ends the watch early, it is then
+// read as the next command once the blocking command returned.
+func (c *Client) watchClose() (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	if c.reader == nil {
+		return closed, func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.reader.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			close(closed)
+		}
+	}()
+
+	return closed, func() {
+		// interrupt the read, then clear the deadline for the next one
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// blockingPop returns the handler of BLPOP, or BRPOP when front is false,
+// replying the name of the list an element was popped from and the
+// element.
+func blockingPop(front bool) func(*Client, []Value) Value {
+	return func(c *Client, args []Value) Value {
+		timeout, errReply, ok := parseBlockTimeout(args[len(args)-1].bulk)
+		if !ok {
+			return errReply
+		}
+		keys := []string{}
+		for _, arg := range args[:len(args)-1] {
+			keys = append(keys, arg.bulk)
+		}
+
+		pop := func(key string) Value {
+			elements := keyspace[key].list()
+			var element string
+			if front {
+				element = elements.popFront()
+			} else {
+				element = elements.popBack()
+			}
+			dropIfEmpty(key)
+			return Value{typ: "array", array: []Value{{typ: "bulk", bulk: key}, {typ: "bulk", bulk: element}}}
+		}
+		return blockOnLists(c, keys, timeout, pop, Value{typ: "nullarray"})
+	}
+}
+
+// blmove handles BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout,
+// the blocking form of LMOVE.
+func blmove(c *Client, args []Value) Value {
+	fromFront, ok := parseListEnd(args[2].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	toFront, ok := parseListEnd(args[3].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	timeout, errReply, ok := parseBlockTimeout(args[4].bulk)
+	if !ok {
+		return errReply
+	}
+	destination := args[1].bulk
+
+	move := func(source string) Value {
+		return moveListElement(source, destination, fromFront, toFront)
+	}
+	return blockOnLists(c, []string{args[0].bulk}, timeout, move, Value{typ: "null"})
+}
+
+// blmpop handles BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT
+// count], the blocking form of LMPOP.
+func blmpop(c *Client, args []Value) Value {
+	timeout, errReply, ok := parseBlockTimeout(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	keys, front, count, errReply, ok := parseMpopArgs(args[1:])
+	if !ok {
+		return errReply
+	}
+
+	pop := func(key string) Value {
+		return popMany(key, front, count)
+	}
+	return blockOnLists(c, keys, timeout, pop, Value{typ: "nullarray"})
+}
+
+// blockingPopPropagate persists BLPOP and BRPOP as the LPOP or RPOP of the
+// list an element was taken from. Timeouts change nothing and are not
+// logged.
+func blockingPopPropagate(value Value, result Value) Value {
+	if result.typ != "array" {
+		return Value{}
+	}
+	pop := "LPOP"
+	if strings.EqualFold(value.array[0].bulk, "BRPOP") {
+		pop = "RPOP"
+	}
+	return commandValue(pop, result.array[0].bulk)
+}
+
+// blmovePropagate persists BLMOVE as LMOVE when an element was moved.
+func blmovePropagate(value Value, result Value) Value {
+	if result.typ != "bulk" {
+		return Value{}
+	}
+	args := value.array
+	return commandValue("LMOVE", args[1].bulk, args[2].bulk, args[3].bulk, args[4].bulk)
+}
+
+// blmpopPropagate persists BLMPOP as the LPOP or RPOP, with a count, of
+// the elements it took.
+func blmpopPropagate(value Value, result Value) Value {
+	if result.typ != "array" {
+		return Value{}
+	}
+	pop := "RPOP"
+	if _, front, _, _, ok := parseMpopArgs(value.array[2:]); ok && front {
+		pop = "LPOP"
+	}
+	return commandValue(pop, result.array[0].bulk, strconv.Itoa(len(result.array[1].array)))
+}
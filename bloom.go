@@ -0,0 +1,529 @@
+// Bloom filters.
+//
+// A Bloom filter answers "was this item added before?" in a fixed number
+// of bits per item, at the price of sometimes answering yes for an item
+// that never was, like the filters of the RedisBloom module:
+//
+//	BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+//	BF.ADD key item
+//	BF.MADD key item [item ...]
+//	BF.EXISTS key item
+//	BF.MEXISTS key item [item ...]
+//	BF.INFO key [CAPACITY|SIZE|FILTERS|ITEMS|EXPANSION]
+//	BF.SCANDUMP key iterator
+//	BF.LOADCHUNK key iterator data
+//
+// BF.RESERVE creates a filter meant for capacity items with a false
+// positive rate of error_rate, BF.ADD creates one with a rate of 0.01 and a
+// capacity of 100. A filter that reached its capacity is scalable: it adds
+// a layer expansion times larger, 2 by default, with half the error rate
+// of the previous one, so that the overall rate stays below error_rate. An
+// item is looked up in every layer and added to the newest one. Adding to a
+// full NONSCALING filter fails instead.
+//
+// Every layer hashes an item twice with FNV and derives the positions of
+// its bits from the two hashes, so adding the same items always sets the
+// same bits and the AOF, which logs BF.ADD as it is, replays exactly.
+// Snapshots can not replay the items, which the filter does not keep, so
+// they write the filter with BF.LOADCHUNK, in the chunks BF.SCANDUMP
+// replies: a header describing the layers followed by the bits of every
+// layer. The chunks are in a format of this server and can not be loaded
+// into Redis. TYPE names the key MBbloom--.
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Defaults of the filters created by BF.ADD and BF.MADD, the bf-error-rate
+// and bf-initial-size of RedisBloom, and of the expansion.
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+)
+
+// bloomMaxBits is the most bits a layer may have, 4 GiB.
+const bloomMaxBits = 1 << 35
+
+// bloomLayer is one fixed-size Bloom filter of a scalable filter.
+type bloomLayer struct {
+	// capacity is the number of items the layer is meant for and items
+	// the number added to it
+	capacity, items int64
+	errorRate       float64
+	hashes          int
+	nbits           uint64
+	bits            []byte
+}
+
+// newBloomLayer returns an empty layer for capacity items with the given
+// false positive rate, false when it would be too large.
+func newBloomLayer(capacity int64, errorRate float64) (*bloomLayer, bool) {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	nbits := math.Ceil(float64(capacity) * bitsPerItem)
+	if nbits > bloomMaxBits {
+		return nil, false
+	}
+	l := &bloomLayer{
+		capacity:  capacity,
+		errorRate: errorRate,
+		hashes:    max(int(math.Ceil(math.Ln2*bitsPerItem)), 1),
+		nbits:     max(uint64(nbits), 1),
+	}
+	l.bits = make([]byte, (l.nbits+7)/8)
+	return l, true
+}
+
+// bloomHashes returns the two hashes the positions of the bits of item are
+// derived from.
+func bloomHashes(item string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(item))
+	h2 := fnv.New64()
+	h2.Write([]byte(item))
+	// an odd step never cycles back to the first bit early
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+// has reports whether all the bits of the item with hashes a and b are
+// set.
+func (l *bloomLayer) has(a, b uint64) bool {
+	for i := uint64(0); i < uint64(l.hashes); i++ {
+		bit := (a + i*b) % l.nbits
+		if l.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets the bits of the item with hashes a and b.
+func (l *bloomLayer) add(a, b uint64) {
+	for i := uint64(0); i < uint64(l.hashes); i++ {
+		bit := (a + i*b) % l.nbits
+		l.bits[bit/8] |= 1 << (bit % 8)
+	}
+	l.items++
+}
+
+// bloomFilter is a scalable Bloom filter, made of layers of growing
+// capacity.
+type bloomFilter struct {
+	// expansion is how many times larger every new layer is, 0 for a
+	// filter that does not scale
+	expansion int64
+	layers    []*bloomLayer
+}
+
+// newBloomFilter returns an empty filter, false when its first layer would
+// be too large.
+func newBloomFilter(errorRate float64, capacity, expansion int64) (*bloomFilter, bool) {
+	l, ok := newBloomLayer(capacity, errorRate)
+	if !ok {
+		return nil, false
+	}
+	return &bloomFilter{expansion: expansion, layers: []*bloomLayer{l}}, true
+}
+
+// has reports whether the item may have been added.
+func (f *bloomFilter) has(item string) bool {
+	a, b := bloomHashes(item)
+	for _, l := range f.layers {
+		if l.has(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the item, reporting whether it was not in the filter yet. It
+// replies an error when the filter is full and can not scale.
+func (f *bloomFilter) add(item string) (bool, Value) {
+	a, b := bloomHashes(item)
+	for _, l := range f.layers {
+		if l.has(a, b) {
+			return false, Value{}
+		}
+	}
+	last := f.layers[len(f.layers)-1]
+	if last.items >= last.capacity {
+		if f.expansion == 0 {
+			return false, Value{typ: "error", str: "ERR non scaling filter is full"}
+		}
+		if last.capacity > math.MaxInt64/f.expansion {
+			return false, Value{typ: "error", str: "ERR Insufficient memory to create filter"}
+		}
+		next, ok := newBloomLayer(last.capacity*f.expansion, last.errorRate/2)
+		if !ok {
+			return false, Value{typ: "error", str: "ERR Insufficient memory to create filter"}
+		}
+		f.layers = append(f.layers, next)
+		last = next
+	}
+	last.add(a, b)
+	return true, Value{}
+}
+
+// capacity returns the number of items the filter holds before it scales
+// again.
+func (f *bloomFilter) capacity() int64 {
+	total := int64(0)
+	for _, l := range f.layers {
+		total += l.capacity
+	}
+	return total
+}
+
+// items returns the number of items added.
+func (f *bloomFilter) items() int64 {
+	total := int64(0)
+	for _, l := range f.layers {
+		total += l.items
+	}
+	return total
+}
+
+// size returns the number of bytes the bits of the filter take.
+func (f *bloomFilter) size() int {
+	size := 0
+	for _, l := range f.layers {
+		size += len(l.bits)
+	}
+	return size
+}
+
+// bloom returns the filter of a Bloom filter key, nil when the object is
+// not one.
+func (o object) bloom() *bloomFilter {
+	f, _ := o.value.(*bloomFilter)
+	return f
+}
+
+// readBloom returns the filter stored at key, nil when the key does not
+// exist, or WRONGTYPE when it holds another type. keyspaceMu must be held.
+func readBloom(key string) (*bloomFilter, Value, bool) {
+	obj, ok := keyspace[key]
+	if ok && obj.typ != bloomObject {
+		return nil, wrongTypeError, false
+	}
+	return obj.bloom(), Value{}, true
+}
+
+// bfReserve handles BF.RESERVE key error_rate capacity [EXPANSION
+// expansion] [NONSCALING].
+func bfReserve(args []Value) Value {
+	key := args[0].bulk
+	errorRate, err := strconv.ParseFloat(args[1].bulk, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR bad error rate"}
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return Value{typ: "error", str: "ERR (0 < error rate range < 1)"}
+	}
+	capacity, err := strconv.ParseInt(args[2].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR bad capacity"}
+	}
+	if capacity <= 0 {
+		return Value{typ: "error", str: "ERR (capacity should be larger than 0)"}
+	}
+	expansion, nonScaling, hasExpansion := int64(bloomDefaultExpansion), false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "NONSCALING":
+			nonScaling = true
+		case "EXPANSION":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR bad expansion"}
+			}
+			if n < 1 {
+				return Value{typ: "error", str: "ERR expansion should be greater or equal to 1"}
+			}
+			expansion, hasExpansion = n, true
+			i++
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	if nonScaling {
+		if hasExpansion {
+			return Value{typ: "error", str: "ERR Nonscaling filters cannot expand"}
+		}
+		expansion = 0
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	if _, exists := keyspace[key]; exists {
+		return Value{typ: "error", str: "ERR item exists"}
+	}
+	f, ok := newBloomFilter(errorRate, capacity, expansion)
+	if !ok {
+		return Value{typ: "error", str: "ERR Insufficient memory to create filter"}
+	}
+	setKey(key, object{typ: bloomObject, value: f})
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// bloomAdd adds items to the filter at key, created with the defaults when
+// missing, and replies for every item whether it was new, in an array
+// unless single is set.
+func bloomAdd(key string, items []Value, single bool) Value {
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	f, errReply, ok := readBloom(key)
+	if !ok {
+		return errReply
+	}
+	if f == nil {
+		f, _ = newBloomFilter(bloomDefaultErrorRate, bloomDefaultCapacity, bloomDefaultExpansion)
+		setKey(key, object{typ: bloomObject, value: f})
+		markKeyspaceChanged()
+	}
+	reply := Value{typ: "array", array: make([]Value, 0, len(items))}
+	for _, item := range items {
+		added, errReply := f.add(item.bulk)
+		switch {
+		case errReply.typ != "":
+			reply.array = append(reply.array, errReply)
+		case added:
+			markKeyspaceChanged()
+			reply.array = append(reply.array, Value{typ: "integer", num: 1})
+		default:
+			reply.array = append(reply.array, Value{typ: "integer", num: 0})
+		}
+	}
+	if single {
+		return reply.array[0]
+	}
+	return reply
+}
+
+// bfAdd handles BF.ADD key item, replying 1 when the item was new.
+func bfAdd(args []Value) Value {
+	return bloomAdd(args[0].bulk, args[1:], true)
+}
+
+// bfMadd handles BF.MADD key item [item ...], replying whether every item
+// was new.
+func bfMadd(args []Value) Value {
+	return bloomAdd(args[0].bulk, args[1:], false)
+}
+
+// bloomExists replies for every item whether it may be in the filter at
+// key, in an array unless single is set.
+func bloomExists(key string, items []Value, single bool) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	f, errReply, ok := readBloom(key)
+	if !ok {
+		return errReply
+	}
+	reply := Value{typ: "array", array: make([]Value, 0, len(items))}
+	for _, item := range items {
+		found := 0
+		if f != nil && f.has(item.bulk) {
+			found = 1
+		}
+		reply.array = append(reply.array, Value{typ: "integer", num: found})
+	}
+	if single {
+		return reply.array[0]
+	}
+	return reply
+}
+
+// bfExists handles BF.EXISTS key item.
+func bfExists(args []Value) Value {
+	return bloomExists(args[0].bulk, args[1:], true)
+}
+
+// bfMexists handles BF.MEXISTS key item [item ...].
+func bfMexists(args []Value) Value {
+	return bloomExists(args[0].bulk, args[1:], false)
+}
+
+// bfInfo handles BF.INFO key [CAPACITY|SIZE|FILTERS|ITEMS|EXPANSION],
+// replying the capacity of the filter, the bytes it takes, its number of
+// layers, the items added and its expansion, null when it does not scale,
+// or only the field asked for.
+func bfInfo(args []Value) Value {
+	if len(args) > 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'bf.info' command"}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	f, errReply, ok := readBloom(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR not found"}
+	}
+	expansion := Value{typ: "null"}
+	if f.expansion > 0 {
+		expansion = Value{typ: "integer", num: int(f.expansion)}
+	}
+	fields := []Value{
+		{typ: "bulk", bulk: "Capacity"}, {typ: "integer", num: int(f.capacity())},
+		{typ: "bulk", bulk: "Size"}, {typ: "integer", num: f.size()},
+		{typ: "bulk", bulk: "Number of filters"}, {typ: "integer", num: len(f.layers)},
+		{typ: "bulk", bulk: "Number of items inserted"}, {typ: "integer", num: int(f.items())},
+		{typ: "bulk", bulk: "Expansion rate"}, expansion,
+	}
+	if len(args) == 1 {
+		return Value{typ: "array", array: fields}
+	}
+	switch strings.ToUpper(args[1].bulk) {
+	case "CAPACITY":
+		return Value{typ: "array", array: fields[1:2]}
+	case "SIZE":
+		return Value{typ: "array", array: fields[3:4]}
+	case "FILTERS":
+		return Value{typ: "array", array: fields[5:6]}
+	case "ITEMS":
+		return Value{typ: "array", array: fields[7:8]}
+	case "EXPANSION":
+		return Value{typ: "array", array: fields[9:10]}
+	}
+	return Value{typ: "error", str: "ERR Invalid information value"}
+}
+
+// bloomHeaderLayerSize is the number of bytes a layer takes in the header
+// chunk: its capacity, items, error rate, hashes and number of bits.
+const bloomHeaderLayerSize = 5 * 8
+
+// chunk returns the chunk iterator of the filter as BF.SCANDUMP replies it,
+// and the iterator of the next chunk, 0 after the last one. Chunk 1 is the
+// header, chunk 2 and on the bits of every layer.
+func (f *bloomFilter) chunk(iterator int64) ([]byte, int64) {
+	switch {
+	case iterator <= 0:
+		header := binary.LittleEndian.AppendUint64(nil, uint64(f.expansion))
+		for _, l := range f.layers {
+			header = binary.LittleEndian.AppendUint64(header, uint64(l.capacity))
+			header = binary.LittleEndian.AppendUint64(header, uint64(l.items))
+			header = binary.LittleEndian.AppendUint64(header, math.Float64bits(l.errorRate))
+			header = binary.LittleEndian.AppendUint64(header, uint64(l.hashes))
+			header = binary.LittleEndian.AppendUint64(header, l.nbits)
+		}
+		return header, 1
+	case iterator <= int64(len(f.layers)):
+		return f.layers[iterator-1].bits, iterator + 1
+	}
+	return nil, 0
+}
+
+// bfScandump handles BF.SCANDUMP key iterator, replying the iterator to
+// pass next, 0 once done, and the chunk of the filter following iterator,
+// starting with 0.
+func bfScandump(args []Value) Value {
+	iterator, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Second argument must be numeric"}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	f, errReply, ok := readBloom(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR not found"}
+	}
+	chunk, next := f.chunk(iterator)
+	return Value{typ: "array", array: []Value{
+		{typ: "integer", num: int(next)},
+		{typ: "bulk", bulk: string(chunk)},
+	}}
+}
+
+// bfLoadchunk handles BF.LOADCHUNK key iterator data, restoring a filter
+// from the chunks BF.SCANDUMP replied, the header first.
+func bfLoadchunk(args []Value) Value {
+	key := args[0].bulk
+	iterator, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Second argument must be numeric"}
+	}
+	data := args[2].bulk
+	invalid := Value{typ: "error", str: "ERR invalid chunk"}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	f, errReply, ok := readBloom(key)
+	if !ok {
+		return errReply
+	}
+	if iterator == 1 {
+		if f != nil {
+			return Value{typ: "error", str: "ERR item exists"}
+		}
+		if len(data) <= 8 || (len(data)-8)%bloomHeaderLayerSize != 0 {
+			return invalid
+		}
+		f = &bloomFilter{expansion: int64(binary.LittleEndian.Uint64([]byte(data)))}
+		for rest := []byte(data[8:]); len(rest) > 0; rest = rest[bloomHeaderLayerSize:] {
+			l := &bloomLayer{
+				capacity:  int64(binary.LittleEndian.Uint64(rest)),
+				items:     int64(binary.LittleEndian.Uint64(rest[8:])),
+				errorRate: math.Float64frombits(binary.LittleEndian.Uint64(rest[16:])),
+				hashes:    int(binary.LittleEndian.Uint64(rest[24:])),
+				nbits:     binary.LittleEndian.Uint64(rest[32:]),
+			}
+			if l.nbits == 0 || l.nbits > bloomMaxBits || l.hashes <= 0 {
+				return invalid
+			}
+			l.bits = make([]byte, (l.nbits+7)/8)
+			f.layers = append(f.layers, l)
+		}
+		setKey(key, object{typ: bloomObject, value: f})
+		markKeyspaceChanged()
+		return Value{typ: "string", str: "OK"}
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+	if iterator < 2 || iterator > int64(len(f.layers))+1 {
+		return invalid
+	}
+	l := f.layers[iterator-2]
+	if len(data) != len(l.bits) {
+		return invalid
+	}
+	copy(l.bits, data)
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// bloomCommands returns the BF.LOADCHUNK commands recreating the filter
+// stored at key.
+func bloomCommands(key string, f *bloomFilter) []Value {
+	commands := []Value{}
+	for iterator := int64(0); ; {
+		chunk, next := f.chunk(iterator)
+		if next == 0 {
+			return commands
+		}
+		commands = append(commands, commandValue("BF.LOADCHUNK", key, strconv.FormatInt(next, 10), string(chunk)))
+		iterator = next
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client holds the state of a single connection to the server.
+type Client struct {
+	// id is a unique, ever increasing connection id
+	id int64
+	// conn is the underlying network connection
+	conn net.Conn
+	// reader buffers what the client sent, set by serveClient
+	reader *bufio.Reader
+	// unread holds what a client taken over on a hot restart had sent
+	// that the previous process buffered but did not run, read before
+	// the connection
+	unread []byte
+	// addr is the remote address of the client as ip:port
+	addr string
+	// writer sends replies back to the client
+	writer *Writer
+	// mu serialises writes to the connection, since other goroutines
+	// may push data (such as MONITOR output) to this client
+	mu sync.Mutex
+	// created is the moment the connection was accepted
+	created time.Time
+	// infoMu guards the fields below that are changed by the client's own
+	// goroutine but read by others for CLIENT LIST and INFO clients. The
+	// owning goroutine may read them without the lock
+	infoMu sync.Mutex
+	// name is the connection name set with CLIENT SETNAME
+	name string
+	// libName and libVer are the client library reported with CLIENT SETINFO
+	libName string
+	libVer  string
+	// lastCmd is the name of the last command run by the client, in lower case
+	lastCmd string
+	// lastInteraction is when the client last ran a command
+	lastInteraction time.Time
+	// idle is set while the client waits for its next command
+	idle bool
+	// blocked is set while the client waits in a blocking command such
+	// as BLPOP
+	blocked bool
+	// db is the selected database, 0 for the live dataset or attachedDB
+	db int
+	// traceID is the correlation ID set with CLIENT TRACEID, recorded
+	// with the commands of this client in the slow log
+	traceID string
+	// authenticated is set once the client passed AUTH, or from the start
+	// when it connected while no password was required
+	authenticated bool
+	// monitor is set once the client has issued MONITOR
+	monitor bool
+	// feed carries MONITOR lines to a monitoring client
+	feed chan string
+	// subscriptions are the pub/sub channels the client subscribed to
+	// and shardSubscriptions the shard channels
+	subscriptions      map[string]bool
+	shardSubscriptions map[string]bool
+	// messages carries pub/sub messages and client-side caching
+	// invalidations to the client
+	messages chan Value
+	// closed is set once the client disconnected, so that no message queue
+	// is started for it again. It is guarded by pubsubMu
+	closed bool
+	// resp is the protocol version chosen with HELLO, 0 until then for
+	// RESP2. It is guarded by infoMu
+	resp int
+	// tracking holds the CLIENT TRACKING options, nil while tracking is
+	// off. It is guarded by trackingMu
+	tracking *tracking
+	// multi is the transaction started with MULTI, nil outside of one.
+	// Only the client's own goroutine uses it
+	multi *transaction
+	// gate counts the commands of the client in progress, which EXEC of
+	// another client waits for
+	gate commandGate
+	// pending are the commands a hot restart interrupted, starting with a
+	// blocking command woken so the client could park, run before the
+	// connection is read again. Only the client's own goroutine uses it
+	pending []Value
+	// retry is set while that blocking command runs again and holds when
+	// its wait ends, zero when it waits forever
+	retry *time.Time
+}
+
+// Clients maps client ids to every connected client.
+var Clients = map[int64]*Client{}
+
+// ClientsMu guards Clients.
+var ClientsMu = sync.RWMutex{}
+
+// clientByID returns the connected client with the given id, or nil.
+func clientByID(id int64) *Client {
+	ClientsMu.RLock()
+	defer ClientsMu.RUnlock()
+
+	return Clients[id]
+}
+
+// maxclients is the maximum number of simultaneously connected clients.
+var maxclients atomic.Int64
+
+func init() {
+	maxclients.Store(10000)
+}
+
+// nextClientID is the id handed to the most recently connected client.
+var nextClientID int64
+
+// newClient registers a new connection and returns its Client.
+func newClient(conn net.Conn) *Client {
+	return newClientWithID(conn, atomic.AddInt64(&nextClientID, 1))
+}
+
+// newClientWithID registers a connection under a given id, used for the
+// clients taken over on a hot restart which keep their id. Later clients
+// are numbered after it.
+func newClientWithID(conn net.Conn, id int64) *Client {
+	for {
+		next := atomic.LoadInt64(&nextClientID)
+		if next >= id || atomic.CompareAndSwapInt64(&nextClientID, next, id) {
+			break
+		}
+	}
+
+	now := time.Now()
+	c := &Client{
+		id:              id,
+		conn:            conn,
+		addr:            conn.RemoteAddr().String(),
+		writer:          NewWriter(conn),
+		created:         now,
+		lastInteraction: now,
+		resp:            2,
+		authenticated:   getRequirepass() == "",
+	}
+
+	ClientsMu.Lock()
+	Clients[c.id] = c
+	ClientsMu.Unlock()
+
+	return c
+}
+
+// Write sends v to the client, serialised with writes from other goroutines.
+func (c *Client) Write(v Value) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writer.Write(v)
+}
+
+// WriteMany sends several replies to the client with a single write.
+func (c *Client) WriteMany(values []Value) error {
+	var bytes []byte
+	for _, v := range values {
+		bytes = append(bytes, v.Marshal()...)
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.Write(bytes)
+	return err
+}
+
+// touch records that the client is running command.
+func (c *Client) touch(command string) {
+	c.infoMu.Lock()
+	c.lastCmd = strings.ToLower(command)
+	c.lastInteraction = time.Now()
+	c.infoMu.Unlock()
+}
+
+// Close unregisters the client and closes its connection.
+func (c *Client) Close() error {
+	ClientsMu.Lock()
+	delete(Clients, c.id)
+	ClientsMu.Unlock()
+
+	unregisterMonitor(c)
+	unregisterSubscriber(c)
+	unregisterTracking(c)
+
+	return c.conn.Close()
+}
+
+// pauseState describes an active CLIENT PAUSE. While the pause is in effect
+// commands covered by it are delayed until end is reached or CLIENT UNPAUSE
+// is called, which is what coordinated failovers and maintenance windows
+// rely on to stop the dataset from changing underneath them.
+type pauseState struct {
+	// end is the moment the pause expires on its own
+	end time.Time
+	// all is true for CLIENT PAUSE ALL and false for CLIENT PAUSE WRITE
+	all bool
+	// done is closed when the pause is lifted early or replaced, waking
+	// every client that is waiting on it
+	done chan struct{}
+}
+
+// pause holds the current pause, or nil when clients are not paused.
+var pause *pauseState
+
+// pauseMu guards pause.
+var pauseMu = sync.Mutex{}
+
+// client handles the CLIENT command and its subcommands.
+func client(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client' command"}
+	}
+
+	switch strings.ToUpper(args[0].bulk) {
+	case "PAUSE":
+		return clientPause(args[1:])
+	case "UNPAUSE":
+		return clientUnpause(args[1:])
+	case "TRACEID":
+		return clientTraceID(c, args[1:])
+	case "ID":
+		return clientID(c, args[1:])
+	case "SETNAME":
+		return clientSetName(c, args[1:])
+	case "GETNAME":
+		return clientGetName(c, args[1:])
+	case "SETINFO":
+		return clientSetInfo(c, args[1:])
+	case "LIST":
+		return clientList(args[1:])
+	case "INFO":
+		return clientInfo(c, args[1:])
+	case "TRACKING":
+		return clientTracking(c, args[1:])
+	case "CACHING":
+		return clientCaching(c, args[1:])
+	case "GETREDIR":
+		return clientGetRedir(c, args[1:])
+	case "TRACKINGINFO":
+		return clientTrackingInfo(c, args[1:])
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try CLIENT HELP."}
+	}
+}
+
+// clientPause implements CLIENT PAUSE timeout [WRITE|ALL]. The timeout is
+// given in milliseconds and the mode defaults to ALL like in Redis.
+func clientPause(args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|pause' command"}
+	}
+
+	ms, err := strconv.ParseInt(args[0].bulk, 10, 64)
+	if err != nil || ms < 0 {
+		return Value{typ: "error", str: "ERR timeout is not an integer or out of range"}
+	}
+
+	all := true
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1].bulk) {
+		case "ALL":
+			all = true
+		case "WRITE":
+			all = false
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	end := time.Now().Add(time.Duration(ms) * time.Millisecond)
+
+	pauseMu.Lock()
+	// A new pause never shortens an existing one and never downgrades
+	// an ALL pause to a WRITE pause, matching Redis behaviour
+	if pause != nil && time.Now().Before(pause.end) {
+		if pause.end.After(end) {
+			end = pause.end
+		}
+		all = all || pause.all
+		close(pause.done)
+	}
+	pause = &pauseState{end: end, all: all, done: make(chan struct{})}
+	pauseMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// clientUnpause implements CLIENT UNPAUSE, lifting any active pause and
+// releasing the clients waiting on it.
+func clientUnpause(args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|unpause' command"}
+	}
+
+	pauseMu.Lock()
+	if pause != nil {
+		close(pause.done)
+		pause = nil
+	}
+	pauseMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// clientTraceID implements CLIENT TRACEID [id], a gostore extension. With an
+// id it attaches the trace/correlation ID to the connection, an empty id
+// clears it; without one it returns the current ID. Applications set it to
+// the ID of the request they are serving so that entries in the slow log can
+// be tied back to that request.
+func clientTraceID(c *Client, args []Value) Value {
+	switch len(args) {
+	case 0:
+		if c.traceID == "" {
+			return Value{typ: "null"}
+		}
+		return Value{typ: "bulk", bulk: c.traceID}
+	case 1:
+		c.infoMu.Lock()
+		c.traceID = args[0].bulk
+		c.infoMu.Unlock()
+		return Value{typ: "string", str: "OK"}
+	default:
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|traceid' command"}
+	}
+}
+
+// waitIfPaused blocks the calling connection while a pause covering the
+// command is active. Write commands are held by both pause modes, every
+// other command only by CLIENT PAUSE ALL.
+func waitIfPaused(write bool) {
+	for {
+		pauseMu.Lock()
+		p := pause
+		pauseMu.Unlock()
+
+		if p == nil || (!p.all && !write) {
+			return
+		}
+
+		wait := time.Until(p.end)
+		if wait <= 0 {
+			return
+		}
+
+		// Sleep until the pause runs out or is lifted/replaced, then check
+		// again in case another pause has been put in place meanwhile
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-p.done:
+			timer.Stop()
+		}
+	}
+}
+
+// clientID implements CLIENT ID, returning the id of the connection.
+func clientID(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|id' command"}
+	}
+	return Value{typ: "integer", num: int(c.id)}
+}
+
+// validClientField reports whether s may be used as a connection name or
+// library name/version. Like Redis, spaces, newlines and other special
+// characters are refused so that CLIENT LIST stays parseable.
+func validClientField(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '!' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientSetName implements CLIENT SETNAME name. An empty name removes it.
+func clientSetName(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|setname' command"}
+	}
+	if !validClientField(args[0].bulk) {
+		return Value{typ: "error", str: "ERR Client names cannot contain spaces, newlines or special characters."}
+	}
+
+	c.infoMu.Lock()
+	c.name = args[0].bulk
+	c.infoMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// clientGetName implements CLIENT GETNAME.
+func clientGetName(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|getname' command"}
+	}
+	if c.name == "" {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "bulk", bulk: c.name}
+}
+
+// clientSetInfo implements CLIENT SETINFO LIB-NAME name | LIB-VER version,
+// which client libraries such as go-redis and Lettuce send while setting
+// up a connection.
+func clientSetInfo(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|setinfo' command"}
+	}
+
+	attr := strings.ToLower(args[0].bulk)
+	if attr != "lib-name" && attr != "lib-ver" {
+		return Value{typ: "error", str: "ERR Unrecognized option '" + args[0].bulk + "'"}
+	}
+	if !validClientField(args[1].bulk) {
+		return Value{typ: "error", str: "ERR " + attr + " cannot contain spaces, newlines or special characters."}
+	}
+
+	c.infoMu.Lock()
+	if attr == "lib-name" {
+		c.libName = args[1].bulk
+	} else {
+		c.libVer = args[1].bulk
+	}
+	c.infoMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// clientList implements CLIENT LIST [ID id [id ...]], describing every
+// connected client on its own line.
+func clientList(args []Value) Value {
+	var ids map[int64]bool
+	if len(args) > 0 {
+		if strings.ToUpper(args[0].bulk) != "ID" || len(args) < 2 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		ids = map[int64]bool{}
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg.bulk, 10, 64)
+			if err != nil || id <= 0 {
+				return Value{typ: "error", str: "ERR Invalid client ID"}
+			}
+			ids[id] = true
+		}
+	}
+
+	ClientsMu.RLock()
+	clients := make([]*Client, 0, len(Clients))
+	for id, c := range Clients {
+		if ids == nil || ids[id] {
+			clients = append(clients, c)
+		}
+	}
+	ClientsMu.RUnlock()
+
+	// list clients in connection order like Redis does
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+	var b strings.Builder
+	for _, c := range clients {
+		b.WriteString(c.describe())
+		b.WriteByte('\n')
+	}
+
+	return Value{typ: "bulk", bulk: b.String()}
+}
+
+// clientInfo implements CLIENT INFO, describing the calling client.
+func clientInfo(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|info' command"}
+	}
+	return Value{typ: "bulk", bulk: c.describe() + "\n"}
+}
+
+// describe returns the CLIENT LIST line of the client.
+func (c *Client) describe() string {
+	now := time.Now()
+
+	// the monitor flag is guarded by monitorsMu rather than infoMu
+	flags := "N"
+	monitorsMu.Lock()
+	if c.monitor {
+		flags = "O"
+	}
+	monitorsMu.Unlock()
+
+	// the tracking options are guarded by trackingMu
+	redir := -1
+	trackingMu.Lock()
+	if c.tracking != nil {
+		redir = int(c.tracking.redirect)
+		if flags == "N" {
+			flags = "t"
+		}
+	}
+	trackingMu.Unlock()
+
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	if c.blocked {
+		flags = "b"
+	}
+	cmd := c.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d cmd=%s user=default redir=%d resp=%d lib-name=%s lib-ver=%s",
+		c.id, c.addr, c.conn.LocalAddr().String(), c.name,
+		int64(now.Sub(c.created).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		flags, c.db, cmd, redir, c.resp, c.libName, c.libVer)
+}
+
+// clientLibraries counts the connected clients per library as reported
+// with CLIENT SETINFO, keyed by "name/version". Clients that did not
+// report a library are not counted.
+func clientLibraries() map[string]int {
+	libs := map[string]int{}
+
+	ClientsMu.RLock()
+	defer ClientsMu.RUnlock()
+
+	for _, c := range Clients {
+		c.infoMu.Lock()
+		name, ver := c.libName, c.libVer
+		c.infoMu.Unlock()
+		if name == "" {
+			continue
+		}
+		if ver != "" {
+			name += "/" + ver
+		}
+		libs[name]++
+	}
+
+	return libs
+}
@@ -0,0 +1,244 @@
+// Cluster rebalance planning.
+//
+// gostore has no cluster mode of its own, but operators resharding a Redis
+// Cluster (or a set of servers behind a proxy) still need to work out which
+// slots to move where. CLUSTER REBALANCE-PLAN does that arithmetic: given
+// the capacity of every node and the size and owner of every slot it
+// returns the slot moves that bring the memory used on each node closest
+// to its share of the total.
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// clusterSlots is the number of hash slots of a Redis Cluster.
+const clusterSlots = 16384
+
+// rebalanceThreshold is the default imbalance, in percent of a node's
+// target, below which a node is considered balanced.
+const rebalanceThreshold = 2
+
+// rebalanceNode is a node taking part in a rebalance plan.
+type rebalanceNode struct {
+	id       string
+	capacity int64
+	used     int64
+	target   int64
+	// slots maps the slots owned by the node to their size in bytes
+	slots map[int]int64
+}
+
+// rebalanceMove moves a slot between two nodes.
+type rebalanceMove struct {
+	slot     int
+	from, to string
+	bytes    int64
+}
+
+// keyHashSlot returns the Redis Cluster hash slot of key: the CRC16 of its
+// hash tag modulo the number of slots.
+func keyHashSlot(key string) int {
+	crc := uint16(0)
+	for _, b := range []byte(hashTag(key)) {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % clusterSlots
+}
+
+// clusterCommand handles the CLUSTER command. Only the planner is
+// available since the server itself does not run in cluster mode.
+func clusterCommand(args []Value) Value {
+	switch strings.ToUpper(args[0].bulk) {
+	case "REBALANCE-PLAN":
+		return clusterRebalancePlan(args[1:])
+	default:
+		return Value{typ: "error", str: "ERR This instance has cluster support disabled"}
+	}
+}
+
+// clusterRebalancePlan implements
+//
+//	CLUSTER REBALANCE-PLAN NODES count id capacity [id capacity ...]
+//	    SLOTS count slot id bytes [slot id bytes ...] [THRESHOLD percent] [EXECUTE]
+//
+// replying with the moves of the plan, each as slot, source node,
+// destination node and bytes moved. Capacities and sizes are in bytes.
+// EXECUTE is refused since this server can not migrate slots itself.
+func clusterRebalancePlan(args []Value) Value {
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	integer := func(s string) (int64, bool) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err == nil && n >= 0
+	}
+
+	nodes := map[string]*rebalanceNode{}
+	order := []*rebalanceNode{}
+	owners := map[int]bool{}
+	threshold := int64(rebalanceThreshold)
+	execute := false
+
+	for i := 0; i < len(args); {
+		switch strings.ToUpper(args[i].bulk) {
+		case "NODES":
+			if i+1 >= len(args) {
+				return syntaxError
+			}
+			count, ok := integer(args[i+1].bulk)
+			if !ok || int64(len(args)-i-2) < 2*count {
+				return syntaxError
+			}
+			for j := 0; j < int(count); j++ {
+				id := args[i+2+2*j].bulk
+				capacity, ok := integer(args[i+3+2*j].bulk)
+				if !ok || capacity == 0 {
+					return Value{typ: "error", str: "ERR invalid capacity for node " + id}
+				}
+				if nodes[id] != nil {
+					return Value{typ: "error", str: "ERR duplicate node " + id}
+				}
+				nodes[id] = &rebalanceNode{id: id, capacity: capacity, slots: map[int]int64{}}
+				order = append(order, nodes[id])
+			}
+			i += 2 + 2*int(count)
+		case "SLOTS":
+			if i+1 >= len(args) {
+				return syntaxError
+			}
+			count, ok := integer(args[i+1].bulk)
+			if !ok || int64(len(args)-i-2) < 3*count {
+				return syntaxError
+			}
+			for j := 0; j < int(count); j++ {
+				slot, ok := integer(args[i+2+3*j].bulk)
+				if !ok || slot >= clusterSlots {
+					return Value{typ: "error", str: "ERR Invalid or out of range slot"}
+				}
+				if owners[int(slot)] {
+					return Value{typ: "error", str: "ERR Slot " + strconv.FormatInt(slot, 10) + " specified multiple times"}
+				}
+				owners[int(slot)] = true
+				node := nodes[args[i+3+3*j].bulk]
+				if node == nil {
+					return Value{typ: "error", str: "ERR Unknown node " + args[i+3+3*j].bulk}
+				}
+				bytes, ok := integer(args[i+4+3*j].bulk)
+				if !ok {
+					return syntaxError
+				}
+				node.slots[int(slot)] = bytes
+				node.used += bytes
+			}
+			i += 2 + 3*int(count)
+		case "THRESHOLD":
+			if i+1 >= len(args) {
+				return syntaxError
+			}
+			n, ok := integer(args[i+1].bulk)
+			if !ok {
+				return syntaxError
+			}
+			threshold = n
+			i += 2
+		case "EXECUTE":
+			execute = true
+			i++
+		default:
+			return syntaxError
+		}
+	}
+	if len(order) < 2 {
+		return Value{typ: "error", str: "ERR at least two nodes are needed to rebalance"}
+	}
+	if execute {
+		return Value{typ: "error", str: "ERR This instance has cluster support disabled, apply the plan with the cluster's own migration tooling"}
+	}
+
+	moves := planRebalance(order, threshold)
+
+	values := []Value{}
+	for _, m := range moves {
+		values = append(values, Value{typ: "array", array: []Value{
+			{typ: "integer", num: m.slot},
+			{typ: "bulk", bulk: m.from},
+			{typ: "bulk", bulk: m.to},
+			{typ: "integer", num: int(m.bytes)},
+		}})
+	}
+	return Value{typ: "array", array: values}
+}
+
+// planRebalance computes the slot moves that bring every node closest to
+// its share of the total memory, in proportion to its capacity. Nodes
+// within threshold percent of their target are left alone. The plan is
+// deterministic for a given input.
+func planRebalance(nodes []*rebalanceNode, threshold int64) []rebalanceMove {
+	total, capacity := int64(0), int64(0)
+	for _, n := range nodes {
+		total += n.used
+		capacity += n.capacity
+	}
+	for _, n := range nodes {
+		n.target = total * n.capacity / capacity
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+
+	moves := []rebalanceMove{}
+	for {
+		// move from the node most above its target to the node most
+		// below it
+		var from, to *rebalanceNode
+		for _, n := range nodes {
+			if from == nil || n.used-n.target > from.used-from.target {
+				from = n
+			}
+			if to == nil || n.used-n.target < to.used-to.target {
+				to = n
+			}
+		}
+		over, under := from.used-from.target, to.target-to.used
+		if over <= from.target*threshold/100 || under <= 0 {
+			break
+		}
+
+		// pick the largest slot that fits in what the destination lacks,
+		// otherwise the smallest slot if moving it still improves things
+		best, bestBytes := -1, int64(0)
+		smallest, smallestBytes := -1, int64(0)
+		for slot, bytes := range from.slots {
+			if bytes == 0 {
+				continue
+			}
+			if bytes <= under && (bytes > bestBytes || bytes == bestBytes && slot < best) {
+				best, bestBytes = slot, bytes
+			}
+			if smallest < 0 || bytes < smallestBytes || bytes == smallestBytes && slot < smallest {
+				smallest, smallestBytes = slot, bytes
+			}
+		}
+		if best < 0 {
+			// the move only helps when the imbalance shrinks
+			if smallest < 0 || smallestBytes >= over+under {
+				break
+			}
+			best, bestBytes = smallest, smallestBytes
+		}
+
+		delete(from.slots, best)
+		from.used -= bestBytes
+		to.slots[best] = bestBytes
+		to.used += bestBytes
+		moves = append(moves, rebalanceMove{slot: best, from: from.id, to: to.id, bytes: bestBytes})
+	}
+
+	return moves
+}
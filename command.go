@@ -0,0 +1,443 @@
+// Command metadata. Smart clients and cluster-aware drivers call COMMAND when
+// they connect to learn the arity, flags and key positions of every command.
+// The metadata lives next to, but separate from, the Handlers map so that it
+// can also drive server-side decisions such as which commands are writes.
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CommandInfo describes a command the way Redis' COMMAND reply does.
+type CommandInfo struct {
+	// Arity is the number of arguments including the command name.
+	// A negative arity -N means at least N arguments.
+	Arity int
+	// Flags are the Redis command flags, such as "write" or "readonly"
+	Flags []string
+	// FirstKey, LastKey and Step locate the key arguments. LastKey -1
+	// means the keys continue up to the last argument
+	FirstKey, LastKey, Step int
+	// NumKeys is the position of the argument giving the number of keys
+	// that directly follow it, for commands such as LMPOP whose keys move
+	// with that number. They carry the "movablekeys" flag and have no
+	// FirstKey, unless a fixed key precedes the number like the
+	// destination of ZUNIONSTORE
+	NumKeys int
+	// Group is the documentation group, such as "string" or "hash"
+	Group string
+	// Since is the Redis version that introduced the command
+	Since string
+	// Summary is a one-line description used by COMMAND DOCS
+	Summary string
+	// Errors are the error replies, or their fixed prefix, that the command
+	// returns on its own besides the generic arity, NOAUTH and OOM errors
+	Errors []string
+}
+
+// Commands maps command names to their metadata. Every entry of Handlers and
+// ClientHandlers has an entry here.
+var Commands = map[string]CommandInfo{
+	"PING":             {Arity: -1, Flags: []string{"fast"}, Group: "connection", Since: "1.0.0", Summary: "Returns the server's liveliness response."},
+	"SET":              {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "1.0.0", Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR invalid expire time in 'set' command", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GET":              {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "1.0.0", Summary: "Returns the string value of a key.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HSET":             {Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Creates or modifies the value of a field in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HGET":             {Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Returns the value of a field in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HGETALL":          {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Returns all fields and values in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CLIENT":           {Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "connection", Since: "2.4.0", Summary: "A container for client connection commands.", Errors: []string{"ERR unknown subcommand", "ERR timeout is not an integer or out of range", "ERR syntax error", "ERR Client names cannot contain spaces, newlines or special characters.", "ERR Unrecognized option", "ERR Invalid client ID", "ERR The client ID you want redirect to does not exist", "ERR PREFIX option requires BCAST mode to be enabled", "ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled"}},
+	"MEMORY":           {Arity: -2, Flags: []string{"readonly"}, Group: "server", Since: "4.0.0", Summary: "A container for memory diagnostics commands.", Errors: []string{"ERR unknown subcommand"}},
+	"AUTH":             {Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth", "allow_busy"}, Group: "connection", Since: "1.0.0", Summary: "Authenticates the connection.", Errors: []string{"ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?", "WRONGPASS invalid username-password pair or user is disabled."}},
+	"MULTI":            {Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast", "allow_busy"}, Group: "transactions", Since: "1.2.0", Summary: "Starts a transaction.", Errors: []string{"ERR MULTI calls can not be nested"}},
+	"EXEC":             {Arity: 1, Flags: []string{"noscript", "loading", "stale", "skip_slowlog"}, Group: "transactions", Since: "1.2.0", Summary: "Executes all commands in a transaction.", Errors: []string{"ERR EXEC without MULTI", "EXECABORT Transaction discarded because of previous errors."}},
+	"DISCARD":          {Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast", "allow_busy"}, Group: "transactions", Since: "2.0.0", Summary: "Discards a transaction.", Errors: []string{"ERR DISCARD without MULTI"}},
+	"HELLO":            {Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth", "allow_busy"}, Group: "connection", Since: "6.0.0", Summary: "Handshakes with the Redis server.", Errors: []string{"ERR Protocol version is not an integer or out of range", "NOPROTO unsupported protocol version", "ERR Syntax error in HELLO option", "WRONGPASS invalid username-password pair or user is disabled.", "NOAUTH HELLO must be called with the client already authenticated"}},
+	"MONITOR":          {Arity: 1, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Since: "1.0.0", Summary: "Listens for all requests received by the server in real-time."},
+	"INFO":             {Arity: -1, Flags: []string{"loading", "stale"}, Group: "server", Since: "1.0.0", Summary: "Returns information and statistics about the server."},
+	"CONFIG":           {Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Since: "2.0.0", Summary: "A container for server configuration commands.", Errors: []string{"ERR unknown subcommand", "ERR The server is running without a config file", "ERR Rewriting config file", "ERR Unknown option or number of arguments for CONFIG SET", "ERR CONFIG SET failed"}},
+	"SAVE":             {Arity: 1, Flags: []string{"admin", "noscript", "no_async_loading", "no_multi"}, Group: "server", Since: "1.0.0", Summary: "Synchronously saves the database(s) to disk.", Errors: []string{"ERR Background save already in progress"}},
+	"BGSAVE":           {Arity: -1, Flags: []string{"admin", "noscript", "no_async_loading"}, Group: "server", Since: "1.0.0", Summary: "Asynchronously saves the database(s) to disk.", Errors: []string{"ERR syntax error", "ERR Background save already in progress"}},
+	"LASTSAVE":         {Arity: 1, Flags: []string{"loading", "stale", "fast"}, Group: "server", Since: "1.0.0", Summary: "Returns the Unix timestamp of the last successful save to disk."},
+	"COMMAND":          {Arity: -1, Flags: []string{"loading", "stale"}, Group: "server", Since: "2.8.13", Summary: "Returns detailed information about all commands.", Errors: []string{"ERR unknown subcommand"}},
+	"SLOWLOG":          {Arity: -2, Flags: []string{"admin", "loading", "stale"}, Group: "server", Since: "2.2.12", Summary: "A container for slow log commands.", Errors: []string{"ERR unknown subcommand", "ERR count should be greater than or equal to -1"}},
+	"SHUTDOWN":         {Arity: -1, Flags: []string{"admin", "noscript", "loading", "stale", "no_multi", "allow_busy"}, Group: "server", Since: "1.0.0", Summary: "Synchronously saves the database(s) to disk and shuts down the Redis server.", Errors: []string{"ERR syntax error", "ERR Errors trying to SHUTDOWN. Check logs."}},
+	"DEBUG":            {Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Since: "1.0.0", Summary: "A container for debugging commands.", Errors: []string{"ERR unknown subcommand", "ERR value is not a valid float", "ERR no such key", "ERR value is not an integer or out of range"}},
+	"SUBSCRIBE":        {Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "2.0.0", Summary: "Listens for messages published to channels."},
+	"UNSUBSCRIBE":      {Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "2.0.0", Summary: "Stops listening to messages posted to channels."},
+	"SSUBSCRIBE":       {Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "7.0.0", Summary: "Listens for messages published to shard channels."},
+	"SUNSUBSCRIBE":     {Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "7.0.0", Summary: "Stops listening to messages posted to shard channels."},
+	"PUBLISH":          {Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}, Group: "pubsub", Since: "2.0.0", Summary: "Posts a message to a channel."},
+	"PUBSUB":           {Arity: -2, Flags: []string{"pubsub", "loading", "stale"}, Group: "pubsub", Since: "2.8.0", Summary: "A container for Pub/Sub commands.", Errors: []string{"ERR unknown subcommand"}},
+	"SPUBLISH":         {Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}, Group: "pubsub", Since: "7.0.0", Summary: "Post a message to a shard channel."},
+	"IDGEN":            {Arity: -3, Flags: []string{"write", "denyoom", "fast"}, Group: "generic", Since: "7.2.0", Summary: "Returns monotonically increasing IDs per namespace.", Errors: []string{"ERR unknown subcommand", "ERR syntax error", "ERR ID space exhausted", "ERR value is not an integer or out of range"}},
+	"SESSION.SET":      {Arity: -5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "session", Since: "7.2.0", Summary: "Stores a session with a TTL and tags.", Errors: []string{"ERR invalid expire time in 'session.set' command", "ERR syntax error"}},
+	"SESSION.GET":      {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "session", Since: "7.2.0", Summary: "Returns the value of a session."},
+	"SESSION.TOUCH":    {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "session", Since: "7.2.0", Summary: "Extends the TTL of a session.", Errors: []string{"ERR invalid expire time in 'session.touch' command"}},
+	"SESSION.DELBYTAG": {Arity: 2, Flags: []string{"write"}, Group: "session", Since: "7.2.0", Summary: "Deletes every session carrying a tag."},
+	"DEL":              {Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Since: "1.0.0", Summary: "Deletes one or more keys."},
+	"EXISTS":           {Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Since: "1.0.0", Summary: "Determines whether one or more keys exist."},
+	"EXPIRE":           {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "1.0.0", Summary: "Sets the expiration time of a key in seconds.", Errors: []string{"ERR value is not an integer or out of range", "ERR Unsupported option", "ERR NX and XX, GT or LT options at the same time are not compatible", "ERR GT and LT options at the same time are not compatible"}},
+	"PEXPIRE":          {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "2.6.0", Summary: "Sets the expiration time of a key in milliseconds.", Errors: []string{"ERR value is not an integer or out of range", "ERR Unsupported option", "ERR NX and XX, GT or LT options at the same time are not compatible", "ERR GT and LT options at the same time are not compatible"}},
+	"PEXPIREAT":        {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "2.6.0", Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", Errors: []string{"ERR value is not an integer or out of range", "ERR Unsupported option", "ERR NX and XX, GT or LT options at the same time are not compatible", "ERR GT and LT options at the same time are not compatible"}},
+	"TTL":              {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "1.0.0", Summary: "Returns the expiration time in seconds of a key."},
+	"PTTL":             {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "2.6.0", Summary: "Returns the expiration time in milliseconds of a key."},
+	"CLUSTER":          {Arity: -2, Flags: []string{"loading", "stale"}, Group: "cluster", Since: "3.0.0", Summary: "A container for Redis Cluster commands.", Errors: []string{"ERR This instance has cluster support disabled", "ERR syntax error", "ERR Invalid or out of range slot", "ERR Unknown node", "ERR at least two nodes are needed to rebalance"}},
+	"EXPIREAT":         {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "1.2.0", Summary: "Sets the expiration time of a key to a Unix timestamp.", Errors: []string{"ERR value is not an integer or out of range", "ERR Unsupported option", "ERR NX and XX, GT or LT options at the same time are not compatible", "ERR GT and LT options at the same time are not compatible"}},
+	"PERSIST":          {Arity: 2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "2.2.0", Summary: "Removes the expiration time of a key."},
+	"EXPIRETIME":       {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "7.0.0", Summary: "Returns the expiration time of a key as a Unix timestamp."},
+	"PEXPIRETIME":      {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "7.0.0", Summary: "Returns the expiration time of a key as a Unix milliseconds timestamp."},
+	"CHECKSUM":         {Arity: 2, Flags: []string{"readonly"}, Group: "server", Since: "7.2.0", Summary: "Returns a digest of the whole dataset or of every key in it.", Errors: []string{"ERR syntax error"}},
+	"SNAPSHOT":         {Arity: -2, Flags: []string{"admin", "noscript"}, Group: "server", Since: "7.2.0", Summary: "Attaches a snapshot file as a read-only database.", Errors: []string{"ERR unknown subcommand", "ERR error loading the snapshot"}},
+	"SELECT":           {Arity: 2, Flags: []string{"loading", "stale", "fast"}, Group: "connection", Since: "1.0.0", Summary: "Changes the selected database.", Errors: []string{"ERR value is not an integer or out of range", "ERR DB index is out of range", "ERR no snapshot attached, use SNAPSHOT ATTACH first"}},
+	"OBJECT":           {Arity: -2, Flags: []string{"readonly"}, FirstKey: 2, LastKey: 2, Step: 1, Group: "generic", Since: "2.2.3", Summary: "A container for object introspection commands.", Errors: []string{"ERR unknown subcommand"}},
+	"EXPLAIN":          {Arity: -2, Flags: []string{"loading", "stale"}, Group: "server", Since: "7.2.0", Summary: "Reports what a command would do without executing it."},
+	"SETNX":            {Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "1.0.0", Summary: "Set the string value of a key only when the key doesn't exist."},
+	"SETEX":            {Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "2.0.0", Summary: "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.", Errors: []string{"ERR value is not an integer or out of range", "ERR invalid expire time in 'setex' command"}},
+	"PSETEX":           {Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "2.6.0", Summary: "Sets both string value and expiration time in milliseconds of a key. The key is created if it doesn't exist.", Errors: []string{"ERR value is not an integer or out of range", "ERR invalid expire time in 'psetex' command"}},
+	"MGET":             {Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "string", Since: "1.0.0", Summary: "Atomically returns the string values of one or more keys."},
+	"MSET":             {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 2, Group: "string", Since: "1.0.1", Summary: "Atomically creates or modifies the string values of one or more keys."},
+	"MSETNX":           {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 2, Group: "string", Since: "1.0.1", Summary: "Atomically modifies the string values of one or more keys only when all keys don't exist."},
+	"GETSET":           {Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "1.0.0", Summary: "Returns the previous string value of a key after setting it to a new value.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GETDEL":           {Arity: 2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "6.2.0", Summary: "Returns the string value of a key after deleting the key.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GETEX":            {Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Since: "6.2.0", Summary: "Returns the string value of a key after setting its expiration time.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR invalid expire time in 'getex' command", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"JOBS":             {Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Since: "7.2.0", Summary: "Lists and cancels background jobs.", Errors: []string{"ERR unknown subcommand", "ERR No such job", "ERR value is not an integer or out of range"}},
+	"TYPE":             {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Since: "1.0.0", Summary: "Determines the type of value stored at a key."},
+	"SCAN":             {Arity: -2, Flags: []string{"readonly"}, Group: "generic", Since: "2.8.0", Summary: "Iterates over the key names in the database.", Errors: []string{"ERR invalid cursor", "ERR syntax error", "ERR value is not an integer or out of range"}},
+	"DBSIZE":           {Arity: 1, Flags: []string{"readonly", "fast"}, Group: "server", Since: "1.0.0", Summary: "Returns the number of keys in the database."},
+	"FLUSHDB":          {Arity: -1, Flags: []string{"write"}, Group: "server", Since: "1.0.0", Summary: "Remove all keys from the current database.", Errors: []string{"ERR syntax error"}},
+	"FLUSHALL":         {Arity: -1, Flags: []string{"write"}, Group: "server", Since: "1.0.0", Summary: "Removes all keys from all databases.", Errors: []string{"ERR syntax error"}},
+	"UNLINK":           {Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Since: "4.0.0", Summary: "Asynchronously deletes one or more keys."},
+	"ECHO":             {Arity: 2, Flags: []string{"fast"}, Group: "connection", Since: "1.0.0", Summary: "Returns the given string."},
+	"HDEL":             {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HEXISTS":          {Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Determines whether a field exists in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HLEN":             {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Returns the number of fields in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HKEYS":            {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Returns all fields in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HVALS":            {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Returns all values in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HMSET":            {Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Sets the values of multiple fields.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HMGET":            {Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Returns the values of all fields in a hash.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HSETNX":           {Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.0.0", Summary: "Sets the value of a field in a hash only when the field doesn't exist.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"HSCAN":            {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Since: "2.8.0", Summary: "Iterates over fields and values of a hash.", Errors: []string{"ERR invalid cursor", "ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LPUSH":            {Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"RPUSH":            {Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LPOP":             {Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", Errors: []string{"ERR syntax error", "ERR value is out of range, must be positive", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"RPOP":             {Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.", Errors: []string{"ERR syntax error", "ERR value is out of range, must be positive", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LLEN":             {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Returns the length of a list.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LRANGE":           {Arity: 4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Returns a range of elements from a list.", Errors: []string{"ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LINSERT":          {Arity: 5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "2.2.0", Summary: "Inserts an element before or after another element in a list.", Errors: []string{"ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LSET":             {Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Sets the value of an element in a list by its index.", Errors: []string{"ERR value is not an integer or out of range", "ERR no such key", "ERR index out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LREM":             {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Removes elements from a list. Deletes the list if the last element was removed.", Errors: []string{"ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LTRIM":            {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.", Errors: []string{"ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LINDEX":           {Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "1.0.0", Summary: "Returns an element from a list by its index.", Errors: []string{"ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LPOS":             {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Since: "6.0.6", Summary: "Returns the index of matching elements in a list.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list", "ERR COUNT can't be negative", "ERR MAXLEN can't be negative", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LMOVE":            {Arity: 5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "list", Since: "6.2.0", Summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", Errors: []string{"ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"RPOPLPUSH":        {Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "list", Since: "1.2.0", Summary: "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BLPOP":            {Arity: -3, Flags: []string{"write", "blocking"}, FirstKey: 1, LastKey: -2, Step: 1, Group: "list", Since: "2.0.0", Summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", Errors: []string{"ERR timeout is not a float or out of range", "ERR timeout is negative", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BRPOP":            {Arity: -3, Flags: []string{"write", "blocking"}, FirstKey: 1, LastKey: -2, Step: 1, Group: "list", Since: "2.0.0", Summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", Errors: []string{"ERR timeout is not a float or out of range", "ERR timeout is negative", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BLMOVE":           {Arity: 6, Flags: []string{"write", "denyoom", "blocking"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "list", Since: "6.2.0", Summary: "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.", Errors: []string{"ERR syntax error", "ERR timeout is not a float or out of range", "ERR timeout is negative", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"LMPOP":            {Arity: -4, Flags: []string{"write", "movablekeys"}, NumKeys: 1, Group: "list", Since: "7.0.0", Summary: "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.", Errors: []string{"ERR numkeys should be greater than 0", "ERR count should be greater than 0", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BLMPOP":           {Arity: -5, Flags: []string{"write", "blocking", "movablekeys"}, NumKeys: 2, Group: "list", Since: "7.0.0", Summary: "Pops the first element from one of multiple lists. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", Errors: []string{"ERR timeout is not a float or out of range", "ERR timeout is negative", "ERR numkeys should be greater than 0", "ERR count should be greater than 0", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SADD":             {Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SREM":             {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Removes one or more members from a set. Deletes the set if the last member was removed.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SMEMBERS":         {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Returns all members of a set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SISMEMBER":        {Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Determines whether a member belongs to a set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SCARD":            {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Returns the number of members in a set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SINTER":           {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Returns the intersect of multiple sets.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SINTERSTORE":      {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Stores the intersect of multiple sets in a key.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SUNION":           {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Returns the union of multiple sets.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SUNIONSTORE":      {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Stores the union of multiple sets in a key.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SDIFF":            {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Returns the difference of multiple sets.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SDIFFSTORE":       {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Since: "1.0.0", Summary: "Stores the difference of multiple sets in a key.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SMISMEMBER":       {Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "6.2.0", Summary: "Determines whether multiple members belong to a set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SMOVE":            {Arity: 4, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "set", Since: "1.0.0", Summary: "Moves a member from one set to another.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SSCAN":            {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Since: "2.8.0", Summary: "Iterates over members of a set.", Errors: []string{"ERR invalid cursor", "ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SINTERCARD":       {Arity: -3, Flags: []string{"readonly", "movablekeys"}, NumKeys: 1, Group: "set", Since: "7.0.0", Summary: "Returns the number of members of the intersect of multiple sets.", Errors: []string{"ERR numkeys should be greater than 0", "ERR Number of keys can't be greater than number of args", "ERR LIMIT can't be negative", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZADD":             {Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", Errors: []string{"ERR syntax error", "ERR XX and NX options at the same time are not compatible", "ERR GT, LT, and/or NX options at the same time are not compatible", "ERR INCR option supports a single increment-element pair", "ERR value is not a valid float", "ERR resulting score is not a number (NaN)", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZSCORE":           {Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Returns the score of a member in a sorted set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZCARD":            {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Returns the number of members in a sorted set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZCOUNT":           {Arity: 4, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.0.0", Summary: "Returns the count of members in a sorted set that have scores within a range.", Errors: []string{"ERR min or max is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZRANGE":           {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Returns members in a sorted set within a range of indexes.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX", "ERR syntax error, WITHSCORES not supported in combination with BYLEX", "ERR min or max is not a float", "ERR min or max not valid string range item", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZRANGESTORE":      {Arity: -5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "sorted-set", Since: "6.2.0", Summary: "Stores a range of members from sorted set in a key.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX", "ERR min or max is not a float", "ERR min or max not valid string range item", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREVRANGE":        {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Returns members in a sorted set within a range of indexes in reverse order.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZRANGEBYSCORE":    {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.0.5", Summary: "Returns members in a sorted set within a range of scores.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR min or max is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREVRANGEBYSCORE": {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.2.0", Summary: "Returns members in a sorted set within a range of scores in reverse order.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR min or max is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZRANK":            {Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.0.0", Summary: "Returns the index of a member in a sorted set ordered by ascending scores.", Errors: []string{"ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREVRANK":         {Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.0.0", Summary: "Returns the index of a member in a sorted set ordered by descending scores.", Errors: []string{"ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZMSCORE":          {Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "6.2.0", Summary: "Returns the score of one or more members in a sorted set.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZINCRBY":          {Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Increments the score of a member in a sorted set.", Errors: []string{"ERR value is not a valid float", "ERR resulting score is not a number (NaN)", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREM":             {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREMRANGEBYRANK":  {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.0.0", Summary: "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed.", Errors: []string{"ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREMRANGEBYSCORE": {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "1.2.0", Summary: "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed.", Errors: []string{"ERR min or max is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZUNION":           {Arity: -3, Flags: []string{"readonly", "movablekeys"}, NumKeys: 1, Group: "sorted-set", Since: "6.2.0", Summary: "Returns the union of multiple sorted sets.", Errors: []string{"ERR value is not an integer or out of range", "ERR at least 1 input key is needed", "ERR syntax error", "ERR weight value is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZINTER":           {Arity: -3, Flags: []string{"readonly", "movablekeys"}, NumKeys: 1, Group: "sorted-set", Since: "6.2.0", Summary: "Returns the intersect of multiple sorted sets.", Errors: []string{"ERR value is not an integer or out of range", "ERR at least 1 input key is needed", "ERR syntax error", "ERR weight value is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZDIFF":            {Arity: -3, Flags: []string{"readonly", "movablekeys"}, NumKeys: 1, Group: "sorted-set", Since: "6.2.0", Summary: "Returns the difference between multiple sorted sets.", Errors: []string{"ERR value is not an integer or out of range", "ERR at least 1 input key is needed", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZUNIONSTORE":      {Arity: -4, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, Step: 1, NumKeys: 2, Group: "sorted-set", Since: "2.0.0", Summary: "Stores the union of multiple sorted sets in a key.", Errors: []string{"ERR value is not an integer or out of range", "ERR at least 1 input key is needed", "ERR syntax error", "ERR weight value is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZINTERSTORE":      {Arity: -4, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, Step: 1, NumKeys: 2, Group: "sorted-set", Since: "2.0.0", Summary: "Stores the intersect of multiple sorted sets in a key.", Errors: []string{"ERR value is not an integer or out of range", "ERR at least 1 input key is needed", "ERR syntax error", "ERR weight value is not a float", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZDIFFSTORE":       {Arity: -4, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, Step: 1, NumKeys: 2, Group: "sorted-set", Since: "6.2.0", Summary: "Stores the difference of multiple sorted sets in a key.", Errors: []string{"ERR value is not an integer or out of range", "ERR at least 1 input key is needed", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZRANGEBYLEX":      {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.8.9", Summary: "Returns members in a sorted set within a lexicographical range.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR min or max not valid string range item", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREVRANGEBYLEX":   {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.8.9", Summary: "Returns members in a sorted set within a lexicographical range in reverse order.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR min or max not valid string range item", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZLEXCOUNT":        {Arity: 4, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.8.9", Summary: "Returns the number of members in a sorted set within a lexicographical range.", Errors: []string{"ERR min or max not valid string range item", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZREMRANGEBYLEX":   {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.8.9", Summary: "Removes members in a sorted set within a lexicographical range. Deletes the sorted set if all members were removed.", Errors: []string{"ERR min or max not valid string range item", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZSCAN":            {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "2.8.0", Summary: "Iterates over members and scores of a sorted set.", Errors: []string{"ERR invalid cursor", "ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"ZRANDMEMBER":      {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted-set", Since: "6.2.0", Summary: "Returns one or more random members from a sorted set.", Errors: []string{"ERR value is not an integer or out of range", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"SETBIT":           {Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Since: "2.2.0", Summary: "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist.", Errors: []string{"ERR bit offset is not an integer or out of range", "ERR bit is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GETBIT":           {Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Since: "2.2.0", Summary: "Returns a bit value by offset.", Errors: []string{"ERR bit offset is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BITCOUNT":         {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Since: "2.6.0", Summary: "Counts the number of set bits (population counting) in a string.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BITPOS":           {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Since: "2.8.7", Summary: "Finds the first set (1) or clear (0) bit in a string.", Errors: []string{"ERR The bit argument must be 1 or 0.", "ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BITOP":            {Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 2, LastKey: -1, Step: 1, Group: "bitmap", Since: "2.6.0", Summary: "Performs bitwise operations on multiple strings, and stores the result.", Errors: []string{"ERR syntax error", "ERR BITOP NOT must be called with a single source key.", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BITFIELD":         {Arity: -2, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Since: "3.2.0", Summary: "Performs arbitrary bitfield integer operations on strings.", Errors: []string{"ERR syntax error", "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.", "ERR bit offset is not an integer or out of range", "ERR value is not an integer or out of range", "ERR Invalid OVERFLOW type specified", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GEOADD":           {Arity: -5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "geo", Since: "3.2.0", Summary: "Adds one or more members to a geospatial index. The key is created if it doesn't exist.", Errors: []string{"ERR syntax error", "ERR value is not a valid float", "ERR invalid longitude,latitude pair", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GEOPOS":           {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "geo", Since: "3.2.0", Summary: "Returns the longitude and latitude of members from a geospatial index.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GEODIST":          {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "geo", Since: "3.2.0", Summary: "Returns the distance between two members of a geospatial index.", Errors: []string{"ERR syntax error", "ERR unsupported unit provided. please use M, KM, FT, MI", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"GEOSEARCH":        {Arity: -7, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "geo", Since: "6.2.0", Summary: "Queries a geospatial index for members inside an area of a box or a circle.", Errors: []string{"ERR syntax error", "ERR value is not a valid float", "ERR invalid longitude,latitude pair", "ERR need numeric radius", "ERR radius cannot be negative", "ERR need numeric width", "ERR need numeric height", "ERR height or width cannot be negative", "ERR unsupported unit provided. please use M, KM, FT, MI", "ERR COUNT must be > 0", "ERR the ANY argument requires COUNT argument", "ERR could not decode requested zset member", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XADD":             {Arity: -5, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Appends a new message to a stream. Creates the key if it doesn't exist.", Errors: []string{"ERR syntax error, MAXLEN and MINID options at the same time are not compatible", "ERR syntax error, LIMIT cannot be used without the special ~ option", "ERR value is not an integer or out of range", "ERR The MAXLEN argument must be >= 0.", "ERR The LIMIT argument must be >= 0.", "ERR Invalid stream ID specified as stream command argument", "ERR The ID specified in XADD must be greater than 0-0", "ERR The ID specified in XADD is equal or smaller than the target stream top item", "ERR The stream has exhausted the last possible ID, unable to add more items", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XLEN":             {Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Return the number of messages in a stream.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XRANGE":           {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Returns the messages from a stream within a range of IDs.", Errors: []string{"ERR Invalid stream ID specified as stream command argument", "ERR invalid start ID for the interval", "ERR invalid end ID for the interval", "ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XREVRANGE":        {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Returns the messages from a stream within a range of IDs in reverse order.", Errors: []string{"ERR Invalid stream ID specified as stream command argument", "ERR invalid start ID for the interval", "ERR invalid end ID for the interval", "ERR syntax error", "ERR value is not an integer or out of range", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XSETID":           {Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "An internal command for replicating stream values.", Errors: []string{"ERR Invalid stream ID specified as stream command argument", "ERR syntax error", "ERR value is not an integer or out of range", "ERR entries_added must be positive", "ERR no such key", "ERR The ID specified in XSETID is smaller than the target stream top item", "ERR The entries_added specified in XSETID is smaller than the target stream length", "ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XREAD":            {Arity: -4, Flags: []string{"readonly", "blocking", "movablekeys"}, Group: "stream", Since: "5.0.0", Summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR timeout is not an integer or out of range", "ERR timeout is negative", "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.", "ERR Invalid stream ID specified as stream command argument", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XGROUP":           {Arity: -2, Flags: []string{"write", "denyoom"}, FirstKey: 2, LastKey: 2, Step: 1, Group: "stream", Since: "5.0.0", Summary: "A container for consumer groups commands.", Errors: []string{"ERR unknown subcommand", "ERR syntax error", "ERR value is not an integer or out of range", "ERR value for ENTRIESREAD must be positive or -1", "ERR Invalid stream ID specified as stream command argument", "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.", "NOGROUP No such consumer group", "BUSYGROUP Consumer Group name already exists", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XREADGROUP":       {Arity: -7, Flags: []string{"write", "blocking", "movablekeys"}, Group: "stream", Since: "5.0.0", Summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR timeout is not an integer or out of range", "ERR timeout is negative", "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.", "ERR Missing GROUP option for XREADGROUP", "ERR Invalid stream ID specified as stream command argument", "ERR The $ ID is meaningless in the context of XREADGROUP", "NOGROUP No such key", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XACK":             {Arity: -4, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.", Errors: []string{"ERR Invalid stream ID specified as stream command argument", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XPENDING":         {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Returns the information and entries from a stream consumer group's pending entries list.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR Invalid stream ID specified as stream command argument", "ERR invalid start ID for the interval", "ERR invalid end ID for the interval", "NOGROUP No such key", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XCLAIM":           {Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.", Errors: []string{"ERR Invalid min-idle-time argument for XCLAIM", "ERR Invalid IDLE option argument for XCLAIM", "ERR Invalid TIME option argument for XCLAIM", "ERR Invalid RETRYCOUNT option argument for XCLAIM", "ERR Invalid stream ID specified as stream command argument", "ERR Unrecognized XCLAIM option", "NOGROUP No such key", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XDEL":             {Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Returns the number of messages after removing them from a stream.", Errors: []string{"ERR Invalid stream ID specified as stream command argument", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XTRIM":            {Arity: -4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "5.0.0", Summary: "Deletes messages from the beginning of a stream.", Errors: []string{"ERR syntax error", "ERR value is not an integer or out of range", "ERR The MAXLEN argument must be >= 0.", "ERR The LIMIT argument must be >= 0.", "ERR Invalid stream ID specified as stream command argument", "ERR syntax error, MAXLEN and MINID options at the same time are not compatible", "ERR syntax error, LIMIT cannot be used without the special ~ option", "ERR syntax error, XTRIM must be called with a trimming strategy", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XINFO":            {Arity: -2, Flags: []string{"readonly"}, FirstKey: 2, LastKey: 2, Step: 1, Group: "stream", Since: "5.0.0", Summary: "A container for stream introspection commands.", Errors: []string{"ERR unknown subcommand", "ERR syntax error", "ERR value is not an integer or out of range", "ERR no such key", "NOGROUP No such consumer group", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"XAUTOCLAIM":       {Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "stream", Since: "6.2.0", Summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.", Errors: []string{"ERR Invalid min-idle-time argument for XAUTOCLAIM", "ERR Invalid stream ID specified as stream command argument", "ERR invalid start ID for the interval", "ERR value is not an integer or out of range", "ERR COUNT must be > 0", "ERR syntax error", "NOGROUP No such key", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"JSON.SET":         {Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "json", Since: "1.0.0", Summary: "Sets or updates the JSON value at a path.", Errors: []string{"ERR new objects must be created at the root", "ERR JSON Path error", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"JSON.GET":         {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "json", Since: "1.0.0", Summary: "Gets the value at one or more paths in JSON serialized form.", Errors: []string{"ERR JSON Path error", "ERR Path does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"JSON.DEL":         {Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "json", Since: "1.0.0", Summary: "Deletes a value.", Errors: []string{"ERR JSON Path error", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"JSON.FORGET":      {Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "json", Since: "1.0.0", Summary: "Deletes a value.", Errors: []string{"ERR JSON Path error", "ERR syntax error", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"JSON.NUMINCRBY":   {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "json", Since: "1.0.0", Summary: "Increments the numeric value at path by a value.", Errors: []string{"ERR JSON Path error", "ERR Path does not exist", "ERR wrong type of path value", "ERR result is not a number", "ERR could not perform this operation on a key that doesn't exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.RESERVE":       {Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Creates a new Bloom Filter.", Errors: []string{"ERR bad error rate", "ERR (0 < error rate range < 1)", "ERR bad capacity", "ERR (capacity should be larger than 0)", "ERR bad expansion", "ERR expansion should be greater or equal to 1", "ERR Nonscaling filters cannot expand", "ERR syntax error", "ERR item exists", "ERR Insufficient memory to create filter"}},
+	"BF.ADD":           {Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Adds an item to a Bloom Filter.", Errors: []string{"ERR non scaling filter is full", "ERR Insufficient memory to create filter", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.MADD":          {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Adds one or more items to a Bloom Filter. A filter will be created if it does not exist.", Errors: []string{"ERR non scaling filter is full", "ERR Insufficient memory to create filter", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.EXISTS":        {Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Checks whether an item exists in a Bloom Filter.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.MEXISTS":       {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Checks whether one or more items exist in a Bloom Filter.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.INFO":          {Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Returns information about a Bloom Filter.", Errors: []string{"ERR not found", "ERR Invalid information value", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.SCANDUMP":      {Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Begins an incremental save of the bloom filter.", Errors: []string{"ERR Second argument must be numeric", "ERR not found", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"BF.LOADCHUNK":     {Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bf", Since: "1.0.0", Summary: "Restores a filter previously saved using SCANDUMP.", Errors: []string{"ERR Second argument must be numeric", "ERR invalid chunk", "ERR item exists", "ERR no such key", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.RESERVE":       {Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Creates a new Cuckoo Filter.", Errors: []string{"ERR Bad capacity", "ERR Bad bucket size", "ERR MAXITERATIONS parameter needs to be a positive integer", "ERR EXPANSION parameter needs to be a non-negative integer", "ERR syntax error", "ERR item exists", "ERR Insufficient memory to create filter"}},
+	"CF.ADD":           {Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Adds an item to a Cuckoo Filter.", Errors: []string{"ERR Filter is full", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.ADDNX":         {Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Adds an item to a Cuckoo Filter if the item did not exist previously.", Errors: []string{"ERR Filter is full", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.EXISTS":        {Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Checks whether one or more items exist in a Cuckoo Filter.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.MEXISTS":       {Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Checks whether one or more items exist in a Cuckoo Filter.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.DEL":           {Arity: 3, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Deletes an item from a Cuckoo Filter.", Errors: []string{"ERR Not found", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.COUNT":         {Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Return the number of times an item might be in a Cuckoo Filter.", Errors: []string{"WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.INFO":          {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Returns information about a Cuckoo Filter.", Errors: []string{"ERR not found", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.SCANDUMP":      {Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Begins an incremental save of the bloom filter.", Errors: []string{"ERR Second argument must be numeric", "ERR not found", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"CF.LOADCHUNK":     {Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "cf", Since: "1.0.0", Summary: "Restores a filter previously saved using SCANDUMP.", Errors: []string{"ERR Second argument must be numeric", "ERR invalid chunk", "ERR item exists", "ERR no such key", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.CREATE":        {Arity: -2, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.0.0", Summary: "Create a new time series.", Errors: []string{"ERR TSDB: Couldn't parse RETENTION", "ERR TSDB: Unknown DUPLICATE_POLICY", "ERR TSDB: Invalid label", "ERR TSDB: wrong parameters", "ERR TSDB: key already exists"}},
+	"TS.ALTER":         {Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.0.0", Summary: "Update the retention, duplicate policy and labels of an existing time series.", Errors: []string{"ERR TSDB: Couldn't parse RETENTION", "ERR TSDB: Unknown DUPLICATE_POLICY", "ERR TSDB: Invalid label", "ERR TSDB: wrong parameters", "ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.ADD":           {Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.0.0", Summary: "Append a sample to a time series.", Errors: []string{"ERR TSDB: invalid timestamp, must be a nonnegative integer", "ERR TSDB: invalid value", "ERR TSDB: Couldn't parse RETENTION", "ERR TSDB: Unknown DUPLICATE_POLICY", "ERR TSDB: Invalid label", "ERR TSDB: wrong parameters", "ERR TSDB: Timestamp is older than retention", "ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.MADD":          {Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 3, Group: "timeseries", Since: "1.0.0", Summary: "Append new samples to one or more time series.", Errors: []string{"ERR TSDB: invalid timestamp, must be a nonnegative integer", "ERR TSDB: invalid value", "ERR TSDB: Timestamp is older than retention", "ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode", "ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.GET":           {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.0.0", Summary: "Get the sample with the highest timestamp from a given time series.", Errors: []string{"ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.RANGE":         {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.0.0", Summary: "Query a range in forward direction.", Errors: []string{"ERR TSDB: wrong fromTimestamp", "ERR TSDB: wrong toTimestamp", "ERR TSDB: Unknown aggregation type", "ERR TSDB: bucketDuration must be greater than zero", "ERR TSDB: Couldn't parse COUNT", "ERR TSDB: wrong parameters", "ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.REVRANGE":      {Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.4.0", Summary: "Query a range in reverse direction.", Errors: []string{"ERR TSDB: wrong fromTimestamp", "ERR TSDB: wrong toTimestamp", "ERR TSDB: Unknown aggregation type", "ERR TSDB: bucketDuration must be greater than zero", "ERR TSDB: Couldn't parse COUNT", "ERR TSDB: wrong parameters", "ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.DEL":           {Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.6.0", Summary: "Delete all samples between two timestamps for a given time series.", Errors: []string{"ERR TSDB: wrong fromTimestamp", "ERR TSDB: wrong toTimestamp", "ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.INFO":          {Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "timeseries", Since: "1.0.0", Summary: "Returns information and statistics for a time series.", Errors: []string{"ERR TSDB: the key does not exist", "WRONGTYPE Operation against a key holding the wrong kind of value"}},
+	"TS.QUERYINDEX":    {Arity: -2, Flags: []string{"readonly"}, Group: "timeseries", Since: "1.0.0", Summary: "Get all time series keys matching a filter list.", Errors: []string{"ERR TSDB: failed parsing labels", "ERR TSDB: please provide at least one matcher"}},
+	"FT.CREATE":        {Arity: -5, Flags: []string{"write", "denyoom"}, Group: "search", Since: "1.0.0", Summary: "Creates an index with the given spec.", Errors: []string{"Index already exists", "Only HASH indexes are supported", "Fields arguments are missing", "Invalid field type for field `x`", "Duplicate field in schema - x", "Tag separator must be a single character"}},
+	"FT.SEARCH":        {Arity: -3, Flags: []string{"readonly"}, Group: "search", Since: "1.0.0", Summary: "Searches the index with a textual query, returning either documents or just ids.", Errors: []string{"Unknown Index name", "Syntax error at offset 0 near x", "Unknown field at offset 0 near x", "Property `x` not loaded nor in schema"}},
+	"FT.DROPINDEX":     {Arity: -2, Flags: []string{"write"}, Group: "search", Since: "2.0.0", Summary: "Deletes the index.", Errors: []string{"Unknown Index name", "ERR syntax error"}},
+	"FT.INFO":          {Arity: 2, Flags: []string{"readonly"}, Group: "search", Since: "1.0.0", Summary: "Returns information and statistics on the index.", Errors: []string{"Unknown Index name"}},
+	"FT._LIST":         {Arity: 1, Flags: []string{"readonly"}, Group: "search", Since: "2.0.0", Summary: "Returns a list of all existing indexes."},
+}
+
+// isWriteCommand reports whether the command modifies the dataset. Write
+// commands are appended to the AOF and are held back by CLIENT PAUSE WRITE.
+func isWriteCommand(name string) bool {
+	for _, flag := range Commands[name].Flags {
+		if flag == "write" {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFinders locate the keys of the commands whose keys neither sit at
+// fixed positions nor follow a count, such as those after STREAMS.
+var keyFinders = map[string]func(args []Value) []string{
+	"XREAD":      xreadKeys,
+	"XREADGROUP": xreadKeys,
+}
+
+// commandKeys returns the key arguments of a call, located through the
+// FirstKey, LastKey and Step of the command followed by the keys counted
+// by its NumKeys argument, or by its key finder.
+func commandKeys(name string, args []Value) []string {
+	if find, ok := keyFinders[name]; ok {
+		return find(args)
+	}
+	info := Commands[name]
+	keys := []string{}
+	if info.FirstKey > 0 {
+		keys = append(keys, positionalKeys(info, args)...)
+	}
+	if info.NumKeys > 0 && info.NumKeys <= len(args) {
+		n, err := strconv.Atoi(args[info.NumKeys-1].bulk)
+		if err != nil || n <= 0 {
+			return keys
+		}
+		for _, arg := range args[info.NumKeys:min(info.NumKeys+n, len(args))] {
+			keys = append(keys, arg.bulk)
+		}
+	}
+	return keys
+}
+
+// positionalKeys returns the keys at the FirstKey, LastKey and Step
+// positions of a command.
+func positionalKeys(info CommandInfo, args []Value) []string {
+	last := info.LastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+	step := info.Step
+	if step <= 0 {
+		step = 1
+	}
+	keys := []string{}
+	for i := info.FirstKey; i <= last; i += step {
+		keys = append(keys, args[i-1].bulk)
+	}
+	return keys
+}
+
+// command handles the COMMAND command and its subcommands.
+func command(args []Value) Value {
+	if len(args) == 0 {
+		values := []Value{}
+		for _, name := range commandNames() {
+			values = append(values, commandEntry(name))
+		}
+		return Value{typ: "array", array: values}
+	}
+
+	switch strings.ToUpper(args[0].bulk) {
+	case "COUNT":
+		if len(args) != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'command|count' command"}
+		}
+		return Value{typ: "integer", num: len(commandNames())}
+	case "LIST":
+		if len(args) != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'command|list' command"}
+		}
+		values := []Value{}
+		for _, name := range commandNames() {
+			values = append(values, Value{typ: "bulk", bulk: strings.ToLower(name)})
+		}
+		return Value{typ: "array", array: values}
+	case "INFO":
+		// without names COMMAND INFO describes every command, like COMMAND
+		if len(args) == 1 {
+			return command(nil)
+		}
+		values := []Value{}
+		for _, arg := range args[1:] {
+			name := strings.ToUpper(arg.bulk)
+			if _, ok := Commands[name]; !ok || !advertised(name) {
+				values = append(values, Value{typ: "null"})
+				continue
+			}
+			values = append(values, commandEntry(name))
+		}
+		return Value{typ: "array", array: values}
+	case "CONFORMANCE":
+		return commandConformance(args[1:])
+	case "DOCS":
+		names := commandNames()
+		if len(args) > 1 {
+			names = names[:0]
+			for _, arg := range args[1:] {
+				name := strings.ToUpper(arg.bulk)
+				if _, ok := Commands[name]; ok && advertised(name) {
+					names = append(names, name)
+				}
+			}
+		}
+		values := []Value{}
+		for _, name := range names {
+			values = append(values, Value{typ: "bulk", bulk: strings.ToLower(name)}, commandDocs(name))
+		}
+		return Value{typ: "array", array: values}
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try COMMAND HELP."}
+	}
+}
+
+// commandNames returns every command name advertised for the configured
+// redis-compat-version in alphabetical order, so that COMMAND replies are
+// stable between calls.
+func commandNames() []string {
+	names := make([]string, 0, len(Commands))
+	for name := range Commands {
+		if advertised(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandEntry builds the COMMAND INFO reply for a single command: name,
+// arity, flags, first key, last key, step, ACL categories, tips, key
+// specifications and subcommands. Like Redis, ACL categories are only
+// included from 6.0 and the last three fields only from 7.0 on.
+func commandEntry(name string) Value {
+	info := Commands[name]
+
+	flags := []Value{}
+	for _, flag := range info.Flags {
+		flags = append(flags, Value{typ: "string", str: flag})
+	}
+
+	categories := []Value{}
+	if info.Group != "" {
+		categories = append(categories, Value{typ: "string", str: "@" + info.Group})
+	}
+	for _, flag := range info.Flags {
+		switch flag {
+		case "write", "readonly", "admin", "fast":
+			categories = append(categories, Value{typ: "string", str: "@" + flag})
+		}
+	}
+
+	entry := []Value{
+		{typ: "bulk", bulk: strings.ToLower(name)},
+		{typ: "integer", num: info.Arity},
+		{typ: "array", array: flags},
+		{typ: "integer", num: info.FirstKey},
+		{typ: "integer", num: info.LastKey},
+		{typ: "integer", num: info.Step},
+	}
+	if compatAtLeast(6, 0) {
+		entry = append(entry, Value{typ: "array", array: categories})
+	}
+	if compatAtLeast(7, 0) {
+		entry = append(entry,
+			Value{typ: "array", array: []Value{}},
+			Value{typ: "array", array: []Value{}},
+			Value{typ: "array", array: []Value{}},
+		)
+	}
+
+	return Value{typ: "array", array: entry}
+}
+
+// commandDocs builds the COMMAND DOCS reply for a single command as a flat
+// array of alternating field names and values.
+func commandDocs(name string) Value {
+	info := Commands[name]
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "summary"}, {typ: "bulk", bulk: info.Summary},
+		{typ: "bulk", bulk: "since"}, {typ: "bulk", bulk: info.Since},
+		{typ: "bulk", bulk: "group"}, {typ: "bulk", bulk: info.Group},
+	}}
+}
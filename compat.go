@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// redisCompatVersion is the Redis version gostore presents itself as. It is
+// reported as redis_version by INFO and limits COMMAND to the commands and
+// reply shape that version had, so that client libraries which gate
+// features on the server version negotiate what gostore actually supports.
+var redisCompatVersion = "7.2.0"
+
+// compatVersion is redisCompatVersion parsed into major, minor and patch.
+var compatVersion = [3]int{7, 2, 0}
+
+// compatMu guards redisCompatVersion and compatVersion.
+var compatMu = sync.RWMutex{}
+
+// parseVersion parses a "major.minor[.patch]" version.
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, errors.New("version must be in the form major.minor[.patch]")
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, errors.New("version must be in the form major.minor[.patch]")
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// getCompatVersion returns the configured compatibility version.
+func getCompatVersion() string {
+	compatMu.RLock()
+	defer compatMu.RUnlock()
+
+	return redisCompatVersion
+}
+
+// setCompatVersion changes the compatibility version.
+func setCompatVersion(s string) error {
+	v, err := parseVersion(s)
+	if err != nil {
+		return err
+	}
+	if v[0] < 1 {
+		return errors.New("version must be at least 1.0.0")
+	}
+
+	compatMu.Lock()
+	redisCompatVersion = s
+	compatVersion = v
+	compatMu.Unlock()
+
+	return nil
+}
+
+// compatAtLeast reports whether the compatibility version is at least
+// major.minor.
+func compatAtLeast(major, minor int) bool {
+	compatMu.RLock()
+	defer compatMu.RUnlock()
+
+	return compatVersion[0] > major || compatVersion[0] == major && compatVersion[1] >= minor
+}
+
+// advertised reports whether the command already existed in the Redis
+// version gostore is compatible with, and so is listed by COMMAND.
+func advertised(name string) bool {
+	since, err := parseVersion(Commands[name].Since)
+	if err != nil {
+		return true
+	}
+
+	compatMu.RLock()
+	defer compatMu.RUnlock()
+
+	for i := range since {
+		if since[i] != compatVersion[i] {
+			return since[i] < compatVersion[i]
+		}
+	}
+	return true
+}
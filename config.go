@@ -0,0 +1,883 @@
+// Runtime configuration.
+//
+// Every tunable setting is registered once in configParams together with the
+// code to read and change it. The registry backs the command line options,
+// CONFIG GET/SET at runtime and CONFIG REWRITE, so a setting only has to be
+// described in a single place.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configParam is a single registered setting.
+type configParam struct {
+	name string
+	// usage is the help text of the command line option
+	usage string
+	// get renders the current value as shown by CONFIG GET
+	get func() string
+	// set parses and applies a new value
+	set func(string) error
+	// immutable settings can only be given at startup
+	immutable bool
+	// def is the value at startup before any option was applied, CONFIG
+	// REWRITE leaves settings still at their default out of the file
+	def string
+	// cmdline is set when the setting was given as a command line option,
+	// which overrides the configuration file on reload too
+	cmdline bool
+}
+
+// configFile is the path of the configuration file the server was started
+// with, empty when it was started without one.
+var configFile string
+
+// configParams lists every registered setting in alphabetical order.
+var configParams = []*configParam{
+	{
+		name:  "alert-aof-size",
+		usage: "AOF size above which an alert is raised, e.g. 1gb (0 to disable)",
+		get:   func() string { return strconv.FormatInt(alertAofSize.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseMemory(s)
+			if err != nil {
+				return err
+			}
+			alertAofSize.Store(n)
+			return nil
+		},
+	},
+	{
+		name:  "alert-clients-percent",
+		usage: "percentage of maxclients above which an alert is raised (0 to disable)",
+		get:   func() string { return strconv.FormatInt(alertClientsPercent.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err != nil || n > 100 {
+				return errors.New("argument must be between 0 and 100 inclusive")
+			}
+			alertClientsPercent.Store(int64(n))
+			return nil
+		},
+	},
+	{
+		name:  "alert-memory-percent",
+		usage: "percentage of maxmemory above which an alert is raised (0 to disable)",
+		get:   func() string { return strconv.FormatInt(alertMemoryPercent.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err != nil || n > 100 {
+				return errors.New("argument must be between 0 and 100 inclusive")
+			}
+			alertMemoryPercent.Store(int64(n))
+			return nil
+		},
+	},
+	{
+		name:  "alert-webhook",
+		usage: "URL alerts are posted to as JSON (empty to disable)",
+		get: func() string {
+			alertWebhookMu.RLock()
+			defer alertWebhookMu.RUnlock()
+			return alertWebhook
+		},
+		set: func(s string) error {
+			if s != "" && !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+				return errors.New("webhook must be an http:// or https:// URL")
+			}
+			alertWebhookMu.Lock()
+			alertWebhook = s
+			alertWebhookMu.Unlock()
+			return nil
+		},
+	},
+	{
+		name:      "appendfilename",
+		usage:     "name of the append only file",
+		immutable: true,
+		get:       func() string { return appendfilename },
+		set:       func(s string) error { appendfilename = s; return nil },
+	},
+	{
+		name:  "appendfsync",
+		usage: "when to flush the append only file to disk (always/everysec/no)",
+		get: func() string {
+			return [...]string{fsyncAlways: "always", fsyncEverySec: "everysec", fsyncNo: "no"}[appendfsync.Load()]
+		},
+		set: func(s string) error {
+			switch strings.ToLower(s) {
+			case "always":
+				appendfsync.Store(fsyncAlways)
+			case "everysec":
+				appendfsync.Store(fsyncEverySec)
+			case "no":
+				appendfsync.Store(fsyncNo)
+			default:
+				return errors.New("argument(s) must be one of the following: always, everysec, no")
+			}
+			return nil
+		},
+	},
+	{
+		name:      "appendonly",
+		usage:     "log every write to the append only file (yes/no)",
+		immutable: true,
+		get:       func() string { return formatYesNo(appendonly) },
+		set:       func(s string) (err error) { appendonly, err = parseYesNo(s); return err },
+	},
+	{
+		name:  "bind",
+		usage: "space separated addresses to listen on (all interfaces when empty)",
+		// listeners are opened once at startup
+		immutable: true,
+		get:       func() string { return bind },
+		set:       func(s string) error { bind = strings.Join(strings.Fields(s), " "); return nil },
+	},
+	{
+		name:  "conformance-mode",
+		usage: "enable COMMAND CONFORMANCE for compatibility test suites (yes/no)",
+		get:   func() string { return formatYesNo(conformanceMode.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			conformanceMode.Store(b)
+			return nil
+		},
+	},
+	{
+		name:  "dbfilename",
+		usage: "name of the snapshot file",
+		get: func() string {
+			saveMu.Lock()
+			defer saveMu.Unlock()
+			return dbfilename
+		},
+		set: func(s string) error {
+			if s == "" || filepath.Base(s) != s {
+				return errors.New("dbfilename can't be a path, just a filename")
+			}
+			saveMu.Lock()
+			dbfilename = s
+			saveMu.Unlock()
+			return nil
+		},
+	},
+	{
+		name:  "deadlock-detector",
+		usage: "report lock-order inversions and long lock waits (yes/no, builds with -tags lockdebug only)",
+		get:   func() string { return formatYesNo(deadlockDetector.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			return setDeadlockDetector(b)
+		},
+	},
+	{
+		name:  "dir",
+		usage: "working directory for the snapshot file",
+		get: func() string {
+			saveMu.Lock()
+			defer saveMu.Unlock()
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return dir
+			}
+			return abs
+		},
+		set: func(s string) error {
+			if st, err := os.Stat(s); err != nil || !st.IsDir() {
+				return errors.New("No such file or directory")
+			}
+			saveMu.Lock()
+			dir = s
+			saveMu.Unlock()
+			return nil
+		},
+	},
+	{
+		name:  "hash-max-listpack-entries",
+		usage: "most fields a hash keeps in the compact encoding",
+		get:   func() string { return strconv.FormatInt(hashMaxListpackEntries.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err == nil {
+				hashMaxListpackEntries.Store(int64(n))
+			}
+			return err
+		},
+	},
+	{
+		name:  "hash-max-listpack-value",
+		usage: "longest field name or value a hash keeps in the compact encoding",
+		get:   func() string { return strconv.FormatInt(hashMaxListpackValue.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err == nil {
+				hashMaxListpackValue.Store(int64(n))
+			}
+			return err
+		},
+	},
+	{
+		name:  "idgen-node-id",
+		usage: "node id (0-1023) embedded in IDGEN SNOWFLAKE IDs",
+		get:   func() string { return strconv.FormatInt(idgenNode.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err != nil || n > idgenMaxNode {
+				return errors.New("argument must be between 0 and 1023 inclusive")
+			}
+			idgenNode.Store(int64(n))
+			return nil
+		},
+	},
+	{
+		name:  "intern-max-len",
+		usage: "longest string considered for interning",
+		// changing the limit at runtime would leave strings interned under
+		// the old limit unreleased, so it is fixed at startup
+		immutable: true,
+		get:       func() string { return strconv.Itoa(internMaxLen) },
+		set:       func(s string) (err error) { internMaxLen, err = parseNonNegative(s); return err },
+	},
+	{
+		name:  "intern-values",
+		usage: "share a single copy of repeated small values and hash field names (yes/no)",
+		get:   func() string { return formatYesNo(internEnabled.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			internEnabled.Store(b)
+			return nil
+		},
+	},
+	{
+		name:  "list-max-listpack-size",
+		usage: "most elements, or when negative the size class from -1 (4 KB) to -5 (64 KB), of a list node",
+		get:   func() string { return strconv.FormatInt(listMaxListpackSize.Load(), 10) },
+		set: func(s string) error {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n == 0 || n < -5 {
+				return errors.New("argument must be a positive number of elements or -1 to -5")
+			}
+			listMaxListpackSize.Store(n)
+			return nil
+		},
+	},
+	{
+		name:  "loglevel",
+		usage: "log verbosity (debug/verbose/notice/warning)",
+		get:   func() string { return logLevelNames[loglevel.Load()] },
+		set: func(s string) error {
+			level, err := parseLogLevel(s)
+			if err == nil {
+				loglevel.Store(level)
+			}
+			return err
+		},
+	},
+	{
+		name:  "maxclients",
+		usage: "maximum number of connected clients",
+		get:   func() string { return strconv.FormatInt(maxclients.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err != nil || n < 1 {
+				return errors.New("argument must be between 1 and 2147483647 inclusive")
+			}
+			maxclients.Store(int64(n))
+			return nil
+		},
+	},
+	{
+		name:  "maxmemory",
+		usage: "memory budget for the dataset, e.g. 512mb (0 for no limit)",
+		get:   func() string { return strconv.FormatInt(maxmemory.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseMemory(s)
+			if err != nil {
+				return err
+			}
+			maxmemory.Store(n)
+			tuneGC()
+			return nil
+		},
+	},
+	{
+		name:      "port",
+		usage:     "TCP port to listen on",
+		immutable: true,
+		get:       func() string { return strconv.Itoa(port) },
+		set: func(s string) error {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n > 65535 {
+				return errors.New("argument must be between 0 and 65535 inclusive")
+			}
+			port = n
+			return nil
+		},
+	},
+	{
+		name:  "protected-mode",
+		usage: "only accept loopback connections when bound to all interfaces without a password (yes/no)",
+		get:   func() string { return formatYesNo(protectedMode.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			protectedMode.Store(b)
+			return nil
+		},
+	},
+	{
+		name:  "read-mostly",
+		usage: "serve GET from lock-free snapshots for read-heavy workloads (yes/no)",
+		get:   func() string { return formatYesNo(readMostly.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err == nil {
+				setReadMostly(b)
+			}
+			return err
+		},
+	},
+	{
+		name:  "redis-compat-version",
+		usage: "Redis version reported by INFO and used to select the commands listed by COMMAND",
+		get:   getCompatVersion,
+		set:   setCompatVersion,
+	},
+	{
+		name:  "reply-ordering",
+		usage: "order of hash fields in replies: none or lexicographic (gostore extension)",
+		get:   func() string { return formatReplyOrdering(sortedReplies.Load()) },
+		set: func(s string) error {
+			sorted, err := parseReplyOrdering(s)
+			if err != nil {
+				return err
+			}
+			sortedReplies.Store(sorted)
+			return nil
+		},
+	},
+	{
+		name:  "requirepass",
+		usage: "password clients must AUTH with (empty disables authentication)",
+		get:   getRequirepass,
+		set:   func(s string) error { setRequirepass(s); return nil },
+	},
+	{
+		name:  "reuseport",
+		usage: "accept connections on one SO_REUSEPORT socket per CPU (yes/no)",
+		// listeners are opened once at startup
+		immutable: true,
+		get:       func() string { return formatYesNo(reusePort) },
+		set:       func(s string) (err error) { reusePort, err = parseYesNo(s); return err },
+	},
+	{
+		name:  "save",
+		usage: `snapshot rules as "seconds changes" pairs, e.g. "3600 1 300 100" ("" disables)`,
+		get: func() string {
+			saveMu.Lock()
+			defer saveMu.Unlock()
+			return formatSaveRules(saveRules)
+		},
+		set: func(s string) error {
+			rules, err := parseSaveRules(s)
+			if err != nil {
+				return err
+			}
+			saveMu.Lock()
+			saveRules = rules
+			saveMu.Unlock()
+			return nil
+		},
+	},
+	{
+		name:  "set-max-intset-entries",
+		usage: "most members a set of integers keeps in the compact encoding",
+		get:   func() string { return strconv.FormatInt(setMaxIntsetEntries.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err == nil {
+				setMaxIntsetEntries.Store(int64(n))
+			}
+			return err
+		},
+	},
+	{
+		name:  "slab-values",
+		usage: "store small string values in slab memory (yes/no)",
+		// values already stored in slabs must keep being copied out on
+		// read, so slab storage can not be switched off at runtime
+		immutable: true,
+		get:       func() string { return formatYesNo(slabEnabled) },
+		set:       func(s string) (err error) { slabEnabled, err = parseYesNo(s); return err },
+	},
+	{
+		name:  "slowlog-log-slower-than",
+		usage: "execution time in microseconds above which commands are logged (-1 disables)",
+		get:   func() string { return strconv.FormatInt(slowlogSlowerThan.Load(), 10) },
+		set: func(s string) error {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < -1 {
+				return errors.New("argument must be -1 or greater")
+			}
+			slowlogSlowerThan.Store(n)
+			return nil
+		},
+	},
+	{
+		name:  "slowlog-max-len",
+		usage: "number of slow log entries kept",
+		get:   func() string { return strconv.FormatInt(slowlogMaxLen.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err != nil {
+				return err
+			}
+			slowlogMaxLen.Store(int64(n))
+			return nil
+		},
+	},
+	{
+		name:  "strict-arguments",
+		usage: "reject non-UTF-8 keys and malformed or out of range numbers (yes/no)",
+		get:   func() string { return formatYesNo(strictArguments.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			strictArguments.Store(b)
+			return nil
+		},
+	},
+	{
+		name:  "tcp-keepalive",
+		usage: "seconds between TCP keepalive probes to clients, 0 to disable (applies to new connections)",
+		get:   func() string { return strconv.FormatInt(tcpKeepAlive.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err != nil {
+				return err
+			}
+			tcpKeepAlive.Store(int64(n))
+			return nil
+		},
+	},
+	{
+		name:  "tcp-nodelay",
+		usage: "disable Nagle's algorithm on client connections (yes/no, applies to new connections)",
+		get:   func() string { return formatYesNo(tcpNoDelay.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			tcpNoDelay.Store(b)
+			return nil
+		},
+	},
+	{
+		name:  "track-key-access",
+		usage: "record the last read and write time of every key for OBJECT LASTACCESS (yes/no)",
+		get:   func() string { return formatYesNo(accessTracking.Load()) },
+		set: func(s string) error {
+			b, err := parseYesNo(s)
+			if err != nil {
+				return err
+			}
+			setAccessTracking(b)
+			return nil
+		},
+	},
+	{
+		name:  "zset-max-listpack-entries",
+		usage: "most members a sorted set keeps in the compact encoding",
+		get:   func() string { return strconv.FormatInt(zsetMaxListpackEntries.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err == nil {
+				zsetMaxListpackEntries.Store(int64(n))
+			}
+			return err
+		},
+	},
+	{
+		name:  "zset-max-listpack-value",
+		usage: "longest member a sorted set keeps in the compact encoding",
+		get:   func() string { return strconv.FormatInt(zsetMaxListpackValue.Load(), 10) },
+		set: func(s string) error {
+			n, err := parseNonNegative(s)
+			if err == nil {
+				zsetMaxListpackValue.Store(int64(n))
+			}
+			return err
+		},
+	},
+}
+
+// recordConfigDefaults remembers the current value of every setting as its
+// default. It runs at the start of main, after all package initialisation
+// and before the configuration file or options are applied.
+func recordConfigDefaults() {
+	for _, p := range configParams {
+		p.def = p.get()
+	}
+}
+
+// configDirective is a single "name value" line of a configuration file.
+type configDirective struct {
+	line  int
+	param *configParam
+	value string
+}
+
+// parseConfigFile reads a redis.conf-style configuration file: one
+// "name value ..." directive per line, blank lines and lines starting with
+// '#' ignored, and values with spaces given in double or single quotes.
+// Repeated save lines add up to a single list of rules.
+func parseConfigFile(path string) ([]configDirective, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	directives := []configDirective{}
+	saves := []string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields, err := splitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		p := lookupConfig(fields[0])
+		if p == nil || len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: Bad directive or wrong number of arguments", i+1)
+		}
+
+		value := strings.Join(fields[1:], " ")
+		if p.name == "save" {
+			saves = append(saves, value)
+			value = strings.Join(saves, " ")
+		}
+		directives = append(directives, configDirective{line: i + 1, param: p, value: value})
+	}
+
+	return directives, nil
+}
+
+// loadConfigFile applies every directive of the configuration file at path.
+func loadConfigFile(path string) error {
+	directives, err := parseConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range directives {
+		if err := d.param.set(d.value); err != nil {
+			return fmt.Errorf("line %d: %s: %v", d.line, d.param.name, err)
+		}
+	}
+	return nil
+}
+
+// reloadConfigFile re-reads the configuration file on SIGHUP and applies the
+// settings that can change at runtime, without touching connections. A
+// setting no longer present in the file goes back to its default. Settings
+// given as command line options keep their value, like at startup where
+// they override the file. Settings that are fixed at startup keep their
+// value and a changed value in the file is reported as needing a restart.
+// The file is validated as a whole first, so a broken file changes nothing.
+func reloadConfigFile() {
+	if configFile == "" {
+		serverLog(logWarning, "Received SIGHUP but the server is running without a config file")
+		return
+	}
+
+	directives, err := parseConfigFile(configFile)
+	if err != nil {
+		serverLog(logWarning, "Config reload failed, keeping the current settings: %v", err)
+		return
+	}
+
+	values := map[*configParam]string{}
+	for _, d := range directives {
+		values[d.param] = d.value
+	}
+
+	for _, p := range configParams {
+		if p.cmdline {
+			continue
+		}
+		value, ok := values[p]
+		if !ok {
+			value = p.def
+		}
+		if value == p.get() {
+			continue
+		}
+		if p.immutable {
+			serverLog(logWarning, "Config reload: '%s' can't be changed at runtime, restart the server to apply it", p.name)
+			continue
+		}
+		if err := p.set(value); err != nil {
+			serverLog(logWarning, "Config reload: invalid value for '%s': %v", p.name, err)
+			continue
+		}
+		serverLog(logNotice, "Config reload: '%s' set to '%s'", p.name, p.get())
+	}
+}
+
+// splitArgs splits a configuration line into arguments the way Redis does,
+// honouring double quotes (with backslash escapes) and single quotes.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+
+		switch line[0] {
+		case '"':
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unbalanced quotes in configuration line")
+			}
+			arg, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			line = line[end+1:]
+		case '\'':
+			end := strings.IndexByte(line[1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unbalanced quotes in configuration line")
+			}
+			args = append(args, line[1:end+1])
+			line = line[end+2:]
+		default:
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+// lookupConfig returns the setting with the given (case-insensitive) name.
+func lookupConfig(name string) *configParam {
+	name = strings.ToLower(name)
+	for _, p := range configParams {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// registerConfigFlags exposes every setting as a command line option.
+func registerConfigFlags(fs *flag.FlagSet) {
+	for _, p := range configParams {
+		fs.Func(p.name, p.usage, func(s string) error {
+			p.cmdline = true
+			return p.set(s)
+		})
+	}
+}
+
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+func formatYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func parseNonNegative(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	return n, nil
+}
+
+// config handles the CONFIG command and its subcommands.
+func config(args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'config' command"}
+	}
+
+	switch strings.ToUpper(args[0].bulk) {
+	case "GET":
+		return configGet(args[1:])
+	case "SET":
+		return configSet(args[1:])
+	case "REWRITE":
+		if len(args) != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'config|rewrite' command"}
+		}
+		if configFile == "" {
+			return Value{typ: "error", str: "ERR The server is running without a config file"}
+		}
+		if err := configRewrite(configFile); err != nil {
+			return Value{typ: "error", str: "ERR Rewriting config file: " + err.Error()}
+		}
+		return Value{typ: "string", str: "OK"}
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try CONFIG HELP."}
+	}
+}
+
+// configGet implements CONFIG GET pattern [pattern ...], replying with the
+// names and values of every setting matching one of the glob patterns.
+func configGet(args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'config|get' command"}
+	}
+
+	values := []Value{}
+	for _, p := range configParams {
+		for _, pattern := range args {
+			if matchGlob(pattern.bulk, p.name, true) {
+				values = append(values, Value{typ: "bulk", bulk: p.name}, Value{typ: "bulk", bulk: p.get()})
+				break
+			}
+		}
+	}
+
+	return Value{typ: "array", array: values}
+}
+
+// configSet implements CONFIG SET name value [name value ...]. Every name is
+// checked before anything is changed, and if applying a value fails the
+// settings already applied by this call are rolled back.
+func configSet(args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'config|set' command"}
+	}
+
+	params := []*configParam{}
+	for i := 0; i < len(args); i += 2 {
+		p := lookupConfig(args[i].bulk)
+		if p == nil {
+			return Value{typ: "error", str: "ERR Unknown option or number of arguments for CONFIG SET - '" + args[i].bulk + "'"}
+		}
+		if p.immutable {
+			return Value{typ: "error", str: "ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - can't set immutable config"}
+		}
+		params = append(params, p)
+	}
+
+	old := make([]string, len(params))
+	for i, p := range params {
+		old[i] = p.get()
+		if err := p.set(args[2*i+1].bulk); err != nil {
+			for j := i; j >= 0; j-- {
+				params[j].set(old[j])
+			}
+			return Value{typ: "error", str: "ERR CONFIG SET failed (possibly related to argument '" + p.name + "') - " + err.Error()}
+		}
+	}
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// configRewrite rewrites the configuration file at path to reflect the
+// current settings. Lines setting a registered option are updated in place
+// and duplicates dropped, comments and unknown lines are kept as they are,
+// and settings changed from their default that the file did not mention yet
+// are appended at the end.
+func configRewrite(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	written := map[string]bool{}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			lines = append(lines, line)
+			continue
+		}
+		p := lookupConfig(fields[0])
+		if p == nil {
+			lines = append(lines, line)
+			continue
+		}
+		if !written[p.name] {
+			lines = append(lines, configLine(p))
+			written[p.name] = true
+		}
+	}
+
+	names := []string{}
+	for _, p := range configParams {
+		if !written[p.name] && p.get() != p.def {
+			names = append(names, p.name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		lines = append(lines, "# Generated by CONFIG REWRITE")
+		for _, name := range names {
+			lines = append(lines, configLine(lookupConfig(name)))
+		}
+	}
+
+	tmp := fmt.Sprintf("%s.temp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// configLine renders a setting as a configuration file directive, quoting
+// the value when it is empty or contains spaces.
+func configLine(p *configParam) string {
+	value := p.get()
+	if value == "" || strings.ContainsAny(value, " \t\"") {
+		value = strconv.Quote(value)
+	}
+	return p.name + " " + value
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// conformanceMode enables COMMAND CONFORMANCE, which describes the declared
+// surface of commands (arity, flags, key positions and error replies) in a
+// form an external compatibility suite can diff against real Redis. It is
+// off by default since it is only meant for testing.
+var conformanceMode atomic.Bool
+
+// arityError returns the error sent when a command is called with the
+// wrong number of arguments.
+func arityError(name string) Value {
+	return Value{typ: "error", str: "ERR wrong number of arguments for '" + strings.ToLower(name) + "' command"}
+}
+
+// arityOK reports whether argc, the number of arguments including the
+// command name, satisfies the declared arity of the command.
+func arityOK(name string, argc int) bool {
+	arity := Commands[name].Arity
+	if arity < 0 {
+		return argc >= -arity
+	}
+	return argc == arity
+}
+
+// commandErrors lists every error reply, or its fixed prefix, that the
+// command can return: the generic errors that follow from its metadata
+// and those it declares itself.
+func commandErrors(name string) []string {
+	info := Commands[name]
+	errors := []string{arityError(name).str}
+
+	noAuth, denyOOM := false, false
+	for _, flag := range info.Flags {
+		switch flag {
+		case "no_auth":
+			noAuth = true
+		case "denyoom":
+			denyOOM = true
+		}
+	}
+	if !noAuth {
+		errors = append(errors, "NOAUTH Authentication required.")
+	}
+	if denyOOM {
+		errors = append(errors, oomError.str)
+	}
+
+	return append(errors, info.Errors...)
+}
+
+// commandConformance implements COMMAND CONFORMANCE name [name ...]. For
+// every name it replies with a flat array of field names and values, or a
+// null for unknown commands.
+func commandConformance(args []Value) Value {
+	if !conformanceMode.Load() {
+		return Value{typ: "error", str: "ERR COMMAND CONFORMANCE is not allowed. Enable it with 'conformance-mode yes'"}
+	}
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'command|conformance' command"}
+	}
+
+	values := []Value{}
+	for _, arg := range args {
+		name := strings.ToUpper(arg.bulk)
+		info, ok := Commands[name]
+		if !ok {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+
+		flags := []Value{}
+		for _, flag := range info.Flags {
+			flags = append(flags, Value{typ: "bulk", bulk: flag})
+		}
+		errors := []Value{}
+		for _, e := range commandErrors(name) {
+			errors = append(errors, Value{typ: "bulk", bulk: e})
+		}
+
+		values = append(values, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: strings.ToLower(name)},
+			{typ: "bulk", bulk: "arity"}, {typ: "integer", num: info.Arity},
+			{typ: "bulk", bulk: "flags"}, {typ: "array", array: flags},
+			{typ: "bulk", bulk: "first-key"}, {typ: "integer", num: info.FirstKey},
+			{typ: "bulk", bulk: "last-key"}, {typ: "integer", num: info.LastKey},
+			{typ: "bulk", bulk: "step"}, {typ: "integer", num: info.Step},
+			{typ: "bulk", bulk: "since"}, {typ: "bulk", bulk: info.Since},
+			{typ: "bulk", bulk: "errors"}, {typ: "array", array: errors},
+		}})
+	}
+
+	return Value{typ: "array", array: values}
+}
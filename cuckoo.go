@@ -0,0 +1,568 @@
+// Cuckoo filters.
+//
+// A cuckoo filter answers the same "was this item added before?" as a Bloom
+// filter, with a small rate of false positives, but can also delete items,
+// like the cuckoo filters of the RedisBloom module:
+//
+//	CF.RESERVE key capacity [BUCKETSIZE bucketsize] [MAXITERATIONS maxiterations] [EXPANSION expansion]
+//	CF.ADD key item
+//	CF.ADDNX key item
+//	CF.EXISTS key item
+//	CF.MEXISTS key item [item ...]
+//	CF.DEL key item
+//	CF.COUNT key item
+//	CF.INFO key
+//	CF.SCANDUMP key iterator
+//	CF.LOADCHUNK key iterator data
+//
+// The filter keeps an 8-bit fingerprint of every item in one of two
+// buckets, each holding bucketsize fingerprints, 2 by default. The second
+// bucket is derived from the first and the fingerprint alone, so when both
+// are full a fingerprint already stored can be moved to its other bucket
+// to make room, up to maxiterations times, 20 by default. When that fails
+// too the filter adds a layer expansion times larger, 1 by default, or
+// replies that it is full with an expansion of 0. CF.ADD creates a filter
+// for 1024 items. Unlike BF.ADD, CF.ADD adds an item again when it is
+// found, CF.ADDNX does not, and CF.DEL removes one copy, so an item must
+// only be deleted when it was added, or another item sharing its
+// fingerprint may be removed instead. CF.COUNT counts the copies of an
+// item, which may include other items with the same fingerprint.
+//
+// Fingerprints to move are picked in a fixed order rather than at random,
+// so replaying CF.ADD from the AOF fills the buckets the same way.
+// Snapshots restore filters with CF.LOADCHUNK, like Bloom filters, in a
+// format of this server. TYPE names the key MBbloomCF.
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Defaults of the filters created by CF.RESERVE and CF.ADD.
+const (
+	cuckooDefaultCapacity      = 1024
+	cuckooDefaultBucketSize    = 2
+	cuckooDefaultMaxIterations = 20
+	cuckooDefaultExpansion     = 1
+)
+
+// cuckooMaxSlots is the most fingerprints a layer may hold.
+const cuckooMaxSlots = 1 << 32
+
+// cuckooLayer is one fixed-size table of buckets of a cuckoo filter.
+type cuckooLayer struct {
+	// buckets is the number of buckets, a power of two
+	buckets uint64
+	// slots holds the fingerprints of every bucket in turn, 0 for an empty
+	// slot
+	slots []byte
+}
+
+// cuckooHash returns the fingerprint of item, never 0, and the hash its
+// first bucket is derived from.
+func cuckooHash(item string) (byte, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	return byte(sum%255 + 1), sum >> 8
+}
+
+// altBucket returns the other bucket of the fingerprint fp stored in bucket
+// i. Applied twice it returns i again.
+func (l *cuckooLayer) altBucket(i uint64, fp byte) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & (l.buckets - 1)
+}
+
+// bucket returns the slots of bucket i.
+func (l *cuckooLayer) bucket(i uint64, bucketSize int) []byte {
+	return l.slots[i*uint64(bucketSize) : (i+1)*uint64(bucketSize)]
+}
+
+// cuckooFilter is a cuckoo filter, made of layers added as it fills up.
+type cuckooFilter struct {
+	bucketSize, maxIterations int
+	// expansion is how many times more buckets every new layer has, 0 for
+	// a filter that does not grow
+	expansion      int
+	items, deleted int64
+	layers         []*cuckooLayer
+}
+
+// newCuckooLayer returns an empty layer of at least the given number of
+// buckets, false when it would be too large.
+func newCuckooLayer(buckets uint64, bucketSize int) (*cuckooLayer, bool) {
+	if buckets > cuckooMaxSlots/uint64(bucketSize) {
+		return nil, false
+	}
+	// round up to a power of two, so that the alternate bucket of the
+	// alternate bucket is the first one
+	if buckets&(buckets-1) != 0 {
+		buckets = 1 << bits.Len64(buckets)
+	}
+	return &cuckooLayer{buckets: buckets, slots: make([]byte, buckets*uint64(bucketSize))}, true
+}
+
+// buckets returns the first bucket of an item with the given hash in
+// layer l and its fingerprint's other one.
+func (f *cuckooFilter) buckets(l *cuckooLayer, fp byte, h uint64) (uint64, uint64) {
+	i1 := h & (l.buckets - 1)
+	return i1, l.altBucket(i1, fp)
+}
+
+// count returns how many copies of the item with fingerprint fp and hash h
+// the filter holds.
+func (f *cuckooFilter) count(fp byte, h uint64) int {
+	n := 0
+	for _, l := range f.layers {
+		i1, i2 := f.buckets(l, fp, h)
+		for _, slot := range l.bucket(i1, f.bucketSize) {
+			if slot == fp {
+				n++
+			}
+		}
+		if i2 == i1 {
+			continue
+		}
+		for _, slot := range l.bucket(i2, f.bucketSize) {
+			if slot == fp {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// place stores fp in an empty slot of bucket i of layer l, false when the
+// bucket is full.
+func (f *cuckooFilter) place(l *cuckooLayer, i uint64, fp byte) bool {
+	bucket := l.bucket(i, f.bucketSize)
+	for j, slot := range bucket {
+		if slot == 0 {
+			bucket[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// relocate stores fp in bucket i of layer l by moving the fingerprints in
+// its way to their other buckets, at most maxIterations times. When that
+// does not free a slot every move is undone and it returns false.
+func (f *cuckooFilter) relocate(l *cuckooLayer, i uint64, fp byte) bool {
+	type move struct {
+		index    uint64
+		previous byte
+	}
+	moves := []move{}
+	for n := 0; n < f.maxIterations; n++ {
+		index := i*uint64(f.bucketSize) + uint64(n%f.bucketSize)
+		moves = append(moves, move{index: index, previous: l.slots[index]})
+		fp, l.slots[index] = l.slots[index], fp
+		i = l.altBucket(i, fp)
+		if f.place(l, i, fp) {
+			return true
+		}
+	}
+	for j := len(moves) - 1; j >= 0; j-- {
+		l.slots[moves[j].index] = moves[j].previous
+	}
+	return false
+}
+
+// add adds a copy of item. It replies an error when the filter is full and
+// can not grow.
+func (f *cuckooFilter) add(item string) Value {
+	fp, h := cuckooHash(item)
+	for _, l := range f.layers {
+		i1, i2 := f.buckets(l, fp, h)
+		if f.place(l, i1, fp) || f.place(l, i2, fp) {
+			f.items++
+			return Value{}
+		}
+	}
+	last := f.layers[len(f.layers)-1]
+	i1, _ := f.buckets(last, fp, h)
+	if f.relocate(last, i1, fp) {
+		f.items++
+		return Value{}
+	}
+	if f.expansion == 0 {
+		return Value{typ: "error", str: "ERR Filter is full"}
+	}
+	next, ok := newCuckooLayer(last.buckets*uint64(f.expansion), f.bucketSize)
+	if !ok {
+		return Value{typ: "error", str: "ERR Filter is full"}
+	}
+	f.layers = append(f.layers, next)
+	i1, _ = f.buckets(next, fp, h)
+	f.place(next, i1, fp)
+	f.items++
+	return Value{}
+}
+
+// remove removes a copy of item, newest layer first, reporting whether one
+// was found.
+func (f *cuckooFilter) remove(item string) bool {
+	fp, h := cuckooHash(item)
+	for j := len(f.layers) - 1; j >= 0; j-- {
+		l := f.layers[j]
+		i1, i2 := f.buckets(l, fp, h)
+		for _, i := range []uint64{i1, i2} {
+			bucket := l.bucket(i, f.bucketSize)
+			for k, slot := range bucket {
+				if slot == fp {
+					bucket[k] = 0
+					f.items--
+					f.deleted++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// size returns the number of bytes the buckets of the filter take.
+func (f *cuckooFilter) size() int {
+	size := 0
+	for _, l := range f.layers {
+		size += len(l.slots)
+	}
+	return size
+}
+
+// cuckoo returns the filter of a cuckoo filter key, nil when the object is
+// not one.
+func (o object) cuckoo() *cuckooFilter {
+	f, _ := o.value.(*cuckooFilter)
+	return f
+}
+
+// readCuckoo returns the filter stored at key, nil when the key does not
+// exist, or WRONGTYPE when it holds another type. keyspaceMu must be held.
+func readCuckoo(key string) (*cuckooFilter, Value, bool) {
+	obj, ok := keyspace[key]
+	if ok && obj.typ != cuckooObject {
+		return nil, wrongTypeError, false
+	}
+	return obj.cuckoo(), Value{}, true
+}
+
+// newCuckooFilter returns an empty filter for capacity items, false when
+// it would be too large.
+func newCuckooFilter(capacity int64, bucketSize, maxIterations, expansion int) (*cuckooFilter, bool) {
+	buckets := max(uint64(capacity)/uint64(bucketSize), 1)
+	l, ok := newCuckooLayer(buckets, bucketSize)
+	if !ok {
+		return nil, false
+	}
+	return &cuckooFilter{bucketSize: bucketSize, maxIterations: maxIterations, expansion: expansion, layers: []*cuckooLayer{l}}, true
+}
+
+// cfReserve handles CF.RESERVE key capacity [BUCKETSIZE bucketsize]
+// [MAXITERATIONS maxiterations] [EXPANSION expansion].
+func cfReserve(args []Value) Value {
+	key := args[0].bulk
+	capacity, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil || capacity <= 0 {
+		return Value{typ: "error", str: "ERR Bad capacity"}
+	}
+	bucketSize, maxIterations, expansion := cuckooDefaultBucketSize, cuckooDefaultMaxIterations, cuckooDefaultExpansion
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		n, err := strconv.Atoi(args[i+1].bulk)
+		switch strings.ToUpper(args[i].bulk) {
+		case "BUCKETSIZE":
+			if err != nil || n < 1 || n > 255 {
+				return Value{typ: "error", str: "ERR Bad bucket size"}
+			}
+			bucketSize = n
+		case "MAXITERATIONS":
+			if err != nil || n < 1 || n > 65535 {
+				return Value{typ: "error", str: "ERR MAXITERATIONS parameter needs to be a positive integer"}
+			}
+			maxIterations = n
+		case "EXPANSION":
+			if err != nil || n < 0 || n > 32768 {
+				return Value{typ: "error", str: "ERR EXPANSION parameter needs to be a non-negative integer"}
+			}
+			expansion = n
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	if _, exists := keyspace[key]; exists {
+		return Value{typ: "error", str: "ERR item exists"}
+	}
+	f, ok := newCuckooFilter(capacity, bucketSize, maxIterations, expansion)
+	if !ok {
+		return Value{typ: "error", str: "ERR Insufficient memory to create filter"}
+	}
+	setKey(key, object{typ: cuckooObject, value: f})
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// cuckooAdd returns the handler of CF.ADD key item, or of CF.ADDNX key item
+// with nx, which adds nothing and replies 0 when the item is found. The
+// filter is created with the defaults when missing.
+func cuckooAdd(nx bool) func([]Value) Value {
+	return func(args []Value) Value {
+		key, item := args[0].bulk, args[1].bulk
+
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		f, errReply, ok := readCuckoo(key)
+		if !ok {
+			return errReply
+		}
+		if f == nil {
+			f, _ = newCuckooFilter(cuckooDefaultCapacity, cuckooDefaultBucketSize, cuckooDefaultMaxIterations, cuckooDefaultExpansion)
+			setKey(key, object{typ: cuckooObject, value: f})
+			markKeyspaceChanged()
+		}
+		if nx && f.count(cuckooHash(item)) > 0 {
+			return Value{typ: "integer", num: 0}
+		}
+		if errReply := f.add(item); errReply.typ != "" {
+			return errReply
+		}
+		markKeyspaceChanged()
+		return Value{typ: "integer", num: 1}
+	}
+}
+
+// cuckooLookup replies for every item how many copies of it the filter at
+// key holds, only whether it holds any unless count is set, in an array
+// unless single is set.
+func cuckooLookup(key string, items []Value, single, count bool) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	f, errReply, ok := readCuckoo(key)
+	if !ok {
+		return errReply
+	}
+	reply := Value{typ: "array", array: make([]Value, 0, len(items))}
+	for _, item := range items {
+		n := 0
+		if f != nil {
+			n = f.count(cuckooHash(item.bulk))
+		}
+		if !count {
+			n = min(n, 1)
+		}
+		reply.array = append(reply.array, Value{typ: "integer", num: n})
+	}
+	if single {
+		return reply.array[0]
+	}
+	return reply
+}
+
+// cfExists handles CF.EXISTS key item.
+func cfExists(args []Value) Value {
+	return cuckooLookup(args[0].bulk, args[1:], true, false)
+}
+
+// cfMexists handles CF.MEXISTS key item [item ...].
+func cfMexists(args []Value) Value {
+	return cuckooLookup(args[0].bulk, args[1:], false, false)
+}
+
+// cfCount handles CF.COUNT key item.
+func cfCount(args []Value) Value {
+	return cuckooLookup(args[0].bulk, args[1:], true, true)
+}
+
+// cfDel handles CF.DEL key item, replying 1 when a copy of the item was
+// removed.
+func cfDel(args []Value) Value {
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	f, errReply, ok := readCuckoo(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR Not found"}
+	}
+	if !f.remove(args[1].bulk) {
+		return Value{typ: "integer", num: 0}
+	}
+	markKeyspaceChanged()
+	return Value{typ: "integer", num: 1}
+}
+
+// cfInfo handles CF.INFO key, replying the bytes the filter takes, its
+// buckets, layers, items added and deleted and its parameters.
+func cfInfo(args []Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	f, errReply, ok := readCuckoo(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR not found"}
+	}
+	buckets := 0
+	for _, l := range f.layers {
+		buckets += int(l.buckets)
+	}
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "Size"}, {typ: "integer", num: f.size()},
+		{typ: "bulk", bulk: "Number of buckets"}, {typ: "integer", num: buckets},
+		{typ: "bulk", bulk: "Number of filters"}, {typ: "integer", num: len(f.layers)},
+		{typ: "bulk", bulk: "Number of items inserted"}, {typ: "integer", num: int(f.items)},
+		{typ: "bulk", bulk: "Number of items deleted"}, {typ: "integer", num: int(f.deleted)},
+		{typ: "bulk", bulk: "Bucket size"}, {typ: "integer", num: f.bucketSize},
+		{typ: "bulk", bulk: "Expansion rate"}, {typ: "integer", num: f.expansion},
+		{typ: "bulk", bulk: "Max iterations"}, {typ: "integer", num: f.maxIterations},
+	}}
+}
+
+// chunk returns the chunk iterator of the filter as CF.SCANDUMP replies
+// it, and the iterator of the next chunk, 0 after the last one. Chunk 1 is
+// the header, chunk 2 and on the buckets of every layer.
+func (f *cuckooFilter) chunk(iterator int64) ([]byte, int64) {
+	switch {
+	case iterator <= 0:
+		header := []byte{}
+		for _, n := range []uint64{uint64(f.bucketSize), uint64(f.maxIterations), uint64(f.expansion), uint64(f.items), uint64(f.deleted)} {
+			header = binary.LittleEndian.AppendUint64(header, n)
+		}
+		for _, l := range f.layers {
+			header = binary.LittleEndian.AppendUint64(header, l.buckets)
+		}
+		return header, 1
+	case iterator <= int64(len(f.layers)):
+		return f.layers[iterator-1].slots, iterator + 1
+	}
+	return nil, 0
+}
+
+// cfScandump handles CF.SCANDUMP key iterator, replying the iterator to
+// pass next, 0 once done, and the chunk of the filter following iterator,
+// starting with 0.
+func cfScandump(args []Value) Value {
+	iterator, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Second argument must be numeric"}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	f, errReply, ok := readCuckoo(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR not found"}
+	}
+	chunk, next := f.chunk(iterator)
+	return Value{typ: "array", array: []Value{
+		{typ: "integer", num: int(next)},
+		{typ: "bulk", bulk: string(chunk)},
+	}}
+}
+
+// cuckooHeaderSize is the number of bytes the header chunk takes before
+// the number of buckets of every layer.
+const cuckooHeaderSize = 5 * 8
+
+// cfLoadchunk handles CF.LOADCHUNK key iterator data, restoring a filter
+// from the chunks CF.SCANDUMP replied, the header first.
+func cfLoadchunk(args []Value) Value {
+	key := args[0].bulk
+	iterator, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Second argument must be numeric"}
+	}
+	data := []byte(args[2].bulk)
+	invalid := Value{typ: "error", str: "ERR invalid chunk"}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	f, errReply, ok := readCuckoo(key)
+	if !ok {
+		return errReply
+	}
+	if iterator == 1 {
+		if f != nil {
+			return Value{typ: "error", str: "ERR item exists"}
+		}
+		if len(data) <= cuckooHeaderSize || len(data)%8 != 0 {
+			return invalid
+		}
+		word := func(i int) uint64 {
+			return binary.LittleEndian.Uint64(data[i*8:])
+		}
+		f = &cuckooFilter{
+			bucketSize:    int(word(0)),
+			maxIterations: int(word(1)),
+			expansion:     int(word(2)),
+			items:         int64(word(3)),
+			deleted:       int64(word(4)),
+		}
+		if f.bucketSize < 1 || f.bucketSize > 255 {
+			return invalid
+		}
+		for i := cuckooHeaderSize / 8; i < len(data)/8; i++ {
+			buckets := word(i)
+			if buckets == 0 || buckets&(buckets-1) != 0 {
+				return invalid
+			}
+			l, ok := newCuckooLayer(buckets, f.bucketSize)
+			if !ok {
+				return invalid
+			}
+			f.layers = append(f.layers, l)
+		}
+		setKey(key, object{typ: cuckooObject, value: f})
+		markKeyspaceChanged()
+		return Value{typ: "string", str: "OK"}
+	}
+	if f == nil {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+	if iterator < 2 || iterator > int64(len(f.layers))+1 {
+		return invalid
+	}
+	l := f.layers[iterator-2]
+	if len(data) != len(l.slots) {
+		return invalid
+	}
+	copy(l.slots, data)
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// cuckooCommands returns the CF.LOADCHUNK commands recreating the filter
+// stored at key.
+func cuckooCommands(key string, f *cuckooFilter) []Value {
+	commands := []Value{}
+	for iterator := int64(0); ; {
+		chunk, next := f.chunk(iterator)
+		if next == 0 {
+			return commands
+		}
+		commands = append(commands, commandValue("CF.LOADCHUNK", key, strconv.FormatInt(next, 10), string(chunk)))
+		iterator = next
+	}
+}
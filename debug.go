@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// debugCommand handles the DEBUG command and its subcommands, which exist to test
+// the server itself.
+func debugCommand(args []Value) Value {
+	switch strings.ToUpper(args[0].bulk) {
+	case "SLEEP":
+		return debugSleep(args[1:])
+	case "DIGEST":
+		return debugDigest(args[1:])
+	case "DIGEST-VALUE":
+		return debugDigestValue(args[1:])
+	case "OBJECT":
+		return debugObject(args[1:])
+	case "JMAP":
+		return debugJmap(args[1:])
+	case "SET-ACTIVE-EXPIRE":
+		return debugSetActiveExpire(args[1:])
+	case "CHANGE-REPL-ID":
+		return debugChangeReplID(args[1:])
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try DEBUG HELP."}
+	}
+}
+
+// debugSleep implements DEBUG SLEEP seconds. Like in Redis, where the whole
+// server stops for the duration, it holds the keyspace lock while sleeping
+// so that every other client touching the dataset is stalled.
+func debugSleep(args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'debug|sleep' command"}
+	}
+
+	secs, err := strconv.ParseFloat(args[0].bulk, 64)
+	if err != nil || secs < 0 {
+		return Value{typ: "error", str: "ERR value is not a valid float"}
+	}
+
+	// the stall is intentional, keep the deadlock detector quiet about it
+	debugSleeping.Add(1)
+	defer debugSleeping.Add(-1)
+
+	keyspaceMu.Lock()
+	time.Sleep(time.Duration(secs * float64(time.Second)))
+	keyspaceMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// debugObject implements DEBUG OBJECT key, describing how a value is stored
+// in the format of Redis, which test suites parse for the encoding:
+//
+//	Value at:0xc000123456 refcount:1 encoding:embstr serializedlength:5 lru:1700000000 lru_seconds_idle:3 storage:heap
+//
+// The encoding is the one OBJECT ENCODING replies. storage is a gostore
+// extension telling whether a string lives in the intern pool, in slab
+// memory or on the Go heap.
+func debugObject(args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'debug|object' command"}
+	}
+	key := args[0].bulk
+
+	keyspaceMu.RLock()
+	obj, ok := keyspace[key]
+	var addr any
+	encoding, storage, length, refs := obj.encoding(), "heap", 0, 1
+	switch obj.typ {
+	case stringObject:
+		addr = unsafe.StringData(obj.str)
+		length = len(obj.str)
+		if n := internRefs(obj.str); n > 0 {
+			storage, refs = "intern", n
+		} else if inSlab(obj.str) {
+			storage = "slab"
+		}
+	case hashObject:
+		addr = obj.hash()
+		obj.hash().each(func(f, v string) {
+			length += len(f) + len(v)
+		})
+	case listObject:
+		addr = obj.list()
+		for _, element := range obj.list().elements() {
+			length += len(element)
+		}
+	case setObject:
+		addr = obj.set()
+		obj.set().each(func(member string) {
+			length += len(member)
+		})
+	case zsetObject:
+		addr = obj.zset()
+		obj.zset().each(func(member string, score float64) {
+			length += len(member) + len(formatScore(score))
+		})
+	case jsonObject:
+		addr = obj.json()
+		length = len(obj.json().String())
+	case bloomObject:
+		addr = obj.bloom()
+		length = obj.bloom().size()
+	case cuckooObject:
+		addr = obj.cuckoo()
+		length = obj.cuckoo().size()
+	case timeSeriesObject:
+		addr = obj.timeSeries()
+		length = obj.timeSeries().size()
+	case streamObject:
+		addr = obj.stream()
+		for _, e := range obj.stream().entries {
+			length += len(e.id.String())
+			for _, f := range e.fields {
+				length += len(f)
+			}
+		}
+	}
+	keyspaceMu.RUnlock()
+
+	if !ok || expired(key) {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+
+	read, write := lastAccess(key)
+	used := max(read, write)
+	return Value{typ: "string", str: fmt.Sprintf("Value at:%p refcount:%d encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d storage:%s",
+		addr, refs, encoding, length, used/1000, (nowMs()-used)/1000, storage)}
+}
+
+// debugJmap implements DEBUG JMAP, named after the JVM heap dump tool. It
+// writes a profile of the Go heap to the working directory, to be read with
+// go tool pprof, and replies its path.
+func debugJmap(args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'debug|jmap' command"}
+	}
+
+	saveMu.Lock()
+	path := filepath.Join(dir, fmt.Sprintf("heap-%d-%d.pprof", os.Getpid(), time.Now().Unix()))
+	saveMu.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return Value{typ: "error", str: "ERR " + err.Error()}
+	}
+	defer f.Close()
+
+	// collect first so that the profile reflects the live heap
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return Value{typ: "error", str: "ERR " + err.Error()}
+	}
+	serverLog(logNotice, "Heap profile written to %s", path)
+
+	return Value{typ: "bulk", bulk: path}
+}
+
+// debugSetActiveExpire implements DEBUG SET-ACTIVE-EXPIRE 0|1, switching the
+// active expiry cycle off or on so that tests can rely on keys only expiring
+// lazily.
+func debugSetActiveExpire(args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'debug|set-active-expire' command"}
+	}
+	switch args[0].bulk {
+	case "0":
+		activeExpire.Store(false)
+	case "1":
+		activeExpire.Store(true)
+	default:
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	return Value{typ: "string", str: "OK"}
+}
+
+// debugChangeReplID implements DEBUG CHANGE-REPL-ID, which gives the server a
+// new replication id and forgets the previous one like a failover would.
+func debugChangeReplID(args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'debug|change-repl-id' command"}
+	}
+	changeReplID()
+	return Value{typ: "string", str: "OK"}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// gostore diff compares two datasets and reports the keys present in only
+// one of them and the keys whose type, value or expiry time differ. Each
+// side is either a live instance given as host:port, which is asked for the
+// digest of every key with CHECKSUM KEYS, or a snapshot or AOF file, which
+// is replayed into this process to digest it the same way. Only digests
+// travel over the network, never the values themselves.
+
+// runDiff runs gostore diff. Like diff(1) it exits with status 0 when the
+// datasets are identical, 1 when they differ and 2 on errors.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for connecting to and reading from live instances")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [-timeout d] a b\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "a and b are host:port addresses of live instances or snapshot/AOF files.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	sides := [2]map[string]digestEntry{}
+	for i, source := range fs.Args() {
+		entries, err := diffEntries(source, *timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+			os.Exit(2)
+		}
+		sides[i] = map[string]digestEntry{}
+		for _, e := range entries {
+			sides[i][e.id()] = e
+		}
+	}
+
+	if !reportDiff(sides[0], sides[1]) {
+		os.Exit(1)
+	}
+}
+
+// diffEntries returns the digest entries of a live instance or a file.
+func diffEntries(source string, timeout time.Duration) ([]digestEntry, error) {
+	if st, err := os.Stat(source); err == nil && !st.IsDir() {
+		return fileEntries(source)
+	}
+	return instanceEntries(source, timeout)
+}
+
+// fileEntries replays a snapshot or AOF file into the dataset of this
+// process and digests it. The dataset is emptied first, so both sides of
+// a diff can be files.
+func fileEntries(path string) ([]digestEntry, error) {
+	clearDataset(false)
+	if err := loadSnapshot(path); err != nil {
+		return nil, err
+	}
+	return digestEntries(), nil
+}
+
+// instanceEntries asks a live instance for the digest of every key.
+func instanceEntries(addr string, timeout time.Duration) ([]digestEntry, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(commandValue("CHECKSUM", "KEYS").Marshal()); err != nil {
+		return nil, err
+	}
+	reply, err := newrESP(conn).Read()
+	if err != nil {
+		return nil, err
+	}
+	if reply.typ == "error" {
+		return nil, fmt.Errorf("%s", reply.str)
+	}
+	if reply.typ != "array" || len(reply.array)%3 != 0 {
+		return nil, fmt.Errorf("unexpected reply to CHECKSUM KEYS")
+	}
+
+	entries := []digestEntry{}
+	for i := 0; i < len(reply.array); i += 3 {
+		d, err := hex.DecodeString(reply.array[i+2].bulk)
+		if err != nil || len(d) != digestSize {
+			return nil, fmt.Errorf("invalid digest in reply to CHECKSUM KEYS")
+		}
+		e := digestEntry{name: reply.array[i].bulk, typ: reply.array[i+1].bulk}
+		copy(e.digest[:], d)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// reportDiff prints the differences between two datasets, one line per
+// entry ("-" only in a, "+" only in b, "~" differing) followed by a
+// summary, and reports whether the datasets are identical.
+func reportDiff(a, b map[string]digestEntry) bool {
+	ids := []string{}
+	for id := range a {
+		ids = append(ids, id)
+	}
+	for id := range b {
+		if _, ok := a[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	onlyA, onlyB, differ, same := 0, 0, 0, 0
+	for _, id := range ids {
+		ea, inA := a[id]
+		eb, inB := b[id]
+		switch {
+		case !inB:
+			onlyA++
+			fmt.Printf("- %s (%s)\n", ea.name, ea.typ)
+		case !inA:
+			onlyB++
+			fmt.Printf("+ %s (%s)\n", eb.name, eb.typ)
+		case ea.typ != eb.typ:
+			differ++
+			fmt.Printf("~ %s (%s, %s)\n", ea.name, ea.typ, eb.typ)
+		case ea.digest != eb.digest:
+			differ++
+			fmt.Printf("~ %s (%s)\n", ea.name, ea.typ)
+		default:
+			same++
+		}
+	}
+
+	fmt.Printf("%d only in a, %d only in b, %d differing, %d identical\n", onlyA, onlyB, differ, same)
+	return onlyA+onlyB+differ == 0
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// The dataset digest lets operators check that a restored backup, a
+// migrated instance or a replica holds exactly the same data as its source.
+// Like DEBUG DIGEST in Redis every key is hashed on its own and the key
+// digests are XORed together, so the result does not depend on the order in
+// which the maps are walked. An empty dataset digests to all zeroes.
+
+// digestSize is the length of a digest in bytes.
+const digestSize = sha1.Size
+
+// digest is a dataset or key digest.
+type digest [digestSize]byte
+
+// mix XORs another digest into d.
+func (d *digest) mix(other digest) {
+	for i := range d {
+		d[i] ^= other[i]
+	}
+}
+
+// String returns the digest as lower case hex.
+func (d digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// digestOf hashes its parts, each prefixed by its length so that no two
+// different lists of parts hash the same input.
+func digestOf(parts ...string) digest {
+	h := sha1.New()
+	for _, p := range parts {
+		writeDigestPart(h, p)
+	}
+	var d digest
+	h.Sum(d[:0])
+	return d
+}
+
+// writeDigestPart writes a length prefixed part to h.
+func writeDigestPart(h hash.Hash, p string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+	h.Write(n[:])
+	h.Write([]byte(p))
+}
+
+// hashDigest digests the fields of a hash independently of their order.
+func hashDigest(fields *hashValue) digest {
+	var d digest
+	fields.each(func(f, v string) {
+		d.mix(digestOf(f, v))
+	})
+	return d
+}
+
+// setDigest digests the members of a set independently of their order.
+func setDigest(members *setValue) digest {
+	var d digest
+	members.each(func(member string) {
+		d.mix(digestOf(member))
+	})
+	return d
+}
+
+// zsetDigest digests the members of a sorted set with their scores. Their
+// order follows from the scores, so it needs no digesting of its own.
+func zsetDigest(z *zsetValue) digest {
+	var d digest
+	z.each(func(member string, score float64) {
+		d.mix(digestOf(member, formatScore(score)))
+	})
+	return d
+}
+
+// keyDigest returns the digest of the value and expiry time of key and the
+// type of the key, or false if the key does not exist or already expired.
+// keyspaceMu and expiresMu must be held for reading.
+func keyDigest(key string) (digest, string, bool) {
+	var d digest
+	obj, ok := keyspace[key]
+	if !ok {
+		return d, "", false
+	}
+	switch obj.typ {
+	case stringObject:
+		d = digestOf("string", slabLoad(obj.str))
+	case hashObject:
+		inner := hashDigest(obj.hash())
+		d = digestOf("hash", string(inner[:]))
+	case listObject:
+		// unlike the fields of a hash, the order of the elements matters
+		parts := []string{"list"}
+		d = digestOf(append(parts, obj.list().elements()...)...)
+	case setObject:
+		inner := setDigest(obj.set())
+		d = digestOf("set", string(inner[:]))
+	case zsetObject:
+		inner := zsetDigest(obj.zset())
+		d = digestOf("zset", string(inner[:]))
+	case streamObject:
+		// entries are ordered by ID, and the last ID decides the next ones
+		s := obj.stream()
+		parts := []string{"stream", s.lastID.String(), s.maxDeletedID.String()}
+		for _, e := range s.entries {
+			parts = append(parts, e.id.String())
+			parts = append(parts, e.fields...)
+		}
+		// consumer groups and who their pending entries belong to, but
+		// not the delivery times
+		for _, name := range s.groupNames() {
+			g := s.groups[name]
+			parts = append(parts, name, g.lastID.String())
+			for _, p := range g.pending {
+				parts = append(parts, p.id.String(), p.consumer.name, strconv.FormatInt(p.deliveryCount, 10))
+			}
+		}
+		d = digestOf(parts...)
+	case jsonObject:
+		// the members of objects are digested in order, like RedisJSON
+		// keeps them
+		d = digestOf("json", obj.json().String())
+	case bloomObject:
+		// the layers and their bits, which is what the chunks restore
+		parts := []string{"bloom"}
+		for iterator := int64(0); ; {
+			chunk, next := obj.bloom().chunk(iterator)
+			if next == 0 {
+				break
+			}
+			parts = append(parts, string(chunk))
+			iterator = next
+		}
+		d = digestOf(parts...)
+	case cuckooObject:
+		parts := []string{"cuckoo"}
+		for iterator := int64(0); ; {
+			chunk, next := obj.cuckoo().chunk(iterator)
+			if next == 0 {
+				break
+			}
+			parts = append(parts, string(chunk))
+			iterator = next
+		}
+		d = digestOf(parts...)
+	case timeSeriesObject:
+		// the options, labels in order and samples, by timestamp
+		s := obj.timeSeries()
+		parts := []string{"timeseries", strconv.FormatInt(s.retention, 10), s.duplicatePolicy}
+		for _, l := range s.labels {
+			parts = append(parts, l.name, l.value)
+		}
+		for _, sample := range s.samples {
+			parts = append(parts, strconv.FormatInt(sample.ts, 10), formatScore(sample.value))
+		}
+		d = digestOf(parts...)
+	}
+	typ := obj.typ.String()
+	if at, ok := expires[key]; ok {
+		// a key waiting for lazy expiry is gone for clients already
+		if at <= nowMs() {
+			return digest{}, "", false
+		}
+		d = digestOf(string(d[:]), strconv.FormatInt(at, 10))
+	}
+	return d, typ, true
+}
+
+// digestEntry is the digest of one key, session or ID generator.
+type digestEntry struct {
+	name   string
+	typ    string
+	digest digest
+}
+
+// id identifies the entry across datasets. Sessions and ID generators live
+// in their own namespaces next to the keyspace.
+func (e digestEntry) id() string {
+	if e.typ == "session" || e.typ == "idgen" {
+		return e.typ + "\x00" + e.name
+	}
+	return "key\x00" + e.name
+}
+
+// digestEntries returns the digest of every key with its value and expiry
+// time, every session and every ID generator.
+func digestEntries() []digestEntry {
+	entries := []digestEntry{}
+
+	keyspaceMu.RLock()
+	expiresMu.RLock()
+	for key := range keyspace {
+		if d, typ, ok := keyDigest(key); ok {
+			entries = append(entries, digestEntry{name: key, typ: typ, digest: d})
+		}
+	}
+	expiresMu.RUnlock()
+	keyspaceMu.RUnlock()
+
+	now := nowMs()
+	sessionsMu.Lock()
+	for id, s := range sessions {
+		if s.expiresAt <= now {
+			continue
+		}
+		// tags are a set, their order does not matter
+		var tags digest
+		for _, tag := range s.tags {
+			tags.mix(digestOf(tag))
+		}
+		d := digestOf(s.value, strconv.FormatInt(s.expiresAt, 10), string(tags[:]))
+		entries = append(entries, digestEntry{name: id, typ: "session", digest: d})
+	}
+	sessionsMu.Unlock()
+
+	idgensMu.Lock()
+	for ns, id := range idgens {
+		d := digestOf(strconv.FormatInt(id, 10))
+		entries = append(entries, digestEntry{name: ns, typ: "idgen", digest: d})
+	}
+	idgensMu.Unlock()
+
+	return entries
+}
+
+// datasetDigest returns the digest of the whole dataset.
+func datasetDigest() digest {
+	var d digest
+	for _, e := range digestEntries() {
+		d.mix(digestOf(e.id(), e.typ, string(e.digest[:])))
+	}
+	return d
+}
+
+// checksum handles CHECKSUM DB, replying the digest of the dataset, and
+// CHECKSUM KEYS, replying the name, type and digest of every entry of the
+// dataset as a flat array for tools like gostore diff.
+func checksum(args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	switch strings.ToUpper(args[0].bulk) {
+	case "DB":
+		return Value{typ: "bulk", bulk: datasetDigest().String()}
+	case "KEYS":
+		reply := Value{typ: "array", array: []Value{}}
+		for _, e := range digestEntries() {
+			reply.array = append(reply.array,
+				Value{typ: "bulk", bulk: e.name},
+				Value{typ: "bulk", bulk: e.typ},
+				Value{typ: "bulk", bulk: e.digest.String()})
+		}
+		return reply
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+}
+
+// debugDigest implements DEBUG DIGEST.
+func debugDigest(args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'debug|digest' command"}
+	}
+	return Value{typ: "bulk", bulk: datasetDigest().String()}
+}
+
+// debugDigestValue implements DEBUG DIGEST-VALUE key [key ...], replying
+// the digest of every key, all zeroes for missing keys.
+func debugDigestValue(args []Value) Value {
+	keyspaceMu.RLock()
+	expiresMu.RLock()
+	defer keyspaceMu.RUnlock()
+	defer expiresMu.RUnlock()
+
+	reply := Value{typ: "array", array: []Value{}}
+	for _, arg := range args {
+		d, _, _ := keyDigest(arg.bulk)
+		reply.array = append(reply.array, Value{typ: "bulk", bulk: d.String()})
+	}
+	return reply
+}
@@ -0,0 +1,376 @@
+// Key expiration.
+//
+// Every key of the keyspace may carry an expiry time, whatever its type,
+// kept in the expires map as Unix milliseconds. Expired keys are removed
+// lazily: before a command runs, the keys it names, as given by the key
+// positions in its command metadata, are checked and deleted if their time
+// has come. The deletion is written to the AOF as a DEL ahead of the
+// command itself, so that replaying the AOF sees the same keyspace.
+//
+// Keys nobody names again are removed actively: a background cycle checks a
+// random sample of keys with an expiry time ten times a second, like Redis'
+// active expiry, and goes on sampling while many of them turn out expired.
+//
+// Relative expiry times are always persisted as absolute PEXPIREAT calls,
+// which keeps replaying the AOF from extending them.
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// expires maps keys with an expiry time to that time in Unix milliseconds.
+var expires = map[string]int64{}
+
+// expiresMu guards expires. It is always taken after keyspaceMu.
+var expiresMu = rwLock{name: "expires"}
+
+// expiresCount mirrors len(expires) so that commands can skip the expiry
+// check without locking while no key has an expiry time.
+var expiresCount atomic.Int64
+
+// setExpiry sets the expiry time of key. It must be called with expiresMu
+// held for writing.
+func setExpiry(key string, at int64) {
+	if _, ok := expires[key]; !ok {
+		expiresCount.Add(1)
+	}
+	expires[key] = at
+}
+
+// clearExpiry removes the expiry time of key. It must be called with
+// expiresMu held for writing.
+func clearExpiry(key string) {
+	if _, ok := expires[key]; ok {
+		delete(expires, key)
+		expiresCount.Add(-1)
+	}
+}
+
+// deleteKey removes key from the keyspace and forgets its expiry time,
+// reporting whether it existed. It must be called with keyspaceMu held for
+// writing.
+func deleteKey(key string) bool {
+	obj, found := keyspace[key]
+	if found {
+		// free the storage held by the value before dropping it
+		freeObject(obj)
+		removeKey(key)
+		markKeyspaceChanged()
+	}
+
+	expiresMu.Lock()
+	clearExpiry(key)
+	expiresMu.Unlock()
+	forgetAccess(key)
+
+	return found
+}
+
+// expired reports whether key has an expiry time that has passed.
+func expired(key string) bool {
+	if expiresCount.Load() == 0 {
+		return false
+	}
+
+	expiresMu.RLock()
+	at, ok := expires[key]
+	expiresMu.RUnlock()
+
+	return ok && at <= nowMs()
+}
+
+// expireIfNeeded deletes key if it expired, logging the deletion to the AOF.
+func expireIfNeeded(key string) {
+	if !expired(key) {
+		return
+	}
+
+	keyspaceMu.Lock()
+	// check again now that nobody else can touch the key
+	deleted := expired(key) && deleteKey(key)
+	// log the deletion before the key can be written again, so that
+	// replaying the AOF deletes it in the same order
+	if deleted && aof != nil {
+		aof.Write(commandValue("DEL", key))
+	}
+	keyspaceMu.Unlock()
+
+	if deleted {
+		invalidateKeys([]string{key}, 0)
+	}
+}
+
+// expireCommandKeys lazily expires the keys named by a command.
+func expireCommandKeys(command string, args []Value) {
+	if expiresCount.Load() == 0 {
+		return
+	}
+
+	for _, key := range commandKeys(command, args) {
+		expireIfNeeded(key)
+	}
+}
+
+// activeExpire enables the active expiry cycle. DEBUG SET-ACTIVE-EXPIRE
+// turns it off for tests that exercise lazy expiry.
+var activeExpire atomic.Bool
+
+func init() {
+	activeExpire.Store(true)
+}
+
+// The active expiry cycle runs every activeExpireInterval and checks
+// activeExpireSample keys at a time. It samples again right away while more
+// than a quarter of the sample expired, for at most activeExpireBudget.
+const (
+	activeExpireInterval = 100 * time.Millisecond
+	activeExpireSample   = 20
+	activeExpireBudget   = 25 * time.Millisecond
+)
+
+// activeExpireCron deletes expired keys in the background, logging every
+// deletion to the AOF as a DEL.
+func activeExpireCron() {
+	for {
+		time.Sleep(activeExpireInterval)
+		if !activeExpire.Load() {
+			continue
+		}
+
+		// keys do not expire in the middle of a transaction, nor while a
+		// hot restart hands the AOF over to the new process
+		backgroundGate.enter()
+		if handoff.pending.Load() {
+			backgroundGate.leave()
+			continue
+		}
+		start := time.Now()
+		for expiresCount.Load() > 0 && time.Since(start) < activeExpireBudget {
+			deleted := activeExpireCycle()
+			invalidateKeys(deleted, 0)
+			if len(deleted)*4 <= activeExpireSample {
+				break
+			}
+		}
+		backgroundGate.leave()
+	}
+}
+
+// activeExpireCycle checks a random sample of the keys with an expiry time
+// and deletes the expired ones, returning their names. The deletions are
+// logged to the AOF before the lock is released, so that a write creating
+// one of the keys again is logged after its DEL.
+func activeExpireCycle() []string {
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	// map iteration starts at a random position, which makes the keys
+	// checked a random sample
+	candidates := []string{}
+	now, checked := nowMs(), 0
+	expiresMu.RLock()
+	for key, at := range expires {
+		if at <= now {
+			candidates = append(candidates, key)
+		}
+		if checked++; checked == activeExpireSample {
+			break
+		}
+	}
+	expiresMu.RUnlock()
+
+	deleted := []string{}
+	for _, key := range candidates {
+		if deleteKey(key) {
+			deleted = append(deleted, key)
+			if aof != nil {
+				aof.Write(commandValue("DEL", key))
+			}
+		}
+	}
+	return deleted
+}
+
+// expireCommand handles EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT key time
+// [NX|XX|GT|LT]. unit converts the time argument to milliseconds and
+// absolute tells whether it is a Unix time rather than a duration. A time
+// that already passed deletes the key, and like in Redis one that does not
+// fit in Unix milliseconds is refused instead of wrapping around.
+func expireCommand(name string, unit int64, absolute bool) func([]Value) Value {
+	return func(args []Value) Value {
+		key := args[0].bulk
+		n, err := strconv.ParseInt(args[1].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		invalid := Value{typ: "error", str: "ERR invalid expire time in '" + name + "' command"}
+		if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+			return invalid
+		}
+		at := n * unit
+		if !absolute {
+			now := nowMs()
+			if at > math.MaxInt64-now {
+				return invalid
+			}
+			at += now
+		}
+
+		nx, xx, gt, lt := false, false, false, false
+		for _, arg := range args[2:] {
+			switch strings.ToUpper(arg.bulk) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "GT":
+				gt = true
+			case "LT":
+				lt = true
+			default:
+				return Value{typ: "error", str: "ERR Unsupported option " + arg.bulk}
+			}
+		}
+		if nx && (xx || gt || lt) {
+			return Value{typ: "error", str: "ERR NX and XX, GT or LT options at the same time are not compatible"}
+		}
+		if gt && lt {
+			return Value{typ: "error", str: "ERR GT and LT options at the same time are not compatible"}
+		}
+
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		if _, ok := keyspace[key]; !ok {
+			return Value{typ: "integer", num: 0}
+		}
+
+		expiresMu.Lock()
+		current, hasExpiry := expires[key]
+		expiresMu.Unlock()
+
+		// a key without expiry time counts as expiring never, so GT never
+		// and LT always applies to it
+		switch {
+		case nx && hasExpiry,
+			xx && !hasExpiry,
+			gt && (!hasExpiry || at <= current),
+			lt && hasExpiry && at >= current:
+			return Value{typ: "integer", num: 0}
+		}
+
+		if at <= nowMs() {
+			deleteKey(key)
+			return Value{typ: "integer", num: 1}
+		}
+
+		expiresMu.Lock()
+		setExpiry(key, at)
+		expiresMu.Unlock()
+
+		return Value{typ: "integer", num: 1}
+	}
+}
+
+// expirePropagate persists the EXPIRE family as PEXPIREAT with the
+// resulting absolute time, or as DEL when the call deleted the key.
+func expirePropagate(value Value, result Value) Value {
+	if result.num == 0 {
+		return Value{}
+	}
+	key := value.array[1].bulk
+
+	expiresMu.RLock()
+	at, ok := expires[key]
+	expiresMu.RUnlock()
+
+	if !ok {
+		return commandValue("DEL", key)
+	}
+	return commandValue("PEXPIREAT", key, strconv.FormatInt(at, 10))
+}
+
+// ttlCommand handles TTL and PTTL key, replying the remaining time to live
+// in the unit given in milliseconds, -1 for a key without expiry time and
+// -2 for a missing key.
+func ttlCommand(unit int64) func([]Value) Value {
+	return func(args []Value) Value {
+		key := args[0].bulk
+
+		keyspaceMu.RLock()
+		_, ok := keyspace[key]
+		keyspaceMu.RUnlock()
+		if !ok {
+			return Value{typ: "integer", num: -2}
+		}
+
+		expiresMu.RLock()
+		at, ok := expires[key]
+		expiresMu.RUnlock()
+		if !ok {
+			return Value{typ: "integer", num: -1}
+		}
+
+		left := at - nowMs()
+		if left < 0 {
+			left = 0
+		}
+		// round to the nearest unit like Redis does
+		return Value{typ: "integer", num: int((left + unit/2) / unit)}
+	}
+}
+
+// persist handles PERSIST key, removing the expiry time of the key. It
+// replies 1 when an expiry time was removed and 0 otherwise.
+func persist(args []Value) Value {
+	expiresMu.Lock()
+	defer expiresMu.Unlock()
+
+	if _, ok := expires[args[0].bulk]; !ok {
+		return Value{typ: "integer", num: 0}
+	}
+	clearExpiry(args[0].bulk)
+	return Value{typ: "integer", num: 1}
+}
+
+// expireTimeCommand handles EXPIRETIME and PEXPIRETIME key, replying the
+// absolute Unix expiry time in the unit given in milliseconds, -1 for a
+// key without expiry time and -2 for a missing key.
+func expireTimeCommand(unit int64) func([]Value) Value {
+	return func(args []Value) Value {
+		key := args[0].bulk
+
+		keyspaceMu.RLock()
+		_, ok := keyspace[key]
+		keyspaceMu.RUnlock()
+		if !ok {
+			return Value{typ: "integer", num: -2}
+		}
+
+		expiresMu.RLock()
+		at, ok := expires[key]
+		expiresMu.RUnlock()
+		if !ok {
+			return Value{typ: "integer", num: -1}
+		}
+		return Value{typ: "integer", num: int(at / unit)}
+	}
+}
+
+// expireCommands returns the PEXPIREAT commands restoring the expiry time
+// of every key, for snapshots.
+func expireCommands() []Value {
+	expiresMu.RLock()
+	defer expiresMu.RUnlock()
+
+	commands := []Value{}
+	for key, at := range expires {
+		commands = append(commands, commandValue("PEXPIREAT", key, strconv.FormatInt(at, 10)))
+	}
+	return commands
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"strings"
+)
+
+// EXPLAIN reports what a command would do without running it:
+//
+//	EXPLAIN SET user:42 alice EX 60
+//
+// replies, as a flat array of alternating names and values like MEMORY
+// STATS, the command name, whether it writes, the keys it touches and the
+// cluster slot they hash to, an estimate of how much the dataset would
+// grow or shrink in bytes, and the verdict: "allowed", or the error the
+// command would be refused with (arity, strict mode, out of memory, read
+// only snapshot) before its handler runs. It helps tooling, code reviews
+// and debugging why a client gets refused.
+
+// explain handles EXPLAIN command [arg ...].
+func explain(c *Client, args []Value) Value {
+	command := strings.ToUpper(args[0].bulk)
+	cmdArgs := args[1:]
+
+	keys := []Value{}
+	slot, crossSlot := -1, false
+	for _, key := range commandKeys(command, cmdArgs) {
+		keys = append(keys, Value{typ: "bulk", bulk: key})
+		s := keyHashSlot(key)
+		if slot >= 0 && s != slot {
+			crossSlot = true
+		}
+		if slot < 0 {
+			slot = s
+		}
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "command"}, {typ: "bulk", bulk: strings.ToLower(command)},
+		{typ: "bulk", bulk: "write"}, {typ: "integer", num: int(boolToUint(isWriteCommand(command)))},
+		{typ: "bulk", bulk: "keys"}, {typ: "array", array: keys},
+		{typ: "bulk", bulk: "slot"}, {typ: "integer", num: slot},
+		{typ: "bulk", bulk: "cross-slot"}, {typ: "integer", num: int(boolToUint(crossSlot))},
+		{typ: "bulk", bulk: "memory-delta"}, {typ: "integer", num: memoryDelta(command, cmdArgs)},
+		{typ: "bulk", bulk: "verdict"}, {typ: "bulk", bulk: explainVerdict(c, command, args)},
+	}}
+}
+
+// explainVerdict runs the checks processCommand applies before a handler
+// and returns the error the call would get, or "allowed".
+func explainVerdict(c *Client, command string, value []Value) string {
+	if _, ok := Commands[command]; !ok {
+		return "ERR unknown command '" + strings.ToLower(command) + "'"
+	}
+	if !pubsubAllowed[command] && c.subscribed() && c.protocol() < 3 {
+		return pubsubError(command).str
+	}
+	if !arityOK(command, len(value)) {
+		return arityError(command).str
+	}
+	if err, rejected := strictCheck(command, value[1:]); rejected {
+		return err.str
+	}
+	if c.db == attachedDB && isWriteCommand(command) {
+		return readonlyError.str
+	}
+	if isWriteCommand(command) && oomReject.Load() {
+		return oomError.str
+	}
+	return "allowed"
+}
+
+// keySize estimates the memory used by a key and its value in bytes, 0 for
+// a missing key. keyspaceMu must be held for reading.
+func keySize(key string) int {
+	obj, ok := keyspace[key]
+	if !ok {
+		return 0
+	}
+	size := len(key)
+	switch obj.typ {
+	case stringObject:
+		size += len(obj.str)
+	case hashObject:
+		obj.hash().each(func(f, v string) {
+			size += len(f) + len(v)
+		})
+	case listObject:
+		for _, element := range obj.list().elements() {
+			size += len(element)
+		}
+	case setObject:
+		obj.set().each(func(member string) {
+			size += len(member)
+		})
+	case zsetObject:
+		// a score takes 8 bytes
+		size += obj.zset().len() * 8
+		obj.zset().each(func(member string, _ float64) {
+			size += len(member)
+		})
+	case jsonObject:
+		size += len(obj.json().String())
+	case bloomObject:
+		size += obj.bloom().size()
+	case cuckooObject:
+		size += obj.cuckoo().size()
+	case timeSeriesObject:
+		size += obj.timeSeries().size()
+	case streamObject:
+		// an ID takes 16 bytes
+		for _, e := range obj.stream().entries {
+			size += 16
+			for _, f := range e.fields {
+				size += len(f)
+			}
+		}
+	}
+	return size
+}
+
+// memoryDelta estimates by how many bytes the dataset would grow, or
+// shrink when negative, if the command ran. Only the key and value bytes
+// are counted, not the overhead of the maps holding them.
+func memoryDelta(command string, args []Value) int {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	switch command {
+	case "SET", "SETNX", "MSET", "MSETNX":
+		delta := 0
+		for i := 0; i+1 < len(args); i += 2 {
+			key, value := args[i].bulk, args[i+1].bulk
+			// the new string replaces whatever the key held
+			delta += len(key) + len(value) - keySize(key)
+			// SET and SETNX take a single key followed by options
+			if command == "SET" || command == "SETNX" {
+				break
+			}
+		}
+		return delta
+	case "HSET", "HMSET":
+		if len(args) < 3 {
+			return 0
+		}
+		obj, ok := keyspace[args[0].bulk]
+		if ok && obj.typ != hashObject {
+			// refused with WRONGTYPE
+			return 0
+		}
+		delta := 0
+		if !ok {
+			delta += len(args[0].bulk)
+		}
+		hash := obj.hash()
+		for i := 1; i+1 < len(args); i += 2 {
+			if old, ok := hash.get(args[i].bulk); ok {
+				delta += len(args[i+1].bulk) - len(old)
+			} else {
+				delta += len(args[i].bulk) + len(args[i+1].bulk)
+			}
+		}
+		return delta
+	case "LPUSH", "RPUSH":
+		if len(args) < 2 {
+			return 0
+		}
+		obj, ok := keyspace[args[0].bulk]
+		if ok && obj.typ != listObject {
+			return 0
+		}
+		delta := 0
+		if !ok {
+			delta += len(args[0].bulk)
+		}
+		for _, arg := range args[1:] {
+			delta += len(arg.bulk)
+		}
+		return delta
+	case "SADD":
+		if len(args) < 2 {
+			return 0
+		}
+		obj, ok := keyspace[args[0].bulk]
+		if ok && obj.typ != setObject {
+			return 0
+		}
+		delta := 0
+		if !ok {
+			delta += len(args[0].bulk)
+		}
+		// members already in the set, or repeated, add nothing
+		seen := map[string]bool{}
+		for _, arg := range args[1:] {
+			if !seen[arg.bulk] && !obj.set().has(arg.bulk) {
+				delta += len(arg.bulk)
+			}
+			seen[arg.bulk] = true
+		}
+		return delta
+	case "ZADD":
+		obj, ok := keyspace[args[0].bulk]
+		if ok && obj.typ != zsetObject {
+			return 0
+		}
+		_, first := parseZaddFlags(args)
+		pairs := args[first:]
+		delta := 0
+		if !ok && len(pairs) > 0 {
+			delta += len(args[0].bulk)
+		}
+		// updated members keep their size, each new one adds a score
+		seen := map[string]bool{}
+		for i := 1; i < len(pairs); i += 2 {
+			member := pairs[i].bulk
+			if _, found := obj.zset().score(member); !found && !seen[member] {
+				delta += len(member) + 8
+			}
+			seen[member] = true
+		}
+		return delta
+	case "SETBIT":
+		if len(args) < 3 {
+			return 0
+		}
+		obj, ok := keyspace[args[0].bulk]
+		offset, _, valid := parseBitOffset(args[1].bulk)
+		if ok && obj.typ != stringObject || !valid {
+			return 0
+		}
+		delta := 0
+		if !ok {
+			delta += len(args[0].bulk)
+		}
+		// the string only grows up to the byte holding the offset
+		return delta + max(int(offset/8)+1-len(obj.str), 0)
+	case "DEL":
+		delta := 0
+		for _, arg := range args {
+			delta -= keySize(arg.bulk)
+		}
+		return delta
+	}
+	return 0
+}
@@ -0,0 +1,633 @@
+// Geospatial indexes.
+//
+// A geospatial index is a sorted set whose scores encode the position of
+// every member, so it supports the sorted set commands too:
+//
+//	GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+//	GEOPOS key member [member ...]
+//	GEODIST key member1 member2 [M|KM|FT|MI]
+//	GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude
+//	    BYRADIUS radius M|KM|FT|MI|BYBOX width height M|KM|FT|MI
+//	    [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+//
+// Like Redis, a position is stored as a 52 bit geohash: the longitude and
+// latitude are each cut into 2^26 steps and their bits interleaved, the
+// longitude first, so that nearby points mostly get nearby scores and
+// every cell of the grid at a coarser step is a range of scores. Reading a
+// position back yields the center of its cell, which is within a few
+// centimeters of the original. Latitudes are limited to the +-85.05112878
+// degrees of the Web Mercator projection.
+//
+// GEOSEARCH picks the grid step at which a cell is about the size of the
+// search area, then only looks at the scores of the cell holding the
+// center and of its eight neighbours, skipping those the area does not
+// reach, and keeps the members within the radius or the box. Distances are
+// computed with the haversine formula on a sphere of Earth's radius, and
+// results come unsorted unless ASC or DESC is given; COUNT alone implies
+// ASC, while COUNT with ANY stops at the first members found. As in Redis,
+// the neighbours do not wrap around the 180th meridian, so a search near it
+// misses the members on the other side.
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Bounds of the coordinates and of the geohash grid, as in Redis.
+const (
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	// geoStepMax is the number of bits of each coordinate in a score
+	geoStepMax = 26
+	// earthRadius is the radius, in meters, distances are computed with
+	earthRadius = 6372797.560856
+	// mercatorMax is half the circumference of the Earth in meters
+	mercatorMax = 20037726.37
+)
+
+// geoUnits maps the distance units to meters.
+var geoUnits = map[string]float64{"m": 1, "km": 1000, "ft": 0.3048, "mi": 1609.34}
+
+// geohash is a cell of the grid: the interleaved bits of its longitude and
+// latitude steps, step bits of each.
+type geohash struct {
+	bits uint64
+	step uint
+}
+
+// spread moves the low 32 bits of v to the even bits of the result.
+func spread(v uint64) uint64 {
+	v &= 0xffffffff
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash is the inverse of spread, gathering the even bits of v.
+func squash(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return v
+}
+
+// encodeGeohash returns the cell holding a position at the given step.
+func encodeGeohash(longitude, latitude float64, step uint) geohash {
+	latOffset := (latitude - geoLatMin) / (geoLatMax - geoLatMin) * float64(uint64(1)<<step)
+	longOffset := (longitude - geoLongMin) / (geoLongMax - geoLongMin) * float64(uint64(1)<<step)
+	return geohash{bits: spread(uint64(latOffset)) | spread(uint64(longOffset))<<1, step: step}
+}
+
+// geoArea is the extent of a cell in degrees.
+type geoArea struct {
+	longMin, longMax, latMin, latMax float64
+}
+
+// area returns the extent of the cell.
+func (h geohash) area() geoArea {
+	lat, long := squash(h.bits), squash(h.bits>>1)
+	cells := float64(uint64(1) << h.step)
+	return geoArea{
+		longMin: geoLongMin + float64(long)/cells*(geoLongMax-geoLongMin),
+		longMax: geoLongMin + float64(long+1)/cells*(geoLongMax-geoLongMin),
+		latMin:  geoLatMin + float64(lat)/cells*(geoLatMax-geoLatMin),
+		latMax:  geoLatMin + float64(lat+1)/cells*(geoLatMax-geoLatMin),
+	}
+}
+
+// geoPosition returns the longitude and latitude a score stands for, the
+// center of its cell.
+func geoPosition(score float64) (float64, float64) {
+	a := geohash{bits: uint64(score), step: geoStepMax}.area()
+	longitude := min(max((a.longMin+a.longMax)/2, geoLongMin), geoLongMax)
+	latitude := min(max((a.latMin+a.latMax)/2, geoLatMin), geoLatMax)
+	return longitude, latitude
+}
+
+// geoScore returns the score of a position.
+func geoScore(longitude, latitude float64) float64 {
+	return float64(encodeGeohash(longitude, latitude, geoStepMax).bits)
+}
+
+// move returns the cell dx cells east and dy cells north, wrapping around
+// at the edges of the grid.
+func (h geohash) move(dx, dy int) geohash {
+	longBits := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - 2*h.step)
+	latBits := uint64(0x5555555555555555) >> (64 - 2*h.step)
+	long, lat := h.bits&longBits, h.bits&latBits
+	// adding to the interleaved bits carries through the bits of the other
+	// coordinate once they are all set
+	switch {
+	case dx > 0:
+		long = (long | latBits) + 1
+	case dx < 0:
+		long = (long &^ latBits) - 1
+	}
+	switch {
+	case dy > 0:
+		lat = (lat | longBits) + 1
+	case dy < 0:
+		lat = (lat &^ longBits) - 1
+	}
+	return geohash{bits: long&longBits | lat&latBits, step: h.step}
+}
+
+// scoreRange returns the scores of the positions within the cell.
+func (h geohash) scoreRange() scoreRange {
+	shift := 2 * (geoStepMax - h.step)
+	return scoreRange{min: float64(h.bits << shift), max: float64((h.bits + 1) << shift), maxEx: true}
+}
+
+// degToRad converts degrees to radians.
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// radToDeg converts radians to degrees.
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// latDistance returns the distance in meters between two latitudes on the
+// same meridian.
+func latDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// geoDistance returns the distance in meters between two positions.
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	v := math.Sin((degToRad(long2) - degToRad(long1)) / 2)
+	if v == 0 {
+		return latDistance(lat1, lat2)
+	}
+	u := math.Sin((lat2r - lat1r) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// formatCoordinate formats a longitude or latitude the way Redis does,
+// with up to 17 decimals.
+func formatCoordinate(f float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(f, 'f', 17, 64), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// coordinatesReply replies a position as a longitude and latitude pair.
+func coordinatesReply(longitude, latitude float64) Value {
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: formatCoordinate(longitude)},
+		{typ: "bulk", bulk: formatCoordinate(latitude)},
+	}}
+}
+
+// formatDistance formats a distance the way Redis does, with 4 decimals.
+func formatDistance(meters, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', 4, 64)
+}
+
+// parseCoordinates parses a longitude and latitude pair.
+func parseCoordinates(longArg, latArg string) (float64, float64, Value, bool) {
+	longitude, err1 := strconv.ParseFloat(longArg, 64)
+	latitude, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(longitude) || math.IsNaN(latitude) {
+		return 0, 0, notFloatError, false
+	}
+	if longitude < geoLongMin || longitude > geoLongMax || latitude < geoLatMin || latitude > geoLatMax {
+		return 0, 0, Value{typ: "error", str: fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude)}, false
+	}
+	return longitude, latitude, Value{}, true
+}
+
+// parseGeoUnit parses a distance unit, returning its size in meters.
+func parseGeoUnit(arg string) (float64, Value, bool) {
+	unit, ok := geoUnits[strings.ToLower(arg)]
+	if !ok {
+		return 0, Value{typ: "error", str: "ERR unsupported unit provided. please use M, KM, FT, MI"}, false
+	}
+	return unit, Value{}, true
+}
+
+// geoadd handles GEOADD key [NX|XX] [CH] longitude latitude member
+// [longitude latitude member ...] as the ZADD of the scores of the
+// positions, replying like it.
+func geoadd(args []Value) Value {
+	zaddArgs := []Value{args[0]}
+	first := 1
+	nx, xx := false, false
+options:
+	for ; first < len(args); first++ {
+		switch strings.ToUpper(args[first].bulk) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+		default:
+			break options
+		}
+		zaddArgs = append(zaddArgs, args[first])
+	}
+	rest := args[first:]
+	if len(rest) == 0 || len(rest)%3 != 0 || nx && xx {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	for i := 0; i < len(rest); i += 3 {
+		longitude, latitude, errReply, ok := parseCoordinates(rest[i].bulk, rest[i+1].bulk)
+		if !ok {
+			return errReply
+		}
+		score := strconv.FormatFloat(geoScore(longitude, latitude), 'f', -1, 64)
+		zaddArgs = append(zaddArgs, Value{typ: "bulk", bulk: score}, rest[i+2])
+	}
+	return zadd(zaddArgs)
+}
+
+// geopos handles GEOPOS key member [member ...], replying the position of
+// every member, or null for missing ones.
+func geopos(args []Value) Value {
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		positions := make([]Value, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			score, ok := z.score(arg.bulk)
+			if !ok {
+				positions = append(positions, Value{typ: "nullarray"})
+				continue
+			}
+			positions = append(positions, coordinatesReply(geoPosition(score)))
+		}
+		return Value{typ: "array", array: positions}
+	})
+}
+
+// geodist handles GEODIST key member1 member2 [M|KM|FT|MI], replying the
+// distance between two members, or null when one is missing.
+func geodist(args []Value) Value {
+	unit := 1.0
+	switch len(args) {
+	case 3:
+	case 4:
+		var errReply Value
+		var ok bool
+		if unit, errReply, ok = parseGeoUnit(args[3].bulk); !ok {
+			return errReply
+		}
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		score1, ok1 := z.score(args[1].bulk)
+		score2, ok2 := z.score(args[2].bulk)
+		if !ok1 || !ok2 {
+			return Value{typ: "null"}
+		}
+		long1, lat1 := geoPosition(score1)
+		long2, lat2 := geoPosition(score2)
+		return Value{typ: "bulk", bulk: formatDistance(geoDistance(long1, lat1, long2, lat2), unit)}
+	})
+}
+
+// geoShape is the area a GEOSEARCH looks in: a circle of radius meters,
+// or a box of width by height meters, around a center.
+type geoShape struct {
+	longitude, latitude float64
+	byBox               bool
+	radius              float64
+	width, height       float64
+	// unit is the size in meters of the unit distances are replied in
+	unit float64
+}
+
+// contains reports whether a position is within the shape and its
+// distance to the center.
+func (s geoShape) contains(longitude, latitude float64) (float64, bool) {
+	if !s.byBox {
+		distance := geoDistance(s.longitude, s.latitude, longitude, latitude)
+		return distance, distance <= s.radius
+	}
+	// the latitude distance is the cheaper one, so it is checked first
+	if latDistance(latitude, s.latitude) > s.height/2 {
+		return 0, false
+	}
+	if geoDistance(longitude, latitude, s.longitude, latitude) > s.width/2 {
+		return 0, false
+	}
+	return geoDistance(s.longitude, s.latitude, longitude, latitude), true
+}
+
+// bounds returns the smallest box of degrees holding the shape.
+func (s geoShape) bounds() geoArea {
+	height, width := s.radius, s.radius
+	if s.byBox {
+		height, width = s.height/2, s.width/2
+	}
+	latDelta := radToDeg(height / earthRadius)
+	longDeltaTop := radToDeg(width / earthRadius / math.Cos(degToRad(s.latitude+latDelta)))
+	longDeltaBottom := radToDeg(width / earthRadius / math.Cos(degToRad(s.latitude-latDelta)))
+	// the shape spans the most longitude on the side nearer the pole
+	longDelta := longDeltaTop
+	if s.latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return geoArea{
+		longMin: s.longitude - longDelta,
+		longMax: s.longitude + longDelta,
+		latMin:  s.latitude - latDelta,
+		latMax:  s.latitude + latDelta,
+	}
+}
+
+// geoSearchStep returns the grid step at which a cell is about as wide as
+// a search of the given radius in meters.
+func geoSearchStep(radius, latitude float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// make sure the area fits in the cells in most cases
+	step -= 2
+	// cells get narrower towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// cells returns the cells whose members may lie within the shape: the cell
+// holding the center and those of its eight neighbours the shape reaches,
+// in the order Redis visits them.
+func (s geoShape) cells() []geohash {
+	bounds := s.bounds()
+	radius := s.radius
+	if s.byBox {
+		radius = math.Sqrt(s.width*s.width/4 + s.height*s.height/4)
+	}
+	step := geoSearchStep(radius, s.latitude)
+
+	center := encodeGeohash(s.longitude, s.latitude, step)
+	// the step is too coarse when the shape reaches past a neighbour
+	north, south := center.move(0, 1).area(), center.move(0, -1).area()
+	east, west := center.move(1, 0).area(), center.move(-1, 0).area()
+	if step > 1 && (north.latMax < bounds.latMax || south.latMin > bounds.latMin ||
+		east.longMax < bounds.longMax || west.longMin > bounds.longMin) {
+		step--
+		center = encodeGeohash(s.longitude, s.latitude, step)
+	}
+
+	// north, south, east, west, then the corners
+	moves := [][2]int{{0, 0}, {0, 1}, {0, -1}, {1, 0}, {-1, 0}, {1, 1}, {-1, 1}, {1, -1}, {-1, -1}}
+	area := center.area()
+	cells := []geohash{}
+	for _, m := range moves {
+		// neighbours on a side the center cell already covers are useless
+		if step >= 2 && (m[1] < 0 && area.latMin < bounds.latMin || m[1] > 0 && area.latMax > bounds.latMax ||
+			m[0] < 0 && area.longMin < bounds.longMin || m[0] > 0 && area.longMax > bounds.longMax) {
+			continue
+		}
+		cell := center.move(m[0], m[1])
+		// at the coarsest steps neighbours may be the same cell
+		if len(cells) > 0 && cells[len(cells)-1] == cell {
+			continue
+		}
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// geoMatch is a member found by GEOSEARCH.
+type geoMatch struct {
+	member              string
+	score               float64
+	distance            float64
+	longitude, latitude float64
+}
+
+// geoSearchArgs are the parsed arguments of GEOSEARCH.
+type geoSearchArgs struct {
+	// fromMember is the member at the center, when byMember is set
+	fromMember string
+	byMember   bool
+	shape      geoShape
+	// sort is 1 for ASC, -1 for DESC and 0 for unsorted
+	sort                          int
+	count                         int
+	any                           bool
+	withCoord, withDist, withHash bool
+}
+
+// parseGeoSearch parses the arguments of GEOSEARCH after the key.
+func parseGeoSearch(args []Value) (geoSearchArgs, Value, bool) {
+	var parsed geoSearchArgs
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	fromLonLat, byRadius, byBox := false, false, false
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToUpper(args[i].bulk); {
+		case option == "WITHDIST":
+			parsed.withDist = true
+		case option == "WITHHASH":
+			parsed.withHash = true
+		case option == "WITHCOORD":
+			parsed.withCoord = true
+		case option == "ANY":
+			parsed.any = true
+		case option == "ASC":
+			parsed.sort = 1
+		case option == "DESC":
+			parsed.sort = -1
+		case option == "COUNT" && remaining >= 1:
+			count, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil {
+				return parsed, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+			}
+			if count <= 0 {
+				return parsed, Value{typ: "error", str: "ERR COUNT must be > 0"}, false
+			}
+			parsed.count = count
+			i++
+		case option == "FROMMEMBER" && remaining >= 1:
+			if fromLonLat {
+				return parsed, syntaxError, false
+			}
+			parsed.fromMember = args[i+1].bulk
+			parsed.byMember = true
+			i++
+		case option == "FROMLONLAT" && remaining >= 2:
+			if parsed.byMember {
+				return parsed, syntaxError, false
+			}
+			longitude, latitude, errReply, ok := parseCoordinates(args[i+1].bulk, args[i+2].bulk)
+			if !ok {
+				return parsed, errReply, false
+			}
+			parsed.shape.longitude, parsed.shape.latitude = longitude, latitude
+			fromLonLat = true
+			i += 2
+		case option == "BYRADIUS" && remaining >= 2:
+			if byBox {
+				return parsed, syntaxError, false
+			}
+			radius, err := strconv.ParseFloat(args[i+1].bulk, 64)
+			if err != nil || math.IsNaN(radius) {
+				return parsed, Value{typ: "error", str: "ERR need numeric radius"}, false
+			}
+			if radius < 0 {
+				return parsed, Value{typ: "error", str: "ERR radius cannot be negative"}, false
+			}
+			unit, errReply, ok := parseGeoUnit(args[i+2].bulk)
+			if !ok {
+				return parsed, errReply, false
+			}
+			parsed.shape.radius, parsed.shape.unit = radius*unit, unit
+			byRadius = true
+			i += 2
+		case option == "BYBOX" && remaining >= 3:
+			if byRadius {
+				return parsed, syntaxError, false
+			}
+			width, err := strconv.ParseFloat(args[i+1].bulk, 64)
+			if err != nil || math.IsNaN(width) {
+				return parsed, Value{typ: "error", str: "ERR need numeric width"}, false
+			}
+			height, err := strconv.ParseFloat(args[i+2].bulk, 64)
+			if err != nil || math.IsNaN(height) {
+				return parsed, Value{typ: "error", str: "ERR need numeric height"}, false
+			}
+			if width < 0 || height < 0 {
+				return parsed, Value{typ: "error", str: "ERR height or width cannot be negative"}, false
+			}
+			unit, errReply, ok := parseGeoUnit(args[i+3].bulk)
+			if !ok {
+				return parsed, errReply, false
+			}
+			parsed.shape.byBox = true
+			parsed.shape.width, parsed.shape.height, parsed.shape.unit = width*unit, height*unit, unit
+			byBox = true
+			i += 3
+		default:
+			return parsed, syntaxError, false
+		}
+	}
+
+	if !parsed.byMember && !fromLonLat {
+		return parsed, Value{typ: "error", str: "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch"}, false
+	}
+	if !byRadius && !byBox {
+		return parsed, Value{typ: "error", str: "ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch"}, false
+	}
+	if parsed.any && parsed.count == 0 {
+		return parsed, Value{typ: "error", str: "ERR the ANY argument requires COUNT argument"}, false
+	}
+	// the nearest members are the ones COUNT keeps
+	if parsed.count > 0 && parsed.sort == 0 && !parsed.any {
+		parsed.sort = 1
+	}
+	return parsed, Value{}, true
+}
+
+// geoSearch returns the members of z within the shape. With limit above 0
+// it stops once it found that many.
+func geoSearch(z *zsetValue, shape geoShape, limit int) []geoMatch {
+	matches := []geoMatch{}
+	for _, cell := range shape.cells() {
+		start, end := z.rangeIndexes(cell.scoreRange())
+		for _, e := range z.slice(start, end) {
+			longitude, latitude := geoPosition(e.score)
+			distance, ok := shape.contains(longitude, latitude)
+			if !ok {
+				continue
+			}
+			matches = append(matches, geoMatch{member: e.member, score: e.score, distance: distance, longitude: longitude, latitude: latitude})
+			if limit > 0 && len(matches) == limit {
+				return matches
+			}
+		}
+	}
+	return matches
+}
+
+// geosearch handles GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude
+// latitude BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT
+// count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH], replying the members
+// within the area, each with the requested details.
+func geosearch(args []Value) Value {
+	parsed, errReply, ok := parseGeoSearch(args[1:])
+	if !ok {
+		return errReply
+	}
+
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		if z.len() == 0 {
+			return Value{typ: "array", array: []Value{}}
+		}
+		shape := parsed.shape
+		if parsed.byMember {
+			score, ok := z.score(parsed.fromMember)
+			if !ok {
+				return Value{typ: "error", str: "ERR could not decode requested zset member"}
+			}
+			shape.longitude, shape.latitude = geoPosition(score)
+		}
+
+		limit := 0
+		if parsed.any {
+			limit = parsed.count
+		}
+		matches := geoSearch(z, shape, limit)
+		if parsed.sort != 0 {
+			slices.SortStableFunc(matches, func(a, b geoMatch) int {
+				if a.distance < b.distance {
+					return -parsed.sort
+				}
+				if a.distance > b.distance {
+					return parsed.sort
+				}
+				return 0
+			})
+		}
+		if parsed.count > 0 && len(matches) > parsed.count {
+			matches = matches[:parsed.count]
+		}
+
+		replies := make([]Value, 0, len(matches))
+		for _, m := range matches {
+			member := Value{typ: "bulk", bulk: m.member}
+			if !parsed.withDist && !parsed.withHash && !parsed.withCoord {
+				replies = append(replies, member)
+				continue
+			}
+			reply := []Value{member}
+			if parsed.withDist {
+				reply = append(reply, Value{typ: "bulk", bulk: formatDistance(m.distance, shape.unit)})
+			}
+			if parsed.withHash {
+				reply = append(reply, Value{typ: "integer", num: int(m.score)})
+			}
+			if parsed.withCoord {
+				reply = append(reply, coordinatesReply(m.longitude, m.latitude))
+			}
+			replies = append(replies, Value{typ: "array", array: reply})
+		}
+		return Value{typ: "array", array: replies}
+	})
+}
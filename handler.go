@@ -1,7 +1,9 @@
 package main
 
 import (
-	"sync"
+	"math"
+	"strconv"
+	"strings"
 )
 
 // The Handlers map is a core part of the command processing mechanism
@@ -14,12 +16,410 @@ var Handlers = map[string]func([]Value) Value{
 	"SET": set,
 	// "GET": Retrieves the value for a given key
 	"GET": get,
-	// "HSET": Sets a field in a hash stored at a key
+	// "HSET": Sets fields in a hash stored at a key
 	"HSET": hset,
 	// "HGET": Retrieves a field from a hash stored at a key
 	"HGET": hget,
 	// "HGETALL": Retrieves all fields and values of a hash stored at a key
 	"HGETALL": hgetall,
+	// "MEMORY": Memory usage introspection such as MEMORY STATS
+	"MEMORY": memory,
+	// "COMMAND": Metadata about the supported commands
+	"COMMAND": command,
+	// "INFO": Server information and statistics
+	"INFO": info,
+	// "CONFIG": Reads and changes settings at runtime
+	"CONFIG": config,
+	// "SAVE": Writes a snapshot of the dataset
+	"SAVE": save,
+	// "BGSAVE": Writes a snapshot of the dataset in the background
+	"BGSAVE": bgsave,
+	// "LASTSAVE": Unix time of the last successful snapshot
+	"LASTSAVE": lastsave,
+	// "SLOWLOG": Inspects the log of slow commands
+	"SLOWLOG": slowlogCommand,
+	// "SHUTDOWN": Saves and stops the server
+	"SHUTDOWN": shutdown,
+	// "DEBUG": Commands for testing the server such as DEBUG SLEEP
+	"DEBUG": debugCommand,
+	// "PUBLISH": Posts a message to a pub/sub channel
+	"PUBLISH": publish,
+	// "PUBSUB": Lists channels and counts their subscribers
+	"PUBSUB": pubsubCommand,
+	// "SPUBLISH": Posts a message to a shard channel
+	"SPUBLISH": spublish,
+	// "IDGEN": Generates monotonically increasing IDs per namespace
+	"IDGEN": idgenCommand,
+	// "SESSION.SET": Stores a session with a TTL and tags
+	"SESSION.SET": sessionSet,
+	// "SESSION.GET": Returns the value of a session
+	"SESSION.GET": sessionGet,
+	// "SESSION.TOUCH": Extends the TTL of a session
+	"SESSION.TOUCH": sessionTouch,
+	// "SESSION.DELBYTAG": Deletes every session carrying a tag
+	"SESSION.DELBYTAG": sessionDelByTag,
+	// "DEL": Deletes one or more keys
+	"DEL": del,
+	// "EXISTS": Counts how many of the given keys exist
+	"EXISTS": exists,
+	// "EXPIRE": Sets a time to live in seconds
+	"EXPIRE": expireCommand("expire", 1000, false),
+	// "PEXPIRE": Sets a time to live in milliseconds
+	"PEXPIRE": expireCommand("pexpire", 1, false),
+	// "PEXPIREAT": Sets an expiry time as a Unix time in milliseconds
+	"PEXPIREAT": expireCommand("pexpireat", 1, true),
+	// "TTL": Remaining time to live in seconds
+	"TTL": ttlCommand(1000),
+	// "PTTL": Remaining time to live in milliseconds
+	"PTTL": ttlCommand(1),
+	// "CLUSTER": Cluster helpers such as CLUSTER REBALANCE-PLAN
+	"CLUSTER": clusterCommand,
+	// "EXPIREAT": Sets an expiry time as a Unix time in seconds
+	"EXPIREAT": expireCommand("expireat", 1000, true),
+	// "PERSIST": Removes the expiry time of a key
+	"PERSIST": persist,
+	// "EXPIRETIME": Expiry time as a Unix time in seconds
+	"EXPIRETIME": expireTimeCommand(1000),
+	// "PEXPIRETIME": Expiry time as a Unix time in milliseconds
+	"PEXPIRETIME": expireTimeCommand(1),
+	// "CHECKSUM": Digest of the dataset to compare instances
+	"CHECKSUM": checksum,
+	// "SNAPSHOT": Attaches a snapshot file as the read-only database 1
+	"SNAPSHOT": snapshotCommand,
+	// "OBJECT": Key introspection: idle time and last access times
+	"OBJECT": objectCommand,
+	// "SETNX": Sets a key only if it does not exist
+	"SETNX": setnx,
+	// "SETEX": Sets a key with an expiry time in seconds
+	"SETEX": setexCommand("setex", 1000),
+	// "PSETEX": Sets a key with an expiry time in milliseconds
+	"PSETEX": setexCommand("psetex", 1),
+	// "MGET": Values of several keys at once
+	"MGET": mget,
+	// "MSET": Sets several keys at once
+	"MSET": msetCommand("mset", false),
+	// "MSETNX": Sets several keys at once unless one exists
+	"MSETNX": msetCommand("msetnx", true),
+	// "GETSET": Replaces a value, returning the old one
+	"GETSET": getset,
+	// "GETDEL": Returns a value and deletes the key
+	"GETDEL": getdel,
+	// "GETEX": Returns a value and changes its expiry time
+	"GETEX": getex,
+	// "JOBS": Background jobs: LIST and CANCEL
+	"JOBS": jobsCommand,
+	// "TYPE": Type of the value stored at a key
+	"TYPE": typeCommand,
+	// "SCAN": Iterates over the keyspace with a cursor
+	"SCAN": scan,
+	// "DBSIZE": Number of keys
+	"DBSIZE": dbsize,
+	// "FLUSHDB": Deletes every key
+	"FLUSHDB": flush,
+	// "FLUSHALL": Deletes every key
+	"FLUSHALL": flush,
+	// "UNLINK": Deletes keys, freeing big values in the background
+	"UNLINK": unlink,
+	// "ECHO": Replies its argument as a bulk string
+	"ECHO": echo,
+	// "HDEL": Removes fields from a hash stored at a key
+	"HDEL": hdel,
+	// "HEXISTS": Checks whether a field exists in a hash
+	"HEXISTS": hexists,
+	// "HLEN": Counts the fields of a hash
+	"HLEN": hlen,
+	// "HKEYS": Lists the fields of a hash
+	"HKEYS": hkeys,
+	// "HVALS": Lists the values of a hash
+	"HVALS": hvals,
+	// "HMSET": Sets fields in a hash, replying OK
+	"HMSET": hmset,
+	// "HMGET": Retrieves several fields from a hash
+	"HMGET": hmget,
+	// "HSETNX": Sets a field in a hash unless it exists
+	"HSETNX": hsetnx,
+	// "HSCAN": Iterates over the fields of a hash a few at a time
+	"HSCAN": hscan,
+	// "LPUSH": Prepends elements to a list
+	"LPUSH": listPush(true),
+	// "RPUSH": Appends elements to a list
+	"RPUSH": listPush(false),
+	// "LPOP": Removes and returns elements from the head of a list
+	"LPOP": listPop(true),
+	// "RPOP": Removes and returns elements from the tail of a list
+	"RPOP": listPop(false),
+	// "LLEN": Length of a list
+	"LLEN": llen,
+	// "LRANGE": Elements of a list between two indexes
+	"LRANGE": lrange,
+	// "LINSERT": Inserts an element before or after another one in a list
+	"LINSERT": linsert,
+	// "LSET": Replaces the element at an index of a list
+	"LSET": lset,
+	// "LREM": Removes occurrences of an element from a list
+	"LREM": lrem,
+	// "LTRIM": Keeps only a range of elements of a list
+	"LTRIM": ltrim,
+	// "LINDEX": Element at an index of a list
+	"LINDEX": lindex,
+	// "LPOS": Indexes of matching elements in a list
+	"LPOS": lpos,
+	// "LMOVE": Moves an element from one list to another
+	"LMOVE": lmove,
+	// "RPOPLPUSH": Moves the last element of a list to the head of another
+	"RPOPLPUSH": rpoplpush,
+	// "LMPOP": Pops elements from the first non-empty list
+	"LMPOP": lmpop,
+	// "SADD": Adds members to a set
+	"SADD": sadd,
+	// "SREM": Removes members from a set
+	"SREM": srem,
+	// "SMEMBERS": All members of a set
+	"SMEMBERS": smembers,
+	// "SISMEMBER": Whether a member belongs to a set
+	"SISMEMBER": sismember,
+	// "SCARD": Number of members of a set
+	"SCARD": scard,
+	// "SINTER": Members common to all given sets
+	"SINTER": setAlgebra(setInter),
+	// "SINTERSTORE": Stores the members common to all given sets
+	"SINTERSTORE": setAlgebraStore(setInter),
+	// "SUNION": Members of any of the given sets
+	"SUNION": setAlgebra(setUnion),
+	// "SUNIONSTORE": Stores the members of any of the given sets
+	"SUNIONSTORE": setAlgebraStore(setUnion),
+	// "SDIFF": Members of the first set missing from the others
+	"SDIFF": setAlgebra(setDiff),
+	// "SDIFFSTORE": Stores the members of the first set missing from the others
+	"SDIFFSTORE": setAlgebraStore(setDiff),
+	// "SMISMEMBER": Whether each of several members belongs to a set
+	"SMISMEMBER": smismember,
+	// "SMOVE": Moves a member from one set to another
+	"SMOVE": smove,
+	// "SSCAN": Iterates over the members of a set a few at a time
+	"SSCAN": sscan,
+	// "SINTERCARD": Size of the intersection of several sets
+	"SINTERCARD": sintercard,
+	// "ZADD": Adds members to a sorted set or updates their scores
+	"ZADD": zadd,
+	// "ZSCORE": Score of a member of a sorted set
+	"ZSCORE": zscore,
+	// "ZCARD": Number of members of a sorted set
+	"ZCARD": zcard,
+	// "ZCOUNT": Number of members of a sorted set within a score range
+	"ZCOUNT": zcount,
+	// "ZRANGE": Members of a sorted set within a range of ranks, scores or members
+	"ZRANGE": zrangeCommand(byRank, false, true),
+	// "ZRANGESTORE": Stores a range of a sorted set in another key
+	"ZRANGESTORE": zrangestore,
+	// "ZREVRANGE": Members of a sorted set within a range of ranks, highest first
+	"ZREVRANGE": zrangeCommand(byRank, true, false),
+	// "ZRANGEBYSCORE": Members of a sorted set within a range of scores
+	"ZRANGEBYSCORE": zrangeCommand(byScore, false, false),
+	// "ZREVRANGEBYSCORE": Members of a sorted set within a range of scores, highest first
+	"ZREVRANGEBYSCORE": zrangeCommand(byScore, true, false),
+	// "ZRANK": Rank of a member of a sorted set, lowest score first
+	"ZRANK": zrankCommand(false),
+	// "ZREVRANK": Rank of a member of a sorted set, highest score first
+	"ZREVRANK": zrankCommand(true),
+	// "ZMSCORE": Scores of several members of a sorted set
+	"ZMSCORE": zmscore,
+	// "ZINCRBY": Adds to the score of a member of a sorted set
+	"ZINCRBY": zincrby,
+	// "ZREM": Removes members from a sorted set
+	"ZREM": zrem,
+	// "ZREMRANGEBYRANK": Removes the members of a sorted set within a range of ranks
+	"ZREMRANGEBYRANK": zremrangeCommand(byRank),
+	// "ZREMRANGEBYSCORE": Removes the members of a sorted set within a range of scores
+	"ZREMRANGEBYSCORE": zremrangeCommand(byScore),
+	// "ZUNION": Union of several sorted sets
+	"ZUNION": zsetOpCommand("zunion", setUnion, false),
+	// "ZINTER": Intersection of several sorted sets
+	"ZINTER": zsetOpCommand("zinter", setInter, false),
+	// "ZDIFF": Members of the first sorted set missing from the others
+	"ZDIFF": zsetOpCommand("zdiff", setDiff, false),
+	// "ZUNIONSTORE": Stores the union of several sorted sets
+	"ZUNIONSTORE": zsetOpCommand("zunionstore", setUnion, true),
+	// "ZINTERSTORE": Stores the intersection of several sorted sets
+	"ZINTERSTORE": zsetOpCommand("zinterstore", setInter, true),
+	// "ZDIFFSTORE": Stores the members of the first sorted set missing from the others
+	"ZDIFFSTORE": zsetOpCommand("zdiffstore", setDiff, true),
+	// "ZRANGEBYLEX": Members of a sorted set within a lexicographical range
+	"ZRANGEBYLEX": zrangeCommand(byLex, false, false),
+	// "ZREVRANGEBYLEX": Members of a sorted set within a lexicographical range, last first
+	"ZREVRANGEBYLEX": zrangeCommand(byLex, true, false),
+	// "ZLEXCOUNT": Number of members of a sorted set within a lexicographical range
+	"ZLEXCOUNT": zlexcount,
+	// "ZREMRANGEBYLEX": Removes the members of a sorted set within a lexicographical range
+	"ZREMRANGEBYLEX": zremrangeCommand(byLex),
+	// "ZSCAN": Iterates over the members of a sorted set a few at a time
+	"ZSCAN": zscan,
+	// "ZRANDMEMBER": Random members of a sorted set
+	"ZRANDMEMBER": zrandmember,
+	// "SETBIT": Sets or clears one bit of a string
+	"SETBIT": setbit,
+	// "GETBIT": One bit of a string
+	"GETBIT": getbit,
+	// "BITCOUNT": Number of set bits of a string or a range of it
+	"BITCOUNT": bitcount,
+	// "BITPOS": Position of the first set or clear bit of a string
+	"BITPOS": bitpos,
+	// "BITOP": Stores the bitwise AND, OR, XOR or NOT of strings
+	"BITOP": bitop,
+	// "BITFIELD": Reads, sets and increments integers packed in a string
+	"BITFIELD": bitfield,
+	// "GEOADD": Adds positions to a geospatial index
+	"GEOADD": geoadd,
+	// "GEOPOS": Positions of members of a geospatial index
+	"GEOPOS": geopos,
+	// "GEODIST": Distance between two members of a geospatial index
+	"GEODIST": geodist,
+	// "GEOSEARCH": Members of a geospatial index within a radius or a box
+	"GEOSEARCH": geosearch,
+	// "XADD": Appends an entry to a stream
+	"XADD": xadd,
+	// "XLEN": Number of entries in a stream
+	"XLEN": xlen,
+	// "XRANGE": Entries of a stream within a range of IDs
+	"XRANGE": xrangeCommand(false),
+	// "XREVRANGE": Entries of a stream within a range of IDs, newest first
+	"XREVRANGE": xrangeCommand(true),
+	// "XSETID": Sets the last ID of a stream
+	"XSETID": xsetid,
+	// "XGROUP": Manages the consumer groups of a stream
+	"XGROUP": xgroup,
+	// "XACK": Acknowledges entries pending in a consumer group
+	"XACK": xack,
+	// "XPENDING": Entries pending in a consumer group
+	"XPENDING": xpending,
+	// "XCLAIM": Hands pending entries over to another consumer
+	"XCLAIM": xclaim,
+	// "XDEL": Removes entries from a stream
+	"XDEL": xdel,
+	// "XTRIM": Trims a stream to a length or minimum ID
+	"XTRIM": xtrim,
+	// "XINFO": Describes a stream, its consumer groups and their consumers
+	"XINFO": xinfo,
+	// "XAUTOCLAIM": Hands idle pending entries over to another consumer, a batch at a time
+	"XAUTOCLAIM": xautoclaim,
+	// "JSON.SET": Sets the values at a path of a JSON document
+	"JSON.SET": jsonSet,
+	// "JSON.GET": Values at paths of a JSON document, serialized
+	"JSON.GET": jsonGet,
+	// "JSON.DEL": Deletes the values at a path of a JSON document
+	"JSON.DEL": jsonDel,
+	// "JSON.FORGET": Alias of JSON.DEL
+	"JSON.FORGET": jsonDel,
+	// "JSON.NUMINCRBY": Increments the numbers at a path of a JSON document
+	"JSON.NUMINCRBY": jsonNumIncrBy,
+	// "BF.RESERVE": Creates an empty Bloom filter
+	"BF.RESERVE": bfReserve,
+	// "BF.ADD": Adds an item to a Bloom filter
+	"BF.ADD": bfAdd,
+	// "BF.MADD": Adds items to a Bloom filter
+	"BF.MADD": bfMadd,
+	// "BF.EXISTS": Whether an item may be in a Bloom filter
+	"BF.EXISTS": bfExists,
+	// "BF.MEXISTS": Whether items may be in a Bloom filter
+	"BF.MEXISTS": bfMexists,
+	// "BF.INFO": Describes a Bloom filter
+	"BF.INFO": bfInfo,
+	// "BF.SCANDUMP": Dumps a Bloom filter a chunk at a time
+	"BF.SCANDUMP": bfScandump,
+	// "BF.LOADCHUNK": Restores a Bloom filter from its chunks
+	"BF.LOADCHUNK": bfLoadchunk,
+	// "CF.RESERVE": Creates an empty cuckoo filter
+	"CF.RESERVE": cfReserve,
+	// "CF.ADD": Adds an item to a cuckoo filter
+	"CF.ADD": cuckooAdd(false),
+	// "CF.ADDNX": Adds an item to a cuckoo filter unless it is found
+	"CF.ADDNX": cuckooAdd(true),
+	// "CF.EXISTS": Whether an item may be in a cuckoo filter
+	"CF.EXISTS": cfExists,
+	// "CF.MEXISTS": Whether items may be in a cuckoo filter
+	"CF.MEXISTS": cfMexists,
+	// "CF.DEL": Deletes an item from a cuckoo filter
+	"CF.DEL": cfDel,
+	// "CF.COUNT": Copies of an item in a cuckoo filter
+	"CF.COUNT": cfCount,
+	// "CF.INFO": Describes a cuckoo filter
+	"CF.INFO": cfInfo,
+	// "CF.SCANDUMP": Dumps a cuckoo filter a chunk at a time
+	"CF.SCANDUMP": cfScandump,
+	// "CF.LOADCHUNK": Restores a cuckoo filter from its chunks
+	"CF.LOADCHUNK": cfLoadchunk,
+	// "TS.CREATE": Creates an empty time series
+	"TS.CREATE": tsCreate,
+	// "TS.ALTER": Changes the options of a time series
+	"TS.ALTER": tsAlter,
+	// "TS.ADD": Adds a sample to a time series
+	"TS.ADD": tsAdd,
+	// "TS.MADD": Adds samples to time series
+	"TS.MADD": tsMadd,
+	// "TS.GET": The newest sample of a time series
+	"TS.GET": tsGet,
+	// "TS.RANGE": Samples of a time series in a range, optionally aggregated
+	"TS.RANGE": tsRangeCommand(false),
+	// "TS.REVRANGE": Samples of a time series in a range, newest first
+	"TS.REVRANGE": tsRangeCommand(true),
+	// "TS.DEL": Deletes the samples of a time series in a range
+	"TS.DEL": tsDel,
+	// "TS.INFO": Describes a time series
+	"TS.INFO": tsInfo,
+	// "TS.QUERYINDEX": Keys of the time series matching label filters
+	"TS.QUERYINDEX": tsQueryIndex,
+	// "FT.CREATE": Creates a search index over hashes
+	"FT.CREATE": ftCreate,
+	// "FT.SEARCH": Finds the hashes of an index matching a query
+	"FT.SEARCH": ftSearch,
+	// "FT.DROPINDEX": Deletes a search index
+	"FT.DROPINDEX": ftDropindex,
+	// "FT.INFO": Describes a search index
+	"FT.INFO": ftInfo,
+	// "FT._LIST": Names of the search indexes
+	"FT._LIST": ftList,
+}
+
+// ClientHandlers maps commands that need access to the calling connection,
+// such as MONITOR, to their handler functions. They are looked up after
+// Handlers and are never replayed from the AOF.
+var ClientHandlers = map[string]func(*Client, []Value) Value{
+	// "MONITOR": Streams every command processed by the server
+	"MONITOR": monitor,
+	// "AUTH": Authenticates the connection
+	"AUTH": auth,
+	// "HELLO": Chooses the protocol version and describes the server
+	"HELLO": hello,
+	// "MULTI": Starts a transaction
+	"MULTI": multi,
+	// "DISCARD": Drops the commands queued since MULTI
+	"DISCARD": discard,
+	// "CLIENT": Connection management subcommands such as CLIENT PAUSE
+	"CLIENT": client,
+	// "SUBSCRIBE": Listens for messages published to channels
+	"SUBSCRIBE": subscribe,
+	// "UNSUBSCRIBE": Stops listening to channels
+	"UNSUBSCRIBE": unsubscribe,
+	// "SSUBSCRIBE": Listens for messages published to shard channels
+	"SSUBSCRIBE": ssubscribe,
+	// "SUNSUBSCRIBE": Stops listening to shard channels
+	"SUNSUBSCRIBE": sunsubscribe,
+	// "SELECT": Switches between the live dataset and the attached snapshot
+	"SELECT": selectCommand,
+	// "EXPLAIN": Dry run: keys, slot, memory delta and verdict of a command
+	"EXPLAIN": explain,
+	// "BLPOP": Pops from the head of the first non-empty list, waiting for one
+	"BLPOP": blockingPop(true),
+	// "BRPOP": Pops from the tail of the first non-empty list, waiting for one
+	"BRPOP": blockingPop(false),
+	// "BLMOVE": Moves an element between lists, waiting for one
+	"BLMOVE": blmove,
+	// "BLMPOP": Pops elements from the first non-empty list, waiting for one
+	"BLMPOP": blmpop,
+	// "XREAD": Reads new entries of streams, waiting for them with BLOCK
+	"XREAD": xread,
+	// "XREADGROUP": Reads entries of streams for a consumer of a group
+	"XREADGROUP": xreadgroup,
 }
 
 // ping function takes a slice of Value structs as arguments and returns a Value struct.
@@ -33,37 +433,353 @@ func ping(args []Value) Value {
 	return Value{typ: "string", str: args[0].bulk}
 }
 
-// SETs is a global map variable that stores key-value pairs.
-// It is intended to hold string keys and string values.
-var SETs = map[string]string{}
+// echo handles ECHO message, replying the message as a bulk string. Unlike
+// PING with an argument, which answers with a status reply, the message
+// comes back byte for byte, so it also suits binary payloads.
+func echo(args []Value) Value {
+	return Value{typ: "bulk", bulk: args[0].bulk}
+}
+
+// storeValue prepares a string value for storage in the keyspace. Small repeated
+// values are interned when interning is enabled, otherwise small values are
+// moved into slab memory when slab storage is enabled.
+func storeValue(s string) string {
+	if internEnabled.Load() && len(s) <= internMaxLen {
+		return intern(s)
+	}
+	return slabAlloc(s)
+}
+
+// dropValue must be called with keyspaceMu held whenever a value stored
+// through storeValue leaves the keyspace, so that its intern reference or slab slot is freed.
+func dropValue(s string) {
+	release(s)
+	slabFree(s)
+}
+
+// expiryOption converts the time n given to EX, PX, EXAT or PXAT to Unix
+// milliseconds. Like Redis it refuses times that are not positive or would
+// overflow, instead of wrapping into the past.
+func expiryOption(option string, n int64) (int64, bool) {
+	if n <= 0 {
+		return 0, false
+	}
+	now := int64(0)
+	if option == "EX" || option == "PX" {
+		now = nowMs()
+	}
+	if option == "EX" || option == "EXAT" {
+		if n > math.MaxInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if n > math.MaxInt64-now {
+		return 0, false
+	}
+	return now + n, true
+}
+
+// setOptions are the options of a SET call.
+type setOptions struct {
+	// nx and xx only set the key when it does not or does exist
+	nx, xx bool
+	// get replies with the previous value instead of OK
+	get bool
+	// keepTTL keeps the expiry time of the key
+	keepTTL bool
+	// expireAt is the new expiry time in Unix milliseconds, 0 for none
+	expireAt int64
+}
 
-// SETsMu is a global read-write mutex variable used for synchronization.
-// It provides exclusive access to the SETs map to prevent race conditions
-// when reading from or writing to the map concurrently from multiple goroutines.
-var SETsMu = sync.RWMutex{}
+// parseSetOptions parses [NX|XX] [GET] [EX s|PX ms|EXAT s|PXAT ms|KEEPTTL].
+func parseSetOptions(args []Value) (setOptions, Value, bool) {
+	opts := setOptions{}
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	expiry := false
 
-// set func echoes the SET function from a redis database
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].bulk); option {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if expiry {
+				return opts, syntaxError, false
+			}
+			opts.keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiry || opts.keepTTL || i+1 == len(args) {
+				return opts, syntaxError, false
+			}
+			expiry = true
+			i++
+			n, err := strconv.ParseInt(args[i].bulk, 10, 64)
+			if err != nil {
+				return opts, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+			}
+			expireAt, ok := expiryOption(option, n)
+			if !ok {
+				return opts, Value{typ: "error", str: "ERR invalid expire time in 'set' command"}, false
+			}
+			opts.expireAt = expireAt
+		default:
+			return opts, syntaxError, false
+		}
+	}
+	if opts.nx && opts.xx {
+		return opts, syntaxError, false
+	}
+	return opts, Value{}, true
+}
+
+// set func echoes the SET function from a redis database:
+// SET key value [NX|XX] [GET] [EX s|PX ms|EXAT s|PXAT ms|KEEPTTL]
 func set(args []Value) Value {
 	// check for arguments error
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return Value{typ: "error", str: "ERR wrong number of arguments for 'set' command"}
 	}
 	// key from command
 	key := args[0].bulk
 	// val from command
 	value := args[1].bulk
-	// Acquires an exclusive lock (Lock()) on the mutex SETsMu, ensuring mutual exclusion.
+	// options following the value
+	opts, errReply, ok := parseSetOptions(args[2:])
+	if !ok {
+		return errReply
+	}
+	// Acquires an exclusive lock (Lock()) on the mutex keyspaceMu, ensuring mutual exclusion.
 	// This prevents other goroutines from accessing or modifying the map concurrently
-	SETsMu.Lock()
-	SETs[key] = value
-	// Releases the lock (Unlock()) on the mutex SETsMu after the update operation is
-	// completed. Releasing the lock allows other goroutines to acquire it and perform
+	keyspaceMu.Lock()
+	// Releases the lock (Unlock()) on the mutex keyspaceMu once the update is done.
+	// Releasing the lock allows other goroutines to acquire it and perform
 	// their operations on the map
-	SETsMu.Unlock()
-	// If the key exists, return OK
+	defer keyspaceMu.Unlock()
+
+	old, exists := keyspace[key]
+	// GET can only return a previous string value
+	if opts.get && exists && old.typ != stringObject {
+		return wrongTypeError
+	}
+	// the reply when the value is not replaced or GET was given
+	reply := Value{typ: "null"}
+	if opts.get && exists {
+		reply = Value{typ: "bulk", bulk: slabLoad(old.str)}
+	}
+	if opts.nx && exists || opts.xx && !exists {
+		return reply
+	}
+
+	// SET overwrites a value of any type, free the storage it held
+	if exists {
+		freeObject(old)
+	}
+	setKey(key, object{typ: stringObject, str: storeValue(value)})
+	markKeyspaceChanged()
+	// setting a value discards any expiry time of the key unless KEEPTTL
+	// was given
+	expiresMu.Lock()
+	if opts.expireAt > 0 {
+		setExpiry(key, opts.expireAt)
+	} else if !opts.keepTTL {
+		clearExpiry(key)
+	}
+	expiresMu.Unlock()
+
+	if opts.get {
+		return reply
+	}
 	return Value{typ: "string", str: "OK"}
 }
 
+// setPropagate persists SET with a relative expiry time as SET with the
+// absolute PXAT time, so that replaying it later yields the same deadline.
+// GET has no effect on the dataset and is dropped.
+func setPropagate(value Value, result Value) Value {
+	rewritten := Value{typ: "array", array: value.array[:3:3]}
+	opts := value.array[3:]
+	for i := 0; i < len(opts); i++ {
+		switch option := strings.ToUpper(opts[i].bulk); option {
+		case "GET":
+		case "EX", "PX", "EXAT", "PXAT":
+			n, _ := strconv.ParseInt(opts[i+1].bulk, 10, 64)
+			at := n
+			switch option {
+			case "EX":
+				at = nowMs() + n*1000
+			case "PX":
+				at = nowMs() + n
+			case "EXAT":
+				at = n * 1000
+			}
+			rewritten.array = append(rewritten.array, Value{typ: "bulk", bulk: "PXAT"}, Value{typ: "bulk", bulk: strconv.FormatInt(at, 10)})
+			i++
+		default:
+			rewritten.array = append(rewritten.array, opts[i])
+		}
+	}
+	return rewritten
+}
+
+// setnx handles SETNX key value, the legacy form of SET key value NX,
+// replying 1 when the key was set and 0 when it already existed.
+func setnx(args []Value) Value {
+	if set([]Value{args[0], args[1], {typ: "bulk", bulk: "NX"}}).typ == "null" {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: 1}
+}
+
+// setexCommand handles SETEX key seconds value and PSETEX key milliseconds
+// value, the legacy forms of SET key value EX|PX time. unit converts the
+// time to milliseconds.
+func setexCommand(name string, unit int64) func([]Value) Value {
+	return func(args []Value) Value {
+		n, err := strconv.ParseInt(args[1].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		option := "PX"
+		if unit == 1000 {
+			option = "EX"
+		}
+		// refuse what SET would, but naming this command
+		if _, ok := expiryOption(option, n); !ok {
+			return Value{typ: "error", str: "ERR invalid expire time in '" + name + "' command"}
+		}
+		ms := strconv.FormatInt(n*unit, 10)
+		return set([]Value{args[0], args[2], {typ: "bulk", bulk: "PX"}, {typ: "bulk", bulk: ms}})
+	}
+}
+
+// setexPropagate persists SETEX and PSETEX as SET with the absolute PXAT
+// expiry time.
+func setexPropagate(value Value, result Value) Value {
+	n, _ := strconv.ParseInt(value.array[2].bulk, 10, 64)
+	if strings.EqualFold(value.array[0].bulk, "SETEX") {
+		n *= 1000
+	}
+	at := strconv.FormatInt(nowMs()+n, 10)
+	return commandValue("SET", value.array[1].bulk, value.array[3].bulk, "PXAT", at)
+}
+
+// getset handles GETSET key value, the legacy form of SET key value GET.
+func getset(args []Value) Value {
+	return set([]Value{args[0], args[1], {typ: "bulk", bulk: "GET"}})
+}
+
+// getdel handles GETDEL key, replying the string value of the key and
+// deleting it in the same step, which suits one-shot tokens.
+func getdel(args []Value) Value {
+	key := args[0].bulk
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if !ok {
+		return Value{typ: "null"}
+	}
+	if obj.typ != stringObject {
+		return wrongTypeError
+	}
+	// copy the value out before deleteKey frees its storage
+	value := slabLoad(obj.str)
+	deleteKey(key)
+	return Value{typ: "bulk", bulk: value}
+}
+
+// getex handles GETEX key [EX s|PX ms|EXAT s|PXAT ms|PERSIST], replying the
+// string value of the key while changing its expiry time.
+func getex(args []Value) Value {
+	key := args[0].bulk
+
+	persist := false
+	var expireAt int64
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	for i := 1; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].bulk); option {
+		case "PERSIST":
+			if persist || expireAt != 0 {
+				return syntaxError
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if persist || expireAt != 0 || i+1 == len(args) {
+				return syntaxError
+			}
+			i++
+			n, err := strconv.ParseInt(args[i].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			at, ok := expiryOption(option, n)
+			if !ok {
+				return Value{typ: "error", str: "ERR invalid expire time in 'getex' command"}
+			}
+			expireAt = at
+		default:
+			return syntaxError
+		}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if !ok {
+		return Value{typ: "null"}
+	}
+	if obj.typ != stringObject {
+		return wrongTypeError
+	}
+
+	// the expiry time lives outside the keyspace, so the read lock is enough to
+	// keep the key from disappearing while it changes
+	expiresMu.Lock()
+	if persist {
+		clearExpiry(key)
+	} else if expireAt != 0 {
+		setExpiry(key, expireAt)
+	}
+	expiresMu.Unlock()
+
+	return Value{typ: "bulk", bulk: slabLoad(obj.str)}
+}
+
+// getexPropagate persists GETEX as the expiry change it made: PEXPIREAT
+// with the absolute time, PERSIST, or nothing when the expiry time was
+// left alone or the key does not exist.
+func getexPropagate(value Value, result Value) Value {
+	if result.typ != "bulk" {
+		return Value{}
+	}
+	key := value.array[1].bulk
+	opts := value.array[2:]
+	for i := 0; i < len(opts); i++ {
+		option := strings.ToUpper(opts[i].bulk)
+		if option == "PERSIST" {
+			return commandValue("PERSIST", key)
+		}
+		n, _ := strconv.ParseInt(opts[i+1].bulk, 10, 64)
+		at := n
+		switch option {
+		case "EX":
+			at = nowMs() + n*1000
+		case "PX":
+			at = nowMs() + n
+		case "EXAT":
+			at = n * 1000
+		}
+		return commandValue("PEXPIREAT", key, strconv.FormatInt(at, 10))
+	}
+	return Value{}
+}
+
 // get function simulates the GET command from a Redis-like database.
 // It retrieves the value associated with the specified key from the database.
 // If the key does not exist, it returns a null value.
@@ -76,53 +792,268 @@ func get(args []Value) Value {
 	// Extract the key from the command arguments
 	key := args[0].bulk
 
-	// Acquire a read lock (RLock()) on the mutex SETsMu to allow concurrent reads
-	SETsMu.RLock()
-	// Retrieve the value associated with the key from the map SETs
-	value, ok := SETs[key]
-	// Release the read lock (RUnlock()) on the mutex SETsMu after the read operation
-	SETsMu.RUnlock()
+	// In read-mostly mode try the lock-free snapshot first
+	if readMostly.Load() {
+		if obj, ok, served := snapshotGet(key); served {
+			return getReply(obj, ok)
+		}
+	}
+
+	// Acquire a read lock (RLock()) on the mutex keyspaceMu to allow concurrent reads
+	keyspaceMu.RLock()
+	// Retrieve the value associated with the key from the keyspace
+	obj, ok := keyspace[key]
+	// Copy the value out of slab memory while it can not be overwritten
+	obj.str = slabLoad(obj.str)
+	// Release the read lock (RUnlock()) on the mutex keyspaceMu after the read operation
+	keyspaceMu.RUnlock()
+
+	return getReply(obj, ok)
+}
 
+// getReply builds the reply of GET for the object found at the key: null
+// when the key does not exist, WRONGTYPE when it holds no string, and the
+// value otherwise.
+func getReply(obj object, ok bool) Value {
 	// If the key does not exist in the map, return a null value
 	if !ok {
 		return Value{typ: "null"}
 	}
+	// Only strings can be read with GET
+	if obj.typ != stringObject {
+		return wrongTypeError
+	}
 
 	// If the key exists, return the value associated with it
-	return Value{typ: "bulk", bulk: value}
+	return Value{typ: "bulk", bulk: obj.str}
 }
 
-// HSETs is a map representing a Redis-like hash set data structure.
-var HSETs = map[string]map[string]string{}
+// getBatch looks up several keys at once for pipelined GETs (and MGET),
+// taking the read lock once for the whole batch instead of once per key.
+// Every key yields the reply GET would give for it.
+func getBatch(keys []string) []Value {
+	values := make([]Value, len(keys))
 
-// HSETsMu is a read-write mutex used for synchronization when accessing the HSETs map.
-// It provides exclusive access to the map to prevent race conditions when reading from
-// or writing to the map concurrently from multiple goroutines.
-var HSETsMu = sync.RWMutex{}
+	if readMostly.Load() {
+		snap := keyspaceSnapshot.Load()
+		if snap != nil && snap.version == keyspaceVersion.Load() {
+			for i, key := range keys {
+				obj, ok := snap.m[key]
+				values[i] = getReply(obj, ok)
+			}
+			return values
+		}
+	}
+
+	keyspaceMu.RLock()
+	for i, key := range keys {
+		obj, ok := keyspace[key]
+		obj.str = slabLoad(obj.str)
+		values[i] = getReply(obj, ok)
+	}
+	keyspaceMu.RUnlock()
 
-// The HSET command is used to set the value of a field within a hash stored at a specific key.
+	return values
+}
+
+// mget handles MGET key [key ...], replying the values of all keys with
+// nulls for missing ones. Like in Redis, keys holding no string are
+// reported as missing rather than failing the whole call.
+func mget(args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+	values := getBatch(keys)
+	for i := range values {
+		if values[i].typ == "error" {
+			values[i] = Value{typ: "null"}
+		}
+	}
+	return Value{typ: "array", array: values}
+}
+
+// msetCommand handles MSET key value [key value ...] and, with nx set,
+// MSETNX, which sets nothing unless none of the keys exists. All keys are
+// set under a single lock acquisition, so no client sees only some of
+// them.
+func msetCommand(name string, nx bool) func([]Value) Value {
+	return func(args []Value) Value {
+		if len(args)%2 != 0 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+		}
+
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		if nx {
+			for i := 0; i < len(args); i += 2 {
+				if _, ok := keyspace[args[i].bulk]; ok {
+					return Value{typ: "integer", num: 0}
+				}
+			}
+		}
+
+		expiresMu.Lock()
+		for i := 0; i < len(args); i += 2 {
+			key := args[i].bulk
+			// like SET, overwrite a value of any type and free its storage
+			if old, ok := keyspace[key]; ok {
+				freeObject(old)
+			}
+			setKey(key, object{typ: stringObject, str: storeValue(args[i+1].bulk)})
+			clearExpiry(key)
+		}
+		expiresMu.Unlock()
+		markKeyspaceChanged()
+
+		if nx {
+			return Value{typ: "integer", num: 1}
+		}
+		return Value{typ: "string", str: "OK"}
+	}
+}
+
+// The HSET command is used to set the value of one or more fields within a hash stored at a specific key:
+// HSET hash field value [field value ...]
 // It operates on Redis hash data structures, which allow for the storage of multiple field-value pairs under a single key.
+// It replies the number of fields that were added; fields that already existed only have their value replaced.
 func hset(args []Value) Value {
-	if len(args) != 3 {
+	if len(args) < 3 || len(args)%2 == 0 {
 		return Value{typ: "error", str: "ERR wrong number of arguments for 'hset' command"}
 	}
 	// access hash table
 	hash := args[0].bulk
-	key := args[1].bulk
-	value := args[2].bulk
 
-	// Acquire an exclusive lock (Lock()) on the mutex HSETsMu to ensure mutual exclusion
-	HSETsMu.Lock()
-	if _, ok := HSETs[hash]; !ok {
-		HSETs[hash] = map[string]string{}
+	// Acquire an exclusive lock (Lock()) on the mutex keyspaceMu to ensure mutual exclusion
+	keyspaceMu.Lock()
+	// Release the lock (Unlock()) on the mutex keyspaceMu after the update operation
+	defer keyspaceMu.Unlock()
+
+	// Fields can only be set on a hash
+	fields, ok := writableHash(hash)
+	if !ok {
+		return wrongTypeError
+	}
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		// Field names are shared between hashes with the same schema, so they
+		// are interned when first added; an existing field keeps its name and
+		// only releases the value it held before
+		if fields.set(args[i].bulk, args[i+1].bulk) {
+			added++
+		}
+	}
+
+	return Value{typ: "integer", num: added}
+}
+
+// writableHash returns the fields of the hash stored at key, creating an
+// empty hash when the key does not exist, or false when the key holds
+// another type. A hash created this way must get a field before keyspaceMu,
+// which must be held for writing, is released.
+func writableHash(key string) (*hashValue, bool) {
+	obj, ok := keyspace[key]
+	if ok {
+		return obj.hash(), obj.typ == hashObject
+	}
+	obj = object{typ: hashObject, value: &hashValue{}}
+	setKey(key, obj)
+	markKeyspaceChanged()
+	return obj.hash(), true
+}
+
+// hsetnx handles HSETNX hash field value, setting the field only when it
+// does not exist yet. It replies 1 when the field was set and 0 otherwise.
+func hsetnx(args []Value) Value {
+	hash, field, value := args[0].bulk, args[1].bulk, args[2].bulk
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	if obj, ok := keyspace[hash]; ok {
+		if obj.typ != hashObject {
+			return wrongTypeError
+		}
+		if _, exists := obj.hash().get(field); exists {
+			return Value{typ: "integer", num: 0}
+		}
 	}
-	HSETs[hash][key] = value
-	// Release the lock (Unlock()) on the mutex HSETsMu after the update operation
-	HSETsMu.Unlock()
+	fields, _ := writableHash(hash)
+	fields.set(field, value)
+	return Value{typ: "integer", num: 1}
+}
 
+// hmset handles HMSET hash field value [field value ...], the legacy form
+// of HSET replying OK.
+func hmset(args []Value) Value {
+	if len(args)%2 == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hmset' command"}
+	}
+	if reply := hset(args); reply.typ == "error" {
+		return reply
+	}
 	return Value{typ: "string", str: "OK"}
 }
 
+// removeHashFields deletes fields from a hash and reports how many of them
+// existed. A hash left without fields is removed from the keyspace. It must
+// be called with keyspaceMu held for writing, on a key that holds a hash or
+// does not exist.
+func removeHashFields(hash string, fields []string) int {
+	removed := 0
+	values := keyspace[hash].hash()
+	for _, field := range fields {
+		// del gives back the interned field name and value
+		if values.del(field) {
+			removed++
+		}
+	}
+	dropIfEmpty(hash)
+	return removed
+}
+
+// hdel handles HDEL hash field [field ...], replying how many of the fields
+// existed. The hash is deleted once its last field is gone.
+func hdel(args []Value) Value {
+	hash := args[0].bulk
+	fields := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		fields[i] = arg.bulk
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	if obj, ok := keyspace[hash]; ok && obj.typ != hashObject {
+		return wrongTypeError
+	}
+	return Value{typ: "integer", num: removeHashFields(hash, fields)}
+}
+
+// dropIfEmpty removes key from the keyspace when it names a container that
+// has no elements left, together with its expiry and access times. Every
+// command that removes elements from a collection calls it, since empty
+// keys would still count for EXISTS and the keyspace info and never free
+// their memory. Streams with consumer groups are kept, since the groups
+// are still read from, and so are JSON documents, an empty one being a
+// value of its own. It must be called with keyspaceMu held for writing.
+func dropIfEmpty(key string) {
+	obj, ok := keyspace[key]
+	if !ok || obj.typ == stringObject || obj.typ == jsonObject || obj.elements() > 0 {
+		return
+	}
+	if obj.typ == streamObject && len(obj.stream().groups) > 0 {
+		return
+	}
+	removeKey(key)
+	markKeyspaceChanged()
+	expiresMu.Lock()
+	clearExpiry(key)
+	expiresMu.Unlock()
+	forgetAccess(key)
+}
+
 // hget is a function that retrieves the value associated with a specified key from
 // a hash in the in-memory database.It takes an array of arguments, where the first
 // argument is the hash name and the second argument is the key. If the number of
@@ -144,12 +1075,19 @@ func hget(args []Value) Value {
 	hash := args[0].bulk
 	key := args[1].bulk
 
-	// Read lock to access the hash map storing the hash sets
-	HSETsMu.RLock()
+	// Read lock to access the keyspace storing the hash sets
+	keyspaceMu.RLock()
+	// Retrieve the hash set stored at the hash name
+	obj, exists := keyspace[hash]
 	// Retrieve the value associated with the key from the hash set
-	value, ok := HSETs[hash][key]
+	value, ok := obj.hash().get(key)
 	// Release the read lock
-	HSETsMu.RUnlock()
+	keyspaceMu.RUnlock()
+
+	// Fields can only be read from a hash
+	if exists && obj.typ != hashObject {
+		return wrongTypeError
+	}
 
 	// Check if the key exists in the hash set
 	if !ok {
@@ -177,28 +1115,214 @@ func hgetall(args []Value) Value {
 	// Extract the hash name from the arguments
 	hash := args[0].bulk
 
-	// Read lock to access the hash map storing the hash sets
-	HSETsMu.RLock()
+	// Read lock to access the keyspace storing the hash sets. It is held
+	// while the fields are copied out, since writers modify the same map
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
 	// Retrieve the hash set associated with the hash name
-	value, ok := HSETs[hash]
-	// Release the read lock
-	HSETsMu.RUnlock()
+	obj, ok := keyspace[hash]
 
 	// Check if the hash exists
 	if !ok {
 		// If the hash does not exist, return a null value
 		return Value{typ: "null"}
 	}
+	// Fields can only be read from a hash
+	if obj.typ != hashObject {
+		return wrongTypeError
+	}
+	value := obj.hash()
 
 	// Initialize an empty array to store key-value pairs
 	values := []Value{}
-	// Iterate over all key-value pairs in the hash set
-	for k, v := range value {
+	// Iterate over all fields of the hash set in reply order
+	for _, k := range fieldOrder(value) {
 		// Append the key and value as bulk responses to the values array
 		values = append(values, Value{typ: "bulk", bulk: k})
+		v, _ := value.get(k)
 		values = append(values, Value{typ: "bulk", bulk: v})
 	}
 
 	// Return an array containing all key-value pairs
 	return Value{typ: "array", array: values}
 }
+
+// readHash calls read with the fields of the hash stored at key, nil when
+// the key does not exist, while holding keyspaceMu for reading. It replies
+// WRONGTYPE instead when the key holds another type.
+func readHash(key string, read func(fields *hashValue) Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != hashObject {
+		return wrongTypeError
+	}
+	return read(obj.hash())
+}
+
+// hexists handles HEXISTS hash field, replying 1 when the field exists.
+func hexists(args []Value) Value {
+	return readHash(args[0].bulk, func(fields *hashValue) Value {
+		_, ok := fields.get(args[1].bulk)
+		return Value{typ: "integer", num: int(boolToUint(ok))}
+	})
+}
+
+// hlen handles HLEN hash, replying the number of fields, 0 for a missing
+// hash.
+func hlen(args []Value) Value {
+	return readHash(args[0].bulk, func(fields *hashValue) Value {
+		return Value{typ: "integer", num: fields.len()}
+	})
+}
+
+// hkeys handles HKEYS hash, replying the field names in reply order.
+func hkeys(args []Value) Value {
+	return readHash(args[0].bulk, func(fields *hashValue) Value {
+		names := []Value{}
+		for _, f := range fieldOrder(fields) {
+			names = append(names, Value{typ: "bulk", bulk: f})
+		}
+		return Value{typ: "array", array: names}
+	})
+}
+
+// hvals handles HVALS hash, replying the values in the same order as
+// HKEYS replies their fields.
+func hvals(args []Value) Value {
+	return readHash(args[0].bulk, func(fields *hashValue) Value {
+		values := []Value{}
+		for _, f := range fieldOrder(fields) {
+			v, _ := fields.get(f)
+			values = append(values, Value{typ: "bulk", bulk: v})
+		}
+		return Value{typ: "array", array: values}
+	})
+}
+
+// hmget handles HMGET hash field [field ...], replying the values of the
+// fields with nulls for missing ones.
+func hmget(args []Value) Value {
+	return readHash(args[0].bulk, func(fields *hashValue) Value {
+		values := []Value{}
+		for _, arg := range args[1:] {
+			if value, ok := fields.get(arg.bulk); ok {
+				values = append(values, Value{typ: "bulk", bulk: value})
+			} else {
+				values = append(values, Value{typ: "null"})
+			}
+		}
+		return Value{typ: "array", array: values}
+	})
+}
+
+// del removes the given keys from the keyspace, whatever their type, and
+// returns how many keys were removed.
+func del(args []Value) Value {
+	removed := 0
+
+	keyspaceMu.Lock()
+	for _, arg := range args {
+		if deleteKey(arg.bulk) {
+			removed++
+		}
+	}
+	keyspaceMu.Unlock()
+
+	return Value{typ: "integer", num: removed}
+}
+
+// exists returns how many of the given keys exist in the keyspace. Like in
+// Redis, a key given several times is counted every time.
+func exists(args []Value) Value {
+	count := 0
+
+	keyspaceMu.RLock()
+	for _, arg := range args {
+		if _, ok := keyspace[arg.bulk]; ok {
+			count++
+		}
+	}
+	keyspaceMu.RUnlock()
+
+	return Value{typ: "integer", num: count}
+}
+
+// clearDataset deletes every key, session and ID generator. With async the
+// keyspace is swapped for an empty map and the old one is freed by the
+// lazy freer, so the lock is only held for as long as the swap takes.
+func clearDataset(async bool) {
+	keyspaceMu.Lock()
+	if async {
+		freeDataset(keyspace)
+		keyspace = map[string]object{}
+		rehashAbandon()
+		markKeyspaceChanged()
+
+		expiresMu.Lock()
+		expires = map[string]int64{}
+		expiresCount.Store(0)
+		expiresMu.Unlock()
+
+		accessMu.Lock()
+		accessTimes = map[string]*keyAccess{}
+		accessMu.Unlock()
+	} else {
+		for key := range keyspace {
+			deleteKey(key)
+		}
+	}
+	keyspaceMu.Unlock()
+
+	sessionsMu.Lock()
+	sessions = map[string]*session{}
+	sessionTags = map[string]map[string]bool{}
+	sessionsMu.Unlock()
+
+	idgensMu.Lock()
+	idgens = map[string]int64{}
+	idgensMu.Unlock()
+
+	// indexes are kept, like in RediSearch, and rebuilt from what comes
+	searchReindexAll()
+	// every cached key is gone
+	trackingFlush()
+}
+
+// flush handles FLUSHDB and FLUSHALL [ASYNC|SYNC]. The server has a single
+// writable database, so both delete every key, session and ID generator.
+// SYNC deletes the keys one by one like DEL, freeing only big hashes in the
+// background. ASYNC swaps in an empty keyspace and frees every value in the
+// background, so clients are not held up by a big dataset. The attached
+// snapshot is read-only and survives both.
+func flush(args []Value) Value {
+	if len(args) > 1 || len(args) == 1 && !strings.EqualFold(args[0].bulk, "ASYNC") && !strings.EqualFold(args[0].bulk, "SYNC") {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	clearDataset(len(args) == 1 && strings.EqualFold(args[0].bulk, "ASYNC"))
+	return Value{typ: "string", str: "OK"}
+}
+
+// dbsize handles DBSIZE, replying the number of keys.
+func dbsize(args []Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	return Value{typ: "integer", num: len(keyspace)}
+}
+
+// keyType returns the type of the value stored at key as TYPE names it,
+// such as "string", "hash" or "MBbloom--" for a Bloom filter, or "none" for
+// a missing key. keyspaceMu must be held for reading.
+func keyType(key string) string {
+	return keyspace[key].typ.String()
+}
+
+// typeCommand handles TYPE key.
+func typeCommand(args []Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	return Value{typ: "string", str: keyType(args[0].bulk)}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// run executes a command the way a client would and returns its reply.
+func run(t *testing.T, args ...string) Value {
+	t.Helper()
+	c := &Client{resp: 2}
+	return executeCommand(c, strings.ToUpper(args[0]), commandValue(args...))
+}
+
+// TestEmptiedContainersAreDeleted checks that removing the last element of
+// a container deletes the key with its expiry and access times, instead of
+// leaving an empty value behind.
+func TestEmptiedContainersAreDeleted(t *testing.T) {
+	tests := []struct {
+		name   string
+		create [][]string
+		empty  []string
+	}{
+		{"HDEL", [][]string{{"HSET", "k", "f1", "v", "f2", "v"}}, []string{"HDEL", "k", "f1", "f2"}},
+		{"SREM", [][]string{{"SADD", "k", "a", "b"}}, []string{"SREM", "k", "a", "b"}},
+		{"LPOP", [][]string{{"RPUSH", "k", "a", "b"}}, []string{"LPOP", "k", "2"}},
+		{"ZREM", [][]string{{"ZADD", "k", "1", "a", "2", "b"}}, []string{"ZREM", "k", "a", "b"}},
+		{"XTRIM", [][]string{{"XADD", "k", "1-1", "f", "v"}, {"XADD", "k", "1-2", "f", "v"}}, []string{"XTRIM", "k", "MAXLEN", "0"}},
+		{"XDEL", [][]string{{"XADD", "k", "1-1", "f", "v"}}, []string{"XDEL", "k", "1-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run(t, "FLUSHALL", "SYNC")
+			run(t, "SET", "other", "v")
+			for _, create := range tt.create {
+				if v := run(t, create...); v.typ == "error" {
+					t.Fatalf("%v: %s", create, v.str)
+				}
+			}
+			run(t, "EXPIRE", "k", "1000")
+
+			if v := run(t, "EXISTS", "k"); v.num != 1 {
+				t.Fatalf("EXISTS before = %d, want 1", v.num)
+			}
+			if v := run(t, "DBSIZE"); v.num != 2 {
+				t.Fatalf("DBSIZE before = %d, want 2", v.num)
+			}
+
+			if v := run(t, tt.empty...); v.typ == "error" {
+				t.Fatalf("%v: %s", tt.empty, v.str)
+			}
+
+			if v := run(t, "EXISTS", "k"); v.num != 0 {
+				t.Errorf("EXISTS after = %d, want 0", v.num)
+			}
+			if v := run(t, "DBSIZE"); v.num != 1 {
+				t.Errorf("DBSIZE after = %d, want 1", v.num)
+			}
+			if _, ok := keyspace["k"]; ok {
+				t.Errorf("empty value left in the keyspace")
+			}
+			if _, ok := expires["k"]; ok {
+				t.Errorf("expiry time left behind")
+			}
+			if _, ok := accessTimes["k"]; ok {
+				t.Errorf("access times left behind")
+			}
+		})
+	}
+}
+
+// TestPartlyEmptiedContainersAreKept checks that containers keep their key
+// while elements remain, and that streams read by consumer groups are kept
+// once empty.
+func TestPartlyEmptiedContainersAreKept(t *testing.T) {
+	tests := []struct {
+		name   string
+		create [][]string
+		remove []string
+	}{
+		{"HDEL", [][]string{{"HSET", "k", "f1", "v", "f2", "v"}}, []string{"HDEL", "k", "f1"}},
+		{"SREM", [][]string{{"SADD", "k", "a", "b"}}, []string{"SREM", "k", "a"}},
+		{"LPOP", [][]string{{"RPUSH", "k", "a", "b"}}, []string{"LPOP", "k"}},
+		{"ZREM", [][]string{{"ZADD", "k", "1", "a", "2", "b"}}, []string{"ZREM", "k", "a"}},
+		{"XTRIM with a group", [][]string{{"XADD", "k", "1-1", "f", "v"}, {"XGROUP", "CREATE", "k", "g", "0"}}, []string{"XTRIM", "k", "MAXLEN", "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run(t, "FLUSHALL", "SYNC")
+			for _, create := range tt.create {
+				if v := run(t, create...); v.typ == "error" {
+					t.Fatalf("%v: %s", create, v.str)
+				}
+			}
+			run(t, tt.remove...)
+
+			if v := run(t, "EXISTS", "k"); v.num != 1 {
+				t.Errorf("EXISTS = %d, want 1", v.num)
+			}
+			if v := run(t, "DBSIZE"); v.num != 1 {
+				t.Errorf("DBSIZE = %d, want 1", v.num)
+			}
+		})
+	}
+}
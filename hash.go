@@ -0,0 +1,159 @@
+// Hash encodings.
+//
+// Most hashes hold a handful of short fields, such as one hash per user with
+// a name and an email address, and a Go map costs a few hundred bytes of
+// buckets for even the smallest of them. Like Redis' listpack encoding, a
+// small hash therefore keeps its fields in a flat slice of alternating names
+// and values, which is searched linearly. A hash is converted to a map for
+// good once it holds more than hash-max-listpack-entries fields or a field
+// name or value longer than hash-max-listpack-value bytes.
+package main
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// hashMaxListpackEntries and hashMaxListpackValue are the size limits of the
+// compact encoding. Changing them only affects hashes as they grow.
+var (
+	hashMaxListpackEntries atomic.Int64
+	hashMaxListpackValue   atomic.Int64
+)
+
+func init() {
+	hashMaxListpackEntries.Store(128)
+	hashMaxListpackValue.Store(64)
+}
+
+// hashValue holds the fields of a hash. Field names and values are interned.
+// The read methods treat a nil hash as empty.
+type hashValue struct {
+	// pairs holds the fields and their values one after the other while
+	// the hash is compact
+	pairs []string
+	// fields maps the fields to their values once the hash was converted,
+	// nil before
+	fields map[string]string
+}
+
+// len returns the number of fields.
+func (h *hashValue) len() int {
+	if h == nil {
+		return 0
+	}
+	if h.fields != nil {
+		return len(h.fields)
+	}
+	return len(h.pairs) / 2
+}
+
+// get returns the value of a field.
+func (h *hashValue) get(field string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	if h.fields != nil {
+		value, ok := h.fields[field]
+		return value, ok
+	}
+	for i := 0; i < len(h.pairs); i += 2 {
+		if h.pairs[i] == field {
+			return h.pairs[i+1], true
+		}
+	}
+	return "", false
+}
+
+// set sets the value of a field, reporting whether the field is new. An
+// existing field keeps its interned name and releases its previous value.
+func (h *hashValue) set(field, value string) bool {
+	if h.fields != nil {
+		if old, ok := h.fields[field]; ok {
+			release(old)
+			h.fields[field] = intern(value)
+			return false
+		}
+		h.fields[intern(field)] = intern(value)
+		return true
+	}
+
+	added := true
+	for i := 0; i < len(h.pairs); i += 2 {
+		if h.pairs[i] == field {
+			release(h.pairs[i+1])
+			h.pairs[i+1] = intern(value)
+			added = false
+			break
+		}
+	}
+	if added {
+		h.pairs = append(h.pairs, intern(field), intern(value))
+	}
+
+	limit := int(hashMaxListpackValue.Load())
+	if h.len() > int(hashMaxListpackEntries.Load()) || len(field) > limit || len(value) > limit {
+		h.convert()
+	}
+	return added
+}
+
+// del removes a field, reporting whether it existed, and releases its name
+// and value.
+func (h *hashValue) del(field string) bool {
+	if h == nil {
+		return false
+	}
+	if h.fields != nil {
+		value, ok := h.fields[field]
+		if !ok {
+			return false
+		}
+		release(field)
+		release(value)
+		delete(h.fields, field)
+		return true
+	}
+	for i := 0; i < len(h.pairs); i += 2 {
+		if h.pairs[i] == field {
+			release(h.pairs[i])
+			release(h.pairs[i+1])
+			h.pairs = slices.Delete(h.pairs, i, i+2)
+			return true
+		}
+	}
+	return false
+}
+
+// each calls fn with every field and its value.
+func (h *hashValue) each(fn func(field, value string)) {
+	if h == nil {
+		return
+	}
+	if h.fields != nil {
+		for field, value := range h.fields {
+			fn(field, value)
+		}
+		return
+	}
+	for i := 0; i < len(h.pairs); i += 2 {
+		fn(h.pairs[i], h.pairs[i+1])
+	}
+}
+
+// encoding returns the name of the encoding as DEBUG OBJECT reports it.
+func (h *hashValue) encoding() string {
+	if h != nil && h.fields != nil {
+		return "hashtable"
+	}
+	return "listpack"
+}
+
+// convert moves the fields of a compact hash into a map.
+func (h *hashValue) convert() {
+	h.fields = make(map[string]string, len(h.pairs)/2)
+	for i := 0; i < len(h.pairs); i += 2 {
+		h.fields[h.pairs[i]] = h.pairs[i+1]
+	}
+	h.pairs = nil
+}
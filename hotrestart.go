@@ -0,0 +1,552 @@
+// Hot restart.
+//
+// On SIGUSR2 the server starts a new copy of its binary and hands it the
+// listening sockets and every client connection, so the binary can be
+// upgraded without refusing or dropping a single connection:
+//
+//  1. accepting stops and every connection is parked once the batch of
+//     commands it is running has been answered, so the dataset stops
+//     changing. Commands it already sent beyond that batch are left to the
+//     new process, which reads the bytes buffered for them first. Clients
+//     waiting in a blocking command such as BLPOP are woken and park too,
+//     their command to be run again with what is left of its timeout
+//  2. the dataset is persisted, by syncing the AOF or saving a snapshot
+//  3. the new process is started with the sockets as extra files and the
+//     state of every client written to a pipe
+//  4. the new process loads the dataset, adopts the sockets and reports
+//     that it is ready, after which the old process exits
+//
+// If any step fails the old process resumes serving as if nothing happened.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handoffEnv marks a process started by a hot restart and carries the
+// number of listening sockets it inherited.
+const handoffEnv = "GOSTORE_HANDOFF_LISTENERS"
+
+// File descriptors of a process started by a hot restart: the state pipe,
+// the ready pipe, then the listening sockets followed by the clients.
+const (
+	handoffStateFd = 3
+	handoffReadyFd = 4
+	handoffFirstFd = 5
+)
+
+// handoffDrainTimeout bounds how long the clients may take to finish their
+// in-flight commands, and handoffReadyTimeout how long the new process may
+// take to load the dataset.
+const (
+	handoffDrainTimeout = 10 * time.Second
+	handoffReadyTimeout = 5 * time.Minute
+)
+
+// handoffClient is the state of a client carried over to the new process.
+type handoffClient struct {
+	ID            int64     `json:"id"`
+	Created       time.Time `json:"created"`
+	Name          string    `json:"name"`
+	LibName       string    `json:"lib_name"`
+	LibVer        string    `json:"lib_ver"`
+	TraceID       string    `json:"trace_id"`
+	Authenticated bool      `json:"authenticated"`
+	Monitor       bool      `json:"monitor"`
+	Subscriptions []string  `json:"subscriptions"`
+	// ShardSubscriptions are the shard channels of SSUBSCRIBE
+	ShardSubscriptions []string `json:"shard_subscriptions"`
+	// Resp is the protocol version chosen with HELLO
+	Resp int `json:"resp"`
+	// Tracking are the CLIENT TRACKING arguments that turn tracking on
+	// again, none when it is off
+	Tracking []string `json:"tracking"`
+	// Multi is set for a client in MULTI, whose queued commands are in
+	// Queued as RESP and which fails on EXEC when MultiAborted is set
+	Multi        bool   `json:"multi"`
+	MultiAborted bool   `json:"multi_aborted"`
+	Queued       []byte `json:"queued"`
+	// Buffered are the bytes read from the connection that no command has
+	// run for yet, such as the rest of a long pipeline, which the new
+	// process reads before the connection
+	Buffered []byte `json:"buffered"`
+	// Pending are the commands, as RESP, that a hot restart interrupted:
+	// a blocking command woken to park the client, then the rest of its
+	// batch. Retry is set when the blocking command waits until
+	// RetryDeadline, zero when it waits forever
+	Pending       []byte    `json:"pending"`
+	Retry         bool      `json:"retry"`
+	RetryDeadline time.Time `json:"retry_deadline"`
+}
+
+// handoff tracks a hot restart in progress.
+var handoff struct {
+	// pending is set while a hot restart is in progress
+	pending atomic.Bool
+	mu      sync.Mutex
+	// parked holds the clients that stopped reading commands
+	parked map[*Client]bool
+	// acceptors is the number of accept loops that stopped accepting
+	acceptors int
+	// resume is closed when the hot restart failed and serving goes on
+	resume chan struct{}
+	// interrupt is closed once a hot restart starts, waking the clients
+	// waiting in a blocking command. A new one is made when it failed
+	interrupt chan struct{}
+}
+
+func init() {
+	handoff.interrupt = make(chan struct{})
+}
+
+// serverListeners are the sockets the server accepts clients on.
+var serverListeners []net.Listener
+
+// inheritedClients are the clients handed over by the previous process,
+// adopted once the dataset has been loaded.
+var inheritedClients []handoffClient
+
+// inheritedListeners returns the listening sockets handed over by the
+// previous process on a hot restart, or none when the server was started
+// normally. The client state is kept for adoptClients.
+func inheritedListeners() ([]net.Listener, error) {
+	n, err := strconv.Atoi(os.Getenv(handoffEnv))
+	if err != nil {
+		return nil, nil
+	}
+	os.Unsetenv(handoffEnv)
+
+	state := os.NewFile(handoffStateFd, "handoff-state")
+	defer state.Close()
+	if err := json.NewDecoder(state).Decode(&inheritedClients); err != nil {
+		return nil, fmt.Errorf("hot restart state: %v", err)
+	}
+
+	listeners := []net.Listener{}
+	for fd := handoffFirstFd; fd < handoffFirstFd+n; fd++ {
+		f := os.NewFile(uintptr(fd), "handoff-listener")
+		tsrv, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("hot restart listener fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, tsrv)
+	}
+	return listeners, nil
+}
+
+// adoptClients starts serving the clients handed over by the previous
+// process and tells it that the hot restart is complete.
+func adoptClients(listeners int) {
+	adopted := []*Client{}
+	states := []handoffClient{}
+	for i, h := range inheritedClients {
+		f := os.NewFile(uintptr(handoffFirstFd+listeners+i), "handoff-client")
+		conn, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			serverLog(logWarning, "Unable to adopt client %d: %v", h.ID, err)
+			continue
+		}
+
+		c := newClientWithID(conn, h.ID)
+		c.created = h.Created
+		c.name, c.libName, c.libVer, c.traceID = h.Name, h.LibName, h.LibVer, h.TraceID
+		c.authenticated = h.Authenticated
+		c.unread = h.Buffered
+		if h.Resp == 3 {
+			c.resp = 3
+		}
+		if h.Multi {
+			c.multi = &transaction{aborted: h.MultiAborted, queue: decodeCommands(h.Queued)}
+		}
+		c.pending = decodeCommands(h.Pending)
+		if h.Retry {
+			c.retry = &h.RetryDeadline
+		}
+		if h.Monitor {
+			monitor(c, nil)
+		}
+		if len(h.Subscriptions)+len(h.ShardSubscriptions) > 0 {
+			pubsubMu.Lock()
+			for _, channel := range h.Subscriptions {
+				c.join(channel, false)
+			}
+			for _, channel := range h.ShardSubscriptions {
+				c.join(channel, true)
+			}
+			pubsubMu.Unlock()
+		}
+		adopted = append(adopted, c)
+		states = append(states, h)
+	}
+	// tracking is turned on once every client is back, since it may be
+	// redirected to any of them. The keys read in the default mode were
+	// remembered by the previous process, so the clients drop them all
+	for i, c := range adopted {
+		if len(states[i].Tracking) == 0 {
+			continue
+		}
+		if v := clientTracking(c, commandValue(states[i].Tracking...).array); v.typ == "error" {
+			serverLog(logWarning, "Unable to turn tracking on for client %d: %s", c.id, v.str)
+			continue
+		}
+		trackingMu.Lock()
+		if !c.tracking.bcast {
+			c.invalidate(nil)
+		}
+		trackingMu.Unlock()
+	}
+	for _, c := range adopted {
+		go serveClient(c)
+	}
+	serverLog(logNotice, "Adopted %d clients from the previous process", len(inheritedClients))
+	inheritedClients = nil
+
+	ready := os.NewFile(handoffReadyFd, "handoff-ready")
+	ready.Write([]byte("ready"))
+	ready.Close()
+}
+
+// decodeCommands reads back the commands marshalled by handoffState.
+func decodeCommands(b []byte) []Value {
+	commands := []Value{}
+	reader := newrESP(bytes.NewReader(b))
+	for {
+		value, err := reader.Read()
+		if err != nil {
+			return commands
+		}
+		commands = append(commands, value)
+	}
+}
+
+// parkForHandoff is called by a connection that has no command left to run
+// while a hot restart is pending. It blocks until the hot restart failed;
+// when it succeeds the process exits instead.
+func parkForHandoff(c *Client) {
+	handoff.mu.Lock()
+	handoff.parked[c] = true
+	resume := handoff.resume
+	handoff.mu.Unlock()
+
+	<-resume
+}
+
+// parkAcceptor is called by an accept loop stopped by a hot restart. It
+// blocks until the hot restart failed.
+func parkAcceptor() {
+	handoff.mu.Lock()
+	handoff.acceptors++
+	resume := handoff.resume
+	handoff.mu.Unlock()
+
+	<-resume
+}
+
+// waitIdle is called by a connection before it waits for the next command.
+// It parks the client when a hot restart is pending and otherwise marks it
+// idle, so that hotRestart knows it may interrupt the read.
+func (c *Client) waitIdle() {
+	c.infoMu.Lock()
+	c.idle = true
+	c.infoMu.Unlock()
+
+	if handoff.pending.Load() {
+		parkForHandoff(c)
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// handoffInterrupt returns a channel closed once the next hot restart
+// starts.
+func handoffInterrupt() <-chan struct{} {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+
+	return handoff.interrupt
+}
+
+// busy is called by a connection once a command started to arrive. It
+// clears any read deadline set by hotRestart while the client was idle, so
+// that the rest of the command can still be read.
+func (c *Client) busy() {
+	c.infoMu.Lock()
+	c.idle = false
+	c.conn.SetReadDeadline(time.Time{})
+	c.infoMu.Unlock()
+}
+
+// hotRestart hands the server over to a new process started from the same
+// binary with the same arguments.
+func hotRestart() {
+	if !handoff.pending.CompareAndSwap(false, true) {
+		return
+	}
+	serverLog(logWarning, "Received SIGUSR2, starting hot restart...")
+
+	handoff.mu.Lock()
+	handoff.parked = map[*Client]bool{}
+	handoff.acceptors = 0
+	handoff.resume = make(chan struct{})
+	handoff.mu.Unlock()
+
+	err := handOver()
+
+	// handOver only returns when the new process did not take over
+	serverLog(logWarning, "Hot restart failed, resuming: %v", err)
+	for _, tsrv := range serverListeners {
+		setListenerDeadline(tsrv, time.Time{})
+	}
+	handoff.mu.Lock()
+	handoff.pending.Store(false)
+	handoff.interrupt = make(chan struct{})
+	close(handoff.resume)
+	handoff.mu.Unlock()
+}
+
+// handOver runs the hot restart and exits the process on success.
+func handOver() error {
+	// stop accepting and wake every idle or blocked connection, busy ones
+	// park on their own once they are done
+	for _, tsrv := range serverListeners {
+		setListenerDeadline(tsrv, time.Now())
+	}
+	handoff.mu.Lock()
+	close(handoff.interrupt)
+	handoff.mu.Unlock()
+	ClientsMu.RLock()
+	for _, c := range Clients {
+		c.infoMu.Lock()
+		if c.idle {
+			c.conn.SetReadDeadline(time.Now())
+		}
+		c.infoMu.Unlock()
+	}
+	ClientsMu.RUnlock()
+
+	clients, err := waitParked()
+	if err != nil {
+		return err
+	}
+	// active expiry stops for the rest of the hot restart, so that it does
+	// not write to the AOF the new process loads; wait for a cycle already
+	// running
+	backgroundGate.drain()
+
+	// persist the dataset for the new process
+	if aof != nil {
+		err = aof.Sync()
+	} else {
+		err = saveSnapshot()
+	}
+	if err != nil {
+		return err
+	}
+
+	files := []*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	// starting the new process puts the sockets, which the duplicates share
+	// their flags with, in blocking mode. Deadlines stop working then, so
+	// they are put back for the old process to go on if the new one fails
+	sockets := []*os.File{}
+	defer func() {
+		for _, f := range sockets {
+			setNonblock(f)
+		}
+	}()
+
+	stateR, stateW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		stateR.Close()
+		stateW.Close()
+		return err
+	}
+	files = append(files, stateR, stateW, readyR, readyW)
+
+	extra := []*os.File{stateR, readyW}
+	for _, tsrv := range serverListeners {
+		f, err := listenerFile(tsrv)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		sockets = append(sockets, f)
+		extra = append(extra, f)
+	}
+	state := []handoffClient{}
+	for _, c := range clients {
+		f, err := connFile(c.conn)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		sockets = append(sockets, f)
+		extra = append(extra, f)
+		state = append(state, c.handoffState())
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), handoffEnv+"="+strconv.Itoa(len(serverListeners)))
+	cmd.ExtraFiles = extra
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// the child holds its own copies of the pipe ends it uses
+	stateR.Close()
+	readyW.Close()
+
+	go func() {
+		json.NewEncoder(stateW).Encode(state)
+		stateW.Close()
+	}()
+
+	// wait for the new process to report that it is ready
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(readyR, buf)
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(handoffReadyTimeout):
+		err = errors.New("timed out waiting for the new process")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	serverLog(logWarning, "New process %d took over, bye bye...", cmd.Process.Pid)
+	if aof != nil {
+		aof.Close()
+	}
+	os.Exit(0)
+	return nil
+}
+
+// waitParked waits until every accept loop stopped and every client is parked and has no pub/sub or
+// MONITOR output left to send, and returns the clients.
+func waitParked() ([]*Client, error) {
+	deadline := time.Now().Add(handoffDrainTimeout)
+	for {
+		ClientsMu.RLock()
+		clients := make([]*Client, 0, len(Clients))
+		for _, c := range Clients {
+			clients = append(clients, c)
+		}
+		ClientsMu.RUnlock()
+
+		handoff.mu.Lock()
+		drained := handoff.acceptors == len(serverListeners)
+		for _, c := range clients {
+			if !handoff.parked[c] || len(c.feed) > 0 || len(c.messages) > 0 {
+				drained = false
+				break
+			}
+		}
+		handoff.mu.Unlock()
+
+		if drained {
+			return clients, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for clients to finish their commands")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// handoffState returns the state of a parked client to carry over.
+func (c *Client) handoffState() handoffClient {
+	h := handoffClient{
+		ID:            c.id,
+		Created:       c.created,
+		Name:          c.name,
+		LibName:       c.libName,
+		LibVer:        c.libVer,
+		TraceID:       c.traceID,
+		Authenticated: c.authenticated,
+		Resp:          c.resp,
+		Tracking:      c.trackingArgs(),
+	}
+	// the client is parked, nothing else reads its buffer
+	if c.reader != nil && c.reader.Buffered() > 0 {
+		buffered, _ := c.reader.Peek(c.reader.Buffered())
+		h.Buffered = append([]byte{}, buffered...)
+	}
+	for _, value := range c.pending {
+		h.Pending = append(h.Pending, value.Marshal()...)
+	}
+	if c.retry != nil {
+		h.Retry, h.RetryDeadline = true, *c.retry
+	}
+	if c.multi != nil {
+		h.Multi, h.MultiAborted = true, c.multi.aborted
+		for _, value := range c.multi.queue {
+			h.Queued = append(h.Queued, value.Marshal()...)
+		}
+	}
+
+	monitorsMu.RLock()
+	h.Monitor = c.monitor
+	monitorsMu.RUnlock()
+
+	pubsubMu.RLock()
+	for channel := range c.subscriptions {
+		h.Subscriptions = append(h.Subscriptions, channel)
+	}
+	for channel := range c.shardSubscriptions {
+		h.ShardSubscriptions = append(h.ShardSubscriptions, channel)
+	}
+	pubsubMu.RUnlock()
+
+	return h
+}
+
+// setListenerDeadline makes Accept on tsrv return once t is reached.
+func setListenerDeadline(tsrv net.Listener, t time.Time) {
+	if l, ok := tsrv.(interface{ SetDeadline(time.Time) error }); ok {
+		l.SetDeadline(t)
+	}
+}
+
+// listenerFile returns a duplicate of the socket of tsrv.
+func listenerFile(tsrv net.Listener) (*os.File, error) {
+	l, ok := tsrv.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %s can not be handed over", tsrv.Addr())
+	}
+	return l.File()
+}
+
+// connFile returns a duplicate of the socket of conn.
+func connFile(conn net.Conn) (*os.File, error) {
+	c, ok := conn.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("connection %s can not be handed over", conn.RemoteAddr())
+	}
+	return c.File()
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleHotRestart runs a hot restart whenever SIGUSR2 is received.
+func handleHotRestart() {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			hotRestart()
+		}
+	}()
+}
+
+// setNonblock puts the socket f shares with a connection or listener back
+// in non-blocking mode.
+func setNonblock(f *os.File) {
+	syscall.SetNonblock(int(f.Fd()), true)
+}
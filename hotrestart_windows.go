@@ -0,0 +1,10 @@
+package main
+
+import "os"
+
+// handleHotRestart does nothing on Windows, which has neither SIGUSR2 nor
+// a way to pass sockets to a child process.
+func handleHotRestart() {}
+
+// setNonblock is never called on Windows.
+func setNonblock(f *os.File) {}
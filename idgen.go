@@ -0,0 +1,186 @@
+// ID generation.
+//
+// IDGEN hands out monotonically increasing 64-bit IDs per namespace, either
+// as a plain sequence or Snowflake-style, combining a millisecond timestamp,
+// the node id of this server and a sequence number so that several servers
+// can generate IDs for the same namespace without coordination.
+//
+// Generated IDs are persisted as IDGEN SEED commands carrying the issued ID
+// rather than as the IDGEN NEXT call itself, so that replaying the AOF
+// restores exactly the last ID handed out regardless of the mode or the
+// clock at replay time.
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idgenEpoch is the start of Snowflake timestamps, 2024-01-01 UTC in Unix
+// milliseconds.
+const idgenEpoch = 1704067200000
+
+// Layout of a Snowflake ID: 41 bits of milliseconds since idgenEpoch, 10
+// bits of node id and 12 bits of sequence.
+const (
+	idgenNodeBits     = 10
+	idgenSequenceBits = 12
+	idgenMaxNode      = 1<<idgenNodeBits - 1
+)
+
+// idgenNode is the node id embedded in Snowflake IDs.
+var idgenNode atomic.Int64
+
+// idgens maps every namespace to the last ID handed out.
+var idgens = map[string]int64{}
+
+// idgensMu guards idgens.
+var idgensMu = sync.Mutex{}
+
+// idgenCommand handles IDGEN NEXT, CURRENT and SEED.
+func idgenCommand(args []Value) Value {
+	switch strings.ToUpper(args[0].bulk) {
+	case "NEXT":
+		return idgenNext(args[1:])
+	case "CURRENT":
+		return idgenCurrent(args[1:])
+	case "SEED":
+		return idgenSeed(args[1:])
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try IDGEN HELP."}
+	}
+}
+
+// idgenNext implements IDGEN NEXT namespace [SNOWFLAKE], returning an ID
+// greater than every ID handed out before for the namespace.
+func idgenNext(args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'idgen|next' command"}
+	}
+	snowflake := false
+	if len(args) == 2 {
+		if strings.ToUpper(args[1].bulk) != "SNOWFLAKE" {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		snowflake = true
+	}
+
+	ns := args[0].bulk
+
+	idgensMu.Lock()
+	defer idgensMu.Unlock()
+
+	last := idgens[ns]
+	var id int64
+	if snowflake {
+		var ok bool
+		if id, ok = snowflakeID(last); !ok {
+			return Value{typ: "error", str: "ERR clock moved backwards, refusing to generate a Snowflake ID"}
+		}
+	} else {
+		if last == 1<<63-1 {
+			return Value{typ: "error", str: "ERR ID space exhausted"}
+		}
+		id = last + 1
+	}
+	idgens[ns] = id
+
+	return Value{typ: "integer", num: int(id)}
+}
+
+// snowflakeID returns the next Snowflake ID of this node after last. The
+// sequence never carries into the node id, which would let two nodes hand
+// out the same ID: once 4096 IDs were issued within a millisecond it waits
+// for the next one. An ID for a millisecond before that of last would not
+// be greater than it, so when the clock went backwards no ID is returned.
+func snowflakeID(last int64) (int64, bool) {
+	const msShift = idgenNodeBits + idgenSequenceBits
+	node := idgenNode.Load()
+	lastMs := last >> msShift
+	lastNode := last >> idgenSequenceBits & idgenMaxNode
+	lastSequence := last & (1<<idgenSequenceBits - 1)
+
+	ms := time.Now().UnixMilli() - idgenEpoch
+	if ms < lastMs {
+		return 0, false
+	}
+	if ms == lastMs {
+		switch {
+		case lastNode == node && lastSequence < 1<<idgenSequenceBits-1:
+			return last + 1, true
+		case lastNode < node:
+			// the last ID came from a node numbered below this one
+			return ms<<msShift | node<<idgenSequenceBits, true
+		}
+		for ms == lastMs {
+			time.Sleep(time.Until(time.UnixMilli(idgenEpoch + ms + 1)))
+			ms = time.Now().UnixMilli() - idgenEpoch
+		}
+		if ms < lastMs {
+			return 0, false
+		}
+	}
+	return ms<<msShift | node<<idgenSequenceBits, true
+}
+
+// idgenCurrent implements IDGEN CURRENT namespace, returning the last ID
+// handed out, or 0 when none was.
+func idgenCurrent(args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'idgen|current' command"}
+	}
+
+	idgensMu.Lock()
+	defer idgensMu.Unlock()
+
+	return Value{typ: "integer", num: int(idgens[args[0].bulk])}
+}
+
+// idgenSeed implements IDGEN SEED namespace id, making sure the next ID of
+// the namespace is greater than id. A namespace never goes backwards, so
+// seeding below its last ID changes nothing.
+func idgenSeed(args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'idgen|seed' command"}
+	}
+	id, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil || id < 0 {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	idgensMu.Lock()
+	if id > idgens[args[0].bulk] {
+		idgens[args[0].bulk] = id
+	}
+	idgensMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// idgenPropagate rewrites IDGEN for the AOF: NEXT is persisted as a SEED of
+// the ID it returned and CURRENT is not persisted at all.
+func idgenPropagate(value Value, result Value) Value {
+	switch strings.ToUpper(value.array[1].bulk) {
+	case "NEXT":
+		return commandValue("IDGEN", "SEED", value.array[2].bulk, strconv.Itoa(result.num))
+	case "SEED":
+		return value
+	default:
+		return Value{}
+	}
+}
+
+// idgenCommands returns the IDGEN SEED commands recreating every namespace.
+func idgenCommands() []Value {
+	idgensMu.Lock()
+	defer idgensMu.Unlock()
+
+	commands := []Value{}
+	for ns, id := range idgens {
+		commands = append(commands, commandValue("IDGEN", "SEED", ns, strconv.FormatInt(id, 10)))
+	}
+	return commands
+}
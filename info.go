@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startTime is when the server process started, reported by INFO server.
+var startTime = time.Now()
+
+// infoSections lists the INFO sections in the order they are reported. Each
+// section is rendered as "field:value" lines.
+var infoSections = []struct {
+	name   string
+	render func() []string
+}{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"memory", infoMemory},
+	{"replication", infoReplication},
+	{"keyspace", infoKeyspace},
+}
+
+// info handles the INFO [section ...] command. Without arguments, or with
+// "all"/"default"/"everything", every section is returned.
+func info(args []Value) Value {
+	wanted := map[string]bool{}
+	for _, arg := range args {
+		wanted[strings.ToLower(arg.bulk)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, line := range section.render() {
+			b.WriteString(line + "\r\n")
+		}
+	}
+
+	return Value{typ: "bulk", bulk: b.String()}
+}
+
+func infoServer() []string {
+	return []string{
+		"redis_version:" + getCompatVersion(),
+		"gostore_go_version:" + runtime.Version(),
+		fmt.Sprintf("arch_bits:%d", 32<<(^uint(0)>>63)),
+		"os:" + runtime.GOOS + " " + runtime.GOARCH,
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"tcp_port:6379",
+		fmt.Sprintf("uptime_in_seconds:%d", int64(time.Since(startTime).Seconds())),
+	}
+}
+
+func infoClients() []string {
+	ClientsMu.RLock()
+	connected := len(Clients)
+	ClientsMu.RUnlock()
+
+	// client libraries reported with CLIENT SETINFO, sorted by name
+	libs := clientLibraries()
+	names := make([]string, 0, len(libs))
+	for name, n := range libs {
+		names = append(names, fmt.Sprintf("%s=%d", name, n))
+	}
+	sort.Strings(names)
+
+	return []string{
+		fmt.Sprintf("connected_clients:%d", connected),
+		fmt.Sprintf("maxclients:%d", maxclients.Load()),
+		fmt.Sprintf("blocked_clients:%d", blockedClients.Load()),
+		"client_libraries:" + strings.Join(names, ","),
+	}
+}
+
+func infoMemory() []string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", ms.HeapAlloc),
+		fmt.Sprintf("used_memory_rss:%d", processRSS(int64(ms.Sys))),
+		fmt.Sprintf("used_memory_peak:%d", ms.HeapSys),
+		fmt.Sprintf("maxmemory:%d", maxmemory.Load()),
+		"maxmemory_policy:noeviction",
+		fmt.Sprintf("gomemlimit:%d", debug.SetMemoryLimit(-1)),
+		fmt.Sprintf("active_defrag_running:%d", rehashStats.running.Load()),
+		fmt.Sprintf("active_rehash_maps:%d", rehashStats.maps.Load()),
+		fmt.Sprintf("active_rehash_entries:%d", rehashStats.entries.Load()),
+		fmt.Sprintf("lazyfree_pending_objects:%d", lazyfreePending.Load()),
+		fmt.Sprintf("lazyfreed_objects:%d", lazyfreedObjects.Load()),
+	}
+}
+
+// replID is the replication id of the dataset and replID2 the id it had
+// before the last change, 40 zeroes when there was none. The server has no
+// replicas, the ids only matter to tools that follow the replication
+// history, and change with DEBUG CHANGE-REPL-ID.
+var replID, replID2 = newReplID(), strings.Repeat("0", 40)
+
+// replMu guards replID and replID2.
+var replMu = sync.Mutex{}
+
+// newReplID returns a random replication id of 40 hex digits.
+func newReplID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// changeReplID gives the dataset a new replication id. Like in Redis the
+// previous id is not kept as replID2, since no replica can continue from it.
+func changeReplID() {
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	replID = newReplID()
+	replID2 = strings.Repeat("0", 40)
+}
+
+func infoReplication() []string {
+	replMu.Lock()
+	defer replMu.Unlock()
+
+	return []string{
+		"role:master",
+		"connected_slaves:0",
+		"master_replid:" + replID,
+		"master_replid2:" + replID2,
+		"master_repl_offset:0",
+		"second_repl_offset:-1",
+	}
+}
+
+func infoKeyspace() []string {
+	keyspaceMu.RLock()
+	keys := len(keyspace)
+	keyspaceMu.RUnlock()
+
+	if keys == 0 {
+		return infoAttached()
+	}
+
+	// avg_ttl is the average time to live of the keys with an expiry
+	// time, in milliseconds
+	expiresMu.RLock()
+	now, total := nowMs(), int64(0)
+	for _, at := range expires {
+		if at > now {
+			total += at - now
+		}
+	}
+	withExpiry := int64(len(expires))
+	expiresMu.RUnlock()
+	avg := int64(0)
+	if withExpiry > 0 {
+		avg = total / withExpiry
+	}
+
+	return append([]string{fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=%d", keys, withExpiry, avg)}, infoAttached()...)
+}
@@ -0,0 +1,108 @@
+// Interning keeps a single shared copy of small strings that are stored over
+// and over again, such as enum-like values ("active", "pending") or the field
+// names of hashes that all follow the same schema. Instead of every key holding
+// its own copy of the bytes, all of them point at the same canonical string.
+//
+// Each canonical string carries a reference count that is incremented when a
+// key starts using it and decremented when the key is overwritten or deleted.
+// Once nobody uses a string any more it is dropped from the pool so the pool
+// itself does not grow without bound.
+package main
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// internEnabled turns value and field name interning on. It is off by default
+// because it only pays off for highly repetitive datasets.
+var internEnabled atomic.Bool
+
+// internMaxLen is the longest string that is considered for interning. Longer
+// strings are rarely repeated exactly and are stored as they are.
+var internMaxLen = 64
+
+// internEntry is a canonical string together with the number of places in the
+// keyspace currently referring to it.
+type internEntry struct {
+	s    string
+	refs int
+}
+
+// internPool maps string contents to their canonical entry.
+var internPool = map[string]*internEntry{}
+
+// internMu guards internPool. It is always taken after keyspaceMu.
+var internMu = rwLock{name: "intern"}
+
+// intern returns the canonical copy of s and records one more reference to it.
+// When interning is disabled or s is too long, s is returned unchanged.
+func intern(s string) string {
+	if !internEnabled.Load() || len(s) > internMaxLen {
+		return s
+	}
+
+	internMu.Lock()
+	defer internMu.Unlock()
+
+	if e, ok := internPool[s]; ok {
+		e.refs++
+		return e.s
+	}
+	internPool[s] = &internEntry{s: s, refs: 1}
+
+	return s
+}
+
+// release drops one reference to s, removing it from the pool when it is no
+// longer used. It must be called whenever a value or field name stored through
+// intern leaves the keyspace (overwrite, delete, eviction). Releasing a string
+// that was stored before interning was enabled only causes the pool to forget
+// a canonical copy earlier than it could have; it never affects stored data.
+func release(s string) {
+	if len(s) > internMaxLen {
+		return
+	}
+
+	internMu.Lock()
+	defer internMu.Unlock()
+
+	e, ok := internPool[s]
+	if !ok {
+		return
+	}
+	e.refs--
+	if e.refs <= 0 {
+		delete(internPool, s)
+	}
+}
+
+// internRefs returns the number of references held on s when it is the
+// canonical copy kept by the pool, 0 otherwise.
+func internRefs(s string) int {
+	if len(s) == 0 || len(s) > internMaxLen {
+		return 0
+	}
+
+	internMu.RLock()
+	defer internMu.RUnlock()
+
+	e, ok := internPool[s]
+	if !ok || unsafe.StringData(e.s) != unsafe.StringData(s) {
+		return 0
+	}
+	return e.refs
+}
+
+// internStats reports the number of distinct interned strings and the total
+// number of references held on them.
+func internStats() (strings int, refs int) {
+	internMu.Lock()
+	defer internMu.Unlock()
+
+	for _, e := range internPool {
+		refs += e.refs
+	}
+
+	return len(internPool), refs
+}
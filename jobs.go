@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Background work is registered as jobs, so operators have one place to
+// see and control what the server is doing besides serving clients:
+//
+//	JOBS LIST        every running job and the most recently finished ones
+//	JOBS CANCEL id   asks a running job to stop
+//
+// A job reports its progress as done out of total units of work, and long
+// loops check cancelled() between units, cleaning up after themselves when
+// asked to stop. Background saves run as jobs, and so does freeing the
+// keyspace dropped by FLUSHALL ASYNC, which can not stop halfway and refuses
+// to be cancelled. Big values freed in the background after a DEL are too
+// short-lived to be listed one by one; INFO counts them instead.
+
+// job is a unit of background work.
+type job struct {
+	id          int64
+	kind        string
+	description string
+	started     time.Time
+	// done and total measure the progress, total is 0 when unknown
+	done, total atomic.Int64
+	// cancellable is false for work that can not stop halfway
+	cancellable bool
+	// cancel is set when the job was asked to stop
+	cancel atomic.Bool
+
+	// mu guards the fields below
+	mu       sync.Mutex
+	finished time.Time
+	err      error
+}
+
+// jobHistory is how many finished jobs JOBS LIST keeps showing.
+const jobHistory = 16
+
+// jobs maps job ids to running and recently finished jobs.
+var jobs = map[int64]*job{}
+
+// jobsMu guards jobs.
+var jobsMu = sync.Mutex{}
+
+// nextJobID is the id of the last job started.
+var nextJobID atomic.Int64
+
+// errJobCancelled is the error of a job that stopped because it was
+// cancelled.
+var errJobCancelled = errors.New("cancelled")
+
+// startJob registers a new running job.
+func startJob(kind, description string) *job {
+	return registerJob(&job{kind: kind, description: description, cancellable: true})
+}
+
+// startUncancellableJob registers a new running job that JOBS CANCEL
+// refuses to stop.
+func startUncancellableJob(kind, description string) *job {
+	return registerJob(&job{kind: kind, description: description})
+}
+
+// registerJob gives j an id and a start time and adds it to jobs.
+func registerJob(j *job) *job {
+	j.id = nextJobID.Add(1)
+	j.started = time.Now()
+
+	jobsMu.Lock()
+	jobs[j.id] = j
+	jobsMu.Unlock()
+
+	return j
+}
+
+// progress records that done out of total units of work are complete. It
+// may be called on a nil job, for work that runs outside of a job.
+func (j *job) progress(done, total int64) {
+	if j == nil {
+		return
+	}
+	j.done.Store(done)
+	j.total.Store(total)
+}
+
+// cancelled reports whether the job was asked to stop. A nil job is never
+// cancelled.
+func (j *job) cancelled() bool {
+	return j != nil && j.cancel.Load()
+}
+
+// finish marks the job as finished with err, nil on success, and forgets
+// the oldest finished jobs beyond jobHistory.
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	j.finished = time.Now()
+	j.err = err
+	j.mu.Unlock()
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	finished := []*job{}
+	for _, other := range jobs {
+		if other.state() != "running" {
+			finished = append(finished, other)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].id < finished[b].id })
+	for len(finished) > jobHistory {
+		delete(jobs, finished[0].id)
+		finished = finished[1:]
+	}
+}
+
+// state returns running, done, cancelled or failed.
+func (j *job) state() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case j.finished.IsZero():
+		return "running"
+	case j.err == nil:
+		return "done"
+	case errors.Is(j.err, errJobCancelled):
+		return "cancelled"
+	default:
+		return "failed"
+	}
+}
+
+// describe renders the job for JOBS LIST as alternating names and values.
+func (j *job) describe() Value {
+	state := j.state()
+
+	j.mu.Lock()
+	end := j.finished
+	errText := ""
+	if j.err != nil {
+		errText = j.err.Error()
+	}
+	j.mu.Unlock()
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	// progress in percent, -1 when the job can not tell
+	percent := -1
+	if total := j.total.Load(); total > 0 {
+		percent = int(j.done.Load() * 100 / total)
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "id"}, {typ: "integer", num: int(j.id)},
+		{typ: "bulk", bulk: "type"}, {typ: "bulk", bulk: j.kind},
+		{typ: "bulk", bulk: "description"}, {typ: "bulk", bulk: j.description},
+		{typ: "bulk", bulk: "state"}, {typ: "bulk", bulk: state},
+		{typ: "bulk", bulk: "progress"}, {typ: "integer", num: percent},
+		{typ: "bulk", bulk: "started"}, {typ: "integer", num: int(j.started.Unix())},
+		{typ: "bulk", bulk: "elapsed-ms"}, {typ: "integer", num: int(end.Sub(j.started).Milliseconds())},
+		{typ: "bulk", bulk: "error"}, {typ: "bulk", bulk: errText},
+	}}
+}
+
+// jobsCommand handles JOBS LIST and JOBS CANCEL id.
+func jobsCommand(args []Value) Value {
+	switch strings.ToUpper(args[0].bulk) {
+	case "LIST":
+		if len(args) != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'jobs|list' command"}
+		}
+		jobsMu.Lock()
+		list := []*job{}
+		for _, j := range jobs {
+			list = append(list, j)
+		}
+		jobsMu.Unlock()
+		sort.Slice(list, func(a, b int) bool { return list[a].id < list[b].id })
+
+		reply := Value{typ: "array", array: []Value{}}
+		for _, j := range list {
+			reply.array = append(reply.array, j.describe())
+		}
+		return reply
+
+	case "CANCEL":
+		if len(args) != 2 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'jobs|cancel' command"}
+		}
+		id, err := strconv.ParseInt(args[1].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		jobsMu.Lock()
+		j, ok := jobs[id]
+		jobsMu.Unlock()
+		if !ok {
+			return Value{typ: "error", str: "ERR No such job " + args[1].bulk}
+		}
+		if j.state() != "running" {
+			return Value{typ: "error", str: "ERR Job " + args[1].bulk + " is not running"}
+		}
+		if !j.cancellable {
+			return Value{typ: "error", str: "ERR Job " + args[1].bulk + " can not be cancelled"}
+		}
+		j.cancel.Store(true)
+		return Value{typ: "string", str: "OK"}
+
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try JOBS HELP."}
+	}
+}
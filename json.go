@@ -0,0 +1,840 @@
+// JSON documents.
+//
+// A key can hold a parsed JSON document, like the RedisJSON module stores
+// them, so a client changes one field of a document without reading and
+// rewriting all of it:
+//
+//	JSON.SET key path value [NX|XX]
+//	JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path ...]
+//	JSON.DEL key [path]
+//	JSON.FORGET key [path]
+//	JSON.NUMINCRBY key path value
+//
+// Paths are JSONPath expressions starting with $: .name or ['name'] for the
+// member of an object, [index] for the element of an array, negative
+// indexes counting from the end, * or [*] for every member or element,
+// several names or indexes in brackets separated by commas and .. to look
+// for the rest of the path at any depth. A path matches any number of
+// values: JSON.GET replies them in a JSON array, JSON.SET replaces them all
+// and JSON.NUMINCRBY replies an array of the new numbers, null for the
+// values that were no number. The legacy paths of RedisJSON 1, . for the
+// root and .a.b or a.b otherwise, are accepted too and act on the first
+// match only, replying it alone and an error when there is none. Filter
+// expressions and slices are not supported.
+//
+// JSON.SET creates a document only at the root, $ or ., and adds a member
+// to the objects matched by the path without its last name. NX only sets
+// values that do not exist and XX those that do, replying null otherwise.
+// Deleting the root deletes the key. Documents keep the order of the
+// members of their objects, integers and floats are told apart like
+// RedisJSON does, so incrementing an integer by an integer keeps it one,
+// and TYPE names the key ReJSON-RL. Documents are persisted as a JSON.SET
+// of the whole document at the root.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonKind is the type of a JSON value.
+type jsonKind uint8
+
+const (
+	jsonNull jsonKind = iota
+	jsonBool
+	jsonInt
+	jsonFloat
+	jsonString
+	jsonArray
+	jsonMap
+)
+
+// String returns the name of the kind as RedisJSON reports it.
+func (k jsonKind) String() string {
+	switch k {
+	case jsonBool:
+		return "boolean"
+	case jsonInt:
+		return "integer"
+	case jsonFloat:
+		return "number"
+	case jsonString:
+		return "string"
+	case jsonArray:
+		return "array"
+	case jsonMap:
+		return "object"
+	}
+	return "null"
+}
+
+// jsonNode is a JSON value. Values are replaced in place, so that every
+// path matching a value can be changed through the pointer to it.
+type jsonNode struct {
+	kind jsonKind
+	b    bool
+	i    int64
+	f    float64
+	s    string
+	// elements are the elements of an array
+	elements []*jsonNode
+	// keys and values are the members of an object, in the order they
+	// were added
+	keys   []string
+	values []*jsonNode
+}
+
+// parseJSON parses a JSON text into a document.
+func parseJSON(text string) (*jsonNode, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	n, err := decodeJSONNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing characters")
+	}
+	return n, nil
+}
+
+// decodeJSONNode reads the next value from dec.
+func decodeJSONNode(dec *json.Decoder) (*jsonNode, error) {
+	token, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("EOF while parsing a value")
+		}
+		return nil, err
+	}
+	switch t := token.(type) {
+	case nil:
+		return &jsonNode{kind: jsonNull}, nil
+	case bool:
+		return &jsonNode{kind: jsonBool, b: t}, nil
+	case string:
+		return &jsonNode{kind: jsonString, s: t}, nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return &jsonNode{kind: jsonInt, i: i}, nil
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil, errors.New("number out of range")
+		}
+		return &jsonNode{kind: jsonFloat, f: f}, nil
+	case json.Delim:
+		if t == '[' {
+			n := &jsonNode{kind: jsonArray, elements: []*jsonNode{}}
+			for dec.More() {
+				element, err := decodeJSONNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				n.elements = append(n.elements, element)
+			}
+			_, err := dec.Token()
+			return n, err
+		}
+		n := &jsonNode{kind: jsonMap}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.set(key.(string), value)
+		}
+		_, err := dec.Token()
+		return n, err
+	}
+	return nil, errors.New("unexpected token")
+}
+
+// member returns the member key of an object, nil when it has none.
+func (n *jsonNode) member(key string) *jsonNode {
+	for i, k := range n.keys {
+		if k == key {
+			return n.values[i]
+		}
+	}
+	return nil
+}
+
+// set sets the member key of an object, adding it last when it is new.
+func (n *jsonNode) set(key string, value *jsonNode) {
+	for i, k := range n.keys {
+		if k == key {
+			n.values[i] = value
+			return
+		}
+	}
+	n.keys = append(n.keys, key)
+	n.values = append(n.values, value)
+}
+
+// children returns the elements of an array or the member values of an
+// object.
+func (n *jsonNode) children() []*jsonNode {
+	if n.kind == jsonArray {
+		return n.elements
+	}
+	return n.values
+}
+
+// clone returns a deep copy of the value.
+func (n *jsonNode) clone() *jsonNode {
+	c := *n
+	if n.elements != nil {
+		c.elements = make([]*jsonNode, len(n.elements))
+		for i, element := range n.elements {
+			c.elements[i] = element.clone()
+		}
+	}
+	if n.keys != nil {
+		c.keys = append([]string(nil), n.keys...)
+		c.values = make([]*jsonNode, len(n.values))
+		for i, value := range n.values {
+			c.values[i] = value.clone()
+		}
+	}
+	return &c
+}
+
+// remove removes the children of an array or object that are in doomed and
+// returns how many it removed.
+func (n *jsonNode) remove(doomed map[*jsonNode]bool) int {
+	removed := 0
+	if n.kind == jsonArray {
+		kept := n.elements[:0]
+		for _, element := range n.elements {
+			if doomed[element] {
+				removed++
+				continue
+			}
+			kept = append(kept, element)
+		}
+		n.elements = kept
+		return removed
+	}
+	keys, values := n.keys[:0], n.values[:0]
+	for i, value := range n.values {
+		if doomed[value] {
+			removed++
+			continue
+		}
+		keys, values = append(keys, n.keys[i]), append(values, value)
+	}
+	n.keys, n.values = keys, values
+	return removed
+}
+
+// jsonFormat is how a document is serialized: the string indenting every
+// level, the one ending every line and the one following a colon, all
+// empty for the compact form.
+type jsonFormat struct {
+	indent, newline, space string
+}
+
+// String serializes the value in the compact form.
+func (n *jsonNode) String() string {
+	return string(n.appendTo(nil, jsonFormat{}, 0))
+}
+
+// appendTo appends the value serialized with format at the given nesting
+// depth to buf.
+func (n *jsonNode) appendTo(buf []byte, format jsonFormat, depth int) []byte {
+	switch n.kind {
+	case jsonNull:
+		return append(buf, "null"...)
+	case jsonBool:
+		return strconv.AppendBool(buf, n.b)
+	case jsonInt:
+		return strconv.AppendInt(buf, n.i, 10)
+	case jsonFloat:
+		return append(buf, formatJSONFloat(n.f)...)
+	case jsonString:
+		return appendJSONString(buf, n.s)
+	}
+
+	start, end := byte('['), byte(']')
+	if n.kind == jsonMap {
+		start, end = '{', '}'
+	}
+	children := n.children()
+	buf = append(buf, start)
+	if len(children) == 0 {
+		return append(buf, end)
+	}
+	for i, child := range children {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, format.newline...)
+		buf = append(buf, strings.Repeat(format.indent, depth+1)...)
+		if n.kind == jsonMap {
+			buf = appendJSONString(buf, n.keys[i])
+			buf = append(buf, ':')
+			buf = append(buf, format.space...)
+		}
+		buf = child.appendTo(buf, format, depth+1)
+	}
+	buf = append(buf, format.newline...)
+	buf = append(buf, strings.Repeat(format.indent, depth)...)
+	return append(buf, end)
+}
+
+// formatJSONFloat formats a float so that it reads back as a float,
+// keeping a fractional part when it is integral.
+func formatJSONFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// appendJSONString appends s quoted as a JSON string to buf, escaping only
+// what JSON requires.
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, "\ufffd"...)
+			} else {
+				buf = append(buf, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, `\n`...)
+		case '\r':
+			buf = append(buf, `\r`...)
+		case '\t':
+			buf = append(buf, `\t`...)
+		default:
+			if c < 0x20 {
+				buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			} else {
+				buf = append(buf, c)
+			}
+		}
+		i++
+	}
+	return append(buf, '"')
+}
+
+// jsonSegment is a step of a path, selecting members or elements of the
+// values matched so far.
+type jsonSegment struct {
+	// recursive applies the step to the values and all their descendants
+	recursive bool
+	// wildcard selects every member or element
+	wildcard bool
+	// names select members of objects and indexes elements of arrays
+	names   []string
+	indexes []int
+}
+
+// jsonPath is a parsed path.
+type jsonPath struct {
+	text     string
+	segments []jsonSegment
+	// legacy is set for the paths of RedisJSON 1, which act on their first
+	// match only
+	legacy bool
+}
+
+// isRoot reports whether the path is the root of the document.
+func (p jsonPath) isRoot() bool {
+	return len(p.segments) == 0
+}
+
+// parseJSONPath parses a JSONPath or a legacy path.
+func parseJSONPath(text string) (jsonPath, bool) {
+	p := jsonPath{text: text}
+	rest := text
+	switch {
+	case strings.HasPrefix(rest, "$"):
+		rest = rest[1:]
+	case rest == ".":
+		p.legacy = true
+		return p, true
+	case strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "["):
+		p.legacy = true
+	default:
+		p.legacy = true
+		rest = "." + rest
+	}
+
+	for rest != "" {
+		var seg jsonSegment
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			seg.recursive = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] != '[':
+			return p, false
+		}
+		if rest == "" {
+			return p, false
+		}
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 || !parseJSONBracket(rest[1:end], &seg) {
+				return p, false
+			}
+			rest = rest[end+1:]
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			switch name {
+			case "":
+				return p, false
+			case "*":
+				seg.wildcard = true
+			default:
+				seg.names = []string{name}
+			}
+			rest = rest[end:]
+		}
+		p.segments = append(p.segments, seg)
+	}
+	return p, true
+}
+
+// parseJSONBracket parses what is between the brackets of a step: *,
+// quoted names or indexes separated by commas.
+func parseJSONBracket(inner string, seg *jsonSegment) bool {
+	inner = strings.TrimSpace(inner)
+	if inner == "*" {
+		seg.wildcard = true
+		return true
+	}
+	for _, part := range strings.Split(inner, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && (part[0] == '\'' || part[0] == '"') && part[len(part)-1] == part[0] {
+			seg.names = append(seg.names, part[1:len(part)-1])
+			continue
+		}
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			return false
+		}
+		seg.indexes = append(seg.indexes, index)
+	}
+	return true
+}
+
+// jsonPathError is the reply to a path that does not parse.
+func jsonPathError(path string) Value {
+	return Value{typ: "error", str: "ERR JSON Path error: invalid path '" + path + "'"}
+}
+
+// jsonMatch is a value matched by a path and the array or object holding
+// it, nil for the root.
+type jsonMatch struct {
+	node, parent *jsonNode
+}
+
+// apply appends the values the step selects among the children of n to
+// matches.
+func (seg jsonSegment) apply(n *jsonNode, matches []jsonMatch) []jsonMatch {
+	switch {
+	case n.kind != jsonArray && n.kind != jsonMap:
+	case seg.wildcard:
+		for _, child := range n.children() {
+			matches = append(matches, jsonMatch{node: child, parent: n})
+		}
+	case n.kind == jsonMap:
+		for _, name := range seg.names {
+			if child := n.member(name); child != nil {
+				matches = append(matches, jsonMatch{node: child, parent: n})
+			}
+		}
+	default:
+		for _, index := range seg.indexes {
+			if index < 0 {
+				index += len(n.elements)
+			}
+			if index >= 0 && index < len(n.elements) {
+				matches = append(matches, jsonMatch{node: n.elements[index], parent: n})
+			}
+		}
+	}
+	return matches
+}
+
+// descendants appends n and all the values nested in it to nodes.
+func descendants(n *jsonNode, nodes []*jsonNode) []*jsonNode {
+	nodes = append(nodes, n)
+	if n.kind == jsonArray || n.kind == jsonMap {
+		for _, child := range n.children() {
+			nodes = descendants(child, nodes)
+		}
+	}
+	return nodes
+}
+
+// findJSON returns the values of the document matched by the segments.
+func findJSON(root *jsonNode, segments []jsonSegment) []jsonMatch {
+	matches := []jsonMatch{{node: root}}
+	for _, seg := range segments {
+		next := []jsonMatch{}
+		for _, m := range matches {
+			if !seg.recursive {
+				next = seg.apply(m.node, next)
+				continue
+			}
+			for _, n := range descendants(m.node, nil) {
+				next = seg.apply(n, next)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// find returns the values of the document the path matches, only the
+// first for a legacy path.
+func (p jsonPath) find(root *jsonNode) []jsonMatch {
+	matches := findJSON(root, p.segments)
+	if p.legacy && len(matches) > 1 {
+		matches = matches[:1]
+	}
+	return matches
+}
+
+// json returns the document of a JSON key, nil when the object is not one.
+func (o object) json() *jsonNode {
+	n, _ := o.value.(*jsonNode)
+	return n
+}
+
+// readJSON returns the document stored at key, nil when the key does not
+// exist, or WRONGTYPE when it holds another type. keyspaceMu must be held.
+func readJSON(key string) (*jsonNode, Value, bool) {
+	obj, ok := keyspace[key]
+	if ok && obj.typ != jsonObject {
+		return nil, wrongTypeError, false
+	}
+	return obj.json(), Value{}, true
+}
+
+// jsonSet handles JSON.SET key path value [NX|XX].
+func jsonSet(args []Value) Value {
+	key := args[0].bulk
+	path, ok := parseJSONPath(args[1].bulk)
+	if !ok {
+		return jsonPathError(args[1].bulk)
+	}
+	value, err := parseJSON(args[2].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR " + err.Error()}
+	}
+	nx, xx := false, false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg.bulk) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	if nx && xx {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	root, errReply, ok := readJSON(key)
+	if !ok {
+		return errReply
+	}
+	if root == nil {
+		if !path.isRoot() {
+			return Value{typ: "error", str: "ERR new objects must be created at the root"}
+		}
+		if xx {
+			return Value{typ: "null"}
+		}
+		setKey(key, object{typ: jsonObject, value: value})
+		markKeyspaceChanged()
+		return Value{typ: "string", str: "OK"}
+	}
+
+	matches := path.find(root)
+	if len(matches) > 0 {
+		if nx {
+			return Value{typ: "null"}
+		}
+		for _, m := range matches {
+			*m.node = *value.clone()
+		}
+		markKeyspaceChanged()
+		return Value{typ: "string", str: "OK"}
+	}
+
+	// a missing member is added to the objects matched by the rest of the
+	// path
+	last := len(path.segments) - 1
+	seg := path.segments[last]
+	if xx || seg.recursive || seg.wildcard || len(seg.names) != 1 {
+		return Value{typ: "null"}
+	}
+	parents := findJSON(root, path.segments[:last])
+	if path.legacy && len(parents) > 1 {
+		parents = parents[:1]
+	}
+	added := false
+	for _, parent := range parents {
+		if parent.node.kind == jsonMap {
+			parent.node.set(seg.names[0], value.clone())
+			added = true
+		}
+	}
+	if !added {
+		return Value{typ: "null"}
+	}
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// jsonGet handles JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE
+// space] [path ...], replying the document serialized, or the values the
+// paths match.
+func jsonGet(args []Value) Value {
+	key := args[0].bulk
+	var format jsonFormat
+	i := 1
+options:
+	for ; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i].bulk) {
+		case "INDENT":
+			format.indent = args[i+1].bulk
+		case "NEWLINE":
+			format.newline = args[i+1].bulk
+		case "SPACE":
+			format.space = args[i+1].bulk
+		default:
+			break options
+		}
+	}
+	texts := []string{"."}
+	if i < len(args) {
+		texts = texts[:0]
+		for _, arg := range args[i:] {
+			texts = append(texts, arg.bulk)
+		}
+	}
+	paths := make([]jsonPath, len(texts))
+	legacy := true
+	for j, text := range texts {
+		path, ok := parseJSONPath(text)
+		if !ok {
+			return jsonPathError(text)
+		}
+		paths[j] = path
+		legacy = legacy && path.legacy
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	root, errReply, ok := readJSON(key)
+	if !ok {
+		return errReply
+	}
+	if root == nil {
+		return Value{typ: "null"}
+	}
+
+	// results holds what every path replies, a single value for legacy
+	// paths and an array of the matches otherwise
+	results := make([]*jsonNode, len(paths))
+	for j, path := range paths {
+		matches := path.find(root)
+		if legacy {
+			if len(matches) == 0 {
+				return Value{typ: "error", str: "ERR Path '" + path.text + "' does not exist"}
+			}
+			results[j] = matches[0].node
+			continue
+		}
+		result := &jsonNode{kind: jsonArray, elements: []*jsonNode{}}
+		for _, m := range matches {
+			result.elements = append(result.elements, m.node)
+		}
+		results[j] = result
+	}
+	if len(results) == 1 {
+		return Value{typ: "bulk", bulk: string(results[0].appendTo(nil, format, 0))}
+	}
+	reply := &jsonNode{kind: jsonMap}
+	for j, result := range results {
+		reply.set(paths[j].text, result)
+	}
+	return Value{typ: "bulk", bulk: string(reply.appendTo(nil, format, 0))}
+}
+
+// jsonDel handles JSON.DEL key [path] and JSON.FORGET, replying how many
+// values it deleted. Deleting the root deletes the key.
+func jsonDel(args []Value) Value {
+	key := args[0].bulk
+	text := "$"
+	if len(args) > 1 {
+		text = args[1].bulk
+	}
+	if len(args) > 2 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	path, ok := parseJSONPath(text)
+	if !ok {
+		return jsonPathError(text)
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	root, errReply, ok := readJSON(key)
+	if !ok {
+		return errReply
+	}
+	if root == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	if path.isRoot() {
+		deleteKey(key)
+		return Value{typ: "integer", num: 1}
+	}
+
+	doomed := map[*jsonNode]bool{}
+	parents := []*jsonNode{}
+	for _, m := range path.find(root) {
+		if !doomed[m.node] {
+			doomed[m.node] = true
+			parents = append(parents, m.parent)
+		}
+	}
+	deleted := 0
+	for _, parent := range parents {
+		deleted += parent.remove(doomed)
+	}
+	if deleted > 0 {
+		markKeyspaceChanged()
+	}
+	return Value{typ: "integer", num: deleted}
+}
+
+// add adds the number by to the number n, false when the result overflows
+// a float.
+func (n *jsonNode) add(by *jsonNode) bool {
+	if n.kind == jsonInt && by.kind == jsonInt {
+		sum := n.i + by.i
+		if (sum > n.i) == (by.i > 0) {
+			n.i = sum
+			return true
+		}
+	}
+	sum := n.float() + by.float()
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return false
+	}
+	*n = jsonNode{kind: jsonFloat, f: sum}
+	return true
+}
+
+// float returns a number as a float.
+func (n *jsonNode) float() float64 {
+	if n.kind == jsonInt {
+		return float64(n.i)
+	}
+	return n.f
+}
+
+// jsonNumIncrBy handles JSON.NUMINCRBY key path value, replying the new
+// numbers serialized.
+func jsonNumIncrBy(args []Value) Value {
+	key := args[0].bulk
+	path, ok := parseJSONPath(args[1].bulk)
+	if !ok {
+		return jsonPathError(args[1].bulk)
+	}
+	by, err := parseJSON(args[2].bulk)
+	if err != nil || by.kind != jsonInt && by.kind != jsonFloat {
+		return Value{typ: "error", str: "ERR expected a number but found '" + args[2].bulk + "'"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	root, errReply, ok := readJSON(key)
+	if !ok {
+		return errReply
+	}
+	if root == nil {
+		return Value{typ: "error", str: "ERR could not perform this operation on a key that doesn't exist"}
+	}
+
+	matches := path.find(root)
+	if path.legacy {
+		if len(matches) == 0 {
+			return Value{typ: "error", str: "ERR Path '" + path.text + "' does not exist"}
+		}
+		n := matches[0].node
+		if n.kind != jsonInt && n.kind != jsonFloat {
+			return Value{typ: "error", str: "ERR wrong type of path value - expected a number but found " + n.kind.String()}
+		}
+	}
+	// check every number first so that an overflow changes nothing
+	for _, m := range matches {
+		if n := m.node; (n.kind == jsonInt || n.kind == jsonFloat) && !n.clone().add(by) {
+			return Value{typ: "error", str: "ERR result is not a number"}
+		}
+	}
+
+	result := &jsonNode{kind: jsonArray, elements: []*jsonNode{}}
+	changed := false
+	for _, m := range matches {
+		n := m.node
+		if n.kind != jsonInt && n.kind != jsonFloat {
+			result.elements = append(result.elements, &jsonNode{kind: jsonNull})
+			continue
+		}
+		n.add(by)
+		changed = true
+		result.elements = append(result.elements, n)
+	}
+	if changed {
+		markKeyspaceChanged()
+	}
+	if path.legacy {
+		return Value{typ: "bulk", bulk: result.elements[0].String()}
+	}
+	return Value{typ: "bulk", bulk: result.String()}
+}
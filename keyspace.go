@@ -0,0 +1,191 @@
+// Keyspace.
+//
+// Every key lives in a single map from its name to a typed object, so a name
+// holds exactly one value at a time. Commands that expect a value of one type
+// reply WRONGTYPE when the key holds another, like Redis does, instead of
+// keeping a string and a hash of the same name side by side. SET and MSET
+// are the exception: they overwrite whatever the key held.
+package main
+
+// objectType is the type of the value held by an object.
+type objectType uint8
+
+const (
+	stringObject objectType = iota + 1
+	hashObject
+	listObject
+	setObject
+	zsetObject
+	streamObject
+	jsonObject
+	bloomObject
+	cuckooObject
+	timeSeriesObject
+)
+
+// String returns the type name as TYPE replies it.
+func (t objectType) String() string {
+	switch t {
+	case stringObject:
+		return "string"
+	case hashObject:
+		return "hash"
+	case listObject:
+		return "list"
+	case setObject:
+		return "set"
+	case zsetObject:
+		return "zset"
+	case streamObject:
+		return "stream"
+	case jsonObject:
+		// the name of the RedisJSON module type
+		return "ReJSON-RL"
+	case bloomObject:
+		// the name of the RedisBloom module type
+		return "MBbloom--"
+	case cuckooObject:
+		return "MBbloomCF"
+	case timeSeriesObject:
+		// the name of the RedisTimeSeries module type
+		return "TSDB-TYPE"
+	}
+	return "none"
+}
+
+// object is a value stored in the keyspace. Strings are kept in str, every
+// other type behind value, so that adding a type does not grow the entry of
+// every key by another pointer.
+type object struct {
+	typ objectType
+	// str is the value of a string, as returned by storeValue
+	str string
+	// value is the *hashValue, *listValue, *setValue, *zsetValue,
+	// *streamValue, *jsonNode, *bloomFilter, *cuckooFilter or *timeSeries of
+	// the other types
+	value any
+}
+
+// hash returns the fields of a hash, nil when the object is not a hash.
+func (o object) hash() *hashValue {
+	h, _ := o.value.(*hashValue)
+	return h
+}
+
+// list returns the elements of a list, nil when the object is not a list.
+func (o object) list() *listValue {
+	l, _ := o.value.(*listValue)
+	return l
+}
+
+// set returns the members of a set, nil when the object is not a set.
+func (o object) set() *setValue {
+	s, _ := o.value.(*setValue)
+	return s
+}
+
+// zset returns the members of a sorted set, nil when the object is not a
+// sorted set.
+func (o object) zset() *zsetValue {
+	z, _ := o.value.(*zsetValue)
+	return z
+}
+
+// stream returns the entries of a stream, nil when the object is not a
+// stream.
+func (o object) stream() *streamValue {
+	s, _ := o.value.(*streamValue)
+	return s
+}
+
+// elements returns the number of fields, elements or members of a hash,
+// list, set or sorted set and the entries of a stream, 0 for a string, a
+// JSON document, a Bloom or cuckoo filter or a time series.
+func (o object) elements() int {
+	switch o.typ {
+	case hashObject:
+		return o.hash().len()
+	case listObject:
+		return o.list().len()
+	case setObject:
+		return o.set().len()
+	case zsetObject:
+		return o.zset().len()
+	case streamObject:
+		return o.stream().len()
+	}
+	return 0
+}
+
+// encoding returns the name of the encoding of the value as OBJECT
+// ENCODING replies it. Strings report the encoding Redis would pick for
+// them, other types the one they are stored with.
+func (o object) encoding() string {
+	switch o.typ {
+	case stringObject:
+		switch {
+		case canonicalInt.MatchString(o.str) && len(o.str) <= 20:
+			return "int"
+		case len(o.str) <= 44:
+			return "embstr"
+		default:
+			return "raw"
+		}
+	case hashObject:
+		return o.hash().encoding()
+	case listObject:
+		return o.list().encoding()
+	case setObject:
+		return o.set().encoding()
+	case zsetObject:
+		return o.zset().encoding()
+	case streamObject:
+		return "stream"
+	case jsonObject, bloomObject, cuckooObject, timeSeriesObject:
+		// module types are reported as raw
+		return "raw"
+	}
+	return ""
+}
+
+// keyspace maps every key to its value.
+var keyspace = map[string]object{}
+
+// keyspaceMu guards keyspace and the hashes, lists, sets, sorted sets,
+// streams, JSON documents, Bloom and cuckoo filters and time series stored
+// in it. It is taken before expiresMu and the storage locks.
+var keyspaceMu = rwLock{name: "keyspace"}
+
+// setKey stores obj under key. Every write to the keyspace map goes through
+// setKey or removeKey, so that a rebuild of the map by the active rehash
+// picks up the keys changed while it runs. It must be called with
+// keyspaceMu held for writing.
+func setKey(key string, obj object) {
+	keyspace[key] = obj
+	rehashTouch(key)
+}
+
+// removeKey removes key from the keyspace map, leaving its storage, expiry
+// and access times to the caller. It must be called with keyspaceMu held
+// for writing.
+func removeKey(key string) {
+	delete(keyspace, key)
+	rehashTouch(key)
+}
+
+// wrongTypeError is the reply of a command run against a key holding a value
+// of another type.
+var wrongTypeError = Value{typ: "error", str: "WRONGTYPE Operation against a key holding the wrong kind of value"}
+
+// freeObject gives back the storage held by a value that left the keyspace.
+// List elements and the members of sets and sorted sets are not interned
+// or slab allocated and are left to the garbage collector. It must be
+// called with keyspaceMu held for writing.
+func freeObject(obj object) {
+	switch obj.typ {
+	case stringObject:
+		dropValue(obj.str)
+	case hashObject:
+		freeHash(obj.hash())
+	}
+}
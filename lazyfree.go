@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// Deleting a hash gives back the interned string of every field name and
+// value, which for a hash with a million fields takes long enough to stall
+// every client waiting on the keyspace lock. Like Redis' lazy freeing, a
+// big hash is therefore only unlinked from the keyspace while the lock is
+// held and its fields are released by a background goroutine afterwards.
+// This applies to DEL, UNLINK and expiry alike. FLUSHALL ASYNC goes one
+// step further and hands the whole keyspace to the same goroutine.
+
+// lazyfreeThreshold is the number of fields above which a deleted hash is
+// freed in the background. Smaller hashes are cheaper to free right away.
+const lazyfreeThreshold = 64
+
+// lazyfreeItem is a big hash or a whole flushed keyspace to free.
+type lazyfreeItem struct {
+	hash    *hashValue
+	dataset map[string]object
+	// job reports the progress of freeing a dataset
+	job *job
+}
+
+// lazyfreeQueue carries unlinked hashes and flushed keyspaces to the
+// background freer.
+var lazyfreeQueue = make(chan lazyfreeItem, 1024)
+
+// lazyfreePending counts the hashes and the values of flushed keyspaces
+// waiting to be freed, for INFO.
+var lazyfreePending atomic.Int64
+
+// lazyfreedObjects counts the values freed in the background, for INFO.
+var lazyfreedObjects atomic.Int64
+
+func init() {
+	go lazyfree()
+}
+
+// freeHash gives back the interned field names and values of a hash that
+// left the keyspace, in the background when it is big.
+func freeHash(hash *hashValue) {
+	if hash.len() > lazyfreeThreshold {
+		select {
+		case lazyfreeQueue <- lazyfreeItem{hash: hash}:
+			lazyfreePending.Add(1)
+			return
+		default:
+			// the freer is behind, free this one in place
+		}
+	}
+	releaseHash(hash)
+}
+
+// releaseHash releases every field name and value of hash.
+func releaseHash(hash *hashValue) {
+	hash.each(func(field, value string) {
+		release(field)
+		release(value)
+	})
+}
+
+// freeDataset gives back the storage held by every value of a keyspace
+// that was swapped out by a flush, in the background and as a job. Unlike
+// a single hash it is never freed in place when the freer is behind, since
+// that is what the caller holding keyspaceMu wants to avoid.
+func freeDataset(dataset map[string]object) {
+	lazyfreePending.Add(int64(len(dataset)))
+	j := startUncancellableJob("lazyfree", "free "+strconv.Itoa(len(dataset))+" flushed keys")
+	item := lazyfreeItem{dataset: dataset, job: j}
+	select {
+	case lazyfreeQueue <- item:
+	default:
+		go releaseItem(item)
+	}
+}
+
+// releaseDataset releases the values of a flushed keyspace, the same way
+// freeObject does for a deleted key, reporting its progress to j.
+func releaseDataset(dataset map[string]object, j *job) {
+	total := int64(len(dataset))
+	done := int64(0)
+	for _, obj := range dataset {
+		switch obj.typ {
+		case stringObject:
+			dropValue(obj.str)
+		case hashObject:
+			releaseHash(obj.hash())
+		}
+		lazyfreePending.Add(-1)
+		lazyfreedObjects.Add(1)
+		if done++; done%1024 == 0 {
+			j.progress(done, total)
+		}
+	}
+	j.progress(total, total)
+	j.finish(nil)
+}
+
+// releaseItem frees a hash or a flushed keyspace sent to the freer.
+func releaseItem(item lazyfreeItem) {
+	if item.dataset != nil {
+		releaseDataset(item.dataset, item.job)
+		return
+	}
+	releaseHash(item.hash)
+	lazyfreePending.Add(-1)
+	lazyfreedObjects.Add(1)
+}
+
+// lazyfree frees the hashes and keyspaces sent to lazyfreeQueue.
+func lazyfree() {
+	for item := range lazyfreeQueue {
+		releaseItem(item)
+	}
+}
+
+// unlink handles UNLINK key [key ...]. Deleting always unlinks big values
+// and frees them in the background, so it is the same as DEL.
+func unlink(args []Value) Value {
+	return del(args)
+}
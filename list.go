@@ -0,0 +1,885 @@
+// Lists.
+//
+// A list is a sequence of strings that grows and shrinks at both ends, the
+// building block of queues and stacks:
+//
+//	LPUSH key element [element ...]   RPUSH key element [element ...]
+//	LPOP key [count]                  RPOP key [count]
+//	LLEN key                          LRANGE key start stop
+//	LINSERT key BEFORE|AFTER pivot element
+//	LSET key index element            LREM key count element
+//	LTRIM key start stop              LINDEX key index
+//	LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+//	LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+//	RPOPLPUSH source destination
+//	LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+//
+// Lists are kept like Redis' quicklist, as a doubly linked list of nodes
+// each holding a bounded chunk of consecutive elements, so pushing and
+// popping at either end is O(1) without ever copying a long list, and
+// reading by index skips whole nodes. Small lists fit in a single node and
+// report the listpack encoding, longer ones quicklist. Indexes count from 0
+// at the head; negative indexes count from the tail, -1 being the last
+// element. A list is deleted once its last element is popped.
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// listMaxListpackSize limits the size of a quicklist node like Redis'
+// list-max-listpack-size: a positive value is the most elements a node
+// holds, -1 to -5 limit a node to 4, 8, 16, 32 or 64 KB of elements.
+var listMaxListpackSize atomic.Int64
+
+func init() {
+	listMaxListpackSize.Store(-2)
+}
+
+// listNodeLimit returns the most elements or, when count is 0, the most
+// bytes of elements a node may hold.
+func listNodeLimit() (count, bytes int) {
+	limit := listMaxListpackSize.Load()
+	if limit > 0 {
+		return int(limit), 0
+	}
+	return 0, 4096 << (-max(limit, -5) - 1)
+}
+
+// listValue holds the elements of a list as a doubly linked list of nodes,
+// each holding a slice of consecutive elements. The read methods treat a
+// nil list as empty.
+type listValue struct {
+	head, tail *listNode
+	// n is the number of elements
+	n int
+}
+
+// listNode is a chunk of consecutive elements of a list.
+type listNode struct {
+	prev, next *listNode
+	elements   []string
+	// size is the total length of the elements in bytes
+	size int
+}
+
+// listNodeFits reports whether a node holding n elements of size bytes in total
+// stays within the node size limit. A single element always fits.
+func listNodeFits(n, size int) bool {
+	count, bytes := listNodeLimit()
+	if n <= 1 {
+		return true
+	}
+	if count > 0 {
+		return n <= count
+	}
+	return size <= bytes
+}
+
+// full reports whether the node can not take element without exceeding
+// the node size limit.
+func (node *listNode) full(element string) bool {
+	return !listNodeFits(len(node.elements)+1, node.size+len(element))
+}
+
+// fits reports whether the elements of two nodes fit into one.
+func (node *listNode) fits(other *listNode) bool {
+	return listNodeFits(len(node.elements)+len(other.elements), node.size+other.size)
+}
+
+// len returns the number of elements.
+func (l *listValue) len() int {
+	if l == nil {
+		return 0
+	}
+	return l.n
+}
+
+// locate returns the node holding the element at position i, which must be
+// in range, and the position of the element in the node. The nodes are
+// walked from the nearer end of the list.
+func (l *listValue) locate(i int) (*listNode, int) {
+	if i < l.n/2 {
+		node := l.head
+		for i >= len(node.elements) {
+			i -= len(node.elements)
+			node = node.next
+		}
+		return node, i
+	}
+	node, rest := l.tail, l.n-1-i
+	for rest >= len(node.elements) {
+		rest -= len(node.elements)
+		node = node.prev
+	}
+	return node, len(node.elements) - 1 - rest
+}
+
+// index returns the element at position i, which must be in range.
+func (l *listValue) index(i int) string {
+	node, at := l.locate(i)
+	return node.elements[at]
+}
+
+// set replaces the element at position i, which must be in range.
+func (l *listValue) set(i int, element string) {
+	node, at := l.locate(i)
+	node.size += len(element) - len(node.elements[at])
+	node.elements[at] = element
+}
+
+// walk calls fn with the elements from position from, which must be in
+// range, towards the tail, or towards the head when reverse is set, until
+// fn returns false or the end of the list is reached.
+func (l *listValue) walk(from int, reverse bool, fn func(i int, element string) bool) {
+	if from < 0 || from >= l.len() {
+		return
+	}
+	node, at := l.locate(from)
+	for i := from; node != nil; {
+		if !fn(i, node.elements[at]) {
+			return
+		}
+		if reverse {
+			i, at = i-1, at-1
+			if at < 0 {
+				node = node.prev
+				if node != nil {
+					at = len(node.elements) - 1
+				}
+			}
+		} else {
+			i, at = i+1, at+1
+			if at == len(node.elements) {
+				node, at = node.next, 0
+			}
+		}
+	}
+}
+
+// elements returns every element from head to tail.
+func (l *listValue) elements() []string {
+	all := make([]string, 0, l.len())
+	l.walk(0, false, func(_ int, element string) bool {
+		all = append(all, element)
+		return true
+	})
+	return all
+}
+
+// insert inserts an element at position i, shifting the elements from i
+// to the tail by one. i may be the length of the list to append. Only the
+// node at i is changed, it is split in two when it grows over the limit.
+func (l *listValue) insert(i int, element string) {
+	if i == 0 {
+		l.pushFront(element)
+		return
+	}
+	if i == l.n {
+		l.pushBack(element)
+		return
+	}
+	node, at := l.locate(i)
+	node.elements = slices.Insert(node.elements, at, element)
+	node.size += len(element)
+	l.n++
+
+	if !listNodeFits(len(node.elements), node.size) {
+		l.split(node)
+	}
+}
+
+// split moves the second half of a node into a new node after it.
+func (l *listValue) split(node *listNode) {
+	half := len(node.elements) / 2
+	moved := &listNode{elements: slices.Clone(node.elements[half:])}
+	for _, element := range moved.elements {
+		moved.size += len(element)
+	}
+	clear(node.elements[half:])
+	node.elements = node.elements[:half]
+	node.size -= moved.size
+	l.linkAfter(node, moved)
+}
+
+// retain keeps the elements for which keep returns true, in order, and
+// drops the others. keep is called once per element, from the head. Nodes
+// left empty are unlinked and neighbours that fit together are merged.
+func (l *listValue) retain(keep func(i int, element string) bool) {
+	i := 0
+	for node := l.head; node != nil; {
+		kept := node.elements[:0]
+		size := 0
+		for _, element := range node.elements {
+			if keep(i, element) {
+				kept = append(kept, element)
+				size += len(element)
+			}
+			i++
+		}
+		clear(node.elements[len(kept):])
+		l.n -= len(node.elements) - len(kept)
+		node.elements, node.size = kept, size
+
+		next := node.next
+		switch {
+		case len(node.elements) == 0:
+			l.unlink(node)
+		case node.prev != nil && node.prev.fits(node):
+			node.prev.elements = append(node.prev.elements, node.elements...)
+			node.prev.size += node.size
+			l.unlink(node)
+		}
+		node = next
+	}
+}
+
+// pushBack appends an element at the tail.
+func (l *listValue) pushBack(element string) {
+	if l.tail == nil || l.tail.full(element) {
+		l.linkAfter(l.tail, &listNode{})
+	}
+	l.tail.elements = append(l.tail.elements, element)
+	l.tail.size += len(element)
+	l.n++
+}
+
+// pushFront inserts an element at the head. Elements are inserted at the
+// start of the head node, which is bounded by the node size limit, so this
+// stays O(1) however long the list is.
+func (l *listValue) pushFront(element string) {
+	if l.head == nil || l.head.full(element) {
+		l.linkBefore(l.head, &listNode{})
+	}
+	l.head.elements = slices.Insert(l.head.elements, 0, element)
+	l.head.size += len(element)
+	l.n++
+}
+
+// popFront removes and returns the element at the head. The list must not
+// be empty.
+func (l *listValue) popFront() string {
+	node := l.head
+	element := node.elements[0]
+	// clear the slot so the popped string can be collected
+	node.elements[0] = ""
+	node.elements = node.elements[1:]
+	node.size -= len(element)
+	l.n--
+	if len(node.elements) == 0 {
+		l.unlink(node)
+	}
+	return element
+}
+
+// popBack removes and returns the element at the tail. The list must not be
+// empty.
+func (l *listValue) popBack() string {
+	node := l.tail
+	last := len(node.elements) - 1
+	element := node.elements[last]
+	node.elements[last] = ""
+	node.elements = node.elements[:last]
+	node.size -= len(element)
+	l.n--
+	if len(node.elements) == 0 {
+		l.unlink(node)
+	}
+	return element
+}
+
+// linkAfter links node after prev, or as the head when prev is nil.
+func (l *listValue) linkAfter(prev, node *listNode) {
+	node.prev = prev
+	if prev == nil {
+		node.next = l.head
+		l.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+	if node.next == nil {
+		l.tail = node
+	} else {
+		node.next.prev = node
+	}
+}
+
+// linkBefore links node before next, or as the tail when next is nil.
+func (l *listValue) linkBefore(next, node *listNode) {
+	if next == nil {
+		l.linkAfter(l.tail, node)
+		return
+	}
+	l.linkAfter(next.prev, node)
+}
+
+// unlink removes a node from the list.
+func (l *listValue) unlink(node *listNode) {
+	if node.prev == nil {
+		l.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+	if node.next == nil {
+		l.tail = node.prev
+	} else {
+		node.next.prev = node.prev
+	}
+	node.prev, node.next = nil, nil
+}
+
+// encoding returns the name of the encoding as OBJECT ENCODING reports it:
+// listpack while the list fits in a single node, quicklist beyond.
+func (l *listValue) encoding() string {
+	if l != nil && l.head != l.tail {
+		return "quicklist"
+	}
+	return "listpack"
+}
+
+// writableList returns the elements of the list stored at key, creating an
+// empty list when the key does not exist, or false when the key holds
+// another type. A list created this way must get an element before
+// keyspaceMu, which must be held for writing, is released.
+func writableList(key string) (*listValue, bool) {
+	obj, ok := keyspace[key]
+	if ok {
+		return obj.list(), obj.typ == listObject
+	}
+	obj = object{typ: listObject, value: &listValue{}}
+	setKey(key, obj)
+	markKeyspaceChanged()
+	return obj.list(), true
+}
+
+// readList calls read with the elements of the list stored at key, nil when
+// the key does not exist, while holding keyspaceMu for reading. It replies
+// WRONGTYPE instead when the key holds another type.
+func readList(key string, read func(elements *listValue) Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != listObject {
+		return wrongTypeError
+	}
+	return read(obj.list())
+}
+
+// listPush returns the handler of LPUSH, or RPUSH when front is false. The
+// elements are pushed one after the other, so LPUSH a b c leaves c at the
+// head. It replies the length of the list after the push.
+func listPush(front bool) func([]Value) Value {
+	return func(args []Value) Value {
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		elements, ok := writableList(args[0].bulk)
+		if !ok {
+			return wrongTypeError
+		}
+		for _, arg := range args[1:] {
+			if front {
+				elements.pushFront(arg.bulk)
+			} else {
+				elements.pushBack(arg.bulk)
+			}
+		}
+		length := elements.len()
+		serveListWaiters(args[0].bulk)
+		return Value{typ: "integer", num: length}
+	}
+}
+
+// listPop returns the handler of LPOP, or RPOP when front is false. Without
+// a count it replies the popped element, with one an array of up to count
+// elements; either way null when the list does not exist.
+func listPop(front bool) func([]Value) Value {
+	return func(args []Value) Value {
+		key := args[0].bulk
+		count, withCount := 1, len(args) == 2
+		if len(args) > 2 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		if withCount {
+			n, err := strconv.Atoi(args[1].bulk)
+			if err != nil || n < 0 {
+				return Value{typ: "error", str: "ERR value is out of range, must be positive"}
+			}
+			count = n
+		}
+
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		obj, ok := keyspace[key]
+		if !ok {
+			return Value{typ: "null"}
+		}
+		if obj.typ != listObject {
+			return wrongTypeError
+		}
+		elements := obj.list()
+		popped := []Value{}
+		for len(popped) < count && elements.len() > 0 {
+			var element string
+			if front {
+				element = elements.popFront()
+			} else {
+				element = elements.popBack()
+			}
+			popped = append(popped, Value{typ: "bulk", bulk: element})
+		}
+		dropIfEmpty(key)
+
+		if !withCount {
+			return popped[0]
+		}
+		return Value{typ: "array", array: popped}
+	}
+}
+
+// llen handles LLEN key, replying the length of the list, 0 for a missing
+// list.
+func llen(args []Value) Value {
+	return readList(args[0].bulk, func(elements *listValue) Value {
+		return Value{typ: "integer", num: elements.len()}
+	})
+}
+
+// listRange converts the start and stop indexes of a range, which may be
+// negative, into positions from the head, clamped to a list of n elements.
+// It returns false when the range is empty.
+func listRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	return start, stop, start <= stop
+}
+
+// lrange handles LRANGE key start stop, replying the elements between both
+// indexes, inclusive.
+func lrange(args []Value) Value {
+	start, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	stop, err := strconv.Atoi(args[2].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	return readList(args[0].bulk, func(elements *listValue) Value {
+		found := []Value{}
+		start, stop, ok := listRange(start, stop, elements.len())
+		if !ok {
+			return Value{typ: "array", array: found}
+		}
+		elements.walk(start, false, func(i int, element string) bool {
+			found = append(found, Value{typ: "bulk", bulk: element})
+			return i < stop
+		})
+		return Value{typ: "array", array: found}
+	})
+}
+
+// linsert handles LINSERT key BEFORE|AFTER pivot element, inserting the
+// element next to the first occurrence of pivot from the head. It replies
+// the length of the list after the insert, -1 when pivot was not found and
+// 0 when the list does not exist.
+func linsert(args []Value) Value {
+	key, pivot, element := args[0].bulk, args[2].bulk, args[3].bulk
+	var after bool
+	switch strings.ToUpper(args[1].bulk) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if !ok {
+		return Value{typ: "integer", num: 0}
+	}
+	if obj.typ != listObject {
+		return wrongTypeError
+	}
+	elements := obj.list()
+	at := -1
+	elements.walk(0, false, func(i int, e string) bool {
+		if e == pivot {
+			at = i
+		}
+		return at < 0
+	})
+	if at < 0 {
+		return Value{typ: "integer", num: -1}
+	}
+	if after {
+		at++
+	}
+	elements.insert(at, element)
+	return Value{typ: "integer", num: elements.len()}
+}
+
+// lset handles LSET key index element, replacing the element at index,
+// which may be negative.
+func lset(args []Value) Value {
+	key, element := args[0].bulk, args[2].bulk
+	index, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if !ok {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+	if obj.typ != listObject {
+		return wrongTypeError
+	}
+	elements := obj.list()
+	if index < 0 {
+		index += elements.len()
+	}
+	if index < 0 || index >= elements.len() {
+		return Value{typ: "error", str: "ERR index out of range"}
+	}
+	elements.set(index, element)
+	return Value{typ: "string", str: "OK"}
+}
+
+// lrem handles LREM key count element, removing the first count
+// occurrences of element from the head when count is positive, the last
+// -count ones when it is negative and all of them when it is 0. It replies
+// how many elements were removed.
+func lrem(args []Value) Value {
+	key, element := args[0].bulk, args[2].bulk
+	count, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if !ok {
+		return Value{typ: "integer", num: 0}
+	}
+	if obj.typ != listObject {
+		return wrongTypeError
+	}
+	elements := obj.list()
+
+	// find the positions to remove first, walking from the tail for a
+	// negative count, then drop them in a single pass
+	remove := map[int]bool{}
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	from := 0
+	if count < 0 {
+		from = elements.len() - 1
+	}
+	elements.walk(from, count < 0, func(i int, e string) bool {
+		if e == element {
+			remove[i] = true
+		}
+		return limit == 0 || len(remove) < limit
+	})
+	if len(remove) > 0 {
+		elements.retain(func(i int, _ string) bool { return !remove[i] })
+		dropIfEmpty(key)
+	}
+	return Value{typ: "integer", num: len(remove)}
+}
+
+// ltrim handles LTRIM key start stop, keeping only the elements between
+// both indexes, inclusive. The list is deleted when the range is empty.
+func ltrim(args []Value) Value {
+	key := args[0].bulk
+	start, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	stop, err := strconv.Atoi(args[2].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if !ok {
+		return Value{typ: "string", str: "OK"}
+	}
+	if obj.typ != listObject {
+		return wrongTypeError
+	}
+	elements := obj.list()
+	start, stop, ok = listRange(start, stop, elements.len())
+	elements.retain(func(i int, _ string) bool { return ok && i >= start && i <= stop })
+	dropIfEmpty(key)
+	return Value{typ: "string", str: "OK"}
+}
+
+// lindex handles LINDEX key index, replying the element at index, which may
+// be negative, or null when it is out of range.
+func lindex(args []Value) Value {
+	index, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	return readList(args[0].bulk, func(elements *listValue) Value {
+		if index < 0 {
+			index += elements.len()
+		}
+		if index < 0 || index >= elements.len() {
+			return Value{typ: "null"}
+		}
+		return Value{typ: "bulk", bulk: elements.index(index)}
+	})
+}
+
+// lpos handles LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len],
+// replying the index of the first occurrence of element, or null. RANK
+// skips the first rank-1 matches, counting from the tail when negative;
+// COUNT replies the indexes of up to num matches as an array, all of them
+// for 0; MAXLEN compares at most len elements, so a scan for a missing
+// element in a long list can be bounded.
+func lpos(args []Value) Value {
+	element := args[1].bulk
+	rank, count, maxLen, withCount := 1, 1, 0, false
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		n, err := strconv.Atoi(args[i+1].bulk)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		switch strings.ToUpper(args[i].bulk) {
+		case "RANK":
+			if n == 0 {
+				return Value{typ: "error", str: "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"}
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return Value{typ: "error", str: "ERR COUNT can't be negative"}
+			}
+			count, withCount = n, true
+		case "MAXLEN":
+			if n < 0 {
+				return Value{typ: "error", str: "ERR MAXLEN can't be negative"}
+			}
+			maxLen = n
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	return readList(args[0].bulk, func(elements *listValue) Value {
+		skip, from := rank-1, 0
+		if rank < 0 {
+			skip, from = -rank-1, elements.len()-1
+		}
+		found := []Value{}
+		compared := 0
+		elements.walk(from, rank < 0, func(i int, e string) bool {
+			compared++
+			if e == element {
+				if skip > 0 {
+					skip--
+				} else {
+					found = append(found, Value{typ: "integer", num: i})
+				}
+			}
+			return (count == 0 || len(found) < count) && (maxLen == 0 || compared < maxLen)
+		})
+
+		if withCount {
+			return Value{typ: "array", array: found}
+		}
+		if len(found) == 0 {
+			return Value{typ: "null"}
+		}
+		return found[0]
+	})
+}
+
+// parseListEnd parses the LEFT or RIGHT argument of LMOVE and friends,
+// returning true for the head of the list.
+func parseListEnd(arg string) (bool, bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// moveListElement pops an element from one end of the source list and
+// pushes it to one end of the destination list, which may be the same
+// list, replying the element, or null when the source does not exist. It
+// must be called with keyspaceMu held for writing.
+func moveListElement(source, destination string, fromFront, toFront bool) Value {
+	obj, ok := keyspace[source]
+	if !ok {
+		return Value{typ: "null"}
+	}
+	if obj.typ != listObject {
+		return wrongTypeError
+	}
+	// check the destination before popping, so a failed move loses nothing
+	if dst, ok := keyspace[destination]; ok && dst.typ != listObject {
+		return wrongTypeError
+	}
+
+	var element string
+	if fromFront {
+		element = obj.list().popFront()
+	} else {
+		element = obj.list().popBack()
+	}
+	// the source is still in the keyspace when it was emptied, so moving
+	// the only element of a list to itself keeps the list
+	target, _ := writableList(destination)
+	if toFront {
+		target.pushFront(element)
+	} else {
+		target.pushBack(element)
+	}
+	dropIfEmpty(source)
+	serveListWaiters(destination)
+	return Value{typ: "bulk", bulk: element}
+}
+
+// lmove handles LMOVE source destination LEFT|RIGHT LEFT|RIGHT, moving an
+// element atomically from one list to another. With source and destination
+// the same, it rotates the list. Reliable queues use it to move a job to a
+// processing list in the same step it is taken from the queue.
+func lmove(args []Value) Value {
+	fromFront, ok := parseListEnd(args[2].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	toFront, ok := parseListEnd(args[3].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	return moveListElement(args[0].bulk, args[1].bulk, fromFront, toFront)
+}
+
+// rpoplpush handles RPOPLPUSH source destination, the legacy form of LMOVE
+// source destination RIGHT LEFT.
+func rpoplpush(args []Value) Value {
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	return moveListElement(args[0].bulk, args[1].bulk, false, true)
+}
+
+// parseMpopArgs parses the numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// arguments of LMPOP and BLMPOP.
+func parseMpopArgs(args []Value) (keys []string, front bool, count int, errReply Value, ok bool) {
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	numKeys, err := strconv.Atoi(args[0].bulk)
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, Value{typ: "error", str: "ERR numkeys should be greater than 0"}, false
+	}
+	if numKeys+1 >= len(args) {
+		return nil, false, 0, syntaxError, false
+	}
+	for _, arg := range args[1 : numKeys+1] {
+		keys = append(keys, arg.bulk)
+	}
+	rest := args[numKeys+1:]
+	if front, ok = parseListEnd(rest[0].bulk); !ok {
+		return nil, false, 0, syntaxError, false
+	}
+
+	count = 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.EqualFold(rest[1].bulk, "COUNT"):
+		count, err = strconv.Atoi(rest[2].bulk)
+		if err != nil || count <= 0 {
+			return nil, false, 0, Value{typ: "error", str: "ERR count should be greater than 0"}, false
+		}
+	default:
+		return nil, false, 0, syntaxError, false
+	}
+	return keys, front, count, Value{}, true
+}
+
+// popMany pops up to count elements from one end of the list at key, which
+// must exist, replying the key and the elements. It must be called with
+// keyspaceMu held for writing.
+func popMany(key string, front bool, count int) Value {
+	elements := keyspace[key].list()
+	popped := []Value{}
+	for len(popped) < count && elements.len() > 0 {
+		var element string
+		if front {
+			element = elements.popFront()
+		} else {
+			element = elements.popBack()
+		}
+		popped = append(popped, Value{typ: "bulk", bulk: element})
+	}
+	dropIfEmpty(key)
+	return Value{typ: "array", array: []Value{{typ: "bulk", bulk: key}, {typ: "array", array: popped}}}
+}
+
+// lmpop handles LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count],
+// popping up to count elements from the first of the lists that is not
+// empty. Consumers of prioritized queues list them from the most urgent
+// down. It replies the name of the list and the elements, or a null array
+// when every list is empty.
+func lmpop(args []Value) Value {
+	keys, front, count, errReply, ok := parseMpopArgs(args)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	for _, key := range keys {
+		obj, ok := keyspace[key]
+		if !ok {
+			continue
+		}
+		if obj.typ != listObject {
+			return wrongTypeError
+		}
+		return popMany(key, front, count)
+	}
+	return Value{typ: "nullarray"}
+}
@@ -0,0 +1,42 @@
+// Lock layer.
+//
+// The keyspace locks are named so that, in builds made with the lockdebug
+// tag, a lock-dependency tracker can follow which goroutine holds which lock
+// and report lock-order inversions and locks that are waited on for too
+// long. In regular builds rwLock is a plain sync.RWMutex and the tracker
+// costs nothing.
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rwLock is a read-write mutex with a name used in deadlock reports.
+type rwLock struct {
+	sync.RWMutex
+	name string
+}
+
+// deadlockDetector enables the lock-dependency tracker. It can only be
+// switched on in builds made with the lockdebug tag.
+var deadlockDetector atomic.Bool
+
+// lockWaitWarn is how long a lock may be waited on before the tracker
+// reports it as a potential deadlock.
+const lockWaitWarn = 5 * time.Second
+
+// debugSleeping counts the DEBUG SLEEP calls in progress. While one runs the
+// keyspace is stalled on purpose, so long lock waits are not reported.
+var debugSleeping atomic.Int64
+
+// setDeadlockDetector switches the tracker on or off.
+func setDeadlockDetector(on bool) error {
+	if on && !lockDebugBuild {
+		return errors.New("the deadlock detector requires a build with -tags lockdebug")
+	}
+	deadlockDetector.Store(on)
+	return nil
+}
@@ -0,0 +1,139 @@
+//go:build lockdebug
+
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lockDebugBuild reports whether the lock-dependency tracker is compiled in.
+const lockDebugBuild = true
+
+// lockTracker records the locks held by every goroutine and the order in
+// which locks have been taken together.
+var lockTracker = struct {
+	sync.Mutex
+	// held lists the names of the locks held by each goroutine, oldest first
+	held map[int64][]string
+	// order maps "a" -> "b" to the stack of the first goroutine seen taking
+	// lock b while holding lock a
+	order map[[2]string][]byte
+}{
+	held:  map[int64][]string{},
+	order: map[[2]string][]byte{},
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the
+// header of its stack trace.
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	buf = buf[:bytes.IndexByte(buf, ' ')]
+	id, _ := strconv.ParseInt(string(buf), 10, 64)
+	return id
+}
+
+// stack returns the stack trace of the calling goroutine.
+func stack() []byte {
+	buf := make([]byte, 16<<10)
+	return buf[:runtime.Stack(buf, false)]
+}
+
+// acquiring checks the lock order before l is taken by the goroutine gid
+// and starts a timer reporting the wait if it takes too long.
+func (l *rwLock) acquiring(gid int64) *time.Timer {
+	lockTracker.Lock()
+	for _, h := range lockTracker.held[gid] {
+		if h == l.name {
+			serverLog(logWarning, "Deadlock: lock %s taken again by the goroutine holding it\n%s", l.name, stack())
+			continue
+		}
+		edge := [2]string{h, l.name}
+		if _, ok := lockTracker.order[edge]; !ok {
+			lockTracker.order[edge] = stack()
+		}
+		if first, ok := lockTracker.order[[2]string{l.name, h}]; ok {
+			serverLog(logWarning, "Potential deadlock: lock %s taken while holding %s, but %s was also taken while holding %s\n%s\nfirst taken in the other order at:\n%s",
+				l.name, h, h, l.name, stack(), first)
+		}
+	}
+	lockTracker.Unlock()
+
+	trace := stack()
+	return time.AfterFunc(lockWaitWarn, func() {
+		if debugSleeping.Load() == 0 {
+			serverLog(logWarning, "Potential deadlock: lock %s not acquired after %v\n%s", l.name, lockWaitWarn, trace)
+		}
+	})
+}
+
+// acquired records that l is held by the goroutine gid.
+func (l *rwLock) acquired(gid int64, wait *time.Timer) {
+	wait.Stop()
+
+	lockTracker.Lock()
+	lockTracker.held[gid] = append(lockTracker.held[gid], l.name)
+	lockTracker.Unlock()
+}
+
+// released records that the goroutine gid gave l up. Read locks may be
+// released by another goroutine than the one that took them, in which case
+// there is nothing to forget.
+func (l *rwLock) released(gid int64) {
+	lockTracker.Lock()
+	defer lockTracker.Unlock()
+
+	held := lockTracker.held[gid]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i] == l.name {
+			held = append(held[:i], held[i+1:]...)
+			break
+		}
+	}
+	if len(held) == 0 {
+		delete(lockTracker.held, gid)
+	} else {
+		lockTracker.held[gid] = held
+	}
+}
+
+func (l *rwLock) Lock() {
+	if !deadlockDetector.Load() {
+		l.RWMutex.Lock()
+		return
+	}
+	gid := goroutineID()
+	wait := l.acquiring(gid)
+	l.RWMutex.Lock()
+	l.acquired(gid, wait)
+}
+
+func (l *rwLock) Unlock() {
+	if deadlockDetector.Load() {
+		l.released(goroutineID())
+	}
+	l.RWMutex.Unlock()
+}
+
+func (l *rwLock) RLock() {
+	if !deadlockDetector.Load() {
+		l.RWMutex.RLock()
+		return
+	}
+	gid := goroutineID()
+	wait := l.acquiring(gid)
+	l.RWMutex.RLock()
+	l.acquired(gid, wait)
+}
+
+func (l *rwLock) RUnlock() {
+	if deadlockDetector.Load() {
+		l.released(goroutineID())
+	}
+	l.RWMutex.RUnlock()
+}
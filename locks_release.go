@@ -0,0 +1,6 @@
+//go:build !lockdebug
+
+package main
+
+// lockDebugBuild reports whether the lock-dependency tracker is compiled in.
+const lockDebugBuild = false
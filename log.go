@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// log levels, from the most to the least verbose, as in redis.conf
+const (
+	logDebug = iota
+	logVerbose
+	logNotice
+	logWarning
+)
+
+// logLevelNames maps the loglevel setting values to log levels.
+var logLevelNames = []string{logDebug: "debug", logVerbose: "verbose", logNotice: "notice", logWarning: "warning"}
+
+// logLevelMarks are the characters Redis prints in front of each message to
+// show its level.
+var logLevelMarks = []string{logDebug: ".", logVerbose: "-", logNotice: "*", logWarning: "#"}
+
+// loglevel is the least severe level still written to the log.
+var loglevel atomic.Int32
+
+func init() {
+	loglevel.Store(logNotice)
+}
+
+// parseLogLevel returns the log level with the given name.
+func parseLogLevel(s string) (int32, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return int32(level), nil
+		}
+	}
+	return 0, fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(logLevelNames, ", "))
+}
+
+// serverLog writes a message to standard output in the Redis log format,
+// provided level is at least the configured loglevel:
+//
+//	4321:M 17 Oct 2026 10:15:30.123 * message
+func serverLog(level int32, format string, args ...interface{}) {
+	if level < loglevel.Load() {
+		return
+	}
+	now := time.Now().Format("02 Jan 2006 15:04:05.000")
+	fmt.Printf("%d:M %s %s %s\n", os.Getpid(), now, logLevelMarks[level], fmt.Sprintf(format, args...))
+}
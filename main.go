@@ -1,111 +1,580 @@
 package main
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
+	"flag"
+	"io"
 	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-func main() {
-	fmt.Println("connected.port@ 6379")
+// bind lists the addresses the server listens on, separated by spaces. An
+// empty list listens on all interfaces.
+var bind = ""
 
-	//setup TCP: Transmission Control Protocol server. This server reads in RESP data from
-	//redis-cli. The listening port is 6379. On receiving and accepting incoming
-	//connection request from redis cli, establish a communication channel with redis-cli
-	tsrv, err := net.Listen("tcp", ":6379")
-	//check if error occured during server setup
-	if err != nil {
-		fmt.Println(err)
+// port is the TCP port the server listens on.
+var port = 6379
+
+// aof is the append-only file shared by every connection. Write commands
+// are logged to it before they are applied in memory.
+var aof *Aof
+
+func main() {
+	// "gostore proxy" runs the sharding proxy instead of a server
+	if len(os.Args) > 1 && os.Args[1] == "proxy" {
+		runProxy(os.Args[2:])
+		return
+	}
+	// "gostore diff" compares two instances or snapshot files
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
 		return
 	}
 
-	aof, err := NewAof("database.aof")
+	// A configuration file may be given as the first argument, like
+	// redis-server does. Its directives are applied first so that every
+	// registered setting given as a command line option afterwards,
+	// e.g. -maxmemory 512mb -appendfsync always, overrides the file
+	recordConfigDefaults()
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		configFile, _ = filepath.Abs(args[0])
+		if err := loadConfigFile(configFile); err != nil {
+			serverLog(logWarning, "Fatal error, can't open config file '%s': %v", args[0], err)
+			return
+		}
+		args = args[1:]
+	}
+	registerConfigFlags(flag.CommandLine)
+	flag.CommandLine.Parse(args)
+
+	// reload the runtime settings from the configuration file on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfigFile()
+		}
+	}()
+
+	// shut down cleanly on SIGTERM and SIGINT, the same way SHUTDOWN does
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-term
+		serverLog(logWarning, "Received termination signal, scheduling shutdown...")
+		prepareForShutdown(false, false)
+		serverLog(logWarning, "gostore is now ready to exit, bye bye...")
+		os.Exit(0)
+	}()
+
+	// hand the server over to a new process on SIGUSR2
+	handleHotRestart()
+
+	// size the Go runtime memory limits after the dataset budget and
+	// start watching memory usage before any data is loaded
+	tuneGC()
+	go memoryWatchdog()
+	// give memory of shrunk keyspace maps back over time
+	go activeRehash()
+	// take snapshots according to the save rules
+	go saveCron()
+	// publish lock-free copies of the keyspace in read-mostly mode
+	go snapshotBuilder()
+	// warn operators when soft limits are crossed
+	go alertCron()
+	// delete expired sessions nobody reads
+	go sessionExpireCron()
+
+	serverLog(logNotice, "connected.port@ %d", port)
+
+	//setup TCP: Transmission Control Protocol server. This server reads in RESP data from
+	//redis-cli. The listening port is 6379 unless configured otherwise. On receiving and
+	//accepting incoming connection request from redis cli, establish a communication
+	//channel with redis-cli. One listener is opened per bind address, or one
+	//per CPU and bind address with reuseport. When started by systemd socket
+	//activation the sockets passed by systemd are used instead
+	listeners, err := inheritedListeners()
+	if err == nil && len(listeners) == 0 {
+		listeners, err = systemdListeners()
+		if len(listeners) > 0 {
+			serverLog(logNotice, "Using %d listening sockets passed by systemd", len(listeners))
+		}
+	}
 	if err != nil {
-		fmt.Println(err)
+		serverLog(logWarning, "%v", err)
 		return
 	}
-	defer aof.Close()
-
-	// Performing operations from the AOF file before executing them in memory offers
-	// data durability, replayability, and consistency in database systems. By logging
-	// every operation to disk first, potential data loss due to system crashes or restarts
-	// is mitigated. Replaying operations from the AOF file during system recovery ensures
-	// that the database state is accurately reconstructed. Additionally, executing operations
-	// from the AOF file guarantees that the in-memory database reflects all logged operations,
-	// maintaining data consistency. Asynchronous execution of AOF file operations can improve
-	// system performance by separating disk I/O from other application tasks. Furthermore,
-	// inspecting the AOF file allows for debugging and monitoring of database activity, providing
-	// insights into the history of operations. In summary, leveraging the AOF file for operations
-	// before executing them in memory enhances data durability, consistency, and system
-	/// performance in database management
-	aof.Read(func(value Value) {
-		command := strings.ToUpper(value.array[0].bulk)
-		args := value.array[1:]
-
-		handler, ok := Handlers[command]
-		if !ok {
-			fmt.Println("Invalid command: ", command)
+	addrs := strings.Fields(bind)
+	if len(addrs) == 0 {
+		addrs = []string{""}
+	}
+	if len(listeners) > 0 {
+		addrs = nil
+	}
+	for _, addr := range addrs {
+		tsrvs, err := listen(net.JoinHostPort(addr, strconv.Itoa(port)))
+		//check if error occured during server setup
+		if err != nil {
+			serverLog(logWarning, "%v", err)
 			return
 		}
+		listeners = append(listeners, tsrvs...)
+	}
 
-		handler(args)
-	})
-	//Accepts incoming connections ('aconn') from clients on TCP listener ('tsrv').
-	aconn, err := tsrv.Accept()
-	if err != nil {
-		fmt.Println(err)
+	if appendonly {
+		var err error
+		aof, err = NewAof(filepath.Join(dir, appendfilename))
+		if err != nil {
+			serverLog(logWarning, "%v", err)
+			return
+		}
+		defer aof.Close()
+
+		// Performing operations from the AOF file before executing them in memory offers
+		// data durability, replayability, and consistency in database systems. By logging
+		// every operation to disk first, potential data loss due to system crashes or restarts
+		// is mitigated. Replaying operations from the AOF file during system recovery ensures
+		// that the database state is accurately reconstructed. Additionally, executing operations
+		// from the AOF file guarantees that the in-memory database reflects all logged operations,
+		// maintaining data consistency. Asynchronous execution of AOF file operations can improve
+		// system performance by separating disk I/O from other application tasks. Furthermore,
+		// inspecting the AOF file allows for debugging and monitoring of database activity, providing
+		// insights into the history of operations. In summary, leveraging the AOF file for operations
+		// before executing them in memory enhances data durability, consistency, and system
+		/// performance in database management
+		var r transactionReplay
+		aof.Read(r.replay)
+		// a transaction left open would take in the commands logged from
+		// now on the next time the AOF is loaded
+		if r.finish() {
+			aof.Write(commandValue("DISCARD"))
+		}
+	} else if err := loadSnapshot(snapshotPath()); err != nil {
+		// without the AOF the last snapshot is the most recent copy of the data
+		serverLog(logWarning, "%v", err)
 		return
 	}
 
-	//defer connection closing before function exits
-	defer aconn.Close()
+	// take over the clients of the previous process on a hot restart
+	if inheritedClients != nil {
+		adoptClients(len(listeners))
+	}
+
+	// delete expired keys nobody reads. It starts once the dataset is
+	// loaded, so that replaying the AOF never races with its deletions
+	go activeExpireCron()
+
+	// accept clients on every listener, the server runs until one fails
+	serverListeners = listeners
+	done := make(chan error)
+	for _, tsrv := range listeners {
+		go func(tsrv net.Listener) {
+			done <- acceptLoop(tsrv)
+		}(tsrv)
+	}
+	serverLog(logWarning, "%v", <-done)
+}
 
+// acceptLoop accepts clients on tsrv until accepting fails.
+func acceptLoop(tsrv net.Listener) error {
 	for {
-		// create new instance of a pointer to
-		// an RESP struct with aconn
-		redis_msg := newrESP(aconn)
-		// read RESP struct for redis_msg using Read
-		value, err := redis_msg.Read()
+		//Accepts incoming connections ('aconn') from clients on TCP listener ('tsrv').
+		aconn, err := tsrv.Accept()
 		if err != nil {
-			fmt.Println(err)
-			return
+			// a hot restart stops accepting while it hands the
+			// listeners over, resume if it does not succeed
+			if handoff.pending.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+				parkAcceptor()
+				continue
+			}
+			return err
 		}
-		// Ensure the message is of type array
-		if value.typ != "array" {
-			// print error if not array and
-			// continue to next iteration
-			fmt.Println("Invalid request, expected array")
+
+		// refuse the connection once the client limit has been reached
+		ClientsMu.RLock()
+		full := int64(len(Clients)) >= maxclients.Load()
+		ClientsMu.RUnlock()
+		if full {
+			NewWriter(aconn).Write(Value{typ: "error", str: "ERR max number of clients reached"})
+			aconn.Close()
 			continue
 		}
-		// Ensure message is not empty
-		if len(value.array) == 0 {
-			// print error if empty
-			// and continue to the next iteration
-			fmt.Println("Invalid request, expected array length > 0")
+
+		// refuse remote clients while the server is open to everyone
+		if protectedRefuses(aconn) {
+			serverLog(logVerbose, "Refused connection from %s in protected mode", aconn.RemoteAddr())
+			NewWriter(aconn).Write(protectedError)
+			aconn.Close()
 			continue
 		}
 
-		// This line of code converts the first element of an array,
-		// accessed via `value.array[0].bulk`, to uppercase using the
-		// `strings.ToUpper()` function. The resulting uppercase string
-		// is assigned to the variable `command`.
-		command := strings.ToUpper(value.array[0].bulk)
-		// set array[1:] to args
-		args := value.array[1:]
-		// create  a new instance
-		writer := NewWriter(aconn)
-		// check handler validity
-		handler, ok := Handlers[command]
-		if !ok {
-			fmt.Println("Invalid command: ", command)
-			writer.Write(Value{typ: "string", str: ""})
-			continue
+		tuneConn(aconn)
+
+		// register the connection so other clients can see and reach it,
+		// then serve every client on its own goroutine so that one slow
+		// or paused client does not stop the server from accepting others
+		go serveClient(newClient(aconn))
+	}
+}
+
+// tcpKeepAlive is the interval in seconds between TCP keepalive probes sent
+// to idle clients, so that peers which vanished behind a NAT or firewall are
+// noticed. Zero disables keepalives.
+var tcpKeepAlive atomic.Int64
+
+// tcpNoDelay disables Nagle's algorithm on client connections when set, so
+// that small replies are sent without delay.
+var tcpNoDelay atomic.Bool
+
+func init() {
+	tcpKeepAlive.Store(300)
+	tcpNoDelay.Store(true)
+}
+
+// tuneConn applies the TCP settings to a newly accepted connection.
+func tuneConn(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if err := tcp.SetNoDelay(tcpNoDelay.Load()); err != nil {
+		serverLog(logVerbose, "Unable to set TCP_NODELAY: %v", err)
+	}
+
+	if secs := tcpKeepAlive.Load(); secs > 0 {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(time.Duration(secs) * time.Second)
+	} else {
+		tcp.SetKeepAlive(false)
+	}
+}
+
+// propagateRewriters maps write commands whose effect depends on more than
+// their arguments, such as the time or the current state, to a function
+// returning the deterministic command to persist in their place, or an
+// array of such commands. The function sees the command and its result and
+// may return an empty Value to persist nothing.
+var propagateRewriters = map[string]func(value Value, result Value) Value{
+	"SET":           setPropagate,
+	"SETEX":         setexPropagate,
+	"PSETEX":        setexPropagate,
+	"GETEX":         getexPropagate,
+	"EXPIRE":        expirePropagate,
+	"PEXPIRE":       expirePropagate,
+	"EXPIREAT":      expirePropagate,
+	"PEXPIREAT":     expirePropagate,
+	"IDGEN":         idgenPropagate,
+	"SESSION.SET":   sessionPropagate,
+	"SESSION.TOUCH": sessionPropagate,
+	"BLPOP":         blockingPopPropagate,
+	"BRPOP":         blockingPopPropagate,
+	"BLMOVE":        blmovePropagate,
+	"BLMPOP":        blmpopPropagate,
+	"XADD":          xaddPropagate,
+	"XREADGROUP":    xreadgroupPropagate,
+	"XCLAIM":        xclaimPropagate,
+	"XAUTOCLAIM":    xautoclaimPropagate,
+	"TS.ADD":        tsAddPropagate,
+	"TS.MADD":       tsMaddPropagate,
+}
+
+// replayCommand executes a command read back from the AOF or a snapshot
+// file while the dataset is being loaded.
+func replayCommand(value Value) {
+	command := strings.ToUpper(value.array[0].bulk)
+	args := value.array[1:]
+
+	handler, ok := Handlers[command]
+	if !ok {
+		// the client commands persisted as themselves, like XREADGROUP,
+		// only need their client to block, which they are logged without
+		if clientHandler, ok := ClientHandlers[command]; ok && isWriteCommand(command) {
+			clientHandler(nil, args)
+			searchTouch(command, args)
+			return
 		}
-		if command == "SET" || command == "HSET" {
-			aof.Write(value)
+		serverLog(logWarning, "Invalid command: %s", command)
+		return
+	}
+
+	handler(args)
+	if isWriteCommand(command) {
+		searchTouch(command, args)
+	}
+}
+
+// pipelineMaxBatch is the largest number of pipelined commands read and
+// answered as a single batch.
+const pipelineMaxBatch = 128
+
+// serveClient reads commands from a single client connection until the
+// client disconnects, executing each one and writing back its reply.
+//
+// Commands a client pipelines are handled in batches: every command already
+// waiting in the read buffer is read at once, runs of GETs inside the batch
+// are resolved together under a single lock acquisition and all replies are
+// sent back with a single write.
+func serveClient(c *Client) {
+	//defer connection closing before function exits
+	defer c.Close()
+
+	// create new instance of a pointer to an RESP struct with the connection.
+	// The reader is kept for the whole connection so that bytes of a
+	// pipelined command buffered by a previous Read are not lost
+	redis_msg := newrESP(c.conn)
+	if len(c.unread) > 0 {
+		redis_msg = newrESP(io.MultiReader(bytes.NewReader(c.unread), c.conn))
+		c.unread = nil
+	}
+	c.reader = redis_msg.reader
+
+	for {
+		// wait for the next command without consuming it, so that a hot
+		// restart can interrupt the wait and hand the connection over
+		// without losing a partly received command
+		c.waitIdle()
+		// the commands interrupted by a hot restart run first
+		batch := c.pending
+		c.pending = nil
+		if len(batch) == 0 {
+			if _, err := redis_msg.reader.Peek(1); err != nil {
+				if handoff.pending.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+					continue
+				}
+				serverLog(logVerbose, "Client %s closed: %v", c.addr, err)
+				return
+			}
+		}
+		c.busy()
+
+		// read RESP struct for redis_msg using Read, followed by the
+		// rest of the pipeline if more commands are already buffered
+		for len(batch) == 0 || (len(batch) < pipelineMaxBatch && redis_msg.reader.Buffered() > 0) {
+			value, err := redis_msg.readCommand()
+			if err != nil {
+				// like Redis, say why before closing the connection
+				if errors.Is(err, errInvalidBulkLength) {
+					c.Write(Value{typ: "error", str: "ERR " + err.Error()})
+				}
+				serverLog(logVerbose, "Client %s closed: %v", c.addr, err)
+				return
+			}
+			batch = append(batch, value)
+		}
+
+		replies := make([]Value, 0, len(batch))
+		for i := 0; i < len(batch); {
+			// strict mode checks every key, which the batched lookup skips
+			if n := getRun(batch[i:]); n > 1 && c.authorized() && !c.subscribed() && c.db == 0 && c.multi == nil && !strictArguments.Load() {
+				replies = append(replies, processGets(c, batch[i:i+n])...)
+				i += n
+				continue
+			}
+			reply := processCommand(c, batch[i])
+			// a blocking command woken by a hot restart runs again, with
+			// the rest of the batch, once the client has parked
+			if reply.typ == requeueReply.typ {
+				c.pending = batch[i:]
+				break
+			}
+			c.retry = nil
+			replies = append(replies, reply)
+			i++
+		}
+		c.WriteMany(replies)
+	}
+}
+
+// processCommand executes a single command sent by c and returns its reply.
+// Requests that are not a command get an empty reply, which writes nothing.
+func processCommand(c *Client, value Value) Value {
+	// Ensure the message is of type array
+	if value.typ != "array" {
+		// print error if not array and
+		// continue to next iteration
+		serverLog(logVerbose, "Invalid request, expected array")
+		return Value{}
+	}
+	// Ensure message is not empty
+	if len(value.array) == 0 {
+		// print error if empty
+		// and continue to the next iteration
+		serverLog(logVerbose, "Invalid request, expected array length > 0")
+		return Value{}
+	}
+
+	// This line of code converts the first element of an array,
+	// accessed via `value.array[0].bulk`, to uppercase using the
+	// `strings.ToUpper()` function. The resulting uppercase string
+	// is assigned to the variable `command`.
+	command := strings.ToUpper(value.array[0].bulk)
+	// set array[1:] to args
+	args := value.array[1:]
+	// check handler validity
+	_, ok := Handlers[command]
+	_, isClientCommand := ClientHandlers[command]
+	if !ok && !isClientCommand {
+		serverLog(logVerbose, "Invalid command: %s", command)
+		// inside MULTI it fails the transaction, and says so like Redis
+		if c.multi != nil {
+			return c.flagTransaction(unknownCommandError(value))
 		}
-		// return results on arguments
-		result := handler(args)
-		writer.Write(result)
+		return Value{typ: "string", str: ""}
+	}
+	c.touch(command)
+	// only AUTH and HELLO, which may authenticate too, are allowed until
+	// the client has authenticated
+	if !c.authorized() && command != "AUTH" && command != "HELLO" {
+		return Value{typ: "error", str: "NOAUTH Authentication required."}
+	}
+	// a subscribed client may only manage its subscriptions, unless it
+	// speaks RESP3 where messages can not be mistaken for replies
+	if !pubsubAllowed[command] && c.subscribed() && c.protocol() < 3 {
+		return pubsubError(command)
+	}
+	// reject calls that do not match the declared arity before they reach
+	// the handler, so that the error is the same for every command
+	if !arityOK(command, len(value.array)) {
+		return c.flagTransaction(arityError(command))
+	}
+	// in strict mode malformed keys and numbers never reach the handler
+	if err, rejected := strictCheck(command, args); rejected {
+		return c.flagTransaction(err)
+	}
+	// inside MULTI the commands are queued for EXEC instead of run
+	if c.multi != nil && !transactionCommands[command] {
+		return c.queueCommand(command, value)
+	}
+	// hold the command back while a CLIENT PAUSE covering it is active.
+	// CLIENT itself is never paused so that CLIENT UNPAUSE can get through
+	if command != "CLIENT" {
+		waitIfPaused(isWriteCommand(command))
+	}
+	// a running command holds back the EXEC of other clients, which waits
+	// for every command but its own
+	if command != "EXEC" {
+		c.gate.enter()
+		defer c.gate.leave()
+	}
+	return executeCommand(c, command, value)
+}
+
+// executeCommand runs a command that passed the checks of processCommand,
+// or was queued by MULTI, and returns its reply.
+func executeCommand(c *Client, command string, value Value) Value {
+	args := value.array[1:]
+	handler := Handlers[command]
+	clientHandler, isClientCommand := ClientHandlers[command]
+	// let every MONITOR see the command before it runs, except AUTH and
+	// HELLO which would reveal the password
+	if command != "AUTH" && command != "HELLO" {
+		feedMonitors(c, value)
+	}
+	// key commands of a client that selected the attached snapshot are
+	// served from it and never reach the live dataset
+	if c.db == attachedDB {
+		if result, handled := attachedCommand(command, args); handled {
+			return result
+		}
+	}
+	// refuse to grow the dataset while memory is over the limit
+	if isWriteCommand(command) && oomReject.Load() {
+		return oomError
+	}
+	// delete the keys of the command that expired, ahead of logging it
+	expireCommandKeys(command, args)
+	// commands with a propagation rewriter are logged once their result
+	// is known, everything else is logged before it runs
+	rewrite, rewritten := propagateRewriters[command]
+	if isWriteCommand(command) && aof != nil && !rewritten {
+		aof.Write(value)
+	}
+	// remember the keys read by a client that caches them
+	trackReads(c, command, args)
+	// return results on arguments
+	start := time.Now()
+	var result Value
+	if isClientCommand {
+		result = clientHandler(c, args)
+	} else {
+		result = handler(args)
+	}
+	// a blocking command woken by a hot restart did nothing, it is run
+	// again later
+	if result.typ == requeueReply.typ {
+		return result
+	}
+	// EXEC is left out, its commands are logged on their own
+	if command != "AUTH" && command != "HELLO" && command != "EXEC" {
+		slowlogPush(c, value, time.Since(start))
+	}
+	if rewritten && aof != nil && result.typ != "error" {
+		if v := rewrite(value, result); v.typ == "array" && len(v.array) > 0 && v.array[0].typ == "array" {
+			for _, command := range v.array {
+				aof.Write(command)
+			}
+		} else if v.typ == "array" {
+			aof.Write(v)
+		}
+	}
+	// remember when the keys of the command were last read or written
+	if result.typ != "error" {
+		recordAccess(command, args)
+	}
+	// count changes towards the save rules
+	if isWriteCommand(command) && result.typ != "error" {
+		dirty.Add(1)
+	}
+	// have the search indexes look at the keys written again, even after
+	// an error since some commands fail halfway, and tell the clients
+	// caching them that they changed
+	if isWriteCommand(command) {
+		searchTouch(command, args)
+		invalidateKeys(commandKeys(command, args), c.id)
+	}
+	return result
+}
+
+// getRun returns how many commands at the start of batch are well-formed
+// single-key GETs that can be resolved together.
+func getRun(batch []Value) int {
+	n := 0
+	for _, value := range batch {
+		if value.typ != "array" || len(value.array) != 2 || !strings.EqualFold(value.array[0].bulk, "GET") {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// processGets executes a run of GET commands found by getRun, looking all
+// keys up under a single lock acquisition.
+func processGets(c *Client, run []Value) []Value {
+	keys := make([]string, len(run))
+	c.touch("GET")
+	c.gate.enter()
+	defer c.gate.leave()
+	for i, value := range run {
+		waitIfPaused(false)
+		feedMonitors(c, value)
+		keys[i] = value.array[1].bulk
+		expireIfNeeded(keys[i])
+		trackReads(c, "GET", value.array[1:])
+	}
+	replies := getBatch(keys)
+	for _, value := range run {
+		recordAccess("GET", value.array[1:])
 	}
+	return replies
 }
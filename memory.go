@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxmemory is the memory budget for the dataset in bytes, 0 means no limit.
+// Once the Go heap grows past it write commands are rejected with an OOM
+// error, the same way Redis behaves with the noeviction policy.
+var maxmemory atomic.Int64
+
+// memoryHeadroom is the share of the container (or system) memory limit the
+// process may use before the watchdog starts rejecting writes. Staying below
+// the hard limit keeps the kernel or Kubernetes from OOM-killing the server.
+const memoryHeadroom = 0.90
+
+// memoryWatchdogInterval is how often the watchdog samples memory usage.
+const memoryWatchdogInterval = 100 * time.Millisecond
+
+// containerLimit caches containerMemoryLimit. It is read by tuneGC, at
+// startup and whenever maxmemory changes, so that the watchdog does not
+// read the cgroup files on every tick.
+var containerLimit atomic.Int64
+
+// oomReject is set by the watchdog while write commands must be rejected.
+var oomReject atomic.Bool
+
+// oomError is the reply sent for write commands while oomReject is set.
+var oomError = Value{typ: "error", str: "OOM command not allowed when used memory > 'maxmemory'."}
+
+// parseMemory parses a memory amount such as "100mb" or "1gb" into bytes,
+// accepting the same units as redis.conf.
+func parseMemory(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid memory amount")
+	}
+	return n * mul, nil
+}
+
+// containerMemoryLimit returns the memory limit imposed on the process by its
+// cgroup (v2 first, then v1), falling back to the total system memory. It
+// returns 0 when neither can be determined.
+func containerMemoryLimit() int64 {
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// "max" and the huge v1 sentinel both mean unlimited
+		if err == nil && n > 0 && n < 1<<60 {
+			return n
+		}
+	}
+
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var kb int64
+		if _, err := fmt.Sscanf(scanner.Text(), "MemTotal: %d kB", &kb); err == nil {
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// processRSS returns the resident set size of the process, falling back to
+// sys, the memory obtained from the OS by the Go runtime, when /proc is
+// missing.
+func processRSS(sys int64) int64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		var size, resident int64
+		if _, err := fmt.Sscanf(string(data), "%d %d", &size, &resident); err == nil {
+			return resident * int64(os.Getpagesize())
+		}
+	}
+	return sys
+}
+
+// tuneGC derives the Go runtime memory settings from maxmemory and the
+// container limit. GOMEMLIMIT is set to leave headroom below the container
+// limit (or to a quarter above maxmemory when that is lower), so the
+// collector works harder as the process approaches the limit instead of the
+// process being killed. With a limit in place GOGC is raised, trading memory
+// far from the limit for fewer collections. Explicit GOGC and GOMEMLIMIT
+// environment variables always win.
+func tuneGC() {
+	containerLimit.Store(containerMemoryLimit())
+	limit := int64(float64(containerLimit.Load()) * memoryHeadroom)
+	if max := maxmemory.Load(); max > 0 && (limit == 0 || max+max/4 < limit) {
+		limit = max + max/4
+	}
+	if limit <= 0 {
+		return
+	}
+
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(limit)
+	}
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(200)
+	}
+}
+
+// memoryWatchdog periodically compares memory usage with maxmemory and the
+// container limit. Crossing maxmemory rejects writes until usage drops;
+// getting close to the container limit additionally forces a collection
+// that returns freed memory to the OS. The heap is measured as the live
+// heap found by the last collection, read from runtime/metrics which
+// unlike runtime.ReadMemStats does not stop the world.
+func memoryWatchdog() {
+	samples := []metrics.Sample{
+		{Name: "/gc/heap/live:bytes"},
+		{Name: "/memory/classes/total:bytes"},
+	}
+	live := func() int64 { return int64(samples[0].Value.Uint64()) }
+	sys := func() int64 { return int64(samples[1].Value.Uint64()) }
+	for {
+		time.Sleep(memoryWatchdogInterval)
+
+		metrics.Read(samples)
+		max := maxmemory.Load()
+		reject := max > 0 && live() > max
+
+		hard := int64(float64(containerLimit.Load()) * memoryHeadroom)
+		if hard > 0 && processRSS(sys()) > hard {
+			debug.FreeOSMemory()
+			metrics.Read(samples)
+			if processRSS(sys()) > hard {
+				reject = true
+			}
+		}
+
+		if reject != oomReject.Load() {
+			if reject {
+				serverLog(logWarning, "memory limit reached, rejecting write commands")
+			} else {
+				serverLog(logNotice, "memory usage back under the limit, accepting write commands")
+			}
+			oomReject.Store(reject)
+		}
+	}
+}
+
+// memory handles the MEMORY command and its subcommands.
+func memory(args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'memory' command"}
+	}
+
+	switch strings.ToUpper(args[0].bulk) {
+	case "STATS":
+		if len(args) != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'memory|stats' command"}
+		}
+		return memoryStats()
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try MEMORY HELP."}
+	}
+}
+
+// memoryStats implements MEMORY STATS. Like Redis it replies with a flat
+// array of alternating names and values. Besides the Go heap figures it
+// reports how much memory interning and slab storage are using; for every
+// slab size class the fragmentation is the share of reserved slot bytes not
+// occupied by value data.
+func memoryStats() Value {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	keyspaceMu.RLock()
+	keys := len(keyspace)
+	keyspaceMu.RUnlock()
+
+	internStrings, internRefs := internStats()
+
+	values := []Value{}
+	add := func(name string, v Value) {
+		values = append(values, Value{typ: "bulk", bulk: name}, v)
+	}
+	num := func(n uint64) Value {
+		return Value{typ: "integer", num: int(n)}
+	}
+
+	add("total.allocated", num(ms.HeapAlloc))
+	add("maxmemory", num(uint64(maxmemory.Load())))
+	add("rss", num(uint64(processRSS(int64(ms.Sys)))))
+	add("gomemlimit", num(uint64(debug.SetMemoryLimit(-1))))
+	add("heap.objects", num(ms.HeapObjects))
+	add("gc.cycles", num(uint64(ms.NumGC)))
+	add("keys.count", num(uint64(keys)))
+	add("intern.strings", num(uint64(internStrings)))
+	add("intern.refs", num(uint64(internRefs)))
+
+	var reserved, used, requested int
+	classes := []Value{}
+	for _, c := range slabStats() {
+		reserved += c.pages * slabPageSize
+		used += c.used * c.size
+		requested += c.requested
+		classes = append(classes,
+			Value{typ: "bulk", bulk: "class." + strconv.Itoa(c.size)},
+			Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "pages"}, num(uint64(c.pages)),
+				{typ: "bulk", bulk: "slots.used"}, num(uint64(c.used)),
+				{typ: "bulk", bulk: "slots.free"}, num(uint64(c.free)),
+				{typ: "bulk", bulk: "fragmentation"}, {typ: "bulk", bulk: ratio(c.used*c.size-c.requested, c.used*c.size)},
+			}},
+		)
+	}
+
+	add("slab.enabled", num(boolToUint(slabEnabled)))
+	add("slab.reserved", num(uint64(reserved)))
+	add("slab.used", num(uint64(used)))
+	add("slab.requested", num(uint64(requested)))
+	add("slab.fragmentation", Value{typ: "bulk", bulk: ratio(used-requested, used)})
+	add("slab.classes", Value{typ: "array", array: classes})
+
+	return Value{typ: "array", array: values}
+}
+
+// ratio formats part/whole with two decimals, reporting 0 for an empty whole.
+func ratio(part, whole int) string {
+	if whole == 0 {
+		return "0.00"
+	}
+	return strconv.FormatFloat(float64(part)/float64(whole), 'f', 2, 64)
+}
+
+func boolToUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// monitorFeedSize is the number of lines buffered for a monitoring client.
+// A client that falls this far behind is disconnected rather than slowing
+// down every other client, like Redis does once the output buffer limit of
+// a monitor is reached.
+const monitorFeedSize = 10000
+
+// monitors is the set of clients that issued MONITOR.
+var monitors = map[*Client]bool{}
+
+// monitorsMu guards monitors.
+var monitorsMu = sync.RWMutex{}
+
+// monitor puts the connection into feed mode, where it receives every
+// command processed by the server.
+func monitor(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'monitor' command"}
+	}
+
+	monitorsMu.Lock()
+	if !c.monitor {
+		c.monitor = true
+		c.feed = make(chan string, monitorFeedSize)
+		monitors[c] = true
+		go c.writeFeed(c.feed)
+	}
+	monitorsMu.Unlock()
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// writeFeed sends queued MONITOR lines to the client until the feed is closed.
+func (c *Client) writeFeed(feed chan string) {
+	for line := range feed {
+		if err := c.Write(Value{typ: "string", str: line}); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// unregisterMonitor stops feeding c, if it is a monitor.
+func unregisterMonitor(c *Client) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	if c.monitor {
+		delete(monitors, c)
+		close(c.feed)
+		c.monitor = false
+	}
+}
+
+// feedMonitors sends the command issued by c to every monitoring client in
+// the Redis MONITOR format:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+func feedMonitors(c *Client, value Value) {
+	monitorsMu.RLock()
+	defer monitorsMu.RUnlock()
+
+	if len(monitors) == 0 {
+		return
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, c.addr)
+	for _, arg := range value.array {
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(arg.bulk))
+	}
+	line := b.String()
+
+	for m := range monitors {
+		select {
+		case m.feed <- line:
+		default:
+			// the monitor can not keep up, drop it
+			m.conn.Close()
+		}
+	}
+}
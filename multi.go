@@ -0,0 +1,292 @@
+// Transactions.
+//
+// MULTI starts a transaction on the connection, queuing the commands that
+// follow until EXEC runs them all at once, like in Redis:
+//
+//	MULTI
+//	EXEC
+//	DISCARD
+//
+// Every command is checked when it is queued, replying QUEUED. A command
+// that is unknown, called with the wrong number of arguments, refused in
+// strict mode or not allowed in a transaction gets its error right away
+// and makes EXEC discard the whole transaction with EXECABORT. Errors that
+// only show when a command runs, such as WRONGTYPE, are replied in their
+// place in the array EXEC replies, and the other commands run regardless;
+// there is no rollback. DISCARD drops the queued commands.
+//
+// EXEC runs the queue with no command of another client in between. Every
+// command holds a gate of its client while it runs, and EXEC closes all of
+// them and waits for the commands in progress to finish, so commands that
+// never meet a transaction only touch the counter of their own client.
+// Blocking commands in a transaction do not wait, replying as if they timed
+// out. The writes of a transaction are logged to the AOF between MULTI and
+// EXEC, and a transaction cut short by a crash is left out when the AOF is
+// loaded.
+package main
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transaction is the state of a client between MULTI and EXEC.
+type transaction struct {
+	// queue holds the commands to run on EXEC
+	queue []Value
+	// aborted is set once a command failed to queue, making EXEC fail
+	aborted bool
+}
+
+// transactionCommands run right away inside MULTI instead of being queued.
+var transactionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+}
+
+// transactionDenied lists the commands that can not be queued besides those
+// flagged no_multi. Their replies go through the message queue of the
+// client or turn the connection into a feed, neither of which fits in the
+// reply of EXEC.
+var transactionDenied = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+	"MONITOR":      true,
+}
+
+// commandGate counts the commands running for a client, or for the
+// background jobs that change the dataset, so that EXEC can wait for them.
+type commandGate struct {
+	running atomic.Int64
+}
+
+// backgroundGate is entered by the background jobs that change the dataset
+// or copy it, so they never run in the middle of a transaction.
+var backgroundGate commandGate
+
+// execPending is set while an EXEC waits for the commands in progress or
+// runs its queue, holding every new command back.
+var execPending atomic.Bool
+
+// execMu serialises EXECs. The commands held back wait on it.
+var execMu = sync.Mutex{}
+
+// enter marks a command as running, waiting first for the EXEC in progress
+// if any. Setting running before looking at execPending, while EXEC sets
+// execPending before looking at running, guarantees that either the command
+// waits or EXEC sees it.
+func (g *commandGate) enter() {
+	for {
+		g.running.Add(1)
+		if !execPending.Load() {
+			return
+		}
+		g.running.Add(-1)
+
+		execMu.Lock()
+		execMu.Unlock()
+	}
+}
+
+// leave marks the command as finished.
+func (g *commandGate) leave() {
+	g.running.Add(-1)
+}
+
+// drain waits for the commands running behind g to finish.
+func (g *commandGate) drain() {
+	for spins := 0; g.running.Load() > 0; spins++ {
+		// most commands are over in microseconds, the rare slow one is
+		// not worth burning a core on
+		if spins < 100 {
+			runtime.Gosched()
+		} else {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+}
+
+// stopCommands holds back the commands of every client but c, and the
+// background jobs, and waits for those in progress to finish.
+func stopCommands(c *Client) {
+	execMu.Lock()
+	execPending.Store(true)
+
+	// clients that connect from now on are held back by execPending
+	ClientsMu.RLock()
+	clients := make([]*Client, 0, len(Clients))
+	for _, other := range Clients {
+		if other != c {
+			clients = append(clients, other)
+		}
+	}
+	ClientsMu.RUnlock()
+
+	for _, other := range clients {
+		other.gate.drain()
+	}
+	backgroundGate.drain()
+}
+
+// resumeCommands lets the commands held back by stopCommands run again.
+func resumeCommands() {
+	execPending.Store(false)
+	execMu.Unlock()
+}
+
+// mayBlock reports whether a blocking command of c may wait, which it may
+// not inside a transaction, nor while the dataset is loaded.
+func (c *Client) mayBlock() bool {
+	return c != nil && c.multi == nil
+}
+
+// flagTransaction makes the transaction of c, if any, fail on EXEC because
+// a command could not be queued, and returns the error err of that command.
+func (c *Client) flagTransaction(err Value) Value {
+	if c.multi != nil {
+		c.multi.aborted = true
+	}
+	return err
+}
+
+// unknownCommandError is the reply to an unknown command inside MULTI.
+func unknownCommandError(value Value) Value {
+	var b strings.Builder
+	for _, arg := range value.array[1:] {
+		b.WriteString("'" + arg.bulk + "' ")
+	}
+	return Value{typ: "error", str: "ERR unknown command '" + value.array[0].bulk + "', with args beginning with: " + b.String()}
+}
+
+// queueCommand queues a command that passed the checks of processCommand
+// for the EXEC of c.
+func (c *Client) queueCommand(command string, value Value) Value {
+	for _, flag := range Commands[command].Flags {
+		if flag == "no_multi" {
+			return c.flagTransaction(Value{typ: "error", str: "ERR Command not allowed inside a transaction"})
+		}
+	}
+	if transactionDenied[command] {
+		return c.flagTransaction(Value{typ: "error", str: "ERR Command not allowed inside a transaction"})
+	}
+	if isWriteCommand(command) && oomReject.Load() {
+		return c.flagTransaction(oomError)
+	}
+
+	c.multi.queue = append(c.multi.queue, value)
+	return Value{typ: "string", str: "QUEUED"}
+}
+
+// multi handles MULTI, starting a transaction.
+func multi(c *Client, args []Value) Value {
+	if c.multi != nil {
+		return Value{typ: "error", str: "ERR MULTI calls can not be nested"}
+	}
+	c.multi = &transaction{}
+	return Value{typ: "string", str: "OK"}
+}
+
+// discard handles DISCARD, dropping the transaction and its queue.
+func discard(c *Client, args []Value) Value {
+	if c.multi == nil {
+		return Value{typ: "error", str: "ERR DISCARD without MULTI"}
+	}
+	c.multi = nil
+	return Value{typ: "string", str: "OK"}
+}
+
+// EXEC runs commands through the handler maps, so it is only added to them
+// once they are initialised.
+func init() {
+	// "EXEC": Runs the commands queued since MULTI
+	ClientHandlers["EXEC"] = execCommand
+}
+
+// execCommand handles EXEC, running the queued commands with no other
+// command in between and replying an array of their replies.
+func execCommand(c *Client, args []Value) Value {
+	t := c.multi
+	if t == nil {
+		return Value{typ: "error", str: "ERR EXEC without MULTI"}
+	}
+	if t.aborted {
+		c.multi = nil
+		return Value{typ: "error", str: "EXECABORT Transaction discarded because of previous errors."}
+	}
+
+	writes := false
+	for _, value := range t.queue {
+		if isWriteCommand(strings.ToUpper(value.array[0].bulk)) {
+			writes = true
+		}
+	}
+	// EXEC itself is no write, but a transaction that writes waits for a
+	// CLIENT PAUSE WRITE like its commands would have
+	if writes {
+		waitIfPaused(true)
+	}
+
+	stopCommands(c)
+	if writes && aof != nil {
+		aof.Write(commandValue("MULTI"))
+	}
+	replies := make([]Value, 0, len(t.queue))
+	for _, value := range t.queue {
+		replies = append(replies, executeCommand(c, strings.ToUpper(value.array[0].bulk), value))
+	}
+	if writes && aof != nil {
+		aof.Write(commandValue("EXEC"))
+	}
+	resumeCommands()
+
+	c.multi = nil
+	return Value{typ: "array", array: replies}
+}
+
+// transactionReplay replays logged commands, holding back the commands of
+// a transaction until its EXEC, so that a transaction cut short by a crash
+// is left out like Redis does when loading an AOF.
+type transactionReplay struct {
+	// open is set between MULTI and EXEC
+	open bool
+	// queue holds the commands of the open transaction
+	queue []Value
+}
+
+// replay replays value, or queues it inside a transaction.
+func (r *transactionReplay) replay(value Value) {
+	switch strings.ToUpper(value.array[0].bulk) {
+	case "MULTI":
+		r.open, r.queue = true, nil
+	case "EXEC":
+		for _, queued := range r.queue {
+			replayCommand(queued)
+		}
+		r.open, r.queue = false, nil
+	case "DISCARD":
+		r.open, r.queue = false, nil
+	default:
+		if r.open {
+			r.queue = append(r.queue, value)
+		} else {
+			replayCommand(value)
+		}
+	}
+}
+
+// finish drops a transaction left open at the end of the file and reports
+// whether there was one.
+func (r *transactionReplay) finish() bool {
+	open := r.open
+	if open {
+		serverLog(logWarning, "Discarding an incomplete MULTI/EXEC transaction of %d commands at the end of the file", len(r.queue))
+	}
+	r.open, r.queue = false, nil
+	return open
+}
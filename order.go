@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Replies listing the fields of a hash or the members of a set follow Go's
+// map iteration order, which changes from one call to the next. Tests
+// comparing replies against recorded snapshots need a stable order, so as a
+// gostore extension the reply-ordering setting can make these replies
+// lexicographically sorted:
+//
+//	reply-ordering none           map order, the fastest (default)
+//	reply-ordering lexicographic  sorted by field or member name
+//
+// Insertion order is not offered. A small hash stored as a listpack does
+// list its fields in the order they were added, but that order is gone once
+// the hash converts to a map, and an intset keeps its members sorted by
+// value. Keeping it for every hash and set would cost memory for an option
+// most deployments never enable.
+
+// sortedReplies is set when reply-ordering is lexicographic.
+var sortedReplies atomic.Bool
+
+// parseReplyOrdering parses a reply-ordering value.
+func parseReplyOrdering(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return false, nil
+	case "lexicographic":
+		return true, nil
+	}
+	return false, errors.New("argument must be 'none' or 'lexicographic'")
+}
+
+// formatReplyOrdering renders the reply-ordering setting.
+func formatReplyOrdering(sorted bool) string {
+	if sorted {
+		return "lexicographic"
+	}
+	return "none"
+}
+
+// fieldOrder returns the field names of a hash in the order replies list
+// them.
+func fieldOrder(fields *hashValue) []string {
+	names := make([]string, 0, fields.len())
+	fields.each(func(name, _ string) {
+		names = append(names, name)
+	})
+	return replyOrder(names)
+}
+
+// replyOrder puts names in the order replies list them, sorting them in
+// place when lexicographic ordering is enabled.
+func replyOrder(names []string) []string {
+	if sortedReplies.Load() {
+		sort.Strings(names)
+	}
+	return names
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// protectedMode refuses connections from other hosts while the server is
+// listening on every interface without a password, so that a store started
+// with the defaults is not accidentally left open to the network.
+var protectedMode atomic.Bool
+
+func init() {
+	protectedMode.Store(true)
+}
+
+// protectedError is sent to clients refused by protected mode.
+var protectedError = Value{typ: "error", str: "DENIED gostore is running in protected mode because protected mode is enabled, " +
+	"no bind address was specified and no password is set. In this mode connections are only accepted from the loopback interface. " +
+	"To allow other hosts to connect either set a password with 'CONFIG SET requirepass <password>' from a local client, " +
+	"start the server with an explicit -bind address or -requirepass, " +
+	"or disable protected mode with 'CONFIG SET protected-mode no' or -protected-mode no."}
+
+// protectedRefuses reports whether protected mode refuses conn. Only TCP
+// connections from non-loopback addresses are ever refused.
+func protectedRefuses(conn net.Conn) bool {
+	if !protectedMode.Load() || bind != "" || getRequirepass() != "" {
+		return false
+	}
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return !addr.IP.IsLoopback()
+}
@@ -0,0 +1,451 @@
+// Static sharding proxy.
+//
+// "gostore proxy" fronts several gostore or Redis servers and spreads the
+// keyspace over them with consistent hashing, for horizontal scale without
+// a cluster:
+//
+//	gostore proxy -port 6380 10.0.0.1:6379 10.0.0.2:6379 10.0.0.3:6379
+//
+// Commands on a single key go to the backend owning the key. MGET and MSET
+// are split into pipelined GETs and SETs on every backend involved, and DEL
+// and EXISTS into one call per backend whose counts are added up. Other
+// multi-key commands must have all their keys on one backend; a hash tag,
+// the part of a key between { and }, makes keys hash together. Pipelined
+// commands stay pipelined: a batch from a client is sent to the backends
+// at once before any reply is read.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// proxyVirtualNodes is the number of points every backend has on the hash
+// ring, which evens out how many keys each one owns.
+const proxyVirtualNodes = 160
+
+// hashRing maps keys to backends with consistent hashing, so that adding or
+// removing a backend only moves the keys of that backend.
+type hashRing struct {
+	backends []string
+	// points are the sorted positions on the ring and owners the index of
+	// the backend owning each of them
+	points []uint32
+	owners []int
+}
+
+// newHashRing places every backend on the ring.
+func newHashRing(backends []string) *hashRing {
+	r := &hashRing{backends: backends}
+
+	type point struct {
+		pos   uint32
+		owner int
+	}
+	points := []point{}
+	for i, b := range backends {
+		for v := 0; v < proxyVirtualNodes; v++ {
+			points = append(points, point{crc32.ChecksumIEEE([]byte(b + "#" + strconv.Itoa(v))), i})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].pos < points[j].pos })
+
+	for _, p := range points {
+		r.points = append(r.points, p.pos)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// hashTag returns the part of key used for hashing: the text between the
+// first { and the following }, if not empty, otherwise the whole key.
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+// owner returns the index of the backend owning key.
+func (r *hashRing) owner(key string) int {
+	h := crc32.ChecksumIEEE([]byte(hashTag(key)))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// proxyPart is a group of commands sent to one backend for one client
+// command.
+type proxyPart struct {
+	backend  int
+	commands []Value
+}
+
+// proxyPlan describes how a client command is executed: either answered by
+// the proxy itself, or sent as parts to the backends with the replies of
+// every part's commands, in order, combined into the reply to the client.
+type proxyPlan struct {
+	local   *Value
+	parts   []proxyPart
+	combine func(replies []Value) Value
+}
+
+// proxyBackend is the connection of a client to one backend.
+type proxyBackend struct {
+	conn   net.Conn
+	writer *bufio.Writer
+	reader *rESP
+	// err is set once the connection failed, the replies still expected
+	// from it are then answered with the error
+	err error
+}
+
+// runProxy runs the "gostore proxy" subcommand.
+func runProxy(args []string) {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	proxyBind := fs.String("bind", "", "address to listen on (all interfaces when empty)")
+	proxyPort := fs.Int("port", 6380, "TCP port to listen on")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s proxy [-bind addr] [-port port] backend [backend ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ring := newHashRing(fs.Args())
+
+	tsrv, err := net.Listen("tcp", net.JoinHostPort(*proxyBind, strconv.Itoa(*proxyPort)))
+	if err != nil {
+		serverLog(logWarning, "%v", err)
+		os.Exit(1)
+	}
+	serverLog(logNotice, "Proxy listening on port %d for %d backends", *proxyPort, len(ring.backends))
+
+	for {
+		conn, err := tsrv.Accept()
+		if err != nil {
+			serverLog(logWarning, "%v", err)
+			os.Exit(1)
+		}
+		go proxyClient(conn, ring)
+	}
+}
+
+// proxyClient serves one client of the proxy until it disconnects.
+func proxyClient(conn net.Conn, ring *hashRing) {
+	defer conn.Close()
+
+	backends := make([]*proxyBackend, len(ring.backends))
+	defer func() {
+		for _, b := range backends {
+			if b != nil && b.conn != nil {
+				b.conn.Close()
+			}
+		}
+	}()
+
+	reader := newrESP(conn)
+	for {
+		batch := []Value{}
+		for len(batch) == 0 || (len(batch) < pipelineMaxBatch && reader.reader.Buffered() > 0) {
+			value, err := reader.readCommand()
+			if err != nil {
+				return
+			}
+			batch = append(batch, value)
+		}
+
+		plans := make([]proxyPlan, len(batch))
+		for i, value := range batch {
+			plans[i] = planCommand(value, ring)
+		}
+
+		// send every part of the batch before reading any reply
+		used := map[int]bool{}
+		for _, plan := range plans {
+			for _, part := range plan.parts {
+				b := backends[part.backend]
+				if b == nil || b.err != nil {
+					if b != nil && b.conn != nil {
+						b.conn.Close()
+					}
+					b = dialBackend(ring.backends[part.backend])
+					backends[part.backend] = b
+				}
+				for _, command := range part.commands {
+					if b.err == nil {
+						_, b.err = b.writer.Write(command.Marshal())
+					}
+				}
+				used[part.backend] = true
+			}
+		}
+		for i := range used {
+			if b := backends[i]; b.err == nil {
+				b.err = b.writer.Flush()
+			}
+		}
+
+		replies := make([]Value, len(plans))
+		for i, plan := range plans {
+			if plan.local != nil {
+				replies[i] = *plan.local
+				continue
+			}
+			results := []Value{}
+			for _, part := range plan.parts {
+				b := backends[part.backend]
+				for range part.commands {
+					results = append(results, b.reply(ring.backends[part.backend]))
+				}
+			}
+			replies[i] = plan.combine(results)
+		}
+
+		var out []byte
+		for _, reply := range replies {
+			out = append(out, reply.Marshal()...)
+		}
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// dialBackend connects to a backend. A failed connection is returned with
+// its error set.
+func dialBackend(addr string) *proxyBackend {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return &proxyBackend{err: err}
+	}
+	return &proxyBackend{conn: conn, writer: bufio.NewWriter(conn), reader: newrESP(conn)}
+}
+
+// reply reads the next reply from the backend.
+func (b *proxyBackend) reply(addr string) Value {
+	if b.err == nil {
+		var v Value
+		v, b.err = b.reader.Read()
+		if b.err == nil {
+			return v
+		}
+	}
+	return Value{typ: "error", str: "ERR backend " + addr + " unavailable: " + b.err.Error()}
+}
+
+// planCommand works out how a client command is executed by the proxy.
+func planCommand(value Value, ring *hashRing) proxyPlan {
+	if value.typ != "array" || len(value.array) == 0 {
+		return localReply(Value{typ: "error", str: "ERR Protocol error"})
+	}
+	name := strings.ToUpper(value.array[0].bulk)
+	args := value.array[1:]
+
+	switch name {
+	case "PING":
+		if len(args) > 0 {
+			return localReply(Value{typ: "bulk", bulk: args[0].bulk})
+		}
+		return localReply(Value{typ: "string", str: "PONG"})
+	case "ECHO":
+		if len(args) != 1 {
+			return localReply(arityError(name))
+		}
+		return localReply(Value{typ: "bulk", bulk: args[0].bulk})
+	case "MGET":
+		if len(args) == 0 {
+			return localReply(arityError(name))
+		}
+		return planMget(args, ring)
+	case "MSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return localReply(arityError(name))
+		}
+		return planMset(args, ring)
+	case "DEL", "EXISTS", "UNLINK", "TOUCH":
+		if len(args) == 0 {
+			return localReply(arityError(name))
+		}
+		return planCount(name, args, ring)
+	}
+
+	// route by the key positions of known commands and by the first
+	// argument of commands only the backends know about
+	info, known := Commands[name]
+	first, last, step := 1, 1, 1
+	if known {
+		first, last, step = info.FirstKey, info.LastKey, info.Step
+	}
+	if known && info.NumKeys > 0 {
+		return planMovableKeys(name, value, ring)
+	}
+	if first <= 0 || len(args) < first {
+		return localReply(Value{typ: "error", str: "ERR '" + strings.ToLower(name) + "' is not supported in proxy mode"})
+	}
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+
+	backend := ring.owner(args[first-1].bulk)
+	for i := first; i <= last; i += step {
+		if ring.owner(args[i-1].bulk) != backend {
+			return localReply(Value{typ: "error", str: "CROSSSLOT Keys in request don't hash to the same backend"})
+		}
+	}
+
+	return proxyPlan{
+		parts:   []proxyPart{{backend: backend, commands: []Value{value}}},
+		combine: func(replies []Value) Value { return replies[0] },
+	}
+}
+
+// planMovableKeys routes a command whose keys follow a numkeys argument,
+// such as LMPOP, to the backend owning all of them.
+func planMovableKeys(name string, value Value, ring *hashRing) proxyPlan {
+	keys := commandKeys(name, value.array[1:])
+	if len(keys) == 0 {
+		return localReply(Value{typ: "error", str: "ERR '" + strings.ToLower(name) + "' is not supported in proxy mode without keys"})
+	}
+	backend := ring.owner(keys[0])
+	for _, key := range keys[1:] {
+		if ring.owner(key) != backend {
+			return localReply(Value{typ: "error", str: "CROSSSLOT Keys in request don't hash to the same backend"})
+		}
+	}
+	return proxyPlan{
+		parts:   []proxyPart{{backend: backend, commands: []Value{value}}},
+		combine: func(replies []Value) Value { return replies[0] },
+	}
+}
+
+// localReply returns a plan answered by the proxy itself.
+func localReply(v Value) proxyPlan {
+	return proxyPlan{local: &v}
+}
+
+// groupKeys groups the positions of keys by the backend owning them, in
+// the order backends are first seen.
+func groupKeys(keys []string, ring *hashRing) (backends []int, positions map[int][]int) {
+	positions = map[int][]int{}
+	for i, key := range keys {
+		b := ring.owner(key)
+		if _, ok := positions[b]; !ok {
+			backends = append(backends, b)
+		}
+		positions[b] = append(positions[b], i)
+	}
+	return backends, positions
+}
+
+// planMget splits MGET into pipelined GETs per backend.
+func planMget(args []Value, ring *hashRing) proxyPlan {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+	backends, positions := groupKeys(keys, ring)
+
+	plan := proxyPlan{}
+	order := []int{}
+	for _, b := range backends {
+		part := proxyPart{backend: b}
+		for _, i := range positions[b] {
+			part.commands = append(part.commands, commandValue("GET", keys[i]))
+			order = append(order, i)
+		}
+		plan.parts = append(plan.parts, part)
+	}
+	plan.combine = func(replies []Value) Value {
+		values := make([]Value, len(keys))
+		for j, i := range order {
+			values[i] = replies[j]
+			// MGET answers null for keys that are not strings
+			if values[i].typ == "error" && strings.HasPrefix(values[i].str, "WRONGTYPE") {
+				values[i] = Value{typ: "null"}
+			}
+		}
+		return Value{typ: "array", array: values}
+	}
+	return plan
+}
+
+// planMset splits MSET into pipelined SETs per backend.
+func planMset(args []Value, ring *hashRing) proxyPlan {
+	keys := []string{}
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i].bulk)
+	}
+	backends, positions := groupKeys(keys, ring)
+
+	plan := proxyPlan{}
+	for _, b := range backends {
+		part := proxyPart{backend: b}
+		for _, i := range positions[b] {
+			part.commands = append(part.commands, commandValue("SET", args[2*i].bulk, args[2*i+1].bulk))
+		}
+		plan.parts = append(plan.parts, part)
+	}
+	plan.combine = firstError(Value{typ: "string", str: "OK"})
+	return plan
+}
+
+// planCount sends a multi-key command counting keys, such as DEL, to every
+// backend owning some of the keys and adds the counts up.
+func planCount(name string, args []Value, ring *hashRing) proxyPlan {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+	backends, positions := groupKeys(keys, ring)
+
+	plan := proxyPlan{}
+	for _, b := range backends {
+		command := []string{name}
+		for _, i := range positions[b] {
+			command = append(command, keys[i])
+		}
+		plan.parts = append(plan.parts, proxyPart{backend: b, commands: []Value{commandValue(command...)}})
+	}
+	plan.combine = func(replies []Value) Value {
+		total := 0
+		for _, reply := range replies {
+			if reply.typ == "error" {
+				return reply
+			}
+			total += reply.num
+		}
+		return Value{typ: "integer", num: total}
+	}
+	return plan
+}
+
+// firstError returns a combine function replying with the first error
+// among the replies, or ok when there is none.
+func firstError(ok Value) func(replies []Value) Value {
+	return func(replies []Value) Value {
+		for _, reply := range replies {
+			if reply.typ == "error" {
+				return reply
+			}
+		}
+		return ok
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// pubsubChannels maps every channel with subscribers to its subscribers.
+var pubsubChannels = map[string]map[*Client]bool{}
+
+// pubsubShardChannels maps every shard channel with subscribers to its
+// subscribers. Shard channels, used by SSUBSCRIBE and SPUBLISH, are a
+// namespace of their own: a message published with SPUBLISH only reaches
+// the SSUBSCRIBE subscribers of the channel. In a Redis Cluster they are
+// served by the node owning the slot of the channel; with a single node
+// they behave like regular channels.
+var pubsubShardChannels = map[string]map[*Client]bool{}
+
+// pubsubMu guards pubsubChannels, pubsubShardChannels and the
+// subscriptions of every client.
+var pubsubMu = sync.RWMutex{}
+
+// pubsubQueueSize is how many messages may be waiting for a subscriber
+// before it is considered too slow and disconnected.
+const pubsubQueueSize = 1024
+
+// pubsubAllowed lists the commands a client may still run once it has
+// subscribed to a channel.
+var pubsubAllowed = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// subscribed reports whether c is subscribed to at least one channel or
+// shard channel.
+func (c *Client) subscribed() bool {
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	return len(c.subscriptions)+len(c.shardSubscriptions) > 0
+}
+
+// pubsubNamespace returns the subscribers of every channel and the
+// subscriptions of c, of the shard channels when shard is set. It must be
+// called with pubsubMu held.
+func (c *Client) pubsubNamespace(shard bool) (map[string]map[*Client]bool, map[string]bool) {
+	if shard {
+		return pubsubShardChannels, c.shardSubscriptions
+	}
+	return pubsubChannels, c.subscriptions
+}
+
+// pushMessage queues v for the client, disconnecting it when it does not
+// keep up. A client speaking RESP3 gets it as a push message, which it can
+// tell apart from the replies to its commands. It must be called with
+// pubsubMu held.
+func (c *Client) pushMessage(v Value) {
+	if v.typ == "array" && c.protocol() == 3 {
+		v.typ = "push"
+	}
+	select {
+	case c.messages <- v:
+	default:
+		c.conn.Close()
+	}
+}
+
+// writeMessages sends queued pub/sub messages to the client until the
+// queue is closed.
+func (c *Client) writeMessages(messages chan Value) {
+	for v := range messages {
+		if err := c.Write(v); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// subscribe handles SUBSCRIBE channel [channel ...].
+func subscribe(c *Client, args []Value) Value {
+	return subscribeChannels(c, args, false)
+}
+
+// ssubscribe handles SSUBSCRIBE shardchannel [shardchannel ...].
+func ssubscribe(c *Client, args []Value) Value {
+	return subscribeChannels(c, args, true)
+}
+
+// subscribeChannels subscribes c to channels, or to shard channels when
+// shard is set. Every subscription is confirmed with its own reply, which
+// is sent through the message queue so that it can not overtake messages
+// already queued.
+func subscribeChannels(c *Client, args []Value, shard bool) Value {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	kind := "subscribe"
+	if shard {
+		kind = "ssubscribe"
+	}
+	for _, arg := range args {
+		channel := arg.bulk
+		c.join(channel, shard)
+		_, subscriptions := c.pubsubNamespace(shard)
+		c.pushMessage(Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: kind},
+			{typ: "bulk", bulk: channel},
+			{typ: "integer", num: len(subscriptions)},
+		}})
+	}
+
+	return Value{}
+}
+
+// unsubscribe handles UNSUBSCRIBE [channel ...], leaving every channel when
+// none is given.
+func unsubscribe(c *Client, args []Value) Value {
+	return unsubscribeChannels(c, args, false)
+}
+
+// sunsubscribe handles SUNSUBSCRIBE [shardchannel ...], leaving every shard
+// channel when none is given.
+func sunsubscribe(c *Client, args []Value) Value {
+	return unsubscribeChannels(c, args, true)
+}
+
+// unsubscribeChannels unsubscribes c from channels, or from shard channels
+// when shard is set, and from all of them when none is given.
+func unsubscribeChannels(c *Client, args []Value, shard bool) Value {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	kind := "unsubscribe"
+	if shard {
+		kind = "sunsubscribe"
+	}
+	channels := []string{}
+	for _, arg := range args {
+		channels = append(channels, arg.bulk)
+	}
+	if len(args) == 0 {
+		_, subscriptions := c.pubsubNamespace(shard)
+		for channel := range subscriptions {
+			channels = append(channels, channel)
+		}
+	}
+
+	// without any subscription there is no queue to go through
+	if c.messages == nil || len(channels) == 0 {
+		var channel Value
+		if len(channels) == 0 {
+			channel = Value{typ: "null"}
+		} else {
+			channel = Value{typ: "bulk", bulk: channels[0]}
+		}
+		reply := Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: kind}, channel, {typ: "integer", num: 0},
+		}}
+		if c.protocol() == 3 {
+			reply.typ = "push"
+		}
+		return reply
+	}
+
+	for _, channel := range channels {
+		c.leave(channel, shard)
+		_, subscriptions := c.pubsubNamespace(shard)
+		c.pushMessage(Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: kind},
+			{typ: "bulk", bulk: channel},
+			{typ: "integer", num: len(subscriptions)},
+		}})
+	}
+
+	return Value{}
+}
+
+// startMessages starts the message queue of c unless it is running
+// already, and reports whether messages can be queued, which they can not
+// once c disconnected. It must be called with pubsubMu write-locked.
+func (c *Client) startMessages() bool {
+	if c.closed {
+		return false
+	}
+	if c.messages == nil {
+		c.messages = make(chan Value, pubsubQueueSize)
+		go c.writeMessages(c.messages)
+	}
+	return true
+}
+
+// join subscribes c to channel, a shard channel when shard is set,
+// starting its message queue on the first subscription. It must be called
+// with pubsubMu held.
+func (c *Client) join(channel string, shard bool) {
+	c.startMessages()
+	if c.subscriptions == nil {
+		c.subscriptions = map[string]bool{}
+		c.shardSubscriptions = map[string]bool{}
+	}
+
+	channels, subscriptions := c.pubsubNamespace(shard)
+	if !subscriptions[channel] {
+		subscriptions[channel] = true
+		if channels[channel] == nil {
+			channels[channel] = map[*Client]bool{}
+		}
+		channels[channel][c] = true
+	}
+}
+
+// leave removes the subscription of c to channel, a shard channel when
+// shard is set. It must be called with pubsubMu held.
+func (c *Client) leave(channel string, shard bool) {
+	channels, subscriptions := c.pubsubNamespace(shard)
+	delete(subscriptions, channel)
+	delete(channels[channel], c)
+	if len(channels[channel]) == 0 {
+		delete(channels, channel)
+	}
+}
+
+// unregisterSubscriber drops every subscription of c when it disconnects.
+func unregisterSubscriber(c *Client) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	c.closed = true
+	if c.messages == nil {
+		return
+	}
+	for channel := range c.subscriptions {
+		c.leave(channel, false)
+	}
+	for channel := range c.shardSubscriptions {
+		c.leave(channel, true)
+	}
+	close(c.messages)
+	c.messages = nil
+}
+
+// publishMessage delivers message to every subscriber of channel, a shard
+// channel when shard is set, and returns how many received it.
+func publishMessage(channel, message string, shard bool) int {
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	kind, channels := "message", pubsubChannels
+	if shard {
+		kind, channels = "smessage", pubsubShardChannels
+	}
+	v := Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: kind},
+		{typ: "bulk", bulk: channel},
+		{typ: "bulk", bulk: message},
+	}}
+	for c := range channels[channel] {
+		c.pushMessage(v)
+	}
+
+	return len(channels[channel])
+}
+
+// publish handles PUBLISH channel message.
+func publish(args []Value) Value {
+	return Value{typ: "integer", num: publishMessage(args[0].bulk, args[1].bulk, false)}
+}
+
+// spublish handles SPUBLISH shardchannel message.
+func spublish(args []Value) Value {
+	return Value{typ: "integer", num: publishMessage(args[0].bulk, args[1].bulk, true)}
+}
+
+// pubsubCommand handles PUBSUB CHANNELS [pattern], NUMSUB [channel ...],
+// NUMPAT, SHARDCHANNELS [pattern] and SHARDNUMSUB [shardchannel ...].
+// Channels are listed in order and, since there are no pattern
+// subscriptions, NUMPAT is always 0.
+func pubsubCommand(args []Value) Value {
+	sub := strings.ToUpper(args[0].bulk)
+
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	all := pubsubChannels
+	if strings.HasPrefix(sub, "SHARD") {
+		all = pubsubShardChannels
+	}
+	switch sub {
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 2 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'pubsub|" + strings.ToLower(sub) + "' command"}
+		}
+		channels := []string{}
+		for channel := range all {
+			if len(args) == 1 || matchGlob(args[1].bulk, channel, false) {
+				channels = append(channels, channel)
+			}
+		}
+		slices.Sort(channels)
+		reply := Value{typ: "array", array: make([]Value, 0, len(channels))}
+		for _, channel := range channels {
+			reply.array = append(reply.array, Value{typ: "bulk", bulk: channel})
+		}
+		return reply
+	case "NUMSUB", "SHARDNUMSUB":
+		reply := Value{typ: "array", array: make([]Value, 0, 2*(len(args)-1))}
+		for _, arg := range args[1:] {
+			reply.array = append(reply.array,
+				Value{typ: "bulk", bulk: arg.bulk},
+				Value{typ: "integer", num: len(all[arg.bulk])})
+		}
+		return reply
+	case "NUMPAT":
+		if len(args) > 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'pubsub|numpat' command"}
+		}
+		return Value{typ: "integer", num: 0}
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try PUBSUB HELP."}
+	}
+}
+
+// pubsubError is returned for commands a subscribed client may not run.
+func pubsubError(command string) Value {
+	return Value{typ: "error", str: "ERR Can't execute '" + strings.ToLower(command) +
+		"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"}
+}
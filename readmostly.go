@@ -0,0 +1,114 @@
+// Read-mostly mode.
+//
+// Under read-heavy load every GET takes keyspaceMu.RLock, and although readers
+// do not exclude each other they all write to the same lock word, which turns
+// the lock into a point of contention across cores. In read-mostly mode GET is
+// served from an immutable copy of the strings in the keyspace published
+// through an atomic pointer, so the hot path takes no lock at all.
+//
+// Writers keep updating the keyspace under keyspaceMu and bump
+// keyspaceVersion. A snapshot is only used while its version matches
+// keyspaceVersion, so readers never observe
+// stale data: after a write they fall back to the locked path until the
+// builder has published a fresh copy. The builder waits a short moment after
+// the first change to fold a whole batch of mutations into a single copy.
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// readMostly enables serving GET from snapshots of the keyspace.
+var readMostly atomic.Bool
+
+// keyspaceVersion counts the changes made to strings and the keys created or
+// removed in the keyspace. It is only incremented with keyspaceMu held for
+// writing.
+var keyspaceVersion atomic.Uint64
+
+// stringsSnapshot is an immutable copy of the keyspace as of version. Strings
+// are copied with their values, other keys only with their type so that GET
+// can still reply WRONGTYPE for them.
+type stringsSnapshot struct {
+	version uint64
+	m       map[string]object
+}
+
+// keyspaceSnapshot is the most recent snapshot, nil when none has been built.
+var keyspaceSnapshot atomic.Pointer[stringsSnapshot]
+
+// snapshotRebuild wakes the snapshot builder. It holds at most one pending
+// request, further changes are picked up by that rebuild.
+var snapshotRebuild = make(chan struct{}, 1)
+
+// snapshotBatchDelay is how long the builder waits for more changes before
+// copying the keyspace.
+const snapshotBatchDelay = 10 * time.Millisecond
+
+// markKeyspaceChanged must be called with keyspaceMu held for writing after
+// every change to a string and whenever a key is created or removed. It
+// invalidates the current snapshot and schedules a new one.
+func markKeyspaceChanged() {
+	keyspaceVersion.Add(1)
+	if !readMostly.Load() {
+		return
+	}
+	select {
+	case snapshotRebuild <- struct{}{}:
+	default:
+	}
+}
+
+// snapshotGet looks key up in the current snapshot without taking any lock.
+// served is false when there is no up to date snapshot and the caller has to
+// use the locked path instead.
+func snapshotGet(key string) (obj object, ok bool, served bool) {
+	snap := keyspaceSnapshot.Load()
+	if snap == nil || snap.version != keyspaceVersion.Load() {
+		return object{}, false, false
+	}
+	obj, ok = snap.m[key]
+	return obj, ok, true
+}
+
+// setReadMostly switches read-mostly mode on or off. Switching it on builds
+// the first snapshot right away, switching it off drops the snapshot.
+func setReadMostly(on bool) {
+	readMostly.Store(on)
+	if on {
+		select {
+		case snapshotRebuild <- struct{}{}:
+		default:
+		}
+	} else {
+		keyspaceSnapshot.Store(nil)
+	}
+}
+
+// snapshotBuilder publishes a new snapshot of the keyspace after each batch of
+// changes while read-mostly mode is on.
+func snapshotBuilder() {
+	for range snapshotRebuild {
+		time.Sleep(snapshotBatchDelay)
+		if !readMostly.Load() {
+			continue
+		}
+
+		keyspaceMu.RLock()
+		m := make(map[string]object, len(keyspace))
+		for k, obj := range keyspace {
+			if obj.typ != stringObject {
+				m[k] = object{typ: obj.typ}
+				continue
+			}
+			// slab slots can be reused once the lock is released, the
+			// snapshot needs copies that outlive them
+			m[k] = object{typ: stringObject, str: slabLoad(obj.str)}
+		}
+		snap := &stringsSnapshot{version: keyspaceVersion.Load(), m: m}
+		keyspaceMu.RUnlock()
+
+		keyspaceSnapshot.Store(snap)
+	}
+}
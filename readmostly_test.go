@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchmarkKeys is the number of strings GET is benchmarked against.
+const benchmarkKeys = 10000
+
+// startSnapshotBuilder starts the snapshot builder once for all benchmarks.
+var startSnapshotBuilder = sync.OnceFunc(func() { go snapshotBuilder() })
+
+// BenchmarkGet compares GET served from snapshots in read-mostly mode with
+// GET under keyspaceMu, with readers on every core and a writer changing a
+// key now and then. Writes more frequent than snapshotBatchDelay keep the
+// snapshot stale, sending most reads down the locked path anyway.
+func BenchmarkGet(b *testing.B) {
+	startSnapshotBuilder()
+	keyspaceMu.Lock()
+	clear(keyspace)
+	keyspaceMu.Unlock()
+	for i := 0; i < benchmarkKeys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		set([]Value{{typ: "bulk", bulk: key}, {typ: "bulk", bulk: "value"}})
+	}
+
+	for _, mode := range []struct {
+		name       string
+		readMostly bool
+		writeEvery time.Duration
+	}{
+		{"locked/write-every-1ms", false, time.Millisecond},
+		{"locked/write-every-100ms", false, 100 * time.Millisecond},
+		{"read-mostly/write-every-1ms", true, time.Millisecond},
+		{"read-mostly/write-every-100ms", true, 100 * time.Millisecond},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			setReadMostly(mode.readMostly)
+			defer setReadMostly(false)
+			// let the first snapshot be built
+			time.Sleep(2 * snapshotBatchDelay)
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(mode.writeEvery)
+				defer ticker.Stop()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					case <-ticker.C:
+					}
+					set([]Value{{typ: "bulk", bulk: "key:" + strconv.Itoa(i%benchmarkKeys)}, {typ: "bulk", bulk: strconv.Itoa(i)}})
+				}
+			}()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				args := make([]Value, 1)
+				for i := 0; pb.Next(); i++ {
+					args[0] = Value{typ: "bulk", bulk: "key:" + strconv.Itoa(i%benchmarkKeys)}
+					get(args)
+				}
+			})
+			b.StopTimer()
+
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Background map shrinking.
+//
+// Go maps never give memory back: once a map has grown to hold a million keys
+// its buckets stay allocated even after every key is deleted. After a flush
+// or a mass expiry the server would keep the memory of its largest ever
+// keyspace. The active rehash loop watches the keyspace maps and rebuilds a
+// map at its current size when it has dropped well below the size it peaked
+// at, letting the old buckets be collected.
+//
+// A big keyspace takes a while to copy, so the copy is made a batch of keys
+// at a time, letting clients in between batches. The keys they write in the
+// meantime are collected in rehashDirty and copied again, or dropped, when
+// the new map is swapped in.
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rehashInterval is how often the keyspace maps are checked.
+const rehashInterval = time.Second
+
+// rehashMinPeak is the smallest peak size worth rebuilding a map for.
+const rehashMinPeak = 1024
+
+// rehashBatch is how many keys are copied between two chances for clients
+// to take keyspaceMu.
+const rehashBatch = 1024
+
+// rehashShrinkFactor is how far a map has to fall below its peak before it is
+// rebuilt; 4 means it is rebuilt once it holds less than a quarter of it.
+const rehashShrinkFactor = 4
+
+// rehashStats counts the work done by the active rehash loop for INFO memory.
+var rehashStats struct {
+	// running is 1 while a map is being rebuilt
+	running atomic.Int64
+	// maps is the number of maps rebuilt so far
+	maps atomic.Int64
+	// entries is the number of entries copied into rebuilt maps
+	entries atomic.Int64
+}
+
+// shrinkable is a keyspace map the active rehash loop looks after.
+type shrinkable struct {
+	mu *rwLock
+	// size returns the current number of entries, called with mu held
+	size func() int
+	// rebuild replaces the map by a freshly allocated copy, called with mu
+	// held. It may release mu for a while in between and reports false when
+	// it had to give up because the map was replaced meanwhile.
+	rebuild func() bool
+	// peak is the largest size seen since the last rebuild
+	peak int
+}
+
+// shrinkables lists the maps watched by the active rehash loop.
+var shrinkables = []*shrinkable{
+	{
+		mu:   &keyspaceMu,
+		size: func() int { return len(keyspace) },
+		rebuild: func() bool {
+			m := make(map[string]object, len(keyspace))
+			rehashDirty = map[string]struct{}{}
+			n := 0
+			// ranging over a map that is written to in between is fine as
+			// long as it is not at the same time; keys written meanwhile
+			// may or may not be seen and are in rehashDirty either way
+			for k, v := range keyspace {
+				m[k] = v
+				if n++; n%rehashBatch == 0 {
+					keyspaceMu.Unlock()
+					keyspaceMu.Lock()
+					if rehashDirty == nil {
+						return false
+					}
+				}
+			}
+			for k := range rehashDirty {
+				if v, ok := keyspace[k]; ok {
+					m[k] = v
+				} else {
+					delete(m, k)
+				}
+			}
+			rehashDirty = nil
+			keyspace = m
+			return true
+		},
+	},
+}
+
+// rehashDirty collects the keys written while the keyspace map is being
+// rebuilt, and is nil when no rebuild is running. It is guarded by
+// keyspaceMu.
+var rehashDirty map[string]struct{}
+
+// rehashTouch records that key was written, for a rebuild of the keyspace
+// map in progress. It must be called with keyspaceMu held for writing.
+func rehashTouch(key string) {
+	if rehashDirty != nil {
+		rehashDirty[key] = struct{}{}
+	}
+}
+
+// rehashAbandon makes a rebuild of the keyspace map in progress give up,
+// for when the map is replaced as a whole. It must be called with
+// keyspaceMu held for writing.
+func rehashAbandon() {
+	rehashDirty = nil
+}
+
+// activeRehash runs forever, shrinking keyspace maps that dropped far below
+// their peak size.
+func activeRehash() {
+	for {
+		time.Sleep(rehashInterval)
+
+		for _, s := range shrinkables {
+			s.mu.Lock()
+			size := s.size()
+			if size > s.peak {
+				s.peak = size
+			}
+			if s.peak >= rehashMinPeak && size*rehashShrinkFactor < s.peak {
+				rehashStats.running.Store(1)
+				if s.rebuild() {
+					rehashStats.maps.Add(1)
+					rehashStats.entries.Add(int64(size))
+				}
+				rehashStats.running.Store(0)
+				s.peak = s.size()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -25,8 +26,24 @@ const (
 	//ARRAY ('*'): This represents an array. It's used to return a list of elements,
 	//like "*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n".
 	ARRAY = '*'
+	//PUSH ('>'): This represents an out-of-band message of RESP3, such as a
+	//pub/sub message or a client-side caching invalidation. It's laid out like
+	//an array, like ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n".
+	PUSH = '>'
+	//MAP ('%'): This represents a RESP3 map, followed by the number of
+	//field-value pairs, like "%1\r\n$5\r\nproto\r\n:3\r\n".
+	MAP = '%'
 )
 
+// protoMaxBulkLen caps the length of a bulk string sent by a client to
+// 512 MB, the default proto-max-bulk-len of Redis, so that a forged length
+// can not make the server allocate more than that, or a negative size.
+const protoMaxBulkLen = 512 * 1024 * 1024
+
+// errInvalidBulkLength is returned for a bulk length that is not a number
+// or exceeds protoMaxBulkLen.
+var errInvalidBulkLength = errors.New("Protocol error: invalid bulk length")
+
 // define struct for Values for parsing and represing Redis protocol in GO
 type Value struct {
 	//data type for value
@@ -37,7 +54,8 @@ type Value struct {
 	num int
 	//store strings from bulk strings
 	bulk string
-	//holds values from arrays
+	//holds values from arrays, push messages, and the alternating fields
+	//and values of maps
 	array []Value
 }
 
@@ -126,6 +144,17 @@ func (r *rESP) Read() (Value, error) {
 	//check if byte is bulk
 	case BULK:
 		return r.readBulk()
+	//simple strings, errors and integers only appear in replies, which
+	//are read when talking to another server
+	case STRING, ERROR:
+		line, _, err := r.readLine()
+		if _type == ERROR {
+			return Value{typ: "error", str: string(line)}, err
+		}
+		return Value{typ: "string", str: string(line)}, err
+	case INTEGER:
+		n, _, err := r.readInteger()
+		return Value{typ: "integer", num: n}, err
 	//byte is neither
 	default:
 		fmt.Printf("Unknown type: %v", string(_type))
@@ -133,6 +162,57 @@ func (r *rESP) Read() (Value, error) {
 	}
 }
 
+// readCommand reads the next command sent by a client. Most traffic consists
+// of GET, SET and PING, which arrive as an array of one to three bulk
+// strings. When the buffered bytes start with such an array header, the
+// command is decoded directly into a single slice of exactly the right size,
+// skipping the recursive Read() and its per-element allocations. Anything
+// else goes through the generic Read().
+func (r *rESP) readCommand() (Value, error) {
+	header, err := r.reader.Peek(4)
+	if err != nil || header[0] != ARRAY || header[1] < '1' || header[1] > '3' || header[2] != '\r' || header[3] != '\n' {
+		return r.Read()
+	}
+	r.reader.Discard(4)
+
+	n := int(header[1] - '0')
+	v := Value{typ: "array", array: make([]Value, n)}
+	for i := 0; i < n; i++ {
+		// every element must be a bulk string "$<len>\r\n<data>\r\n"
+		line, err := r.reader.ReadSlice('\n')
+		if err != nil {
+			return v, err
+		}
+		if len(line) < 4 || line[0] != BULK || line[len(line)-2] != '\r' {
+			return v, fmt.Errorf("Protocol error: expected '$', got '%c'", line[0])
+		}
+		// 512 MB has 9 digits, longer lengths are rejected before they
+		// can overflow
+		digits := line[1 : len(line)-2]
+		if len(digits) > 9 {
+			return v, errInvalidBulkLength
+		}
+		size := 0
+		for _, c := range digits {
+			if c < '0' || c > '9' {
+				return v, errInvalidBulkLength
+			}
+			size = size*10 + int(c-'0')
+		}
+		if size > protoMaxBulkLen {
+			return v, errInvalidBulkLength
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r.reader, data); err != nil {
+			return v, err
+		}
+		v.array[i] = Value{typ: "bulk", bulk: string(data[:size])}
+	}
+
+	return v, nil
+}
+
 // func to read array  from input stream recevied
 // from redis-cli it is bound to a pointer to rESP struct
 func (r *rESP) readArray() (Value, error) {
@@ -144,6 +224,10 @@ func (r *rESP) readArray() (Value, error) {
 	if err != nil {
 		return v, err
 	}
+	// a length of -1 is a null array
+	if len < 0 {
+		return Value{typ: "nullarray"}, nil
+	}
 	// for each line, parse and read the value
 	v.array = make([]Value, 0)
 	// loop continues till array length reached
@@ -170,10 +254,19 @@ func (r *rESP) readBulk() (Value, error) {
 	if err != nil {
 		return v, err
 	}
+	// a length of -1 is a null bulk string
+	if len < 0 {
+		return Value{typ: "null"}, nil
+	}
+	if len > protoMaxBulkLen {
+		return v, errInvalidBulkLength
+	}
 	// create  byte slice to hold bulk string
 	bulk := make([]byte, len)
-	// parse bulk
-	r.reader.Read(bulk)
+	// parse bulk, a large string may take several reads to arrive
+	if _, err := io.ReadFull(r.reader, bulk); err != nil {
+		return v, err
+	}
 	v.bulk = string(bulk)
 	// Read the trailing CRLF
 	r.readLine()
@@ -190,12 +283,20 @@ func (v Value) Marshal() []byte {
 	switch v.typ {
 	case "array":
 		return v.marshalArray()
+	case "push":
+		return v.marshalPush()
+	case "map":
+		return v.marshalMap()
 	case "bulk":
 		return v.marshalBulk()
 	case "string":
 		return v.marshalString()
+	case "integer":
+		return v.marshalInteger()
 	case "null":
 		return v.marshallNull()
+	case "nullarray":
+		return v.marshallNullArray()
 	case "error":
 		return v.marshallError()
 	default:
@@ -223,6 +324,19 @@ func (v Value) marshalString() []byte {
 	return bytes
 }
 
+// func to marshalInteger for integer replies
+// for the Value type
+func (v Value) marshalInteger() []byte {
+	var bytes []byte
+	// In the RESP protocol, an integer is prefixed with a :
+	// character (assuming INTEGER is a constant representing this)
+	bytes = append(bytes, INTEGER)
+	bytes = append(bytes, strconv.Itoa(v.num)...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
 func (v Value) marshalBulk() []byte {
 	var bytes []byte
 	//Appends the BULK identifier to the bytes slice.
@@ -261,6 +375,32 @@ func (v Value) marshalArray() []byte {
 	return bytes
 }
 
+// marshalPush converts a RESP3 push message, whose elements are in array,
+// like an array with the PUSH identifier.
+func (v Value) marshalPush() []byte {
+	bytes := []byte{PUSH}
+	bytes = append(bytes, strconv.Itoa(len(v.array))...)
+	bytes = append(bytes, '\r', '\n')
+	for _, e := range v.array {
+		bytes = append(bytes, e.Marshal()...)
+	}
+
+	return bytes
+}
+
+// marshalMap converts a RESP3 map. Its fields and values alternate in
+// array, so the number of pairs sent is half the number of elements.
+func (v Value) marshalMap() []byte {
+	bytes := []byte{MAP}
+	bytes = append(bytes, strconv.Itoa(len(v.array)/2)...)
+	bytes = append(bytes, '\r', '\n')
+	for _, e := range v.array {
+		bytes = append(bytes, e.Marshal()...)
+	}
+
+	return bytes
+}
+
 // marshallError converts the Value representing an error message
 // to its RESP (Redis Serialization Protocol) representation as a byte slice.
 // It prefixes the error message with the ERROR identifier and terminates
@@ -286,6 +426,12 @@ func (v Value) marshallNull() []byte {
 	return []byte("$-1\r\n")
 }
 
+// marshallNullArray returns the null array, the reply of a blocking
+// command that timed out.
+func (v Value) marshallNullArray() []byte {
+	return []byte("*-1\r\n")
+}
+
 // Writer properties
 // create a wrtier struct to take io.writer
 type Writer struct {
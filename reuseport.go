@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net"
+	"runtime"
+)
+
+// reusePort opens one listening socket per CPU for every bind address with
+// SO_REUSEPORT, each with its own accept loop, so that the kernel spreads
+// incoming connections across them and connection handling scales across
+// cores for workloads with a high connection rate.
+var reusePort = false
+
+// listen opens the listeners for address, several of them when reusePort
+// is enabled.
+func listen(address string) ([]net.Listener, error) {
+	if !reusePort {
+		tsrv, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{tsrv}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	listeners := []net.Listener{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		tsrv, err := lc.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, tsrv)
+	}
+	return listeners, nil
+}
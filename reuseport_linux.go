@@ -0,0 +1,5 @@
+package main
+
+// soReusePort is the SO_REUSEPORT socket option, which the syscall package
+// does not define for Linux.
+const soReusePort = 0xf
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SCAN iterates the keyspace a few keys per call:
+//
+//	SCAN cursor [MATCH pattern] [COUNT count] [TYPE type] [WITHACCESS]
+//
+// Go maps can not resume an iteration between calls, so SCAN 0 takes a
+// snapshot of the key names, which only copies the string headers and is
+// much cheaper than building a KEYS reply, and the cursor then pages
+// through it. The cursor encodes the snapshot id in its upper 32 bits and
+// the position in the lower 32 bits. Like in Redis every key present for
+// the whole iteration is returned, keys deleted in the meantime are
+// skipped and keys added after SCAN 0 may or may not be returned.
+//
+// WITHACCESS is a gostore extension: every key is followed by its last
+// read and last write time as in OBJECT LASTACCESS, so clean-up jobs can
+// find idle keys in one pass.
+//
+// HSCAN walks the fields of a hash the same way:
+//
+//	HSCAN hash cursor [MATCH pattern] [COUNT count] [NOVALUES]
+//
+// replying fields and their values, or only the fields with NOVALUES,
+// SSCAN the members of a set and ZSCAN the members of a sorted set with
+// their scores:
+//
+//	SSCAN key cursor [MATCH pattern] [COUNT count]
+//	ZSCAN key cursor [MATCH pattern] [COUNT count]
+
+// scanSnapshot is the list of key or field names an iteration pages
+// through.
+type scanSnapshot struct {
+	keys     []string
+	lastUsed time.Time
+	// owner names what is iterated, empty for the keyspace, or the type
+	// and name of the key for HSCAN, SSCAN and ZSCAN, like "hash:" followed
+	// by the hash name, so that a cursor can not be continued on something
+	// else
+	owner string
+}
+
+// scanSnapshots maps snapshot ids to the snapshots of iterations in
+// progress.
+var scanSnapshots = map[uint32]*scanSnapshot{}
+
+// scanMu guards scanSnapshots and nextScanID.
+var scanMu = sync.Mutex{}
+
+// nextScanID is the id of the last snapshot taken.
+var nextScanID uint32
+
+// Iterations are abandoned by clients without notice, so the snapshots
+// kept are limited in number and in idle time.
+const (
+	scanMaxSnapshots = 64
+	scanSnapshotTTL  = 5 * time.Minute
+)
+
+// scanDefaultCount is how many keys a call examines without COUNT.
+const scanDefaultCount = 10
+
+// takeScanSnapshot snapshots the key names and returns its id.
+func takeScanSnapshot() uint32 {
+	keyspaceMu.RLock()
+	keys := make([]string, 0, len(keyspace))
+	for key := range keyspace {
+		keys = append(keys, key)
+	}
+	keyspaceMu.RUnlock()
+
+	return addScanSnapshot(&scanSnapshot{keys: keys})
+}
+
+// addScanSnapshot registers the snapshot of a new iteration and returns
+// its id.
+func addScanSnapshot(snap *scanSnapshot) uint32 {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+
+	// forget idle iterations, then the oldest ones beyond the limit
+	now := time.Now()
+	ids := []uint32{}
+	for id, s := range scanSnapshots {
+		if now.Sub(s.lastUsed) > scanSnapshotTTL {
+			delete(scanSnapshots, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return scanSnapshots[ids[a]].lastUsed.Before(scanSnapshots[ids[b]].lastUsed)
+	})
+	for len(ids) >= scanMaxSnapshots {
+		delete(scanSnapshots, ids[0])
+		ids = ids[1:]
+	}
+
+	// id 0 would make the first cursor look like a finished iteration
+	nextScanID++
+	if nextScanID == 0 {
+		nextScanID++
+	}
+	snap.lastUsed = now
+	scanSnapshots[nextScanID] = snap
+	return nextScanID
+}
+
+// scanOptions are the options of a SCAN, HSCAN, SSCAN or ZSCAN call.
+type scanOptions struct {
+	pattern string
+	count   int
+	// typ and withAccess are only taken by SCAN, noValues only by HSCAN
+	typ        string
+	withAccess bool
+	noValues   bool
+}
+
+// parseScanOptions parses the options following the cursor of command,
+// which is SCAN, HSCAN, SSCAN or ZSCAN.
+func parseScanOptions(args []Value, command string) (scanOptions, Value, bool) {
+	opts := scanOptions{count: scanDefaultCount}
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		if option == "WITHACCESS" && command == "SCAN" {
+			opts.withAccess = true
+			continue
+		}
+		if option == "NOVALUES" && command == "HSCAN" {
+			opts.noValues = true
+			continue
+		}
+		if i+1 == len(args) {
+			return opts, syntaxError, false
+		}
+		i++
+		switch {
+		case option == "MATCH":
+			opts.pattern = args[i].bulk
+		case option == "COUNT":
+			n, err := strconv.Atoi(args[i].bulk)
+			if err != nil {
+				return opts, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+			}
+			if n < 1 {
+				return opts, syntaxError, false
+			}
+			opts.count = n
+		case option == "TYPE" && command == "SCAN":
+			opts.typ = strings.ToLower(args[i].bulk)
+		default:
+			return opts, syntaxError, false
+		}
+	}
+	return opts, Value{}, true
+}
+
+// scanPage looks up the snapshot of the iteration a cursor continues,
+// taking a new one with take for cursor 0, and returns the range of
+// positions the call covers. owner must match the owner of the snapshot.
+func scanPage(arg string, count int, owner string, take func() uint32) (snap *scanSnapshot, id uint32, pos, end int, errReply Value, ok bool) {
+	invalid := Value{typ: "error", str: "ERR invalid cursor"}
+	cursor, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return nil, 0, 0, 0, invalid, false
+	}
+
+	id, pos = uint32(cursor>>32), int(uint32(cursor))
+	if cursor == 0 {
+		id = take()
+	}
+	scanMu.Lock()
+	snap, found := scanSnapshots[id]
+	if found {
+		snap.lastUsed = time.Now()
+	}
+	scanMu.Unlock()
+	if !found || pos > len(snap.keys) || snap.owner != owner {
+		return nil, 0, 0, 0, invalid, false
+	}
+
+	return snap, id, pos, min(pos+count, len(snap.keys)), Value{}, true
+}
+
+// scanReply builds the reply of a call that covered snap up to end, and
+// forgets the snapshot once the iteration is complete.
+func scanReply(snap *scanSnapshot, id uint32, end int, found []Value) Value {
+	next := uint64(0)
+	if end < len(snap.keys) {
+		next = uint64(id)<<32 | uint64(end)
+	} else {
+		scanMu.Lock()
+		delete(scanSnapshots, id)
+		scanMu.Unlock()
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: strconv.FormatUint(next, 10)},
+		{typ: "array", array: found},
+	}}
+}
+
+// scan handles the SCAN command.
+func scan(args []Value) Value {
+	opts, errReply, ok := parseScanOptions(args[1:], "SCAN")
+	if !ok {
+		return errReply
+	}
+	snap, id, pos, end, errReply, ok := scanPage(args[0].bulk, opts.count, "", takeScanSnapshot)
+	if !ok {
+		return errReply
+	}
+	pattern, typ := opts.pattern, opts.typ
+
+	found := []Value{}
+	keyspaceMu.RLock()
+	for _, key := range snap.keys[pos:end] {
+		// skip keys deleted since the snapshot was taken or expired
+		t := keyType(key)
+		if t == "none" || expired(key) || typ != "" && t != typ {
+			continue
+		}
+		if pattern != "" && !matchGlob(pattern, key, false) {
+			continue
+		}
+		found = append(found, Value{typ: "bulk", bulk: key})
+	}
+	keyspaceMu.RUnlock()
+
+	if opts.withAccess {
+		withTimes := []Value{}
+		for _, key := range found {
+			read, write := lastAccess(key.bulk)
+			withTimes = append(withTimes, key,
+				Value{typ: "integer", num: int(read / 1000)},
+				Value{typ: "integer", num: int(write / 1000)})
+		}
+		found = withTimes
+	}
+
+	return scanReply(snap, id, end, found)
+}
+
+// hscan handles the HSCAN command.
+func hscan(args []Value) Value {
+	hash := args[0].bulk
+	opts, errReply, ok := parseScanOptions(args[2:], "HSCAN")
+	if !ok {
+		return errReply
+	}
+	keyspaceMu.RLock()
+	obj, exists := keyspace[hash]
+	keyspaceMu.RUnlock()
+	if exists && obj.typ != hashObject {
+		return wrongTypeError
+	}
+	owner := "hash:" + hash
+
+	take := func() uint32 {
+		keyspaceMu.RLock()
+		fields := keyspace[hash].hash()
+		names := make([]string, 0, fields.len())
+		fields.each(func(field, _ string) {
+			names = append(names, field)
+		})
+		keyspaceMu.RUnlock()
+
+		return addScanSnapshot(&scanSnapshot{keys: names, owner: owner})
+	}
+	snap, id, pos, end, errReply, ok := scanPage(args[1].bulk, opts.count, owner, take)
+	if !ok {
+		return errReply
+	}
+
+	found := []Value{}
+	readHash(hash, func(fields *hashValue) Value {
+		for _, field := range snap.keys[pos:end] {
+			// skip fields deleted since the snapshot was taken
+			value, ok := fields.get(field)
+			if !ok || opts.pattern != "" && !matchGlob(opts.pattern, field, false) {
+				continue
+			}
+			found = append(found, Value{typ: "bulk", bulk: field})
+			if !opts.noValues {
+				found = append(found, Value{typ: "bulk", bulk: value})
+			}
+		}
+		return Value{}
+	})
+
+	return scanReply(snap, id, end, found)
+}
+
+// sscan handles the SSCAN command.
+func sscan(args []Value) Value {
+	set := args[0].bulk
+	opts, errReply, ok := parseScanOptions(args[2:], "SSCAN")
+	if !ok {
+		return errReply
+	}
+	keyspaceMu.RLock()
+	obj, exists := keyspace[set]
+	keyspaceMu.RUnlock()
+	if exists && obj.typ != setObject {
+		return wrongTypeError
+	}
+	owner := "set:" + set
+
+	take := func() uint32 {
+		keyspaceMu.RLock()
+		members := keyspace[set].set()
+		names := make([]string, 0, members.len())
+		members.each(func(member string) {
+			names = append(names, member)
+		})
+		keyspaceMu.RUnlock()
+
+		return addScanSnapshot(&scanSnapshot{keys: names, owner: owner})
+	}
+	snap, id, pos, end, errReply, ok := scanPage(args[1].bulk, opts.count, owner, take)
+	if !ok {
+		return errReply
+	}
+
+	found := []Value{}
+	readSet(set, func(members *setValue) Value {
+		for _, member := range snap.keys[pos:end] {
+			// skip members removed since the snapshot was taken
+			if !members.has(member) || opts.pattern != "" && !matchGlob(opts.pattern, member, false) {
+				continue
+			}
+			found = append(found, Value{typ: "bulk", bulk: member})
+		}
+		return Value{}
+	})
+
+	return scanReply(snap, id, end, found)
+}
+
+// zscan handles the ZSCAN command.
+func zscan(args []Value) Value {
+	zset := args[0].bulk
+	opts, errReply, ok := parseScanOptions(args[2:], "ZSCAN")
+	if !ok {
+		return errReply
+	}
+	keyspaceMu.RLock()
+	obj, exists := keyspace[zset]
+	keyspaceMu.RUnlock()
+	if exists && obj.typ != zsetObject {
+		return wrongTypeError
+	}
+	owner := "zset:" + zset
+
+	take := func() uint32 {
+		keyspaceMu.RLock()
+		z := keyspace[zset].zset()
+		names := make([]string, 0, z.len())
+		z.each(func(member string, _ float64) {
+			names = append(names, member)
+		})
+		keyspaceMu.RUnlock()
+
+		return addScanSnapshot(&scanSnapshot{keys: names, owner: owner})
+	}
+	snap, id, pos, end, errReply, ok := scanPage(args[1].bulk, opts.count, owner, take)
+	if !ok {
+		return errReply
+	}
+
+	found := []Value{}
+	readZset(zset, func(z *zsetValue) Value {
+		for _, member := range snap.keys[pos:end] {
+			// skip members removed since the snapshot was taken
+			score, ok := z.score(member)
+			if !ok || opts.pattern != "" && !matchGlob(opts.pattern, member, false) {
+				continue
+			}
+			found = append(found, Value{typ: "bulk", bulk: member}, Value{typ: "bulk", bulk: formatScore(score)})
+		}
+		return Value{}
+	})
+
+	return scanReply(snap, id, end, found)
+}
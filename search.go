@@ -0,0 +1,858 @@
+// Secondary indexes.
+//
+// A search index maps fields of the hashes under a few key prefixes, so
+// that "every user whose status is active" is answered without a SCAN and
+// filtering on the client, like a subset of the RediSearch module:
+//
+//	FT.CREATE index [ON HASH] [PREFIX count prefix ...] SCHEMA field [AS alias] TAG|NUMERIC [SEPARATOR sep] [CASESENSITIVE] [SORTABLE] ...
+//	FT.SEARCH index query [NOCONTENT] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+//	FT.DROPINDEX index [DD]
+//	FT.INFO index
+//	FT._LIST
+//
+// An index covers the hashes whose key starts with one of its prefixes,
+// every hash without PREFIX. A TAG field holds tags separated by sep, a
+// comma by default, compared without case unless CASESENSITIVE is given,
+// and a NUMERIC field a number; a hash whose NUMERIC field is not a number
+// is left out of the index and counted as an indexing failure.
+//
+// A query is * for every document or a list of clauses a document must all
+// pass: @field:{tag|tag...} for a document having one of the tags and
+// @field:[min max] for a number in the range, where ( before a bound
+// excludes it and -inf and +inf leave the range open. A clause preceded by
+// - selects the documents that do not pass it. FT.SEARCH replies the number
+// of matching documents followed by the key and fields of every document
+// from offset, num of them, 0 and 10 by default, in the order of their keys
+// or of SORTBY.
+//
+// Tags map to the keys of the documents holding them and numbers are kept
+// sorted, so a search only looks at the documents its first clause
+// selects. Rather than hooking every command that changes a hash, the keys
+// every write command names are queued on the indexes covering them and
+// indexed again by the next command reading the index; FLUSHALL and
+// FLUSHDB have every index rebuilt. Keys removed by the active expiry or
+// eviction are skipped when found missing. Indexes are not keys: they are
+// persisted as their FT.CREATE and survive FLUSHALL.
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// searchField is a field of the schema of an index.
+type searchField struct {
+	// name is the field of the hashes and alias the name queries use
+	name, alias   string
+	numeric       bool
+	separator     byte
+	caseSensitive bool
+	sortable      bool
+}
+
+// searchDoc is the indexed content of a hash.
+type searchDoc struct {
+	// tags maps the alias of every TAG field to the tags of the hash
+	tags map[string][]string
+	// numbers maps the alias of every NUMERIC field to its value
+	numbers map[string]float64
+}
+
+// searchNumber is a number of a NUMERIC field and the key holding it.
+type searchNumber struct {
+	value float64
+	key   string
+}
+
+// searchIndex is an index created by FT.CREATE.
+type searchIndex struct {
+	name     string
+	prefixes []string
+	fields   []*searchField
+	// docs maps the keys of the indexed hashes to their content
+	docs map[string]*searchDoc
+	// tags maps the alias of every TAG field and every tag to the keys of
+	// the documents with the tag
+	tags map[string]map[string]map[string]bool
+	// numbers holds the numbers of every NUMERIC field by value, nil after
+	// a document changed until the next search sorts them again
+	numbers map[string][]searchNumber
+	// failures counts the hashes that could not be indexed
+	failures int
+	// pending are the keys written since they were last indexed and stale
+	// is set when every key has to be indexed again
+	pending map[string]bool
+	stale   bool
+}
+
+// searchIndexes maps the names of the indexes to their index.
+var searchIndexes = map[string]*searchIndex{}
+
+// searchIndexCount is the number of indexes, read by writes without taking
+// searchMu.
+var searchIndexCount atomic.Int64
+
+// searchMu guards searchIndexes and the indexes. It is taken after
+// keyspaceMu.
+var searchMu = rwLock{name: "search"}
+
+// unknownIndexError is the reply to a command naming a missing index.
+var unknownIndexError = Value{typ: "error", str: "Unknown Index name"}
+
+// covers reports whether the index covers key.
+func (idx *searchIndex) covers(key string) bool {
+	if len(idx.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range idx.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the field queries name alias, nil when there is none.
+func (idx *searchIndex) field(alias string) *searchField {
+	for _, f := range idx.fields {
+		if f.alias == alias {
+			return f
+		}
+	}
+	return nil
+}
+
+// splitTags returns the tags of a TAG field value.
+func (f *searchField) splitTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(value, string(f.separator)) {
+		if tag = f.normalize(strings.TrimSpace(tag)); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// normalize returns a tag the way it is indexed and looked up.
+func (f *searchField) normalize(tag string) string {
+	if f.caseSensitive {
+		return tag
+	}
+	return strings.ToLower(tag)
+}
+
+// unindex removes a document from the index.
+func (idx *searchIndex) unindex(key string) {
+	doc, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+	for alias, tags := range doc.tags {
+		for _, tag := range tags {
+			keys := idx.tags[alias][tag]
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(idx.tags[alias], tag)
+			}
+		}
+	}
+	if len(doc.numbers) > 0 {
+		idx.numbers = nil
+	}
+	delete(idx.docs, key)
+}
+
+// reindex indexes the current value of key again. keyspaceMu must be held.
+func (idx *searchIndex) reindex(key string) {
+	idx.unindex(key)
+	obj, ok := keyspace[key]
+	if !ok || obj.typ != hashObject || expired(key) || !idx.covers(key) {
+		return
+	}
+
+	doc := &searchDoc{tags: map[string][]string{}, numbers: map[string]float64{}}
+	for _, f := range idx.fields {
+		value, ok := obj.hash().get(f.name)
+		if !ok {
+			continue
+		}
+		if f.numeric {
+			n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || math.IsNaN(n) {
+				idx.failures++
+				return
+			}
+			doc.numbers[f.alias] = n
+		} else if tags := f.splitTags(value); len(tags) > 0 {
+			doc.tags[f.alias] = tags
+		}
+	}
+
+	for alias, tags := range doc.tags {
+		for _, tag := range tags {
+			if idx.tags[alias] == nil {
+				idx.tags[alias] = map[string]map[string]bool{}
+			}
+			if idx.tags[alias][tag] == nil {
+				idx.tags[alias][tag] = map[string]bool{}
+			}
+			idx.tags[alias][tag][key] = true
+		}
+	}
+	if len(doc.numbers) > 0 {
+		idx.numbers = nil
+	}
+	idx.docs[key] = doc
+}
+
+// refresh indexes the pending keys, or every key when the index is stale.
+// keyspaceMu must be held and searchMu held for writing.
+func (idx *searchIndex) refresh() {
+	if idx.stale {
+		idx.docs = map[string]*searchDoc{}
+		idx.tags = map[string]map[string]map[string]bool{}
+		idx.numbers = nil
+		idx.failures = 0
+		for key := range keyspace {
+			idx.reindex(key)
+		}
+		idx.stale = false
+	} else {
+		for key := range idx.pending {
+			idx.reindex(key)
+		}
+	}
+	idx.pending = map[string]bool{}
+}
+
+// sortedNumbers returns the numbers of a NUMERIC field by value.
+func (idx *searchIndex) sortedNumbers(alias string) []searchNumber {
+	if idx.numbers == nil {
+		idx.numbers = map[string][]searchNumber{}
+		for key, doc := range idx.docs {
+			for a, n := range doc.numbers {
+				idx.numbers[a] = append(idx.numbers[a], searchNumber{value: n, key: key})
+			}
+		}
+		for _, numbers := range idx.numbers {
+			slices.SortFunc(numbers, func(a, b searchNumber) int {
+				if a.value != b.value {
+					if a.value < b.value {
+						return -1
+					}
+					return 1
+				}
+				return strings.Compare(a.key, b.key)
+			})
+		}
+	}
+	return idx.numbers[alias]
+}
+
+// searchTouch queues the keys written by a command on the indexes covering
+// them.
+func searchTouch(command string, args []Value) {
+	if searchIndexCount.Load() == 0 {
+		return
+	}
+	keys := commandKeys(command, args)
+	if len(keys) == 0 {
+		return
+	}
+
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	for _, idx := range searchIndexes {
+		for _, key := range keys {
+			if idx.covers(key) {
+				idx.pending[key] = true
+			}
+		}
+	}
+}
+
+// searchReindexAll has every index rebuilt, after the dataset was
+// replaced.
+func searchReindexAll() {
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	for _, idx := range searchIndexes {
+		idx.stale = true
+	}
+}
+
+// ftCreate handles FT.CREATE index [ON HASH] [PREFIX count prefix ...]
+// SCHEMA field [AS alias] TAG|NUMERIC [options] ....
+func ftCreate(args []Value) Value {
+	idx := &searchIndex{name: args[0].bulk, pending: map[string]bool{}, stale: true}
+	i := 1
+	for ; i < len(args) && !strings.EqualFold(args[i].bulk, "SCHEMA"); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "ON":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			if !strings.EqualFold(args[i+1].bulk, "HASH") {
+				return Value{typ: "error", str: "Only HASH indexes are supported"}
+			}
+			i++
+		case "PREFIX":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			n, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil || n < 0 || i+2+n > len(args) {
+				return Value{typ: "error", str: "Bad arguments for PREFIX: Expected an argument"}
+			}
+			for _, prefix := range args[i+2 : i+2+n] {
+				idx.prefixes = append(idx.prefixes, prefix.bulk)
+			}
+			i += 1 + n
+		default:
+			return Value{typ: "error", str: "Unknown argument `" + args[i].bulk + "`"}
+		}
+	}
+	if i >= len(args)-1 {
+		return Value{typ: "error", str: "Fields arguments are missing"}
+	}
+
+	for i++; i < len(args); i++ {
+		f := &searchField{name: args[i].bulk, alias: args[i].bulk, separator: ','}
+		if i+2 < len(args) && strings.EqualFold(args[i+1].bulk, "AS") {
+			f.alias = args[i+2].bulk
+			i += 2
+		}
+		if i+1 == len(args) {
+			return Value{typ: "error", str: "Field `" + f.alias + "` does not have a type"}
+		}
+		i++
+		switch strings.ToUpper(args[i].bulk) {
+		case "TAG":
+		case "NUMERIC":
+			f.numeric = true
+		default:
+			return Value{typ: "error", str: "Invalid field type for field `" + f.alias + "`"}
+		}
+		// the options of the field run up to the next field
+	options:
+		for i+1 < len(args) {
+			switch strings.ToUpper(args[i+1].bulk) {
+			case "SEPARATOR":
+				if f.numeric || i+2 == len(args) || len(args[i+2].bulk) != 1 {
+					return Value{typ: "error", str: "Tag separator must be a single character"}
+				}
+				f.separator = args[i+2].bulk[0]
+				i += 2
+			case "CASESENSITIVE":
+				if f.numeric {
+					return Value{typ: "error", str: "CASESENSITIVE is only valid for TAG fields"}
+				}
+				f.caseSensitive = true
+				i++
+			case "SORTABLE":
+				f.sortable = true
+				i++
+			default:
+				break options
+			}
+		}
+		if idx.field(f.alias) != nil {
+			return Value{typ: "error", str: "Duplicate field in schema - " + f.alias}
+		}
+		idx.fields = append(idx.fields, f)
+	}
+
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	if _, exists := searchIndexes[idx.name]; exists {
+		return Value{typ: "error", str: "Index already exists"}
+	}
+	searchIndexes[idx.name] = idx
+	searchIndexCount.Add(1)
+	return Value{typ: "string", str: "OK"}
+}
+
+// ftDropindex handles FT.DROPINDEX index [DD], deleting the indexed hashes
+// too with DD.
+func ftDropindex(args []Value) Value {
+	if len(args) > 2 || len(args) == 2 && !strings.EqualFold(args[1].bulk, "DD") {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	idx, ok := searchIndexes[args[0].bulk]
+	if !ok {
+		return unknownIndexError
+	}
+	delete(searchIndexes, idx.name)
+	if len(args) == 2 {
+		idx.refresh()
+		deleted := make([]string, 0, len(idx.docs))
+		for key := range idx.docs {
+			deleteKey(key)
+			deleted = append(deleted, key)
+			// the other indexes covering the key drop it too
+			for _, other := range searchIndexes {
+				if other.covers(key) {
+					other.pending[key] = true
+				}
+			}
+		}
+		markKeyspaceChanged()
+		invalidateKeys(deleted, 0)
+	}
+	searchIndexCount.Add(-1)
+	return Value{typ: "string", str: "OK"}
+}
+
+// ftList handles FT._LIST, replying the names of the indexes.
+func ftList(args []Value) Value {
+	searchMu.RLock()
+	defer searchMu.RUnlock()
+
+	names := []string{}
+	for name := range searchIndexes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	reply := Value{typ: "array", array: []Value{}}
+	for _, name := range names {
+		reply.array = append(reply.array, Value{typ: "bulk", bulk: name})
+	}
+	return reply
+}
+
+// ftInfo handles FT.INFO index.
+func ftInfo(args []Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	idx, ok := searchIndexes[args[0].bulk]
+	if !ok {
+		return unknownIndexError
+	}
+	idx.refresh()
+
+	prefixes := Value{typ: "array", array: []Value{}}
+	for _, prefix := range idx.prefixes {
+		prefixes.array = append(prefixes.array, Value{typ: "bulk", bulk: prefix})
+	}
+	if len(idx.prefixes) == 0 {
+		prefixes.array = append(prefixes.array, Value{typ: "bulk", bulk: ""})
+	}
+	attributes := Value{typ: "array", array: []Value{}}
+	for _, f := range idx.fields {
+		attribute := Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "identifier"}, {typ: "bulk", bulk: f.name},
+			{typ: "bulk", bulk: "attribute"}, {typ: "bulk", bulk: f.alias},
+		}}
+		if f.numeric {
+			attribute.array = append(attribute.array, Value{typ: "bulk", bulk: "type"}, Value{typ: "bulk", bulk: "NUMERIC"})
+		} else {
+			attribute.array = append(attribute.array,
+				Value{typ: "bulk", bulk: "type"}, Value{typ: "bulk", bulk: "TAG"},
+				Value{typ: "bulk", bulk: "SEPARATOR"}, Value{typ: "bulk", bulk: string(f.separator)})
+			if f.caseSensitive {
+				attribute.array = append(attribute.array, Value{typ: "bulk", bulk: "CASESENSITIVE"})
+			}
+		}
+		if f.sortable {
+			attribute.array = append(attribute.array, Value{typ: "bulk", bulk: "SORTABLE"})
+		}
+		attributes.array = append(attributes.array, attribute)
+	}
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "index_name"}, {typ: "bulk", bulk: idx.name},
+		{typ: "bulk", bulk: "index_definition"}, {typ: "array", array: []Value{
+			{typ: "bulk", bulk: "key_type"}, {typ: "bulk", bulk: "HASH"},
+			{typ: "bulk", bulk: "prefixes"}, prefixes,
+		}},
+		{typ: "bulk", bulk: "attributes"}, attributes,
+		{typ: "bulk", bulk: "num_docs"}, {typ: "integer", num: len(idx.docs)},
+		{typ: "bulk", bulk: "hash_indexing_failures"}, {typ: "integer", num: idx.failures},
+		{typ: "bulk", bulk: "indexing"}, {typ: "integer", num: 0},
+		{typ: "bulk", bulk: "percent_indexed"}, {typ: "bulk", bulk: "1"},
+	}}
+}
+
+// searchClause is a clause of a query.
+type searchClause struct {
+	field   *searchField
+	negated bool
+	// tags are the tags of a TAG clause
+	tags []string
+	// min and max bound a NUMERIC clause, excluded when minExclusive or
+	// maxExclusive is set
+	min, max                   float64
+	minExclusive, maxExclusive bool
+}
+
+// matches reports whether a document passes the clause.
+func (c *searchClause) matches(doc *searchDoc) bool {
+	matched := false
+	if c.field.numeric {
+		n, ok := doc.numbers[c.field.alias]
+		matched = ok && (n > c.min || n == c.min && !c.minExclusive) && (n < c.max || n == c.max && !c.maxExclusive)
+	} else {
+		for _, tag := range doc.tags[c.field.alias] {
+			if slices.Contains(c.tags, tag) {
+				matched = true
+				break
+			}
+		}
+	}
+	return matched != c.negated
+}
+
+// searchSyntaxError returns the reply to a query that can not be parsed
+// at offset.
+func searchSyntaxError(query string, offset int) Value {
+	near := strings.Fields(query[offset:])
+	if len(near) == 0 {
+		return Value{typ: "error", str: "Syntax error at offset " + strconv.Itoa(offset)}
+	}
+	return Value{typ: "error", str: "Syntax error at offset " + strconv.Itoa(offset) + " near " + near[0]}
+}
+
+// parseSearchBound parses a bound of a NUMERIC clause.
+func parseSearchBound(arg string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	n, err := strconv.ParseFloat(arg, 64)
+	return n, exclusive, err == nil && !math.IsNaN(n)
+}
+
+// parseSearchQuery parses the clauses of a query.
+func parseSearchQuery(idx *searchIndex, query string) ([]*searchClause, Value, bool) {
+	clauses := []*searchClause{}
+	if strings.TrimSpace(query) == "*" {
+		return clauses, Value{}, true
+	}
+	i := 0
+	for {
+		for i < len(query) && query[i] == ' ' {
+			i++
+		}
+		if i == len(query) {
+			break
+		}
+		start := i
+		c := &searchClause{}
+		if query[i] == '-' {
+			c.negated = true
+			i++
+		}
+		if i == len(query) || query[i] != '@' {
+			return nil, searchSyntaxError(query, start), false
+		}
+		colon := strings.IndexByte(query[i:], ':')
+		if colon < 0 {
+			return nil, searchSyntaxError(query, start), false
+		}
+		alias := query[i+1 : i+colon]
+		if c.field = idx.field(alias); c.field == nil {
+			return nil, Value{typ: "error", str: "Unknown field at offset " + strconv.Itoa(start) + " near " + alias}, false
+		}
+		i += colon + 1
+		for i < len(query) && query[i] == ' ' {
+			i++
+		}
+
+		switch {
+		case i < len(query) && query[i] == '{' && !c.field.numeric:
+			// tags are separated by |, which a backslash escapes like any
+			// other character
+			tag := []byte{}
+			for i++; ; i++ {
+				if i == len(query) {
+					return nil, searchSyntaxError(query, start), false
+				}
+				ch := query[i]
+				if ch == '\\' && i+1 < len(query) {
+					i++
+					tag = append(tag, query[i])
+					continue
+				}
+				if ch == '|' || ch == '}' {
+					if t := c.field.normalize(strings.TrimSpace(string(tag))); t != "" {
+						c.tags = append(c.tags, t)
+					}
+					tag = tag[:0]
+					if ch == '}' {
+						i++
+						break
+					}
+					continue
+				}
+				tag = append(tag, ch)
+			}
+			if len(c.tags) == 0 {
+				return nil, searchSyntaxError(query, start), false
+			}
+		case i < len(query) && query[i] == '[' && c.field.numeric:
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 {
+				return nil, searchSyntaxError(query, start), false
+			}
+			bounds := strings.Fields(strings.ReplaceAll(query[i+1:i+end], ",", " "))
+			if len(bounds) != 2 {
+				return nil, searchSyntaxError(query, start), false
+			}
+			var ok bool
+			if c.min, c.minExclusive, ok = parseSearchBound(bounds[0]); !ok {
+				return nil, Value{typ: "error", str: "Bad lower range: " + bounds[0]}, false
+			}
+			if c.max, c.maxExclusive, ok = parseSearchBound(bounds[1]); !ok {
+				return nil, Value{typ: "error", str: "Bad upper range: " + bounds[1]}, false
+			}
+			i += end + 1
+		default:
+			return nil, searchSyntaxError(query, start), false
+		}
+		clauses = append(clauses, c)
+	}
+	return clauses, Value{}, true
+}
+
+// candidates returns the keys of the documents that may pass the clauses:
+// those selected by the first clause that is not negated, every document
+// when there is none.
+func (idx *searchIndex) candidates(clauses []*searchClause) []string {
+	keys := []string{}
+	for _, c := range clauses {
+		if c.negated {
+			continue
+		}
+		if !c.field.numeric {
+			for _, tag := range c.tags {
+				for key := range idx.tags[c.field.alias][tag] {
+					keys = append(keys, key)
+				}
+			}
+			// a document with several of the tags is listed once
+			slices.Sort(keys)
+			return slices.Compact(keys)
+		}
+		numbers := idx.sortedNumbers(c.field.alias)
+		from := sort.Search(len(numbers), func(i int) bool {
+			return numbers[i].value >= c.min
+		})
+		for _, n := range numbers[from:] {
+			if n.value > c.max {
+				break
+			}
+			keys = append(keys, n.key)
+		}
+		return keys
+	}
+	for key := range idx.docs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ftSearch handles FT.SEARCH index query [NOCONTENT] [RETURN count field
+// ...] [SORTBY field [ASC|DESC]] [LIMIT offset num].
+func ftSearch(args []Value) Value {
+	noContent, returned := false, []string(nil)
+	sortBy, descending := "", false
+	offset, num := 0, 10
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "NOCONTENT":
+			noContent = true
+		case "RETURN":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "Bad arguments for RETURN: Expected an argument"}
+			}
+			n, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil || n < 0 || i+2+n > len(args) {
+				return Value{typ: "error", str: "Bad arguments for RETURN: Expected an argument"}
+			}
+			returned = []string{}
+			for _, field := range args[i+2 : i+2+n] {
+				returned = append(returned, field.bulk)
+			}
+			if n == 0 {
+				noContent = true
+			}
+			i += 1 + n
+		case "SORTBY":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "Bad arguments for SORTBY: Expected an argument"}
+			}
+			sortBy = args[i+1].bulk
+			i++
+			if i+1 < len(args) && (strings.EqualFold(args[i+1].bulk, "ASC") || strings.EqualFold(args[i+1].bulk, "DESC")) {
+				descending = strings.EqualFold(args[i+1].bulk, "DESC")
+				i++
+			}
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return Value{typ: "error", str: "Bad arguments for LIMIT: Expected an argument"}
+			}
+			o, err1 := strconv.Atoi(args[i+1].bulk)
+			n, err2 := strconv.Atoi(args[i+2].bulk)
+			if err1 != nil || err2 != nil || o < 0 || n < 0 {
+				return Value{typ: "error", str: "Bad arguments for LIMIT: Value is not a non-negative integer"}
+			}
+			offset, num = o, n
+			i += 2
+		default:
+			return Value{typ: "error", str: "Unknown argument `" + args[i].bulk + "`"}
+		}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+	searchMu.Lock()
+	defer searchMu.Unlock()
+
+	idx, ok := searchIndexes[args[0].bulk]
+	if !ok {
+		return unknownIndexError
+	}
+	clauses, errReply, ok := parseSearchQuery(idx, args[1].bulk)
+	if !ok {
+		return errReply
+	}
+	var sortField *searchField
+	if sortBy != "" {
+		if sortField = idx.field(sortBy); sortField == nil {
+			return Value{typ: "error", str: "Property `" + sortBy + "` not loaded nor in schema"}
+		}
+	}
+	idx.refresh()
+
+	keys := []string{}
+	for _, key := range idx.candidates(clauses) {
+		// the active expiry and eviction remove keys without a command
+		if obj, ok := keyspace[key]; !ok || obj.typ != hashObject || expired(key) {
+			continue
+		}
+		doc := idx.docs[key]
+		matched := true
+		for _, c := range clauses {
+			if !c.matches(doc) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	if sortField != nil {
+		sortSearchKeys(idx, keys, sortField, descending)
+	}
+
+	reply := Value{typ: "array", array: []Value{{typ: "integer", num: len(keys)}}}
+	for _, key := range keys[min(offset, len(keys)):min(offset+num, len(keys))] {
+		reply.array = append(reply.array, Value{typ: "bulk", bulk: key})
+		if noContent {
+			continue
+		}
+		h := keyspace[key].hash()
+		fields := Value{typ: "array", array: []Value{}}
+		if returned == nil {
+			h.each(func(field, value string) {
+				fields.array = append(fields.array, Value{typ: "bulk", bulk: field}, Value{typ: "bulk", bulk: value})
+			})
+		}
+		for _, name := range returned {
+			field := name
+			if f := idx.field(name); f != nil {
+				field = f.name
+			}
+			if value, ok := h.get(field); ok {
+				fields.array = append(fields.array, Value{typ: "bulk", bulk: name}, Value{typ: "bulk", bulk: value})
+			}
+		}
+		reply.array = append(reply.array, fields)
+	}
+	return reply
+}
+
+// sortSearchKeys orders the keys of the documents found by a field,
+// numbers by value and tags by their indexed text, the documents without
+// the field last. Ties keep the order of the keys.
+func sortSearchKeys(idx *searchIndex, keys []string, f *searchField, descending bool) {
+	slices.SortStableFunc(keys, func(a, b string) int {
+		da, db := idx.docs[a], idx.docs[b]
+		var cmp int
+		if f.numeric {
+			na, okA := da.numbers[f.alias]
+			nb, okB := db.numbers[f.alias]
+			switch {
+			case okA != okB:
+				if okA {
+					return -1
+				}
+				return 1
+			case na < nb:
+				cmp = -1
+			case na > nb:
+				cmp = 1
+			}
+		} else {
+			ta, tb := da.tags[f.alias], db.tags[f.alias]
+			if len(ta) == 0 || len(tb) == 0 {
+				return min(len(tb), 1) - min(len(ta), 1)
+			}
+			cmp = strings.Compare(strings.Join(ta, string(f.separator)), strings.Join(tb, string(f.separator)))
+		}
+		if descending {
+			return -cmp
+		}
+		return cmp
+	})
+}
+
+// searchCommands returns the FT.CREATE commands recreating every index, for
+// snapshots.
+func searchCommands() []Value {
+	searchMu.RLock()
+	defer searchMu.RUnlock()
+
+	commands := []Value{}
+	for _, idx := range searchIndexes {
+		args := []string{"FT.CREATE", idx.name, "ON", "HASH"}
+		if len(idx.prefixes) > 0 {
+			args = append(args, "PREFIX", strconv.Itoa(len(idx.prefixes)))
+			args = append(args, idx.prefixes...)
+		}
+		args = append(args, "SCHEMA")
+		for _, f := range idx.fields {
+			args = append(args, f.name, "AS", f.alias)
+			if f.numeric {
+				args = append(args, "NUMERIC")
+			} else {
+				args = append(args, "TAG", "SEPARATOR", string(f.separator))
+				if f.caseSensitive {
+					args = append(args, "CASESENSITIVE")
+				}
+			}
+			if f.sortable {
+				args = append(args, "SORTABLE")
+			}
+		}
+		commands = append(commands, commandValue(args...))
+	}
+	return commands
+}
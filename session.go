@@ -0,0 +1,276 @@
+// Session store.
+//
+// Web frameworks keep their sessions in Redis as a value with a TTL plus a
+// hand-maintained set per user listing the user's sessions, so that logging
+// a user out everywhere does not need a scan. The SESSION.* commands do the
+// bookkeeping on the server: every session carries a set of tags, such as
+// the user id, and SESSION.DELBYTAG deletes every session of a tag in time
+// proportional to the number of sessions it has.
+//
+// Sessions live in their own namespace next to the keyspace. Expiry times
+// are absolute and persisted as PXAT, so replaying the AOF or loading a
+// snapshot does not extend them.
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// session is a stored session.
+type session struct {
+	value string
+	// expiresAt is the expiry time in Unix milliseconds
+	expiresAt int64
+	tags      []string
+}
+
+// sessions maps session ids to sessions.
+var sessions = map[string]*session{}
+
+// sessionTags maps every tag to the ids of the sessions carrying it.
+var sessionTags = map[string]map[string]bool{}
+
+// sessionsMu guards sessions and sessionTags.
+var sessionsMu = sync.Mutex{}
+
+// sessionExpireInterval is how often expired sessions are looked for, and
+// sessionExpireSample how many sessions are checked each time.
+const (
+	sessionExpireInterval = 100 * time.Millisecond
+	sessionExpireSample   = 20
+)
+
+// nowMs returns the current Unix time in milliseconds.
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// parseExpiry parses "EX seconds" or "PXAT unix-ms" into an absolute expiry
+// time in Unix milliseconds.
+func parseExpiry(option, arg string) (int64, bool) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	switch strings.ToUpper(option) {
+	case "EX":
+		// a time that overflows once in milliseconds is refused like SET
+		// does, instead of wrapping into the past
+		now := nowMs()
+		if n > (math.MaxInt64-now)/1000 {
+			return 0, false
+		}
+		return now + n*1000, true
+	case "PXAT":
+		return n, true
+	}
+	return 0, false
+}
+
+// deleteSession removes a session and its tag entries. It must be called
+// with sessionsMu held.
+func deleteSession(id string) bool {
+	s, ok := sessions[id]
+	if !ok {
+		return false
+	}
+	for _, tag := range s.tags {
+		delete(sessionTags[tag], id)
+		if len(sessionTags[tag]) == 0 {
+			delete(sessionTags, tag)
+		}
+	}
+	delete(sessions, id)
+	return true
+}
+
+// lookupSession returns a live session, deleting it if it expired. It must
+// be called with sessionsMu held.
+func lookupSession(id string) *session {
+	s, ok := sessions[id]
+	if !ok {
+		return nil
+	}
+	if s.expiresAt <= nowMs() {
+		deleteSession(id)
+		return nil
+	}
+	return s
+}
+
+// sessionSet handles SESSION.SET id value EX seconds|PXAT unix-ms
+// [TAGS tag [tag ...]], replacing any session stored under id.
+func sessionSet(args []Value) Value {
+	if len(args) < 4 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'session.set' command"}
+	}
+	id, value := args[0].bulk, args[1].bulk
+	expiresAt, ok := parseExpiry(args[2].bulk, args[3].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR invalid expire time in 'session.set' command"}
+	}
+	tags := []string{}
+	if len(args) > 4 {
+		if strings.ToUpper(args[4].bulk) != "TAGS" || len(args) == 5 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		for _, arg := range args[5:] {
+			tags = append(tags, arg.bulk)
+		}
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	deleteSession(id)
+	// a session replayed after its expiry is not stored at all
+	if expiresAt <= nowMs() {
+		return Value{typ: "string", str: "OK"}
+	}
+	sessions[id] = &session{value: value, expiresAt: expiresAt, tags: tags}
+	for _, tag := range tags {
+		if sessionTags[tag] == nil {
+			sessionTags[tag] = map[string]bool{}
+		}
+		sessionTags[tag][id] = true
+	}
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// sessionGet handles SESSION.GET id.
+func sessionGet(args []Value) Value {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s := lookupSession(args[0].bulk)
+	if s == nil {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "bulk", bulk: s.value}
+}
+
+// sessionTouch handles SESSION.TOUCH id seconds|PXAT unix-ms, extending the
+// session. It replies 1, or 0 when there is no such session.
+func sessionTouch(args []Value) Value {
+	var expiresAt int64
+	var ok bool
+	switch len(args) {
+	case 2:
+		expiresAt, ok = parseExpiry("EX", args[1].bulk)
+	case 3:
+		expiresAt, ok = parseExpiry(args[1].bulk, args[2].bulk)
+	}
+	if !ok {
+		return Value{typ: "error", str: "ERR invalid expire time in 'session.touch' command"}
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s := lookupSession(args[0].bulk)
+	if s == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	s.expiresAt = expiresAt
+	return Value{typ: "integer", num: 1}
+}
+
+// sessionDelByTag handles SESSION.DELBYTAG tag, deleting every session
+// carrying the tag and replying how many were deleted.
+func sessionDelByTag(args []Value) Value {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	deleted := 0
+	for id := range sessionTags[args[0].bulk] {
+		if s := sessions[id]; s.expiresAt > nowMs() {
+			deleted++
+		}
+		deleteSession(id)
+	}
+	return Value{typ: "integer", num: deleted}
+}
+
+// sessionPropagate persists relative expiry times as absolute ones.
+func sessionPropagate(value Value, result Value) Value {
+	args := make([]string, len(value.array))
+	for i, arg := range value.array {
+		args[i] = arg.bulk
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "SESSION.SET":
+		if strings.ToUpper(args[3]) == "EX" {
+			args[3], args[4] = "PXAT", strconv.FormatInt(sessionExpiry(args[1]), 10)
+		}
+	case "SESSION.TOUCH":
+		if result.num == 0 {
+			return Value{}
+		}
+		if len(args) == 3 {
+			args = []string{args[0], args[1], "PXAT", strconv.FormatInt(sessionExpiry(args[1]), 10)}
+		}
+	}
+	return commandValue(args...)
+}
+
+// sessionExpiry returns the expiry time of a session, or 1 for a session
+// that does not exist so that replaying it stores nothing.
+func sessionExpiry(id string) int64 {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if s, ok := sessions[id]; ok {
+		return s.expiresAt
+	}
+	return 1
+}
+
+// sessionCommands returns the SESSION.SET commands recreating every live
+// session.
+func sessionCommands() []Value {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	now := nowMs()
+	commands := []Value{}
+	for id, s := range sessions {
+		if s.expiresAt <= now {
+			continue
+		}
+		args := []string{"SESSION.SET", id, s.value, "PXAT", strconv.FormatInt(s.expiresAt, 10)}
+		if len(s.tags) > 0 {
+			args = append(append(args, "TAGS"), s.tags...)
+		}
+		commands = append(commands, commandValue(args...))
+	}
+	return commands
+}
+
+// sessionExpireCron deletes expired sessions in the background, checking a
+// few sessions at a time like Redis' active expiry, so that sessions nobody
+// reads again do not pile up.
+func sessionExpireCron() {
+	for {
+		time.Sleep(sessionExpireInterval)
+
+		sessionsMu.Lock()
+		now, checked := nowMs(), 0
+		// map iteration starts at a random position, which makes the
+		// sessions checked a random sample
+		for id, s := range sessions {
+			if s.expiresAt <= now {
+				deleteSession(id)
+			}
+			if checked++; checked == sessionExpireSample {
+				break
+			}
+		}
+		sessionsMu.Unlock()
+	}
+}
@@ -0,0 +1,501 @@
+// Sets.
+//
+// A set is an unordered collection of distinct strings:
+//
+//	SADD key member [member ...]      SREM key member [member ...]
+//	SMEMBERS key                      SISMEMBER key member
+//	SCARD key                         SMISMEMBER key member [member ...]
+//	SMOVE source destination member   SSCAN key cursor [MATCH pattern] [COUNT count]
+//
+// and the set algebra computed on the server, so that a client does not
+// have to fetch whole sets to combine them:
+//
+//	SINTER key [key ...]              SINTERSTORE destination key [key ...]
+//	SUNION key [key ...]              SUNIONSTORE destination key [key ...]
+//	SDIFF key [key ...]               SDIFFSTORE destination key [key ...]
+//	SINTERCARD numkeys key [key ...] [LIMIT limit]
+//
+// A missing key counts as an empty set. The STORE variants replace
+// whatever the destination held, delete it when the result is empty and
+// reply the number of members stored. SINTERCARD only counts the members
+// of the intersection, and stops counting at limit when it is not 0, so
+// sizing a large intersection does not build it. A set is deleted once its
+// last member is removed.
+//
+// Like Redis' intset, a set holding only integers keeps them as a sorted
+// slice of int64, searched by bisection, which takes 8 bytes per member
+// instead of a string and a map entry. It is converted to a map for good on
+// the first member that is not an integer, or once it holds more than
+// set-max-intset-entries members. Only integers written the way Redis
+// prints them, without leading zeros or a plus sign, count, so every member
+// reads back exactly as it was added. Members of an intset are listed in
+// numerical order, those of a map in no particular order, or sorted with
+// reply-ordering lexicographic.
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// setMaxIntsetEntries is the most members a set keeps in the intset
+// encoding. Changing it only affects sets as they grow.
+var setMaxIntsetEntries atomic.Int64
+
+func init() {
+	setMaxIntsetEntries.Store(512)
+}
+
+// setValue holds the members of a set. The read methods treat a nil set as
+// empty.
+type setValue struct {
+	// ints holds the members in ascending order while the set is an intset
+	ints []int64
+	// members holds the members once the set was converted, nil before
+	members map[string]struct{}
+}
+
+// setInt parses a member as an intset holds it, reporting false when the
+// member is not an integer in canonical form.
+func setInt(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+// len returns the number of members.
+func (s *setValue) len() int {
+	if s == nil {
+		return 0
+	}
+	if s.members != nil {
+		return len(s.members)
+	}
+	return len(s.ints)
+}
+
+// has reports whether member is in the set.
+func (s *setValue) has(member string) bool {
+	if s == nil {
+		return false
+	}
+	if s.members != nil {
+		_, ok := s.members[member]
+		return ok
+	}
+	n, ok := setInt(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.ints, n)
+	return found
+}
+
+// add adds a member, reporting whether it is new.
+func (s *setValue) add(member string) bool {
+	if s.members == nil {
+		n, ok := setInt(member)
+		if ok {
+			i, found := slices.BinarySearch(s.ints, n)
+			if found {
+				return false
+			}
+			s.ints = slices.Insert(s.ints, i, n)
+			if len(s.ints) > int(setMaxIntsetEntries.Load()) {
+				s.convert()
+			}
+			return true
+		}
+		s.convert()
+	}
+	if _, ok := s.members[member]; ok {
+		return false
+	}
+	s.members[member] = struct{}{}
+	return true
+}
+
+// remove removes a member, reporting whether it existed. An intset stays
+// one when it shrinks.
+func (s *setValue) remove(member string) bool {
+	if !s.has(member) {
+		return false
+	}
+	if s.members != nil {
+		delete(s.members, member)
+		return true
+	}
+	n, _ := setInt(member)
+	i, _ := slices.BinarySearch(s.ints, n)
+	s.ints = slices.Delete(s.ints, i, i+1)
+	return true
+}
+
+// each calls fn with every member.
+func (s *setValue) each(fn func(member string)) {
+	s.eachWhile(func(member string) bool {
+		fn(member)
+		return true
+	})
+}
+
+// eachWhile calls fn with every member until fn returns false.
+func (s *setValue) eachWhile(fn func(member string) bool) {
+	if s == nil {
+		return
+	}
+	if s.members != nil {
+		for member := range s.members {
+			if !fn(member) {
+				return
+			}
+		}
+		return
+	}
+	for _, n := range s.ints {
+		if !fn(strconv.FormatInt(n, 10)) {
+			return
+		}
+	}
+}
+
+// encoding returns the name of the encoding as OBJECT ENCODING reports it.
+func (s *setValue) encoding() string {
+	if s != nil && s.members != nil {
+		return "hashtable"
+	}
+	return "intset"
+}
+
+// convert moves the members of an intset into a map.
+func (s *setValue) convert() {
+	s.members = make(map[string]struct{}, len(s.ints)+1)
+	for _, n := range s.ints {
+		s.members[strconv.FormatInt(n, 10)] = struct{}{}
+	}
+	s.ints = nil
+}
+
+// writableSet returns the members of the set stored at key, creating an
+// empty set when the key does not exist, or false when the key holds
+// another type. A set created this way must get a member before keyspaceMu,
+// which must be held for writing, is released.
+func writableSet(key string) (*setValue, bool) {
+	obj, ok := keyspace[key]
+	if ok {
+		return obj.set(), obj.typ == setObject
+	}
+	obj = object{typ: setObject, value: &setValue{}}
+	setKey(key, obj)
+	markKeyspaceChanged()
+	return obj.set(), true
+}
+
+// readSet calls read with the members of the set stored at key, nil when
+// the key does not exist, while holding keyspaceMu for reading. It replies
+// WRONGTYPE instead when the key holds another type.
+func readSet(key string, read func(members *setValue) Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != setObject {
+		return wrongTypeError
+	}
+	return read(obj.set())
+}
+
+// memberReply lists members in reply order.
+func memberReply(members *setValue) Value {
+	names := make([]string, 0, members.len())
+	members.each(func(member string) {
+		names = append(names, member)
+	})
+	reply := []Value{}
+	for _, name := range replyOrder(names) {
+		reply = append(reply, Value{typ: "bulk", bulk: name})
+	}
+	return Value{typ: "array", array: reply}
+}
+
+// sadd handles SADD key member [member ...], replying how many of the
+// members were not in the set yet.
+func sadd(args []Value) Value {
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	members, ok := writableSet(args[0].bulk)
+	if !ok {
+		return wrongTypeError
+	}
+	added := 0
+	for _, arg := range args[1:] {
+		if members.add(arg.bulk) {
+			added++
+		}
+	}
+	return Value{typ: "integer", num: added}
+}
+
+// srem handles SREM key member [member ...], replying how many of the
+// members were in the set. The set is deleted once its last member is gone.
+func srem(args []Value) Value {
+	key := args[0].bulk
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != setObject {
+		return wrongTypeError
+	}
+	removed := 0
+	for _, arg := range args[1:] {
+		if obj.set().remove(arg.bulk) {
+			removed++
+		}
+	}
+	dropIfEmpty(key)
+	return Value{typ: "integer", num: removed}
+}
+
+// smembers handles SMEMBERS key.
+func smembers(args []Value) Value {
+	return readSet(args[0].bulk, memberReply)
+}
+
+// sismember handles SISMEMBER key member, replying 1 when member is in the
+// set.
+func sismember(args []Value) Value {
+	return readSet(args[0].bulk, func(members *setValue) Value {
+		return Value{typ: "integer", num: int(boolToUint(members.has(args[1].bulk)))}
+	})
+}
+
+// smismember handles SMISMEMBER key member [member ...], replying 1 or 0
+// for every member depending on whether it is in the set.
+func smismember(args []Value) Value {
+	return readSet(args[0].bulk, func(members *setValue) Value {
+		found := make([]Value, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			found = append(found, Value{typ: "integer", num: int(boolToUint(members.has(arg.bulk)))})
+		}
+		return Value{typ: "array", array: found}
+	})
+}
+
+// smove handles SMOVE source destination member, moving member from one
+// set to another in one step. It replies 1 when member was moved and 0
+// when it is not in source. Both keys are checked for their type first, so
+// a failed move changes nothing.
+func smove(args []Value) Value {
+	source, destination, member := args[0].bulk, args[1].bulk, args[2].bulk
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	from, ok := keyspace[source]
+	if ok && from.typ != setObject {
+		return wrongTypeError
+	}
+	if to, ok := keyspace[destination]; ok && to.typ != setObject {
+		return wrongTypeError
+	}
+	if !from.set().has(member) {
+		return Value{typ: "integer", num: 0}
+	}
+	if source == destination {
+		return Value{typ: "integer", num: 1}
+	}
+	from.set().remove(member)
+	dropIfEmpty(source)
+	members, _ := writableSet(destination)
+	members.add(member)
+	return Value{typ: "integer", num: 1}
+}
+
+// scard handles SCARD key, replying the number of members, 0 for a missing
+// set.
+func scard(args []Value) Value {
+	return readSet(args[0].bulk, func(members *setValue) Value {
+		return Value{typ: "integer", num: members.len()}
+	})
+}
+
+// setOperation is one of the set algebra operations.
+type setOperation int
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+// setsAt returns the sets stored at keys, nil for missing keys, or false
+// when one of the keys holds another type. keyspaceMu must be held.
+func setsAt(keys []string) ([]*setValue, bool) {
+	sets := make([]*setValue, len(keys))
+	for i, key := range keys {
+		obj, ok := keyspace[key]
+		if ok && obj.typ != setObject {
+			return nil, false
+		}
+		sets[i] = obj.set()
+	}
+	return sets, true
+}
+
+// combineSets computes the intersection, union or difference of sets.
+// The intersection walks the smallest set and the difference the first.
+func combineSets(op setOperation, sets []*setValue) *setValue {
+	result := &setValue{}
+	switch op {
+	case setInter:
+		smallest := sets[0]
+		for _, s := range sets[1:] {
+			if s.len() < smallest.len() {
+				smallest = s
+			}
+		}
+		smallest.each(func(member string) {
+			for _, s := range sets {
+				if !s.has(member) {
+					return
+				}
+			}
+			result.add(member)
+		})
+	case setUnion:
+		for _, s := range sets {
+			s.each(func(member string) {
+				result.add(member)
+			})
+		}
+	case setDiff:
+		sets[0].each(func(member string) {
+			for _, s := range sets[1:] {
+				if s.has(member) {
+					return
+				}
+			}
+			result.add(member)
+		})
+	}
+	return result
+}
+
+// interCard counts the members common to all sets, walking the smallest
+// one and stopping at limit when it is greater than 0.
+func interCard(sets []*setValue, limit int) int {
+	smallest := sets[0]
+	for _, s := range sets[1:] {
+		if s.len() < smallest.len() {
+			smallest = s
+		}
+	}
+	n := 0
+	smallest.eachWhile(func(member string) bool {
+		if limit > 0 && n == limit {
+			return false
+		}
+		for _, s := range sets {
+			if !s.has(member) {
+				return true
+			}
+		}
+		n++
+		return true
+	})
+	return n
+}
+
+// setAlgebra returns the handler of SINTER, SUNION or SDIFF key [key ...],
+// replying the members of the result.
+func setAlgebra(op setOperation) func([]Value) Value {
+	return func(args []Value) Value {
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = arg.bulk
+		}
+
+		keyspaceMu.RLock()
+		defer keyspaceMu.RUnlock()
+
+		sets, ok := setsAt(keys)
+		if !ok {
+			return wrongTypeError
+		}
+		return memberReply(combineSets(op, sets))
+	}
+}
+
+// setAlgebraStore returns the handler of SINTERSTORE, SUNIONSTORE or
+// SDIFFSTORE destination key [key ...], storing the result at destination
+// and replying its number of members.
+func setAlgebraStore(op setOperation) func([]Value) Value {
+	return func(args []Value) Value {
+		destination := args[0].bulk
+		keys := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			keys[i] = arg.bulk
+		}
+
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		sets, ok := setsAt(keys)
+		if !ok {
+			return wrongTypeError
+		}
+		result := combineSets(op, sets)
+		// the destination may be one of the sources, so it is only
+		// replaced once the result is computed; its expiry goes with it
+		deleteKey(destination)
+		if result.len() > 0 {
+			setKey(destination, object{typ: setObject, value: result})
+			markKeyspaceChanged()
+		}
+		return Value{typ: "integer", num: result.len()}
+	}
+}
+
+// sintercard handles SINTERCARD numkeys key [key ...] [LIMIT limit],
+// replying the size of the intersection of the sets, capped at limit.
+func sintercard(args []Value) Value {
+	numKeys, err := strconv.Atoi(args[0].bulk)
+	if err != nil || numKeys <= 0 {
+		return Value{typ: "error", str: "ERR numkeys should be greater than 0"}
+	}
+	if numKeys >= len(args) {
+		return Value{typ: "error", str: "ERR Number of keys can't be greater than number of args"}
+	}
+	keys := []string{}
+	for _, arg := range args[1 : numKeys+1] {
+		keys = append(keys, arg.bulk)
+	}
+
+	limit := 0
+	switch rest := args[numKeys+1:]; {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.EqualFold(rest[0].bulk, "LIMIT"):
+		limit, err = strconv.Atoi(rest[1].bulk)
+		if err != nil || limit < 0 {
+			return Value{typ: "error", str: "ERR LIMIT can't be negative"}
+		}
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	sets, ok := setsAt(keys)
+	if !ok {
+		return wrongTypeError
+	}
+	return Value{typ: "integer", num: interCard(sets, limit)}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// shutdown handles SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE]. On success the
+// process exits and the client sees its connection closed; an error is only
+// returned when the final save failed and FORCE was not given.
+func shutdown(args []Value) Value {
+	save, nosave, force := false, false, false
+	for _, arg := range args {
+		switch strings.ToUpper(arg.bulk) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			nosave = true
+		case "FORCE":
+			force = true
+		case "NOW":
+			// there is no replica to wait for, NOW is accepted for
+			// compatibility and changes nothing
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	if save && nosave {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	serverLog(logWarning, "User requested shutdown...")
+	if err := prepareForShutdown(save, nosave); err != nil && !force {
+		serverLog(logWarning, "Errors trying to shut down the server. Check the logs for more information.")
+		return Value{typ: "error", str: "ERR Errors trying to SHUTDOWN. Check logs."}
+	}
+
+	serverLog(logWarning, "gostore is now ready to exit, bye bye...")
+	os.Exit(0)
+	return Value{}
+}
+
+// prepareForShutdown makes sure nothing is lost when the process exits. The
+// AOF is flushed to disk, and a final snapshot is written when SAVE was given
+// or save rules are configured, unless NOSAVE was given.
+func prepareForShutdown(save, nosave bool) error {
+	var errs []error
+
+	if aof != nil {
+		serverLog(logNotice, "Calling fsync() on the AOF file.")
+		if err := aof.Sync(); err != nil {
+			serverLog(logWarning, "Error flushing the AOF: %v", err)
+			errs = append(errs, err)
+		}
+	}
+
+	saveMu.Lock()
+	hasRules := len(saveRules) > 0
+	saveMu.Unlock()
+
+	if save || (hasRules && !nosave) {
+		serverLog(logNotice, "Saving the final snapshot before exiting.")
+		if err := saveSnapshot(); err != nil {
+			serverLog(logWarning, "Error trying to save the DB, can't exit: %v", err)
+			errs = append(errs, err)
+		} else {
+			serverLog(logNotice, "DB saved on disk")
+		}
+	}
+
+	return errors.Join(errs...)
+}
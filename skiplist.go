@@ -0,0 +1,225 @@
+// Sorted set encodings.
+//
+// A small sorted set keeps its members in a single slice ordered by score,
+// like Redis' listpack encoding: looking up a member scans the slice, which
+// for a few dozen members is faster than hashing and costs no map. Once a
+// sorted set holds more than zset-max-listpack-entries members, or a member
+// longer than zset-max-listpack-value bytes, it is converted for good to a
+// skiplist next to a map from members to scores, again like Redis. The map
+// answers ZSCORE in O(1), and every node of the skiplist records how many
+// members its links skip, so finding a rank, the member at a rank or the
+// start of a score range takes O(log n) without ever sorting, and a range
+// is then removed or read by following the links.
+package main
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// zsetMaxListpackEntries and zsetMaxListpackValue are the size limits of
+// the compact encoding. Changing them only affects sorted sets as they
+// grow.
+var (
+	zsetMaxListpackEntries atomic.Int64
+	zsetMaxListpackValue   atomic.Int64
+)
+
+func init() {
+	zsetMaxListpackEntries.Store(128)
+	zsetMaxListpackValue.Store(64)
+}
+
+// skiplistMaxLevel bounds the number of levels of a node, enough for 4^32
+// members at the promotion probability of 1/4.
+const skiplistMaxLevel = 32
+
+// skiplist is a skiplist of sorted set entries in ascending order.
+type skiplist struct {
+	// header is a sentinel holding the first link of every level
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	// level is the number of levels in use
+	level int
+}
+
+// skiplistNode holds one entry and its links.
+type skiplistNode struct {
+	entry zsetEntry
+	// backward is the previous node, nil for the first one
+	backward *skiplistNode
+	levels   []skiplistLink
+}
+
+// skiplistLink is the link of a node to the next node on one level.
+type skiplistLink struct {
+	forward *skiplistNode
+	// span is the number of positions the link advances
+	span int
+}
+
+// newSkiplist returns an empty skiplist.
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{levels: make([]skiplistLink, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// randomLevel returns the level of a new node, each further level taken
+// with a probability of 1/4.
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.IntN(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// insert adds an entry, which must not be in the skiplist yet.
+func (sl *skiplist) insert(entry zsetEntry) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	// find the last node before the entry on every level and its position
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && zsetLess(x.levels[i].forward.entry, entry) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skiplistNode{entry: entry, levels: make([]skiplistLink, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		// the new node splits the span of the link it was inserted into
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	// links above the node skip one more position
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// unlink removes node x, given the last node before it on every level.
+func (sl *skiplist) unlink(x *skiplistNode, update *[skiplistMaxLevel]*skiplistNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// remove removes an entry, reporting whether it was in the skiplist.
+func (sl *skiplist) remove(entry zsetEntry) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && zsetLess(x.levels[i].forward.entry, entry) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+	if x == nil || x.entry != entry {
+		return false
+	}
+	sl.unlink(x, &update)
+	return true
+}
+
+// removeRange removes the entries from position start up to end,
+// exclusive, and returns them.
+func (sl *skiplist) removeRange(start, end int) []zsetEntry {
+	var update [skiplistMaxLevel]*skiplistNode
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= start {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	// the removed nodes follow each other, so the nodes before the first
+	// one stay the nodes before the next
+	removed := make([]zsetEntry, 0, end-start)
+	x = x.levels[0].forward
+	for ; x != nil && len(removed) < end-start; x = x.levels[0].forward {
+		sl.unlink(x, &update)
+		removed = append(removed, x.entry)
+	}
+	return removed
+}
+
+// firstIndex returns the position of the first entry for which pred is
+// true, or the length when there is none. pred must be false for a prefix
+// of the entries and true for the rest, like for sort.Search.
+func (sl *skiplist) firstIndex(pred func(zsetEntry) bool) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !pred(x.levels[i].forward.entry) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+	return rank
+}
+
+// at returns the node at a position, which must be below the length.
+func (sl *skiplist) at(position int) *skiplistNode {
+	traversed := -1
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= position {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == position {
+			return x
+		}
+	}
+	return nil
+}
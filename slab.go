@@ -0,0 +1,205 @@
+// Slab allocation for small string values.
+//
+// A keyspace holding tens of millions of tiny values turns every value into a
+// separate heap object the Go garbage collector has to track. The slab
+// allocator instead copies small values into large byte slabs divided into
+// fixed-size slots (one size class per power of two). A slab is a single
+// pointer-free allocation, so the collector sees a handful of big objects
+// rather than millions of small ones.
+//
+// Slots are recycled through a free list per size class when a value is
+// overwritten or deleted. Because a freed slot can be handed out again right
+// away, a string backed by a slab must never escape the lock protecting the
+// keyspace; readers take a private copy with slabLoad before releasing it.
+package main
+
+import (
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// slabEnabled turns slab storage of small string values on.
+var slabEnabled = false
+
+// slabPageSize is the size of a single slab in bytes.
+const slabPageSize = 1 << 20
+
+// slabClassSizes are the slot sizes of the size classes. Values longer than
+// the largest class are stored as regular Go strings.
+var slabClassSizes = []int{16, 32, 64, 128, 256}
+
+// slabPage is one slab together with the address of its first byte, which is
+// used to find the page a stored string belongs to.
+type slabPage struct {
+	base uintptr
+	mem  []byte
+}
+
+// slabClass manages the slabs of a single slot size.
+type slabClass struct {
+	size int
+	// pages are kept sorted by base address
+	pages []*slabPage
+	// free holds the addresses of released slots ready for reuse
+	free []uintptr
+	// carving is the page new slots are cut from once free is empty
+	carving *slabPage
+	// next is the offset of the first never-used slot in carving
+	next int
+	// used is the number of slots currently holding a value
+	used int
+	// requested is the sum of the lengths of the values stored in the
+	// used slots, the difference to used*size is internal fragmentation
+	requested int
+}
+
+// slabClasses holds one slabClass per entry of slabClassSizes.
+var slabClasses = newSlabClasses()
+
+// slabMu guards slabClasses. It is always taken after keyspaceMu.
+var slabMu = rwLock{name: "slab"}
+
+func newSlabClasses() []*slabClass {
+	classes := make([]*slabClass, len(slabClassSizes))
+	for i, size := range slabClassSizes {
+		classes[i] = &slabClass{size: size, next: slabPageSize}
+	}
+	return classes
+}
+
+// slabClassFor returns the smallest class able to hold n bytes, or nil when n
+// is too large for slab storage.
+func slabClassFor(n int) *slabClass {
+	for _, c := range slabClasses {
+		if n <= c.size {
+			return c
+		}
+	}
+	return nil
+}
+
+// slabAlloc copies s into a free slot and returns a string backed by that
+// slot. Empty strings, long strings and calls made while slab storage is
+// disabled return s unchanged.
+func slabAlloc(s string) string {
+	if !slabEnabled || len(s) == 0 {
+		return s
+	}
+	c := slabClassFor(len(s))
+	if c == nil {
+		return s
+	}
+
+	slabMu.Lock()
+	defer slabMu.Unlock()
+
+	var slot uintptr
+	if n := len(c.free); n > 0 {
+		slot = c.free[n-1]
+		c.free = c.free[:n-1]
+	} else {
+		// carve a new slot out of the last page, adding a page when full
+		if c.next+c.size > slabPageSize {
+			mem := make([]byte, slabPageSize)
+			page := &slabPage{base: uintptr(unsafe.Pointer(&mem[0])), mem: mem}
+			c.pages = append(c.pages, page)
+			sort.Slice(c.pages, func(i, j int) bool { return c.pages[i].base < c.pages[j].base })
+			c.carving = page
+			c.next = 0
+		}
+		slot = c.carving.base + uintptr(c.next)
+		c.next += c.size
+	}
+
+	page := c.pageOf(slot)
+	off := int(slot - page.base)
+	copy(page.mem[off:off+c.size], s)
+	c.used++
+	c.requested += len(s)
+
+	return unsafe.String(&page.mem[off], len(s))
+}
+
+// pageOf returns the page containing addr, or nil when addr is not slab memory.
+func (c *slabClass) pageOf(addr uintptr) *slabPage {
+	i := sort.Search(len(c.pages), func(i int) bool { return c.pages[i].base+slabPageSize > addr })
+	if i < len(c.pages) && c.pages[i].base <= addr {
+		return c.pages[i]
+	}
+	return nil
+}
+
+// slabFree returns the slot backing s to its class. Strings that do not live
+// in slab memory are ignored, so it is safe to call on any stored value.
+func slabFree(s string) {
+	if len(s) == 0 {
+		return
+	}
+	c := slabClassFor(len(s))
+	if c == nil {
+		return
+	}
+
+	addr := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+
+	slabMu.Lock()
+	defer slabMu.Unlock()
+
+	if c.pageOf(addr) == nil {
+		return
+	}
+	c.free = append(c.free, addr)
+	c.used--
+	c.requested -= len(s)
+}
+
+// inSlab reports whether s is backed by slab memory.
+func inSlab(s string) bool {
+	c := slabClassFor(len(s))
+	if len(s) == 0 || c == nil {
+		return false
+	}
+
+	slabMu.RLock()
+	defer slabMu.RUnlock()
+
+	return c.pageOf(uintptr(unsafe.Pointer(unsafe.StringData(s)))) != nil
+}
+
+// slabLoad returns a copy of s that stays valid after the slot backing it is
+// reused. Values read out of the keyspace go through it before the keyspace
+// lock is released.
+func slabLoad(s string) string {
+	if !slabEnabled {
+		return s
+	}
+	return strings.Clone(s)
+}
+
+// slabClassStats describes the memory use of one size class.
+type slabClassStats struct {
+	size      int
+	pages     int
+	used      int
+	free      int
+	requested int
+}
+
+// slabStats reports per-class usage for MEMORY STATS.
+func slabStats() []slabClassStats {
+	slabMu.Lock()
+	defer slabMu.Unlock()
+
+	stats := make([]slabClassStats, 0, len(slabClasses))
+	for _, c := range slabClasses {
+		stats = append(stats, slabClassStats{
+			size:      c.size,
+			pages:     len(c.pages),
+			used:      c.used,
+			free:      len(c.free),
+			requested: c.requested,
+		})
+	}
+	return stats
+}
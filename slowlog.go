@@ -0,0 +1,147 @@
+// The slow log records commands whose execution took longer than the
+// configured threshold, so operators can find out what made the server slow.
+// Each entry carries the trace ID the client attached with CLIENT TRACEID,
+// which ties a slow command back to the application request that issued it.
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slowlogSlowerThan is the execution time in microseconds above which a
+// command is logged. A negative value disables the slow log, 0 logs every
+// command.
+var slowlogSlowerThan atomic.Int64
+
+// slowlogMaxLen is the number of entries kept, older ones are dropped.
+var slowlogMaxLen atomic.Int64
+
+func init() {
+	slowlogSlowerThan.Store(10000)
+	slowlogMaxLen.Store(128)
+}
+
+// slowlogMaxArgs and slowlogMaxArgLen bound how much of a command is kept,
+// like in Redis.
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a single logged command.
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+	traceID  string
+}
+
+// slowlog holds the entries, newest first.
+var slowlog = []slowlogEntry{}
+
+// slowlogNextID is the id of the next entry.
+var slowlogNextID int64
+
+// slowlogMu guards slowlog and slowlogNextID.
+var slowlogMu = sync.Mutex{}
+
+// slowlogPush records the command if it ran for longer than the threshold.
+func slowlogPush(c *Client, value Value, duration time.Duration) {
+	threshold := slowlogSlowerThan.Load()
+	if threshold < 0 || duration < time.Duration(threshold)*time.Microsecond {
+		return
+	}
+
+	args := []string{}
+	for i, arg := range value.array {
+		if i == slowlogMaxArgs-1 && len(value.array) > slowlogMaxArgs {
+			args = append(args, "... ("+strconv.Itoa(len(value.array)-slowlogMaxArgs+1)+" more arguments)")
+			break
+		}
+		s := arg.bulk
+		if len(s) > slowlogMaxArgLen {
+			s = s[:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(s)-slowlogMaxArgLen) + " more bytes)"
+		}
+		args = append(args, s)
+	}
+
+	slowlogMu.Lock()
+	defer slowlogMu.Unlock()
+
+	entry := slowlogEntry{
+		id:       slowlogNextID,
+		time:     time.Now(),
+		duration: duration,
+		args:     args,
+		addr:     c.addr,
+		name:     c.name,
+		traceID:  c.traceID,
+	}
+	slowlogNextID++
+	slowlog = append([]slowlogEntry{entry}, slowlog...)
+	if max := int(slowlogMaxLen.Load()); len(slowlog) > max {
+		slowlog = slowlog[:max]
+	}
+}
+
+// slowlogCommand handles SLOWLOG GET [count], SLOWLOG LEN and SLOWLOG RESET.
+func slowlogCommand(args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'slowlog' command"}
+	}
+
+	slowlogMu.Lock()
+	defer slowlogMu.Unlock()
+
+	switch strings.ToUpper(args[0].bulk) {
+	case "GET":
+		count := 10
+		if len(args) > 2 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'slowlog|get' command"}
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1].bulk)
+			if err != nil || n < -1 {
+				return Value{typ: "error", str: "ERR count should be greater than or equal to -1"}
+			}
+			count = n
+		}
+		if count == -1 || count > len(slowlog) {
+			count = len(slowlog)
+		}
+
+		entries := []Value{}
+		for _, e := range slowlog[:count] {
+			argv := []Value{}
+			for _, arg := range e.args {
+				argv = append(argv, Value{typ: "bulk", bulk: arg})
+			}
+			// the first six fields are the Redis entry layout, the
+			// trace ID is appended as a gostore extension
+			entries = append(entries, Value{typ: "array", array: []Value{
+				{typ: "integer", num: int(e.id)},
+				{typ: "integer", num: int(e.time.Unix())},
+				{typ: "integer", num: int(e.duration.Microseconds())},
+				{typ: "array", array: argv},
+				{typ: "bulk", bulk: e.addr},
+				{typ: "bulk", bulk: e.name},
+				{typ: "bulk", bulk: e.traceID},
+			}})
+		}
+		return Value{typ: "array", array: entries}
+	case "LEN":
+		return Value{typ: "integer", num: len(slowlog)}
+	case "RESET":
+		slowlog = []slowlogEntry{}
+		return Value{typ: "string", str: "OK"}
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try SLOWLOG HELP."}
+	}
+}
@@ -0,0 +1,319 @@
+// Point-in-time snapshots of the dataset.
+//
+// While the AOF logs every write as it happens, a snapshot captures the whole
+// dataset at a single moment. Snapshots are written in the same RESP format as
+// the AOF, as the shortest list of commands that rebuilds the dataset, so the
+// same replay code loads both and the files stay human-readable.
+//
+// Snapshots are taken on demand with SAVE/BGSAVE and automatically according
+// to the save rules: "save 3600 1 300 100" saves when at least one change
+// happened in the last hour or at least 100 changes in the last five minutes.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// saveRule triggers a snapshot once at least changes writes happened and
+// seconds have passed since the last successful save.
+type saveRule struct {
+	seconds int
+	changes int
+}
+
+// saveRules are the active save rules, an empty list disables automatic saves.
+var saveRules []saveRule
+
+// dbfilename is the name of the snapshot file inside dir.
+var dbfilename = "dump.db"
+
+// dir is the working directory snapshots are written to.
+var dir = "."
+
+// saveMu guards saveRules, dbfilename and dir.
+var saveMu = sync.Mutex{}
+
+// dirty counts the writes since the last successful save.
+var dirty atomic.Int64
+
+// lastSave is the unix time of the last successful save.
+var lastSave atomic.Int64
+
+// bgsaveInProgress is set while a background save is running.
+var bgsaveInProgress atomic.Bool
+
+// saveCronInterval is how often the save rules are evaluated.
+const saveCronInterval = time.Second
+
+func init() {
+	lastSave.Store(time.Now().Unix())
+}
+
+// parseSaveRules parses a "seconds changes [seconds changes ...]" string.
+func parseSaveRules(s string) ([]saveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save parameters")
+	}
+	rules := []saveRule{}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, errors.New("invalid save parameters")
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules, nil
+}
+
+// formatSaveRules renders save rules the way CONFIG GET save shows them.
+func formatSaveRules(rules []saveRule) string {
+	parts := []string{}
+	for _, r := range rules {
+		parts = append(parts, strconv.Itoa(r.seconds), strconv.Itoa(r.changes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// snapshotPath returns the path of the snapshot file.
+func snapshotPath() string {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	return filepath.Join(dir, dbfilename)
+}
+
+// datasetCommands returns the commands that rebuild the current dataset. The
+// data is copied while the keyspace lock is held, so the result is a
+// consistent point-in-time view that can be written out without blocking
+// other clients.
+func datasetCommands() []Value {
+	cmd := commandValue
+	commands := []Value{}
+
+	keyspaceMu.RLock()
+	for k, obj := range keyspace {
+		switch obj.typ {
+		case stringObject:
+			commands = append(commands, cmd("SET", k, slabLoad(obj.str)))
+		case hashObject:
+			obj.hash().each(func(f, v string) {
+				commands = append(commands, cmd("HSET", k, f, v))
+			})
+		case listObject:
+			for _, element := range obj.list().elements() {
+				commands = append(commands, cmd("RPUSH", k, element))
+			}
+		case setObject:
+			obj.set().each(func(member string) {
+				commands = append(commands, cmd("SADD", k, member))
+			})
+		case zsetObject:
+			obj.zset().each(func(member string, score float64) {
+				commands = append(commands, cmd("ZADD", k, formatScore(score), member))
+			})
+		case streamObject:
+			commands = append(commands, streamCommands(k, obj.stream())...)
+		case jsonObject:
+			commands = append(commands, cmd("JSON.SET", k, "$", obj.json().String()))
+		case bloomObject:
+			commands = append(commands, bloomCommands(k, obj.bloom())...)
+		case cuckooObject:
+			commands = append(commands, cuckooCommands(k, obj.cuckoo())...)
+		case timeSeriesObject:
+			commands = append(commands, timeSeriesCommands(k, obj.timeSeries())...)
+		}
+	}
+	keyspaceMu.RUnlock()
+
+	commands = append(commands, expireCommands()...)
+	commands = append(commands, idgenCommands()...)
+	commands = append(commands, sessionCommands()...)
+	commands = append(commands, searchCommands()...)
+
+	return commands
+}
+
+// commandValue builds the RESP array of a command from its arguments.
+func commandValue(args ...string) Value {
+	v := Value{typ: "array"}
+	for _, arg := range args {
+		v.array = append(v.array, Value{typ: "bulk", bulk: arg})
+	}
+	return v
+}
+
+// writeSnapshot writes commands to path. The data goes to a temporary file
+// that is synced and then renamed over path, so a crash mid-save never leaves
+// a truncated snapshot behind. When written by job j, nil otherwise, the
+// progress is reported to it and cancelling it abandons the save.
+func writeSnapshot(path string, commands []Value, j *job) error {
+	tmp := fmt.Sprintf("%s.temp-%d", path, os.Getpid())
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := NewWriter(f)
+	for i, c := range commands {
+		if j.cancelled() {
+			f.Close()
+			os.Remove(tmp)
+			return errJobCancelled
+		}
+		if err := w.Write(c); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		j.progress(int64(i+1), int64(len(commands)))
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// saveSnapshot takes a snapshot of the dataset and writes it to the snapshot
+// file, resetting the dirty counter by the writes it covers.
+func saveSnapshot() error {
+	changes := dirty.Load()
+	if err := writeSnapshot(snapshotPath(), datasetCommands(), nil); err != nil {
+		return err
+	}
+	dirty.Add(-changes)
+	lastSave.Store(time.Now().Unix())
+	return nil
+}
+
+// loadSnapshot replays the snapshot file at path. A missing file is not an
+// error, the server simply starts empty.
+func loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// an AOF may be loaded too, whose transactions are replayed whole
+	var r transactionReplay
+	defer r.finish()
+
+	reader := newrESP(f)
+	for {
+		value, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.replay(value)
+	}
+}
+
+// startBgsave starts a background save, failing when one is already running.
+func startBgsave() error {
+	if !bgsaveInProgress.CompareAndSwap(false, true) {
+		return errors.New("Background save already in progress")
+	}
+
+	// copy the dataset before returning so the snapshot reflects the
+	// moment BGSAVE was issued
+	changes := dirty.Load()
+	commands := datasetCommands()
+	path := snapshotPath()
+
+	j := startJob("bgsave", "save to "+path)
+	go func() {
+		defer bgsaveInProgress.Store(false)
+
+		err := writeSnapshot(path, commands, j)
+		j.finish(err)
+		if err != nil {
+			serverLog(logWarning, "Background saving error: %v", err)
+			return
+		}
+		dirty.Add(-changes)
+		lastSave.Store(time.Now().Unix())
+	}()
+
+	return nil
+}
+
+// saveCron evaluates the save rules once per second, starting a background
+// save when one of them is satisfied.
+func saveCron() {
+	for {
+		time.Sleep(saveCronInterval)
+
+		saveMu.Lock()
+		rules := saveRules
+		saveMu.Unlock()
+
+		elapsed := time.Now().Unix() - lastSave.Load()
+		for _, r := range rules {
+			if dirty.Load() >= int64(r.changes) && dirty.Load() > 0 && elapsed >= int64(r.seconds) {
+				// the copy is never taken in the middle of a transaction
+				backgroundGate.enter()
+				startBgsave()
+				backgroundGate.leave()
+				break
+			}
+		}
+	}
+}
+
+// save handles the SAVE command, saving the dataset synchronously.
+func save(args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'save' command"}
+	}
+	if bgsaveInProgress.Load() {
+		return Value{typ: "error", str: "ERR Background save already in progress"}
+	}
+	if err := saveSnapshot(); err != nil {
+		return Value{typ: "error", str: "ERR " + err.Error()}
+	}
+	return Value{typ: "string", str: "OK"}
+}
+
+// bgsave handles the BGSAVE command, saving the dataset in the background.
+func bgsave(args []Value) Value {
+	if len(args) > 1 || (len(args) == 1 && strings.ToUpper(args[0].bulk) != "SCHEDULE") {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	if err := startBgsave(); err != nil {
+		return Value{typ: "error", str: "ERR " + err.Error()}
+	}
+	return Value{typ: "string", str: "Background saving started"}
+}
+
+// lastsave handles the LASTSAVE command, returning the unix time of the last
+// successful save.
+func lastsave(args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lastsave' command"}
+	}
+	return Value{typ: "integer", num: int(lastSave.Load())}
+}
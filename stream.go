@@ -0,0 +1,1024 @@
+// Streams.
+//
+// A stream is an append-only log of entries, each a small set of field-value
+// pairs identified by an ID that only ever grows, the building block of event
+// pipelines:
+//
+//	XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+//	XLEN key
+//	XRANGE key start end [COUNT count]
+//	XREVRANGE key end start [COUNT count]
+//	XDEL key id [id ...]
+//	XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+//	XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+//	XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+//
+// An ID is made of the milliseconds time an entry was added at and a
+// sequence number telling apart the entries of the same millisecond, written
+// ms-seq. XADD generates it from the clock with *, or only the sequence
+// number with ms-*, and otherwise takes it as given as long as it is greater
+// than the ID of every entry added before, even those trimmed or deleted
+// since. MAXLEN keeps the newest threshold entries and MINID drops those with
+// an ID below threshold. Entries are removed exactly; ~ only lets LIMIT, by
+// default 10000, cap how many one XADD removes, where Redis would also keep
+// the entries sharing a node with the first one kept. XTRIM trims the same
+// way without adding an entry, and XDEL removes entries wherever they are,
+// remembering the greatest ID it removed so the lag of consumer groups is
+// not computed across the hole.
+//
+// Ranges are inclusive, - and + stand for the first and last possible ID and
+// a bound prefixed with ( is exclusive. A bound without a sequence number
+// starts at the first entry of its millisecond or ends at the last one.
+// Unlike other types, a stream is not deleted when its last entry is
+// trimmed, so the last ID is never handed out again. XSETID sets that last
+// ID, the number of entries ever added and the greatest ID deleted, it
+// exists to recreate a stream from a snapshot or the AOF.
+//
+// XREAD replies the entries following the given ID of every stream, $
+// standing for the last ID, so a consumer tails a stream by passing the
+// last ID it read each time. With BLOCK it waits up to that many
+// milliseconds, 0 meaning forever, when none of the streams has new
+// entries. Like a blocking list pop, the client joins the wait queue of
+// every stream, and XADD serves all the readers the new entry is new to
+// before it releases the keyspace lock. Nothing is taken from the stream,
+// so every reader gets the entry.
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID identifies a stream entry.
+type streamID struct {
+	ms, seq uint64
+}
+
+// maxStreamID is the largest possible ID, which + stands for.
+var maxStreamID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+// String formats the ID as ms-seq.
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// compare returns -1, 0 or 1 as id is less than, equal to or greater than
+// other.
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms || id.ms == other.ms && id.seq < other.seq:
+		return -1
+	case id == other:
+		return 0
+	}
+	return 1
+}
+
+// next returns the ID following id, false when id is the largest one.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{ms: id.ms, seq: id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{ms: id.ms + 1}, true
+	}
+	return id, false
+}
+
+// prev returns the ID preceding id, false when id is 0-0.
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{ms: id.ms, seq: id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{ms: id.ms - 1, seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// invalidStreamIDError is the reply to an ID that does not parse.
+var invalidStreamIDError = Value{typ: "error", str: "ERR Invalid stream ID specified as stream command argument"}
+
+// parseStreamID parses an ID written ms-seq, or ms alone, in which case the
+// sequence number is missingSeq. - and + stand for the smallest and the
+// largest ID unless strict is set.
+func parseStreamID(arg string, missingSeq uint64, strict bool) (streamID, bool) {
+	if !strict {
+		switch arg {
+		case "-":
+			return streamID{}, true
+		case "+":
+			return maxStreamID, true
+		}
+	}
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms: ms, seq: missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms: ms, seq: seq}, true
+}
+
+// streamEntry is an entry of a stream.
+type streamEntry struct {
+	id streamID
+	// fields holds the field names and their values in turn
+	fields []string
+}
+
+// streamValue holds the entries of a stream in ascending ID order. Trimming
+// drops the oldest entries by slicing them off, and appending moves the
+// remaining ones to a new array once the old one is full, so a stream capped
+// with MAXLEN does not keep growing. The read methods treat a nil stream as
+// empty.
+type streamValue struct {
+	entries []streamEntry
+	// lastID is the ID of the newest entry ever added, which new IDs must
+	// be greater than
+	lastID streamID
+	// entriesAdded counts the entries ever added, trimmed ones included
+	entriesAdded int64
+	// maxDeletedID is the greatest ID ever removed by XDEL, 0-0 for none
+	maxDeletedID streamID
+	// groups maps the names of the consumer groups to their state
+	groups map[string]*streamGroup
+}
+
+// len returns the number of entries.
+func (s *streamValue) len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.entries)
+}
+
+// lastIDOrZero returns the last ID, 0-0 for a nil stream.
+func (s *streamValue) lastIDOrZero() streamID {
+	if s == nil {
+		return streamID{}
+	}
+	return s.lastID
+}
+
+// search returns the position of the first entry whose ID is not below id,
+// or the length when there is none.
+func (s *streamValue) search(id streamID) int {
+	if s == nil {
+		return 0
+	}
+	i, _ := slices.BinarySearchFunc(s.entries, id, func(e streamEntry, id streamID) int {
+		return e.id.compare(id)
+	})
+	return i
+}
+
+// between returns the entries with an ID from start to end, both included.
+func (s *streamValue) between(start, end streamID) []streamEntry {
+	if s == nil || start.compare(end) > 0 {
+		return nil
+	}
+	i := s.search(start)
+	j := i
+	for j < len(s.entries) && s.entries[j].id.compare(end) <= 0 {
+		j++
+	}
+	return s.entries[i:j]
+}
+
+// nextID returns the ID XADD generates for id, which is * or ms-*, or
+// false when no ID greater than the last one is left.
+func (s *streamValue) nextID(id string) (streamID, bool) {
+	if id == "*" {
+		now := uint64(max(nowMs(), 0))
+		if now > s.lastID.ms {
+			return streamID{ms: now}, true
+		}
+		return s.lastID.next()
+	}
+	ms, _ := strconv.ParseUint(strings.TrimSuffix(id, "-*"), 10, 64)
+	switch {
+	case ms > s.lastID.ms:
+		return streamID{ms: ms}, true
+	case ms == s.lastID.ms && s.lastID.seq < math.MaxUint64:
+		return streamID{ms: ms, seq: s.lastID.seq + 1}, true
+	}
+	return streamID{}, false
+}
+
+// add appends an entry, whose ID must be greater than the last one.
+func (s *streamValue) add(id streamID, fields []string) {
+	s.entries = append(s.entries, streamEntry{id: id, fields: fields})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// trimHead removes the n oldest entries.
+func (s *streamValue) trimHead(n int) {
+	// let the garbage collector have the fields before the array goes
+	clear(s.entries[:n])
+	s.entries = s.entries[n:]
+}
+
+// hasTombstones reports whether XDEL may have left holes in the stream
+// from id on, in which case counting entries no longer tells how many
+// were added in between.
+func (s *streamValue) hasTombstones(id streamID) bool {
+	if len(s.entries) == 0 || s.maxDeletedID == (streamID{}) || s.maxDeletedID.compare(s.entries[0].id) < 0 {
+		return false
+	}
+	return id.compare(s.maxDeletedID) <= 0
+}
+
+// streamTrim is a MAXLEN or MINID trimming option.
+type streamTrim struct {
+	// strategy is "MAXLEN" or "MINID", empty for no trimming
+	strategy string
+	maxlen   int
+	minid    streamID
+	// limit is the most entries removed at once, 0 for no limit
+	limit int
+}
+
+// streamTrimLimit is how many entries an approximate trim removes at most
+// without a LIMIT, like the 100 nodes of 100 entries of Redis.
+const streamTrimLimit = 10000
+
+// trim removes the entries beyond a MAXLEN or below a MINID and returns
+// their number.
+func (s *streamValue) trim(t streamTrim) int {
+	n := 0
+	switch t.strategy {
+	case "MAXLEN":
+		n = max(len(s.entries)-t.maxlen, 0)
+	case "MINID":
+		n = s.search(t.minid)
+	}
+	if t.limit > 0 {
+		n = min(n, t.limit)
+	}
+	s.trimHead(n)
+	return n
+}
+
+// parseStreamTrim parses the trimming option starting at args[i], MAXLEN or
+// MINID followed by an optional = or ~ and the threshold, into t and
+// returns the position of its last argument and whether it was
+// approximate.
+func parseStreamTrim(args []Value, i int, t *streamTrim) (int, bool, Value, bool) {
+	strategy := strings.ToUpper(args[i].bulk)
+	if t.strategy != "" && t.strategy != strategy {
+		return i, false, Value{typ: "error", str: "ERR syntax error, MAXLEN and MINID options at the same time are not compatible"}, false
+	}
+	t.strategy = strategy
+	approx := false
+	if i+2 < len(args) && (args[i+1].bulk == "~" || args[i+1].bulk == "=") {
+		approx = args[i+1].bulk == "~"
+		i++
+	}
+	i++
+	if strategy == "MINID" {
+		id, ok := parseStreamID(args[i].bulk, 0, false)
+		if !ok {
+			return i, false, invalidStreamIDError, false
+		}
+		t.minid = id
+		return i, approx, Value{}, true
+	}
+	maxlen, err := strconv.ParseInt(args[i].bulk, 10, 64)
+	if err != nil {
+		return i, false, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+	}
+	if maxlen < 0 {
+		return i, false, Value{typ: "error", str: "ERR The MAXLEN argument must be >= 0."}, false
+	}
+	t.maxlen = int(min(maxlen, math.MaxInt32))
+	return i, approx, Value{}, true
+}
+
+// parseStreamLimit parses the count of a LIMIT option.
+func parseStreamLimit(arg string) (int64, Value, bool) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+	}
+	if n < 0 {
+		return 0, Value{typ: "error", str: "ERR The LIMIT argument must be >= 0."}, false
+	}
+	return n, Value{}, true
+}
+
+// setLimit sets the limit of t to the LIMIT option when one was given,
+// or to the default one of an approximate trim. LIMIT requires ~.
+func (t *streamTrim) setLimit(approx, limited bool, limit int64) (Value, bool) {
+	if limited && !approx {
+		return Value{typ: "error", str: "ERR syntax error, LIMIT cannot be used without the special ~ option"}, false
+	}
+	switch {
+	case limited:
+		t.limit = int(min(limit, math.MaxInt32))
+	case approx:
+		t.limit = streamTrimLimit
+	}
+	return Value{}, true
+}
+
+// xaddArgs are the parsed arguments of XADD.
+type xaddArgs struct {
+	noMkStream bool
+	trim       streamTrim
+	// idIndex is the position of the ID among the arguments
+	idIndex int
+	// id is the explicit ID, unless auto is set
+	id streamID
+	// auto is set for the IDs * and ms-*
+	auto bool
+}
+
+// parseXadd parses the options, the ID and the fields of XADD.
+func parseXadd(args []Value) (xaddArgs, Value, bool) {
+	var parsed xaddArgs
+	approx, limited := false, false
+	limit := int64(0)
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		more := i+1 < len(args)
+		switch strings.ToUpper(args[i].bulk) {
+		case "NOMKSTREAM":
+			parsed.noMkStream = true
+		case "MAXLEN", "MINID":
+			if !more {
+				break options
+			}
+			end, isApprox, errReply, ok := parseStreamTrim(args, i, &parsed.trim)
+			if !ok {
+				return parsed, errReply, false
+			}
+			i, approx = end, isApprox
+		case "LIMIT":
+			if !more {
+				break options
+			}
+			n, errReply, ok := parseStreamLimit(args[i+1].bulk)
+			if !ok {
+				return parsed, errReply, false
+			}
+			limit, limited = n, true
+			i++
+		default:
+			break options
+		}
+	}
+	fields := len(args) - i - 1
+	if i >= len(args) || fields == 0 || fields%2 != 0 {
+		return parsed, arityError("XADD"), false
+	}
+	if errReply, ok := parsed.trim.setLimit(approx, limited, limit); !ok {
+		return parsed, errReply, false
+	}
+
+	parsed.idIndex = i
+	arg := args[i].bulk
+	if arg == "*" {
+		parsed.auto = true
+		return parsed, Value{}, true
+	}
+	if ms, ok := strings.CutSuffix(arg, "-*"); ok {
+		if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+			return parsed, invalidStreamIDError, false
+		}
+		parsed.auto = true
+		return parsed, Value{}, true
+	}
+	id, ok := parseStreamID(arg, 0, true)
+	if !ok {
+		return parsed, invalidStreamIDError, false
+	}
+	if id == (streamID{}) {
+		return parsed, Value{typ: "error", str: "ERR The ID specified in XADD must be greater than 0-0"}, false
+	}
+	parsed.id = id
+	return parsed, Value{}, true
+}
+
+// readStream calls read with the entries of the stream stored at key, nil
+// when the key does not exist, while holding keyspaceMu for reading. It
+// replies WRONGTYPE instead when the key holds another type.
+func readStream(key string, read func(s *streamValue) Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	return read(obj.stream())
+}
+
+// xadd handles XADD, replying the ID of the new entry, or null when the
+// stream does not exist and NOMKSTREAM was given.
+func xadd(args []Value) Value {
+	key := args[0].bulk
+	parsed, errReply, ok := parseXadd(args)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	if !exists && parsed.noMkStream {
+		return Value{typ: "null"}
+	}
+	s := obj.stream()
+	if s == nil {
+		s = &streamValue{}
+	}
+	if s.lastID == maxStreamID {
+		return Value{typ: "error", str: "ERR The stream has exhausted the last possible ID, unable to add more items"}
+	}
+	id := parsed.id
+	if parsed.auto {
+		id, ok = s.nextID(args[parsed.idIndex].bulk)
+	} else {
+		ok = id.compare(s.lastID) > 0
+	}
+	if !ok {
+		return Value{typ: "error", str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+	}
+
+	fields := make([]string, 0, len(args)-parsed.idIndex-1)
+	for _, arg := range args[parsed.idIndex+1:] {
+		fields = append(fields, arg.bulk)
+	}
+	s.add(id, fields)
+	s.trim(parsed.trim)
+	if !exists {
+		setKey(key, object{typ: streamObject, value: s})
+	}
+	markKeyspaceChanged()
+	serveStreamWaiters(key)
+	return Value{typ: "bulk", bulk: id.String()}
+}
+
+// xaddPropagate persists XADD with the ID it generated in place of * or
+// ms-*, so that replaying the AOF recreates the same entry.
+func xaddPropagate(value Value, result Value) Value {
+	if result.typ != "bulk" {
+		return Value{}
+	}
+	parsed, _, ok := parseXadd(value.array[1:])
+	if !ok {
+		return Value{}
+	}
+	rewritten := Value{typ: "array", array: slices.Clone(value.array)}
+	rewritten.array[parsed.idIndex+1] = Value{typ: "bulk", bulk: result.bulk}
+	return rewritten
+}
+
+// xlen handles XLEN key, replying the number of entries.
+func xlen(args []Value) Value {
+	return readStream(args[0].bulk, func(s *streamValue) Value {
+		return Value{typ: "integer", num: s.len()}
+	})
+}
+
+// xdel handles XDEL key id [id ...], replying how many of the entries
+// were in the stream. Consumer groups keep the deleted entries pending.
+func xdel(args []Value) Value {
+	key := args[0].bulk
+	ids := make([]streamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, ok := parseStreamID(arg.bulk, 0, true)
+		if !ok {
+			return invalidStreamIDError
+		}
+		ids = append(ids, id)
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	s := obj.stream()
+	deleted := 0
+	for _, id := range ids {
+		i := s.search(id)
+		if i == s.len() || s.entries[i].id != id {
+			continue
+		}
+		if i == 0 {
+			s.trimHead(1)
+		} else {
+			s.entries = slices.Delete(s.entries, i, i+1)
+		}
+		if id.compare(s.maxDeletedID) > 0 {
+			s.maxDeletedID = id
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		markKeyspaceChanged()
+		dropIfEmpty(key)
+	}
+	return Value{typ: "integer", num: deleted}
+}
+
+// xtrim handles XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count],
+// replying the number of entries removed.
+func xtrim(args []Value) Value {
+	key := args[0].bulk
+	var t streamTrim
+	approx, limited := false, false
+	limit := int64(0)
+	for i := 1; i < len(args); i++ {
+		more := i+1 < len(args)
+		switch strings.ToUpper(args[i].bulk) {
+		case "MAXLEN", "MINID":
+			if !more {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			end, isApprox, errReply, ok := parseStreamTrim(args, i, &t)
+			if !ok {
+				return errReply
+			}
+			i, approx = end, isApprox
+		case "LIMIT":
+			if !more {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			n, errReply, ok := parseStreamLimit(args[i+1].bulk)
+			if !ok {
+				return errReply
+			}
+			limit, limited = n, true
+			i++
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	if t.strategy == "" {
+		return Value{typ: "error", str: "ERR syntax error, XTRIM must be called with a trimming strategy"}
+	}
+	if errReply, ok := t.setLimit(approx, limited, limit); !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	if !exists {
+		return Value{typ: "integer", num: 0}
+	}
+	n := obj.stream().trim(t)
+	if n > 0 {
+		markKeyspaceChanged()
+		dropIfEmpty(key)
+	}
+	return Value{typ: "integer", num: n}
+}
+
+// parseRangeBound parses a bound of XRANGE, exclusive when prefixed with (.
+// A bound without a sequence number gets missingSeq.
+func parseRangeBound(arg string, missingSeq uint64) (streamID, bool, bool) {
+	if len(arg) > 1 && arg[0] == '(' {
+		id, ok := parseStreamID(arg[1:], missingSeq, true)
+		return id, true, ok
+	}
+	id, ok := parseStreamID(arg, missingSeq, false)
+	return id, false, ok
+}
+
+// streamEntryValue returns the reply for an entry, its ID followed by its
+// fields and values.
+func streamEntryValue(e streamEntry) Value {
+	fields := make([]Value, len(e.fields))
+	for i, f := range e.fields {
+		fields[i] = Value{typ: "bulk", bulk: f}
+	}
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: e.id.String()},
+		{typ: "array", array: fields},
+	}}
+}
+
+// xrangeCommand returns the handler of XRANGE key start end [COUNT count],
+// or with rev of XREVRANGE key end start [COUNT count], which replies the
+// entries from the newest.
+func xrangeCommand(rev bool) func([]Value) Value {
+	return func(args []Value) Value {
+		first, second := args[1].bulk, args[2].bulk
+		if rev {
+			first, second = second, first
+		}
+		start, startExcl, ok := parseRangeBound(first, 0)
+		if !ok {
+			return invalidStreamIDError
+		}
+		end, endExcl, ok := parseRangeBound(second, math.MaxUint64)
+		if !ok {
+			return invalidStreamIDError
+		}
+		if startExcl {
+			if start, ok = start.next(); !ok {
+				return Value{typ: "error", str: "ERR invalid start ID for the interval"}
+			}
+		}
+		if endExcl {
+			if end, ok = end.prev(); !ok {
+				return Value{typ: "error", str: "ERR invalid end ID for the interval"}
+			}
+		}
+
+		count := -1
+		for i := 3; i < len(args); i++ {
+			if !strings.EqualFold(args[i].bulk, "COUNT") || i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			count = int(max(min(n, math.MaxInt32), 0))
+			i++
+		}
+		if count == 0 {
+			return Value{typ: "nullarray"}
+		}
+
+		return readStream(args[0].bulk, func(s *streamValue) Value {
+			entries := s.between(start, end)
+			if count < 0 || count > len(entries) {
+				count = len(entries)
+			}
+			reply := Value{typ: "array", array: make([]Value, 0, count)}
+			for i := 0; i < count; i++ {
+				e := entries[i]
+				if rev {
+					e = entries[len(entries)-1-i]
+				}
+				reply.array = append(reply.array, streamEntryValue(e))
+			}
+			return reply
+		})
+	}
+}
+
+// xsetid handles XSETID key last-id [ENTRIESADDED entries-added]
+// [MAXDELETEDID max-deleted-id], setting the ID new entries must be
+// greater than, the number of entries ever added to the stream and the
+// greatest ID deleted from it.
+func xsetid(args []Value) Value {
+	key := args[0].bulk
+	id, ok := parseStreamID(args[1].bulk, 0, true)
+	if !ok {
+		return invalidStreamIDError
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID streamID
+	hasMaxDeletedID := false
+	for i := 2; i < len(args); i++ {
+		if i+1 == len(args) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		switch strings.ToUpper(args[i].bulk) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if n < 0 {
+				return Value{typ: "error", str: "ERR entries_added must be positive"}
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			deleted, ok := parseStreamID(args[i+1].bulk, 0, true)
+			if !ok {
+				return invalidStreamIDError
+			}
+			if id.compare(deleted) < 0 {
+				return Value{typ: "error", str: "ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id"}
+			}
+			maxDeletedID, hasMaxDeletedID = deleted, true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		i++
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	if !exists {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+	s := obj.stream()
+	if n := len(s.entries); n > 0 && id.compare(s.entries[n-1].id) < 0 {
+		return Value{typ: "error", str: "ERR The ID specified in XSETID is smaller than the target stream top item"}
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(len(s.entries)) {
+		return Value{typ: "error", str: "ERR The entries_added specified in XSETID is smaller than the target stream length"}
+	}
+	s.lastID = id
+	if entriesAdded >= 0 {
+		s.entriesAdded = entriesAdded
+	}
+	if hasMaxDeletedID {
+		s.maxDeletedID = maxDeletedID
+	}
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// streamCommands returns the commands recreating the stream stored at key:
+// an XADD per entry, an XSETID restoring the last ID and what XDEL left
+// behind, and the commands recreating its consumer groups. An empty stream is
+// created like Redis does, by an XADD trimmed away with MAXLEN 0.
+func streamCommands(key string, s *streamValue) []Value {
+	commands := []Value{}
+	if len(s.entries) == 0 {
+		commands = append(commands, commandValue("XADD", key, "MAXLEN", "0", "0-1", "x", "y"))
+	}
+	for _, e := range s.entries {
+		args := append([]string{"XADD", key, e.id.String()}, e.fields...)
+		commands = append(commands, commandValue(args...))
+	}
+	commands = append(commands, commandValue("XSETID", key, s.lastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(s.entriesAdded, 10), "MAXDELETEDID", s.maxDeletedID.String()))
+	return append(commands, groupCommands(key, s)...)
+}
+
+// after returns the entries with an ID greater than id, at most count of
+// them unless count is 0.
+func (s *streamValue) after(id streamID, count int) []streamEntry {
+	if s == nil {
+		return nil
+	}
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		i++
+	}
+	entries := s.entries[i:]
+	if count > 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+// streamWaiter is a client blocked in XREAD on one or more streams.
+type streamWaiter struct {
+	// keys are the streams the client waits on
+	keys []string
+	// serve returns the reply to the client, or false when the streams
+	// hold nothing new to it yet. It is called with keyspaceMu held for
+	// writing
+	serve func() (Value, bool)
+	// reply receives the reply once the client was served
+	reply chan Value
+}
+
+// streamWaiters maps stream keys to the clients waiting on them, in the
+// order they blocked. Like listWaiters it is guarded by keyspaceMu.
+var streamWaiters = map[string][]*streamWaiter{}
+
+// removeStreamWaiter takes a client out of the wait queues of all its
+// streams. keyspaceMu must be held for writing.
+func removeStreamWaiter(w *streamWaiter) {
+	for _, key := range w.keys {
+		queue := streamWaiters[key]
+		kept := queue[:0]
+		for _, other := range queue {
+			if other != w {
+				kept = append(kept, other)
+			}
+		}
+		if len(kept) == 0 {
+			delete(streamWaiters, key)
+		} else {
+			streamWaiters[key] = kept
+		}
+	}
+}
+
+// serveStreamWaiters replies to every client waiting on the stream at key
+// that has entries new to it, oldest first. XADD calls it with keyspaceMu
+// held for writing.
+func serveStreamWaiters(key string) {
+	for _, w := range slices.Clone(streamWaiters[key]) {
+		if reply, ok := w.serve(); ok {
+			removeStreamWaiter(w)
+			w.reply <- reply
+		}
+	}
+}
+
+// xreadArgs are the parsed arguments of XREAD and XREADGROUP.
+type xreadArgs struct {
+	// group and consumer are the names given to GROUP by XREADGROUP
+	group, consumer string
+	// noAck is set by the NOACK option of XREADGROUP
+	noAck bool
+	// count is the most entries replied per stream, 0 for no limit
+	count int
+	// block is set when the client waits, for timeout or forever when 0
+	block   bool
+	timeout time.Duration
+	keys    []string
+	// ids are the IDs the entries must follow, as given
+	ids []string
+}
+
+// parseXread parses the options of XREAD, or of XREADGROUP when group is
+// set, and the keys and IDs following STREAMS.
+func parseXread(args []Value, group bool) (xreadArgs, Value, bool) {
+	var parsed xreadArgs
+	name, symbol := "xread", "$"
+	if group {
+		name, symbol = "xreadgroup", ">"
+	}
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		if option == "STREAMS" {
+			break
+		}
+		if option == "NOACK" && group {
+			parsed.noAck = true
+			continue
+		}
+		if option == "GROUP" && i+2 < len(args) {
+			if !group {
+				return parsed, Value{typ: "error", str: "ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead."}, false
+			}
+			parsed.group, parsed.consumer = args[i+1].bulk, args[i+2].bulk
+			i += 2
+			continue
+		}
+		if i+1 == len(args) {
+			return parsed, Value{typ: "error", str: "ERR syntax error"}, false
+		}
+		switch option {
+		case "COUNT":
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return parsed, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+			}
+			parsed.count = int(max(min(n, math.MaxInt32), 0))
+		case "BLOCK":
+			ms, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return parsed, Value{typ: "error", str: "ERR timeout is not an integer or out of range"}, false
+			}
+			if ms < 0 {
+				return parsed, Value{typ: "error", str: "ERR timeout is negative"}, false
+			}
+			parsed.block = true
+			parsed.timeout = time.Duration(min(ms, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond
+		default:
+			return parsed, Value{typ: "error", str: "ERR syntax error"}, false
+		}
+		i++
+	}
+	if i == len(args) {
+		return parsed, Value{typ: "error", str: "ERR syntax error"}, false
+	}
+	streams := args[i+1:]
+	if len(streams)%2 != 0 {
+		return parsed, Value{typ: "error", str: "ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or '" + symbol + "' must be specified."}, false
+	}
+	if len(streams) == 0 {
+		return parsed, arityError(strings.ToUpper(name)), false
+	}
+	if group && parsed.group == "" {
+		return parsed, Value{typ: "error", str: "ERR Missing GROUP option for XREADGROUP"}, false
+	}
+	for j := 0; j < len(streams)/2; j++ {
+		parsed.keys = append(parsed.keys, streams[j].bulk)
+		parsed.ids = append(parsed.ids, streams[len(streams)/2+j].bulk)
+	}
+	return parsed, Value{}, true
+}
+
+// xreadKeys returns the keys of XREAD and XREADGROUP, the first half of the arguments
+// following STREAMS.
+func xreadKeys(args []Value) []string {
+	for i, arg := range args {
+		if strings.EqualFold(arg.bulk, "STREAMS") {
+			streams := args[i+1:]
+			keys := []string{}
+			for _, key := range streams[:len(streams)/2] {
+				keys = append(keys, key.bulk)
+			}
+			return keys
+		}
+	}
+	return nil
+}
+
+// xread handles XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key
+// ...] id [id ...], replying for every stream with entries following its
+// ID the name of the stream and the entries, or a null array when there
+// are none.
+func xread(c *Client, args []Value) Value {
+	parsed, errReply, ok := parseXread(args, false)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	ids := make([]streamID, len(parsed.keys))
+	for i, key := range parsed.keys {
+		obj, exists := keyspace[key]
+		if exists && obj.typ != streamObject {
+			keyspaceMu.Unlock()
+			return wrongTypeError
+		}
+		switch parsed.ids[i] {
+		case "$":
+			ids[i] = obj.stream().lastIDOrZero()
+			continue
+		case ">":
+			keyspaceMu.Unlock()
+			return Value{typ: "error", str: "ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option."}
+		}
+		id, ok := parseStreamID(parsed.ids[i], 0, true)
+		if !ok {
+			keyspaceMu.Unlock()
+			return invalidStreamIDError
+		}
+		ids[i] = id
+	}
+
+	read := func() (Value, bool) {
+		reply := Value{typ: "array"}
+		for i, key := range parsed.keys {
+			entries := keyspace[key].stream().after(ids[i], parsed.count)
+			if len(entries) == 0 {
+				continue
+			}
+			values := make([]Value, len(entries))
+			for j, e := range entries {
+				values[j] = streamEntryValue(e)
+			}
+			reply.array = append(reply.array, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: key},
+				{typ: "array", array: values},
+			}})
+		}
+		return reply, len(reply.array) > 0
+	}
+	if reply, ok := read(); ok || !parsed.block || !c.mayBlock() {
+		keyspaceMu.Unlock()
+		if !ok {
+			return Value{typ: "nullarray"}
+		}
+		return reply
+	}
+
+	w := &streamWaiter{keys: parsed.keys, serve: read, reply: make(chan Value, 1)}
+	for _, key := range parsed.keys {
+		// a stream named twice is waited on once
+		if !slices.Contains(streamWaiters[key], w) {
+			streamWaiters[key] = append(streamWaiters[key], w)
+		}
+	}
+	keyspaceMu.Unlock()
+
+	reply := awaitReply(c, parsed.timeout, w.reply, func() { removeStreamWaiter(w) }, Value{typ: "nullarray"})
+	// run again after a hot restart, $ would skip the entries added in the
+	// meantime, so the command, whose array args share, is given the IDs
+	// it stood for instead
+	if reply.typ == requeueReply.typ {
+		for i, id := range parsed.ids {
+			if id == "$" {
+				args[len(args)-len(parsed.ids)+i] = Value{typ: "bulk", bulk: ids[i].String()}
+			}
+		}
+	}
+	return reply
+}
@@ -0,0 +1,1018 @@
+// Stream consumer groups.
+//
+// A consumer group lets several workers share the entries of a stream, each
+// entry going to a single consumer of the group, and remembers every entry
+// delivered until the consumer acknowledges it, so that nothing is lost when
+// a worker dies halfway through:
+//
+//	XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+//	XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+//	XGROUP DESTROY key group
+//	XGROUP CREATECONSUMER key group consumer
+//	XGROUP DELCONSUMER key group consumer
+//	XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+//	XACK key group id [id ...]
+//	XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+//	XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+//	XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+//
+// A group starts after the ID given to XGROUP CREATE, $ for the last one.
+// XREADGROUP with the ID > delivers the entries added after the last one
+// the group got, adding them to its pending entries list under the name of
+// the consumer unless NOACK is given, and waits for new ones with BLOCK
+// like XREAD. With any other ID it reads the history of the consumer
+// instead: its pending entries following that ID, again, an entry deleted
+// from the stream since showing up with a null body. XACK removes entries
+// from the list once processed. XPENDING summarizes the list or, given a
+// range, lists its entries with their consumer, the milliseconds since
+// their last delivery and how often they were delivered. XCLAIM hands
+// pending entries idle for at least min-idle-time over to another
+// consumer, which is how the entries of a dead worker get processed.
+// XAUTOCLAIM does the same for the pending entries from start on, whoever
+// they belong to, claiming up to count of them, 100 by default, and
+// replies the ID to pass as start next time, 0-0 once the whole list was
+// scanned, so a worker can sweep the list in small steps.
+//
+// The pending entries of a group are kept in a slice ordered by ID, which
+// entries are mostly appended to, and consumers are created the first time
+// they read. XREADGROUP is logged to the AOF as a non-blocking XREADGROUP
+// counting what it delivered, so that replaying it delivers the same
+// entries, and XCLAIM as an XCLAIM of the entries it claimed at the time it
+// ran, XAUTOCLAIM as the XCLAIM of the same entries. Snapshots recreate groups like Redis rewrites its AOF, pending
+// entries included, with an XCLAIM FORCE each.
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// streamGroup is a consumer group of a stream.
+type streamGroup struct {
+	// lastID is the ID of the last entry delivered to the group
+	lastID streamID
+	// entriesRead is how many entries of the stream the group read up to
+	// lastID, -1 when unknown
+	entriesRead int64
+	// pending holds the entries delivered and not acknowledged yet, in
+	// ascending ID order
+	pending   []*pendingEntry
+	consumers map[string]*streamConsumer
+}
+
+// pendingEntry is an entry delivered to a consumer and not acknowledged
+// yet.
+type pendingEntry struct {
+	id       streamID
+	consumer *streamConsumer
+	// deliveryTime is when the entry was last delivered, in Unix
+	// milliseconds
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+// streamConsumer is a consumer of a group.
+type streamConsumer struct {
+	name string
+	// seenTime is when the consumer last read or claimed, and activeTime
+	// when it last got an entry that way, -1 for never, in Unix
+	// milliseconds
+	seenTime, activeTime int64
+	// pending is the number of entries pending for the consumer
+	pending int
+}
+
+// entriesReadCounter returns the number of entries the stream had when
+// the entry id was added, or -1 when it can not tell, like Redis does to
+// report the lag of a group.
+func (s *streamValue) entriesReadCounter(id streamID) int64 {
+	switch {
+	case s.entriesAdded == 0:
+		return 0
+	case len(s.entries) == 0 && id.compare(s.lastID) <= 0:
+		return s.entriesAdded
+	}
+	switch id.compare(s.lastID) {
+	case 0:
+		return s.entriesAdded
+	case 1:
+		return -1
+	}
+	if s.hasTombstones(streamID{}) {
+		// entries deleted after the first one make the count unknowable
+		return -1
+	}
+	switch id.compare(s.entries[0].id) {
+	case -1:
+		return s.entriesAdded - int64(len(s.entries))
+	case 0:
+		return s.entriesAdded - int64(len(s.entries)) + 1
+	}
+	return -1
+}
+
+// group returns the consumer group name, nil when the stream or the group
+// does not exist.
+func (s *streamValue) group(name string) *streamGroup {
+	if s == nil {
+		return nil
+	}
+	return s.groups[name]
+}
+
+// createGroup adds a consumer group starting after id, false when one of
+// that name exists. entriesRead below -1 is estimated from the ID.
+func (s *streamValue) createGroup(name string, id streamID, entriesRead int64) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	if entriesRead < -1 {
+		entriesRead = s.entriesReadCounter(id)
+	}
+	if s.groups == nil {
+		s.groups = map[string]*streamGroup{}
+	}
+	s.groups[name] = &streamGroup{lastID: id, entriesRead: entriesRead, consumers: map[string]*streamConsumer{}}
+	return true
+}
+
+// groupNames returns the names of the consumer groups in order.
+func (s *streamValue) groupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// consumerNames returns the names of the consumers in order.
+func (g *streamGroup) consumerNames() []string {
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// consumer returns the consumer name, created when it does not exist yet,
+// and whether it was created.
+func (g *streamGroup) consumer(name string) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &streamConsumer{name: name, seenTime: nowMs(), activeTime: -1}
+	g.consumers[name] = c
+	return c, true
+}
+
+// deleteConsumer removes a consumer and its pending entries, returning how
+// many it had.
+func (g *streamGroup) deleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	g.pending = slices.DeleteFunc(g.pending, func(p *pendingEntry) bool {
+		return p.consumer == c
+	})
+	delete(g.consumers, name)
+	return c.pending
+}
+
+// findPending returns the position of the pending entry id, or where it
+// would be inserted, and whether it is pending.
+func (g *streamGroup) findPending(id streamID) (int, bool) {
+	return slices.BinarySearchFunc(g.pending, id, func(p *pendingEntry, id streamID) int {
+		return p.id.compare(id)
+	})
+}
+
+// assign makes the entry id pending for consumer c, delivered at time,
+// moving it from the consumer it was pending for if any, and returns it.
+func (g *streamGroup) assign(id streamID, c *streamConsumer, time int64) *pendingEntry {
+	i, found := g.findPending(id)
+	if !found {
+		p := &pendingEntry{id: id, consumer: c, deliveryTime: time}
+		g.pending = slices.Insert(g.pending, i, p)
+		c.pending++
+		return p
+	}
+	p := g.pending[i]
+	if p.consumer != c {
+		p.consumer.pending--
+		p.consumer = c
+		c.pending++
+	}
+	p.deliveryTime = time
+	return p
+}
+
+// ack removes the entry id from the pending entries, reporting whether it
+// was pending.
+func (g *streamGroup) ack(id streamID) bool {
+	i, found := g.findPending(id)
+	if !found {
+		return false
+	}
+	g.pending[i].consumer.pending--
+	g.pending = slices.Delete(g.pending, i, i+1)
+	return true
+}
+
+// entry returns the entry id of the stream, false when it is not in the
+// stream.
+func (s *streamValue) entry(id streamID) (streamEntry, bool) {
+	i := s.search(id)
+	if i == len(s.entries) || s.entries[i].id != id {
+		return streamEntry{}, false
+	}
+	return s.entries[i], true
+}
+
+// noGroupError is the reply when the stream or the consumer group named by
+// a command does not exist.
+func noGroupError(key, group string) Value {
+	return Value{typ: "error", str: "NOGROUP No such key '" + key + "' or consumer group '" + group + "'"}
+}
+
+// parseEntriesRead parses the ENTRIESREAD option of XGROUP CREATE and
+// XGROUP SETID.
+func parseEntriesRead(arg string) (int64, Value, bool) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+	}
+	if n < -1 {
+		return 0, Value{typ: "error", str: "ERR value for ENTRIESREAD must be positive or -1"}, false
+	}
+	return n, Value{}, true
+}
+
+// xgroupArity maps the subcommands of XGROUP to their least and greatest
+// number of arguments, the subcommand included.
+var xgroupArity = map[string][2]int{
+	"CREATE":         {4, 7},
+	"SETID":          {4, 6},
+	"DESTROY":        {3, 3},
+	"CREATECONSUMER": {4, 4},
+	"DELCONSUMER":    {4, 4},
+}
+
+// xgroup handles the XGROUP subcommands, which manage the consumer groups
+// of a stream and their consumers.
+func xgroup(args []Value) Value {
+	sub := strings.ToUpper(args[0].bulk)
+	arity, ok := xgroupArity[sub]
+	if !ok {
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try XGROUP HELP."}
+	}
+	if len(args) < arity[0] || len(args) > arity[1] {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xgroup|" + strings.ToLower(sub) + "' command"}
+	}
+	key, name := args[1].bulk, args[2].bulk
+
+	mkStream := false
+	entriesRead := int64(-2)
+	if sub == "CREATE" || sub == "SETID" {
+		for i := 4; i < len(args); i++ {
+			switch {
+			case sub == "CREATE" && strings.EqualFold(args[i].bulk, "MKSTREAM"):
+				mkStream = true
+			case strings.EqualFold(args[i].bulk, "ENTRIESREAD") && i+1 < len(args):
+				n, errReply, ok := parseEntriesRead(args[i+1].bulk)
+				if !ok {
+					return errReply
+				}
+				entriesRead = n
+				i++
+			default:
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+		}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	s := obj.stream()
+	if !exists && !mkStream {
+		return Value{typ: "error", str: "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."}
+	}
+	g := s.group(name)
+	if g == nil && sub != "CREATE" && sub != "DESTROY" {
+		return Value{typ: "error", str: "NOGROUP No such consumer group '" + name + "' for key name '" + key + "'"}
+	}
+
+	switch sub {
+	case "CREATE", "SETID":
+		var id streamID
+		if args[3].bulk == "$" {
+			id = s.lastIDOrZero()
+		} else if id, ok = parseStreamID(args[3].bulk, 0, false); !ok {
+			return invalidStreamIDError
+		}
+		if sub == "SETID" {
+			if entriesRead < -1 {
+				entriesRead = s.entriesReadCounter(id)
+			}
+			g.lastID, g.entriesRead = id, entriesRead
+			markKeyspaceChanged()
+			return Value{typ: "string", str: "OK"}
+		}
+		if s == nil {
+			s = &streamValue{}
+		}
+		if !s.createGroup(name, id, entriesRead) {
+			return Value{typ: "error", str: "BUSYGROUP Consumer Group name already exists"}
+		}
+		if !exists {
+			setKey(key, object{typ: streamObject, value: s})
+		}
+		markKeyspaceChanged()
+		return Value{typ: "string", str: "OK"}
+	case "DESTROY":
+		if g == nil {
+			return Value{typ: "integer", num: 0}
+		}
+		delete(s.groups, name)
+		markKeyspaceChanged()
+		return Value{typ: "integer", num: 1}
+	case "CREATECONSUMER":
+		_, created := g.consumer(args[3].bulk)
+		if !created {
+			return Value{typ: "integer", num: 0}
+		}
+		markKeyspaceChanged()
+		return Value{typ: "integer", num: 1}
+	default:
+		pending := g.deleteConsumer(args[3].bulk)
+		markKeyspaceChanged()
+		return Value{typ: "integer", num: pending}
+	}
+}
+
+// readGroup delivers entries of the stream at key to consumer c of group
+// g, the entries after the last one of the group for the ID >, the
+// history of the consumer following id otherwise. It returns the entries
+// replied, which is nil when there are no new ones.
+func readGroup(s *streamValue, g *streamGroup, c *streamConsumer, id string, start streamID, count int, noAck bool) []Value {
+	now := nowMs()
+	values := []Value{}
+	if id == ">" {
+		for _, e := range s.after(g.lastID, count) {
+			if g.entriesRead >= 0 && !s.hasTombstones(e.id) {
+				g.entriesRead++
+			} else {
+				g.entriesRead = s.entriesReadCounter(e.id)
+			}
+			g.lastID = e.id
+			if !noAck {
+				g.assign(e.id, c, now).deliveryCount = 1
+			}
+			values = append(values, streamEntryValue(e))
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		c.activeTime = now
+		return values
+	}
+
+	i, _ := g.findPending(start)
+	for _, p := range g.pending[i:] {
+		if count > 0 && len(values) == count {
+			break
+		}
+		if p.consumer != c || p.id == start {
+			continue
+		}
+		p.deliveryTime = now
+		p.deliveryCount++
+		e, ok := s.entry(p.id)
+		if !ok {
+			// the entry was deleted from the stream since
+			values = append(values, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: p.id.String()},
+				{typ: "nullarray"},
+			}})
+			continue
+		}
+		values = append(values, streamEntryValue(e))
+	}
+	return values
+}
+
+// xreadgroup handles XREADGROUP GROUP group consumer [COUNT count] [BLOCK
+// milliseconds] [NOACK] STREAMS key [key ...] id [id ...], replying like
+// XREAD the entries delivered from every stream. History reads always
+// reply their stream, even without entries, and never wait.
+func xreadgroup(c *Client, args []Value) Value {
+	parsed, errReply, ok := parseXread(args, true)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	starts := make([]streamID, len(parsed.keys))
+	for i, key := range parsed.keys {
+		obj, exists := keyspace[key]
+		if exists && obj.typ != streamObject {
+			keyspaceMu.Unlock()
+			return wrongTypeError
+		}
+		switch parsed.ids[i] {
+		case ">":
+		case "$":
+			keyspaceMu.Unlock()
+			return Value{typ: "error", str: "ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set."}
+		default:
+			id, ok := parseStreamID(parsed.ids[i], 0, true)
+			if !ok {
+				keyspaceMu.Unlock()
+				return invalidStreamIDError
+			}
+			starts[i] = id
+		}
+		if obj.stream().group(parsed.group) == nil {
+			keyspaceMu.Unlock()
+			return Value{typ: "error", str: "NOGROUP No such key '" + key + "' or consumer group '" + parsed.group + "' in XREADGROUP with GROUP option"}
+		}
+	}
+	for _, key := range parsed.keys {
+		consumer, created := keyspace[key].stream().group(parsed.group).consumer(parsed.consumer)
+		consumer.seenTime = nowMs()
+		if created {
+			markKeyspaceChanged()
+		}
+	}
+
+	read := func() (Value, bool) {
+		reply := Value{typ: "array"}
+		for i, key := range parsed.keys {
+			s := keyspace[key].stream()
+			g := s.group(parsed.group)
+			if g == nil {
+				// the stream or the group went away while the client waited
+				return Value{typ: "error", str: "NOGROUP the consumer group this client was blocked on no longer exists"}, true
+			}
+			consumer, _ := g.consumer(parsed.consumer)
+			values := readGroup(s, g, consumer, parsed.ids[i], starts[i], parsed.count, parsed.noAck)
+			if values == nil {
+				continue
+			}
+			if len(values) > 0 {
+				markKeyspaceChanged()
+			}
+			reply.array = append(reply.array, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: key},
+				{typ: "array", array: values},
+			}})
+		}
+		return reply, len(reply.array) > 0
+	}
+	if reply, ok := read(); ok || !parsed.block || !c.mayBlock() {
+		keyspaceMu.Unlock()
+		if !ok {
+			return Value{typ: "nullarray"}
+		}
+		return reply
+	}
+
+	w := &streamWaiter{keys: parsed.keys, serve: read, reply: make(chan Value, 1)}
+	for _, key := range parsed.keys {
+		if !slices.Contains(streamWaiters[key], w) {
+			streamWaiters[key] = append(streamWaiters[key], w)
+		}
+	}
+	keyspaceMu.Unlock()
+
+	return awaitReply(c, parsed.timeout, w.reply, func() { removeStreamWaiter(w) }, Value{typ: "nullarray"})
+}
+
+// xreadgroupPropagate persists XREADGROUP as one non-blocking XREADGROUP
+// per stream it replied, counting the entries it delivered from that
+// stream, so that replaying them delivers the same entries even when more
+// were added meanwhile. A read that delivered nothing only created the
+// consumer.
+func xreadgroupPropagate(value Value, result Value) Value {
+	parsed, _, ok := parseXread(value.array[1:], true)
+	if !ok {
+		return Value{}
+	}
+	commands := Value{typ: "array"}
+	for _, stream := range result.array {
+		key, n := stream.array[0].bulk, len(stream.array[1].array)
+		i := slices.Index(parsed.keys, key)
+		if n == 0 || i < 0 {
+			continue
+		}
+		args := []string{"XREADGROUP", "GROUP", parsed.group, parsed.consumer, "COUNT", strconv.Itoa(n)}
+		if parsed.noAck {
+			args = append(args, "NOACK")
+		}
+		commands.array = append(commands.array, commandValue(append(args, "STREAMS", key, parsed.ids[i])...))
+	}
+	if len(commands.array) == 0 {
+		for _, key := range parsed.keys {
+			commands.array = append(commands.array, commandValue("XGROUP", "CREATECONSUMER", key, parsed.group, parsed.consumer))
+		}
+	}
+	return commands
+}
+
+// xack handles XACK key group id [id ...], replying how many of the
+// entries were pending.
+func xack(args []Value) Value {
+	key, name := args[0].bulk, args[1].bulk
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStreamID(arg.bulk, 0, true)
+		if !ok {
+			return invalidStreamIDError
+		}
+		ids = append(ids, id)
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	g := obj.stream().group(name)
+	if g == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		markKeyspaceChanged()
+	}
+	return Value{typ: "integer", num: acked}
+}
+
+// xpending handles XPENDING key group, replying the number of pending
+// entries, the lowest and highest of their IDs and how many every
+// consumer has, and XPENDING key group [IDLE min-idle-time] start end
+// count [consumer], replying the pending entries in the range.
+func xpending(args []Value) Value {
+	key, name := args[0].bulk, args[1].bulk
+	rest := args[2:]
+	minIdle := int64(-1)
+	if len(rest) > 0 && strings.EqualFold(rest[0].bulk, "IDLE") {
+		if len(rest) < 2 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		n, err := strconv.ParseInt(rest[1].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		minIdle = n
+		rest = rest[2:]
+		if len(rest) == 0 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	if len(rest) != 0 && len(rest) != 3 && len(rest) != 4 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	extended := len(rest) > 0
+	var start, end streamID
+	count, consumer := 0, ""
+	if extended {
+		n, err := strconv.ParseInt(rest[2].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		count = int(max(min(n, 1<<31-1), 0))
+		var startExcl, endExcl, ok bool
+		if start, startExcl, ok = parseRangeBound(rest[0].bulk, 0); !ok {
+			return invalidStreamIDError
+		}
+		if end, endExcl, ok = parseRangeBound(rest[1].bulk, maxStreamID.seq); !ok {
+			return invalidStreamIDError
+		}
+		if startExcl {
+			if start, ok = start.next(); !ok {
+				return Value{typ: "error", str: "ERR invalid start ID for the interval"}
+			}
+		}
+		if endExcl {
+			if end, ok = end.prev(); !ok {
+				return Value{typ: "error", str: "ERR invalid end ID for the interval"}
+			}
+		}
+		if len(rest) == 4 {
+			consumer = rest[3].bulk
+		}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	g := obj.stream().group(name)
+	if g == nil {
+		return noGroupError(key, name)
+	}
+
+	if !extended {
+		if len(g.pending) == 0 {
+			return Value{typ: "array", array: []Value{{typ: "integer", num: 0}, {typ: "null"}, {typ: "null"}, {typ: "nullarray"}}}
+		}
+		consumers := []Value{}
+		for _, cname := range g.consumerNames() {
+			if n := g.consumers[cname].pending; n > 0 {
+				consumers = append(consumers, Value{typ: "array", array: []Value{
+					{typ: "bulk", bulk: cname},
+					{typ: "bulk", bulk: strconv.Itoa(n)},
+				}})
+			}
+		}
+		return Value{typ: "array", array: []Value{
+			{typ: "integer", num: len(g.pending)},
+			{typ: "bulk", bulk: g.pending[0].id.String()},
+			{typ: "bulk", bulk: g.pending[len(g.pending)-1].id.String()},
+			{typ: "array", array: consumers},
+		}}
+	}
+
+	now := nowMs()
+	reply := Value{typ: "array", array: []Value{}}
+	i, _ := g.findPending(start)
+	for _, p := range g.pending[i:] {
+		if len(reply.array) == count || p.id.compare(end) > 0 {
+			break
+		}
+		idle := max(now-p.deliveryTime, 0)
+		if consumer != "" && p.consumer.name != consumer || idle < minIdle {
+			continue
+		}
+		reply.array = append(reply.array, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: p.id.String()},
+			{typ: "bulk", bulk: p.consumer.name},
+			{typ: "integer", num: int(idle)},
+			{typ: "integer", num: int(p.deliveryCount)},
+		}})
+	}
+	return reply
+}
+
+// xclaimArgs are the parsed arguments of XCLAIM.
+type xclaimArgs struct {
+	minIdle int64
+	ids     []streamID
+	// deliveryTime is the delivery time the claimed entries get, -1 for
+	// the time of the claim
+	deliveryTime int64
+	// retryCount is the delivery count they get, -1 to add one to it
+	retryCount int64
+	force      bool
+	justID     bool
+	// lastID is the LASTID option, which raises the last ID of the group
+	lastID    streamID
+	hasLastID bool
+}
+
+// parseXclaim parses the arguments of XCLAIM following the consumer.
+func parseXclaim(args []Value) (xclaimArgs, Value, bool) {
+	parsed := xclaimArgs{deliveryTime: -1, retryCount: -1}
+	minIdle, err := strconv.ParseInt(args[0].bulk, 10, 64)
+	if err != nil {
+		return parsed, Value{typ: "error", str: "ERR Invalid min-idle-time argument for XCLAIM"}, false
+	}
+	parsed.minIdle = max(minIdle, 0)
+
+	i := 1
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i].bulk, 0, true)
+		if !ok {
+			break
+		}
+		parsed.ids = append(parsed.ids, id)
+	}
+	now := nowMs()
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		more := i+1 < len(args)
+		switch {
+		case option == "FORCE":
+			parsed.force = true
+		case option == "JUSTID":
+			parsed.justID = true
+		case option == "IDLE" && more:
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return parsed, Value{typ: "error", str: "ERR Invalid IDLE option argument for XCLAIM"}, false
+			}
+			parsed.deliveryTime = now - n
+			i++
+		case option == "TIME" && more:
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return parsed, Value{typ: "error", str: "ERR Invalid TIME option argument for XCLAIM"}, false
+			}
+			parsed.deliveryTime = n
+			i++
+		case option == "RETRYCOUNT" && more:
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return parsed, Value{typ: "error", str: "ERR Invalid RETRYCOUNT option argument for XCLAIM"}, false
+			}
+			parsed.retryCount = n
+			i++
+		case option == "LASTID" && more:
+			id, ok := parseStreamID(args[i+1].bulk, 0, true)
+			if !ok {
+				return parsed, invalidStreamIDError, false
+			}
+			parsed.lastID, parsed.hasLastID = id, true
+			i++
+		default:
+			return parsed, Value{typ: "error", str: "ERR Unrecognized XCLAIM option '" + args[i].bulk + "'"}, false
+		}
+	}
+	if parsed.deliveryTime > now {
+		// a delivery time in the future would make the entries look idle
+		// for less than nothing
+		parsed.deliveryTime = now
+	}
+	return parsed, Value{}, true
+}
+
+// xclaim handles XCLAIM key group consumer min-idle-time id [id ...]
+// [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE]
+// [JUSTID] [LASTID lastid], replying the entries it claimed, or only
+// their IDs with JUSTID. Pending entries deleted from the stream are
+// acknowledged instead of claimed.
+func xclaim(args []Value) Value {
+	key, name, consumerName := args[0].bulk, args[1].bulk, args[2].bulk
+	parsed, errReply, ok := parseXclaim(args[3:])
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	s := obj.stream()
+	g := s.group(name)
+	if g == nil {
+		return noGroupError(key, name)
+	}
+	if parsed.hasLastID && parsed.lastID.compare(g.lastID) > 0 {
+		g.lastID = parsed.lastID
+		markKeyspaceChanged()
+	}
+
+	now := nowMs()
+	deliveryTime := parsed.deliveryTime
+	if deliveryTime < 0 {
+		deliveryTime = now
+	}
+	var consumer *streamConsumer
+	reply := Value{typ: "array", array: []Value{}}
+	for _, id := range parsed.ids {
+		e, inStream := s.entry(id)
+		i, found := g.findPending(id)
+		if !found && (!parsed.force || !inStream) {
+			continue
+		}
+		if found && !inStream {
+			g.ack(id)
+			markKeyspaceChanged()
+			continue
+		}
+		if found && parsed.minIdle > 0 && now-g.pending[i].deliveryTime < parsed.minIdle {
+			continue
+		}
+		if consumer == nil {
+			consumer, _ = g.consumer(consumerName)
+		}
+		p := g.assign(id, consumer, deliveryTime)
+		switch {
+		case parsed.retryCount >= 0:
+			p.deliveryCount = parsed.retryCount
+		case !parsed.justID:
+			p.deliveryCount++
+		}
+		consumer.seenTime, consumer.activeTime = now, now
+		markKeyspaceChanged()
+		if parsed.justID {
+			reply.array = append(reply.array, Value{typ: "bulk", bulk: id.String()})
+		} else {
+			reply.array = append(reply.array, streamEntryValue(e))
+		}
+	}
+	return reply
+}
+
+// xclaimPropagate persists XCLAIM as the claim of the entries it claimed
+// with their delivery time fixed, since whether an entry is idle enough
+// depends on when the command runs.
+func xclaimPropagate(value Value, result Value) Value {
+	args := value.array[1:]
+	parsed, _, ok := parseXclaim(args[3:])
+	if !ok || len(result.array) == 0 && !parsed.hasLastID {
+		return Value{}
+	}
+	deliveryTime := parsed.deliveryTime
+	if deliveryTime < 0 {
+		deliveryTime = nowMs()
+	}
+	command := []string{"XCLAIM", args[0].bulk, args[1].bulk, args[2].bulk, "0"}
+	for _, claimed := range result.array {
+		if claimed.typ == "array" {
+			claimed = claimed.array[0]
+		}
+		command = append(command, claimed.bulk)
+	}
+	command = append(command, "TIME", strconv.FormatInt(deliveryTime, 10))
+	if parsed.retryCount >= 0 {
+		command = append(command, "RETRYCOUNT", strconv.FormatInt(parsed.retryCount, 10))
+	}
+	if parsed.force {
+		command = append(command, "FORCE")
+	}
+	if parsed.justID {
+		command = append(command, "JUSTID")
+	}
+	if parsed.hasLastID {
+		command = append(command, "LASTID", parsed.lastID.String())
+	}
+	return commandValue(command...)
+}
+
+// xautoclaimAttempts is how many pending entries XAUTOCLAIM looks at per
+// entry it may claim, so that a long list of entries that are not idle
+// enough does not make it scan the whole list at once.
+const xautoclaimAttempts = 10
+
+// xautoclaim handles XAUTOCLAIM key group consumer min-idle-time start
+// [COUNT count] [JUSTID], replying the ID the next call should start at,
+// the entries it claimed, or only their IDs with JUSTID, and the IDs of
+// the pending entries it found deleted from the stream, which it
+// acknowledged.
+func xautoclaim(args []Value) Value {
+	key, name, consumerName := args[0].bulk, args[1].bulk, args[2].bulk
+	minIdle, err := strconv.ParseInt(args[3].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Invalid min-idle-time argument for XAUTOCLAIM"}
+	}
+	minIdle = max(minIdle, 0)
+	start, exclusive, ok := parseRangeBound(args[4].bulk, 0)
+	if !ok {
+		return invalidStreamIDError
+	}
+	if exclusive {
+		if start, ok = start.next(); !ok {
+			return Value{typ: "error", str: "ERR invalid start ID for the interval"}
+		}
+	}
+	count, justID := 100, false
+	for i := 5; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i].bulk, "COUNT") && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if n < 1 || n > math.MaxInt64/xautoclaimAttempts {
+				return Value{typ: "error", str: "ERR COUNT must be > 0"}
+			}
+			count = int(n)
+			i++
+		case strings.EqualFold(args[i].bulk, "JUSTID"):
+			justID = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	s := obj.stream()
+	g := s.group(name)
+	if g == nil {
+		return noGroupError(key, name)
+	}
+
+	now := nowMs()
+	var consumer *streamConsumer
+	claimed := Value{typ: "array", array: []Value{}}
+	deleted := Value{typ: "array", array: []Value{}}
+	attempts := count * xautoclaimAttempts
+	i, _ := g.findPending(start)
+	for ; i < len(g.pending) && attempts > 0 && count > 0; attempts-- {
+		p := g.pending[i]
+		e, inStream := s.entry(p.id)
+		if !inStream {
+			g.ack(p.id)
+			markKeyspaceChanged()
+			deleted.array = append(deleted.array, Value{typ: "bulk", bulk: p.id.String()})
+			count--
+			continue
+		}
+		i++
+		if now-p.deliveryTime < minIdle {
+			continue
+		}
+		if consumer == nil {
+			consumer, _ = g.consumer(consumerName)
+		}
+		g.assign(p.id, consumer, now)
+		if !justID {
+			p.deliveryCount++
+		}
+		consumer.seenTime, consumer.activeTime = now, now
+		markKeyspaceChanged()
+		if justID {
+			claimed.array = append(claimed.array, Value{typ: "bulk", bulk: p.id.String()})
+		} else {
+			claimed.array = append(claimed.array, streamEntryValue(e))
+		}
+		count--
+	}
+
+	next := streamID{}
+	if i < len(g.pending) {
+		next = g.pending[i].id
+	}
+	return Value{typ: "array", array: []Value{{typ: "bulk", bulk: next.String()}, claimed, deleted}}
+}
+
+// xautoclaimPropagate persists XAUTOCLAIM as an XCLAIM of the entries it
+// claimed and of those it found deleted, which replaying the XCLAIM
+// acknowledges again.
+func xautoclaimPropagate(value Value, result Value) Value {
+	if result.typ != "array" || len(result.array[1].array)+len(result.array[2].array) == 0 {
+		return Value{}
+	}
+	args := value.array[1:]
+	command := []string{"XCLAIM", args[0].bulk, args[1].bulk, args[2].bulk, "0"}
+	for _, claimed := range result.array[1].array {
+		if claimed.typ == "array" {
+			claimed = claimed.array[0]
+		}
+		command = append(command, claimed.bulk)
+	}
+	for _, id := range result.array[2].array {
+		command = append(command, id.bulk)
+	}
+	command = append(command, "TIME", strconv.FormatInt(nowMs(), 10))
+	for _, arg := range args[5:] {
+		if strings.EqualFold(arg.bulk, "JUSTID") {
+			command = append(command, "JUSTID")
+			break
+		}
+	}
+	return commandValue(command...)
+}
+
+// groupCommands returns the commands recreating the consumer groups of the
+// stream at key: an XGROUP CREATE per group, an XGROUP CREATECONSUMER per
+// consumer and an XCLAIM per pending entry, which keeps its delivery time
+// and count. Like in Redis, pending entries deleted from the stream are
+// not recreated.
+func groupCommands(key string, s *streamValue) []Value {
+	commands := []Value{}
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		commands = append(commands, commandValue("XGROUP", "CREATE", key, name, g.lastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10)))
+		for _, consumer := range g.consumerNames() {
+			commands = append(commands, commandValue("XGROUP", "CREATECONSUMER", key, name, consumer))
+		}
+		for _, p := range g.pending {
+			commands = append(commands, commandValue("XCLAIM", key, name, p.consumer.name, "0", p.id.String(),
+				"TIME", strconv.FormatInt(p.deliveryTime, 10), "RETRYCOUNT", strconv.FormatInt(p.deliveryCount, 10),
+				"FORCE", "JUSTID", "LASTID", g.lastID.String()))
+		}
+	}
+	return commands
+}
@@ -0,0 +1,262 @@
+// Stream introspection.
+//
+// XINFO describes a stream and its consumer groups, mostly to tell how far
+// behind the workers of a group are:
+//
+//	XINFO STREAM key [FULL [COUNT count]]
+//	XINFO GROUPS key
+//	XINFO CONSUMERS key group
+//
+// XINFO STREAM replies the length of the stream, its last ID, the greatest
+// ID XDEL removed, how many entries were ever added and the first and last
+// entries, FULL adding the entries themselves and every group with its
+// pending entries and consumers, at most count of each, 10 by default and 0
+// for all. XINFO GROUPS replies for every group its consumers, the number of
+// pending entries, the last ID delivered, the entries read and the lag, the
+// number of entries added the group did not read yet. Like in Redis, the lag
+// is null when XDEL left a hole the group did not read past, since entries
+// can then no longer be counted. XINFO CONSUMERS replies for every consumer
+// its pending entries and the milliseconds since it last read and since it
+// last got an entry. Replies are field-value arrays in the order Redis
+// replies its maps in.
+//
+// The radix-tree-keys and radix-tree-nodes fields of Redis describe the
+// radix tree it stores entries in. Streams are slices here, so they are
+// reported as if the entries were stored in nodes of 100, the default
+// stream-node-max-entries.
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// streamNodeEntries is the number of entries per node the radix tree
+// fields of XINFO STREAM are computed with.
+const streamNodeEntries = 100
+
+// lag returns the number of entries added to the stream that the group did
+// not read yet, false when it can not be told.
+func (s *streamValue) lag(g *streamGroup) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.entriesRead >= 0 && !s.hasTombstones(g.lastID) {
+		return s.entriesAdded - g.entriesRead, true
+	}
+	if read := s.entriesReadCounter(g.lastID); read >= 0 {
+		return s.entriesAdded - read, true
+	}
+	return 0, false
+}
+
+// entriesReadValue returns the reply for the entries read by a group, null
+// when unknown.
+func entriesReadValue(n int64) Value {
+	if n < 0 {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "integer", num: int(n)}
+}
+
+// lagValue returns the reply for the lag of a group, null when unknown.
+func lagValue(s *streamValue, g *streamGroup) Value {
+	lag, ok := s.lag(g)
+	if !ok {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "integer", num: int(lag)}
+}
+
+// xinfo handles the XINFO subcommands.
+func xinfo(args []Value) Value {
+	sub := strings.ToUpper(args[0].bulk)
+	switch {
+	case sub == "STREAM" && len(args) >= 2,
+		sub == "GROUPS" && len(args) == 2,
+		sub == "CONSUMERS" && len(args) == 3:
+	case sub == "STREAM" || sub == "GROUPS" || sub == "CONSUMERS":
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xinfo|" + strings.ToLower(sub) + "' command"}
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try XINFO HELP."}
+	}
+	key := args[1].bulk
+
+	full, count := false, 10
+	if sub == "STREAM" && len(args) > 2 {
+		rest := args[2:]
+		if !strings.EqualFold(rest[0].bulk, "FULL") {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		full = true
+		switch {
+		case len(rest) == 3 && strings.EqualFold(rest[1].bulk, "COUNT"):
+			n, err := strconv.ParseInt(rest[2].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			count = int(max(min(n, 1<<31-1), 0))
+		case len(rest) != 1:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != streamObject {
+		return wrongTypeError
+	}
+	if !exists {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+	s := obj.stream()
+	switch sub {
+	case "STREAM":
+		if full {
+			return streamInfoFull(s, count)
+		}
+		return streamInfo(s)
+	case "GROUPS":
+		groups := Value{typ: "array", array: []Value{}}
+		for _, name := range s.groupNames() {
+			g := s.groups[name]
+			groups.array = append(groups.array, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: name},
+				{typ: "bulk", bulk: "consumers"}, {typ: "integer", num: len(g.consumers)},
+				{typ: "bulk", bulk: "pending"}, {typ: "integer", num: len(g.pending)},
+				{typ: "bulk", bulk: "last-delivered-id"}, {typ: "bulk", bulk: g.lastID.String()},
+				{typ: "bulk", bulk: "entries-read"}, entriesReadValue(g.entriesRead),
+				{typ: "bulk", bulk: "lag"}, lagValue(s, g),
+			}})
+		}
+		return groups
+	default:
+		name := args[2].bulk
+		g := s.group(name)
+		if g == nil {
+			return Value{typ: "error", str: "NOGROUP No such consumer group '" + name + "' for key name '" + key + "'"}
+		}
+		now := nowMs()
+		consumers := Value{typ: "array", array: []Value{}}
+		for _, cname := range g.consumerNames() {
+			c := g.consumers[cname]
+			inactive := -1
+			if c.activeTime >= 0 {
+				inactive = int(max(now-c.activeTime, 0))
+			}
+			consumers.array = append(consumers.array, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: cname},
+				{typ: "bulk", bulk: "pending"}, {typ: "integer", num: c.pending},
+				{typ: "bulk", bulk: "idle"}, {typ: "integer", num: int(max(now-c.seenTime, 0))},
+				{typ: "bulk", bulk: "inactive"}, {typ: "integer", num: inactive},
+			}})
+		}
+		return consumers
+	}
+}
+
+// streamInfoHead returns the fields and values XINFO STREAM starts with in
+// both of its forms.
+func streamInfoHead(s *streamValue) []Value {
+	first := streamID{}
+	if len(s.entries) > 0 {
+		first = s.entries[0].id
+	}
+	nodes := (len(s.entries) + streamNodeEntries - 1) / streamNodeEntries
+	return []Value{
+		{typ: "bulk", bulk: "length"}, {typ: "integer", num: len(s.entries)},
+		{typ: "bulk", bulk: "radix-tree-keys"}, {typ: "integer", num: nodes},
+		{typ: "bulk", bulk: "radix-tree-nodes"}, {typ: "integer", num: max(nodes, 1)},
+		{typ: "bulk", bulk: "last-generated-id"}, {typ: "bulk", bulk: s.lastID.String()},
+		{typ: "bulk", bulk: "max-deleted-entry-id"}, {typ: "bulk", bulk: s.maxDeletedID.String()},
+		{typ: "bulk", bulk: "entries-added"}, {typ: "integer", num: int(s.entriesAdded)},
+		{typ: "bulk", bulk: "recorded-first-entry-id"}, {typ: "bulk", bulk: first.String()},
+	}
+}
+
+// streamInfo returns the reply to XINFO STREAM key.
+func streamInfo(s *streamValue) Value {
+	first, last := Value{typ: "null"}, Value{typ: "null"}
+	if n := len(s.entries); n > 0 {
+		first, last = streamEntryValue(s.entries[0]), streamEntryValue(s.entries[n-1])
+	}
+	return Value{typ: "array", array: append(streamInfoHead(s),
+		Value{typ: "bulk", bulk: "groups"}, Value{typ: "integer", num: len(s.groups)},
+		Value{typ: "bulk", bulk: "first-entry"}, first,
+		Value{typ: "bulk", bulk: "last-entry"}, last,
+	)}
+}
+
+// streamInfoFull returns the reply to XINFO STREAM key FULL, with at most
+// count entries, and pending entries per group and consumer, unless count
+// is 0.
+func streamInfoFull(s *streamValue, count int) Value {
+	limit := func(n int) int {
+		if count > 0 {
+			return min(n, count)
+		}
+		return n
+	}
+
+	entries := Value{typ: "array", array: []Value{}}
+	for _, e := range s.entries[:limit(len(s.entries))] {
+		entries.array = append(entries.array, streamEntryValue(e))
+	}
+
+	groups := Value{typ: "array", array: []Value{}}
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		pending := Value{typ: "array", array: []Value{}}
+		for _, p := range g.pending[:limit(len(g.pending))] {
+			pending.array = append(pending.array, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: p.id.String()},
+				{typ: "bulk", bulk: p.consumer.name},
+				{typ: "integer", num: int(p.deliveryTime)},
+				{typ: "integer", num: int(p.deliveryCount)},
+			}})
+		}
+
+		consumers := Value{typ: "array", array: []Value{}}
+		for _, cname := range g.consumerNames() {
+			c := g.consumers[cname]
+			owned := Value{typ: "array", array: []Value{}}
+			for _, p := range g.pending {
+				if count > 0 && len(owned.array) == count {
+					break
+				}
+				if p.consumer != c {
+					continue
+				}
+				owned.array = append(owned.array, Value{typ: "array", array: []Value{
+					{typ: "bulk", bulk: p.id.String()},
+					{typ: "integer", num: int(p.deliveryTime)},
+					{typ: "integer", num: int(p.deliveryCount)},
+				}})
+			}
+			consumers.array = append(consumers.array, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: cname},
+				{typ: "bulk", bulk: "seen-time"}, {typ: "integer", num: int(c.seenTime)},
+				{typ: "bulk", bulk: "active-time"}, {typ: "integer", num: int(c.activeTime)},
+				{typ: "bulk", bulk: "pel-count"}, {typ: "integer", num: c.pending},
+				{typ: "bulk", bulk: "pending"}, owned,
+			}})
+		}
+
+		groups.array = append(groups.array, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: name},
+			{typ: "bulk", bulk: "last-delivered-id"}, {typ: "bulk", bulk: g.lastID.String()},
+			{typ: "bulk", bulk: "entries-read"}, entriesReadValue(g.entriesRead),
+			{typ: "bulk", bulk: "lag"}, lagValue(s, g),
+			{typ: "bulk", bulk: "pel-count"}, {typ: "integer", num: len(g.pending)},
+			{typ: "bulk", bulk: "pending"}, pending,
+			{typ: "bulk", bulk: "consumers"}, consumers,
+		}})
+	}
+
+	return Value{typ: "array", array: append(streamInfoHead(s),
+		Value{typ: "bulk", bulk: "entries"}, entries,
+		Value{typ: "bulk", bulk: "groups"}, groups,
+	)}
+}
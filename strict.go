@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Strict mode validates arguments before a command runs instead of letting
+// the handlers accept whatever strconv accepts. With strict-arguments
+// enabled:
+//
+//   - keys must be valid UTF-8
+//   - integers must be canonical ("12", "-3", not "+12", "012" or "-0") and
+//     times must not overflow once converted to Unix milliseconds
+//   - floats must be finite and written in decimal ("1.5", "2e3", not "NaN",
+//     "Inf" or "0x1p3")
+//
+// Every rejection names the argument and why it was refused, so teams that
+// want the store to enforce data hygiene get precise errors instead of
+// silently stored garbage.
+
+// strictArguments enables strict argument validation.
+var strictArguments atomic.Bool
+
+// canonicalInt matches an integer written the way Redis itself prints it.
+var canonicalInt = regexp.MustCompile(`^(0|-?[1-9][0-9]*)$`)
+
+// decimalFloat matches a float written in plain or exponent decimal notation.
+var decimalFloat = regexp.MustCompile(`^-?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+// strictCheckers validate the non-key arguments of the commands that take
+// numbers. args excludes the command name, like for handlers.
+var strictCheckers = map[string]func(name string, args []Value) error{
+	"SET":             strictExpiryOption(2),
+	"SETEX":           strictTime(1, 1000, false),
+	"PSETEX":          strictTime(1, 1, false),
+	"GETEX":           strictExpiryOption(1),
+	"EXPIRE":          strictTime(1, 1000, false),
+	"PEXPIRE":         strictTime(1, 1, false),
+	"EXPIREAT":        strictTime(1, 1000, true),
+	"PEXPIREAT":       strictTime(1, 1, true),
+	"SESSION.SET":     strictSessionExpiry(2, false),
+	"SESSION.TOUCH":   strictSessionExpiry(1, true),
+	"SELECT":          strictInts(0),
+	"LPOP":            strictInts(1),
+	"RPOP":            strictInts(1),
+	"LRANGE":          strictInts(1, 2),
+	"LSET":            strictInts(1),
+	"LREM":            strictInts(1),
+	"LTRIM":           strictInts(1, 2),
+	"LINDEX":          strictInts(1),
+	"LPOS":            strictInts(3, 5, 7),
+	"ZADD":            strictZaddScores,
+	"ZREVRANGE":       strictInts(1, 2),
+	"ZINCRBY":         strictFloats(1),
+	"ZRANDMEMBER":     strictInts(1),
+	"ZREMRANGEBYRANK": strictInts(1, 2),
+	"SETBIT":          strictInts(1, 2),
+	"GETBIT":          strictInts(1),
+	"BITCOUNT":        strictInts(1, 2),
+	"BITPOS":          strictInts(1, 2, 3),
+	"DEBUG":           strictSubcommand(map[string]func(string, []Value) error{"SLEEP": strictFloats(1), "SET-ACTIVE-EXPIRE": strictInts(1)}),
+	"IDGEN":           strictSubcommand(map[string]func(string, []Value) error{"SEED": strictInts(2)}),
+	"SLOWLOG":         strictSubcommand(map[string]func(string, []Value) error{"GET": strictInts(1)}),
+	"CLIENT":          strictSubcommand(map[string]func(string, []Value) error{"PAUSE": strictInts(1)}),
+}
+
+// strictCheck validates a call in strict mode, returning the error reply
+// or false when the call is acceptable.
+func strictCheck(command string, args []Value) (Value, bool) {
+	if !strictArguments.Load() {
+		return Value{}, false
+	}
+	name := strings.ToLower(command)
+
+	for _, key := range commandKeys(command, args) {
+		if !utf8.ValidString(key) {
+			return Value{typ: "error", str: fmt.Sprintf("ERR invalid key for '%s' command: %q is not valid UTF-8", name, key)}, true
+		}
+	}
+	if check, ok := strictCheckers[command]; ok {
+		if err := check(name, args); err != nil {
+			return Value{typ: "error", str: "ERR " + err.Error()}, true
+		}
+	}
+	return Value{}, false
+}
+
+// strictInt parses a canonical integer.
+func strictInt(name string, args []Value, i int) (int64, error) {
+	s := args[i].bulk
+	if !canonicalInt.MatchString(s) {
+		return 0, fmt.Errorf("argument %d of '%s' is not a canonical integer: %q", i+1, name, s)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("argument %d of '%s' is out of the 64 bit integer range: %q", i+1, name, s)
+	}
+	return n, nil
+}
+
+// strictInts checks that the arguments at the given positions, when
+// present, are canonical integers.
+func strictInts(positions ...int) func(string, []Value) error {
+	return func(name string, args []Value) error {
+		for _, i := range positions {
+			if i < len(args) {
+				if _, err := strictInt(name, args, i); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// strictFloats checks that the arguments at the given positions, when
+// present, are finite decimal floats.
+func strictFloats(positions ...int) func(string, []Value) error {
+	return func(name string, args []Value) error {
+		for _, i := range positions {
+			if i >= len(args) {
+				continue
+			}
+			s := args[i].bulk
+			if !decimalFloat.MatchString(s) {
+				return fmt.Errorf("argument %d of '%s' is not a decimal float: %q", i+1, name, s)
+			}
+			if f, err := strconv.ParseFloat(s, 64); err != nil || math.IsInf(f, 0) {
+				return fmt.Errorf("argument %d of '%s' is out of the float range: %q", i+1, name, s)
+			}
+		}
+		return nil
+	}
+}
+
+// strictZaddScores checks the scores of ZADD, which follow its flags in
+// score and member pairs.
+func strictZaddScores(name string, args []Value) error {
+	_, first := parseZaddFlags(args)
+	for i := first; i < len(args); i += 2 {
+		if err := strictFloats(i)(name, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// strictTime checks the time argument at position i given in unit
+// milliseconds, which must still fit once converted to an absolute Unix
+// time in milliseconds.
+func strictTime(i int, unit int64, absolute bool) func(string, []Value) error {
+	return func(name string, args []Value) error {
+		n, err := strictInt(name, args, i)
+		if err != nil {
+			return err
+		}
+		base := int64(0)
+		if !absolute {
+			base = nowMs()
+		}
+		if n > (math.MaxInt64-base)/unit || n < math.MinInt64/unit {
+			return fmt.Errorf("invalid expire time in '%s' command", name)
+		}
+		return nil
+	}
+}
+
+// strictSessionExpiry checks an "EX seconds" or "PXAT unix-ms" pair at
+// position i, or with bare set the plain seconds SESSION.TOUCH accepts.
+func strictSessionExpiry(i int, bare bool) func(string, []Value) error {
+	return func(name string, args []Value) error {
+		if i >= len(args) {
+			return nil
+		}
+		switch strings.ToUpper(args[i].bulk) {
+		case "EX":
+			if i+1 < len(args) {
+				return strictTime(i+1, 1000, false)(name, args)
+			}
+		case "PXAT":
+			if i+1 < len(args) {
+				return strictTime(i+1, 1, true)(name, args)
+			}
+		default:
+			if bare {
+				return strictTime(i, 1000, false)(name, args)
+			}
+		}
+		return nil
+	}
+}
+
+// strictExpiryOption checks the time following an EX, PX, EXAT or PXAT
+// option among the options starting at position first, as taken by SET and
+// GETEX.
+func strictExpiryOption(first int) func(string, []Value) error {
+	return func(name string, args []Value) error {
+		for i := first; i+1 < len(args); i++ {
+			switch strings.ToUpper(args[i].bulk) {
+			case "EX":
+				return strictTime(i+1, 1000, false)(name, args)
+			case "PX":
+				return strictTime(i+1, 1, false)(name, args)
+			case "EXAT":
+				return strictTime(i+1, 1000, true)(name, args)
+			case "PXAT":
+				return strictTime(i+1, 1, true)(name, args)
+			}
+		}
+		return nil
+	}
+}
+
+// strictSubcommand dispatches to the checker of a subcommand, named after
+// it like "debug|sleep" in errors.
+func strictSubcommand(checkers map[string]func(string, []Value) error) func(string, []Value) error {
+	return func(name string, args []Value) error {
+		if len(args) == 0 {
+			return nil
+		}
+		sub := strings.ToUpper(args[0].bulk)
+		if check, ok := checkers[sub]; ok {
+			return check(name+"|"+strings.ToLower(sub), args)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket
+// activation, following stdin, stdout and stderr.
+const listenFdsStart = 3
+
+// systemdListeners returns the listening sockets passed by systemd socket
+// activation, or none when the server was not socket activated. With
+// socket activation systemd binds the port, which may be privileged, and
+// keeps it open while the service restarts, so no connection is refused in
+// between.
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	// the sockets are not meant for child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := []net.Listener{}
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		tsrv, err := net.FileListener(f)
+		// FileListener works on a duplicate of the descriptor
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket activation fd %d: %v", fd, err)
+		}
+		listeners = append(listeners, tsrv)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,957 @@
+// Time series.
+//
+// A time series keeps numeric samples ordered by their timestamp, in Unix
+// milliseconds, so metrics can be stored and queried without an external
+// database, like the series of the RedisTimeSeries module:
+//
+//	TS.CREATE key [RETENTION retention] [DUPLICATE_POLICY policy] [LABELS label value ...]
+//	TS.ALTER key [RETENTION retention] [DUPLICATE_POLICY policy] [LABELS label value ...]
+//	TS.ADD key timestamp|* value [RETENTION retention] [DUPLICATE_POLICY policy] [ON_DUPLICATE policy] [LABELS label value ...]
+//	TS.MADD key timestamp|* value [key timestamp|* value ...]
+//	TS.GET key
+//	TS.RANGE key from to [FILTER_BY_TS ts ...] [FILTER_BY_VALUE min max] [COUNT count] [ALIGN align] [AGGREGATION aggregator bucketduration [BUCKETTIMESTAMP bt]]
+//	TS.REVRANGE key from to [FILTER_BY_TS ts ...] [FILTER_BY_VALUE min max] [COUNT count] [ALIGN align] [AGGREGATION aggregator bucketduration [BUCKETTIMESTAMP bt]]
+//	TS.DEL key from to
+//	TS.INFO key
+//	TS.QUERYINDEX filter [filter ...]
+//
+// TS.ADD creates a missing series with the options it is given and * stands
+// for the current time, which the AOF logs in its place. A series with a
+// retention keeps the samples at most retention milliseconds older than
+// its newest one and refuses older ones; 0, the default, keeps everything.
+// Adding a sample at a timestamp the series already has follows the
+// duplicate policy: BLOCK, the default, fails, FIRST keeps the old value,
+// LAST the new one, MIN and MAX the smaller and greater and SUM adds them.
+//
+// TS.RANGE replies the samples between from and to, - and + standing for
+// the first and last ones, and TS.REVRANGE the same newest first. With
+// AGGREGATION they are downsampled into buckets of bucketduration
+// milliseconds, starting at multiples of bucketduration shifted by ALIGN,
+// which is a timestamp or start or end for from or to. Every bucket is
+// replied as a sample at its start, or its end or middle with
+// BUCKETTIMESTAMP, holding the avg, sum, min, max, range, count, first,
+// last, std.p, std.s, var.p or var.s of the samples in it. Buckets without
+// samples are left out. FILTER_BY_TS and FILTER_BY_VALUE select the samples
+// before they are aggregated, COUNT limits the reply.
+//
+// Labels are name-value pairs describing a series, which TS.QUERYINDEX
+// finds series by. Its filters are label=value, label!=value, label= for a
+// label a series does not have, label!= for one it has and
+// label=(value,...) and label!=(value,...) for a list of values. At least
+// one filter must select a value.
+//
+// Samples are kept in a slice rather than compressed chunks, so TS.INFO
+// leaves out the chunk fields of RedisTimeSeries, and compaction rules are
+// not supported. TYPE names the key TSDB-TYPE.
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tsSample is one sample of a time series.
+type tsSample struct {
+	ts    int64
+	value float64
+}
+
+// tsLabel is a label of a time series.
+type tsLabel struct {
+	name, value string
+}
+
+// timeSeries is a time series, its samples sorted by timestamp.
+type timeSeries struct {
+	// retention is the most milliseconds a sample may be older than the
+	// newest one, 0 for no limit
+	retention int64
+	// duplicatePolicy is the policy for samples at an existing timestamp,
+	// empty for the default
+	duplicatePolicy string
+	labels          []tsLabel
+	samples         []tsSample
+}
+
+// tsDefaultDuplicatePolicy is the policy of series created without one.
+const tsDefaultDuplicatePolicy = "BLOCK"
+
+// tsDuplicatePolicies are the valid duplicate policies.
+var tsDuplicatePolicies = []string{"BLOCK", "FIRST", "LAST", "MIN", "MAX", "SUM"}
+
+// tsNoKeyError is the reply to a command run against a missing series.
+var tsNoKeyError = Value{typ: "error", str: "ERR TSDB: the key does not exist"}
+
+// timeSeries returns the series of a time series key, nil when the object
+// is not one.
+func (o object) timeSeries() *timeSeries {
+	s, _ := o.value.(*timeSeries)
+	return s
+}
+
+// readTimeSeries returns the series stored at key, nil when the key does
+// not exist, or WRONGTYPE when it holds another type. keyspaceMu must be
+// held.
+func readTimeSeries(key string) (*timeSeries, Value, bool) {
+	obj, ok := keyspace[key]
+	if ok && obj.typ != timeSeriesObject {
+		return nil, wrongTypeError, false
+	}
+	return obj.timeSeries(), Value{}, true
+}
+
+// search returns the index of the first sample at or after ts.
+func (s *timeSeries) search(ts int64) int {
+	return sort.Search(len(s.samples), func(i int) bool {
+		return s.samples[i].ts >= ts
+	})
+}
+
+// searchAfter returns the index of the first sample after ts.
+func (s *timeSeries) searchAfter(ts int64) int {
+	return sort.Search(len(s.samples), func(i int) bool {
+		return s.samples[i].ts > ts
+	})
+}
+
+// between returns the samples from from to to, both included.
+func (s *timeSeries) between(from, to int64) []tsSample {
+	if from > to {
+		return nil
+	}
+	return s.samples[s.search(from):s.searchAfter(to)]
+}
+
+// add adds a sample, resolving a sample at the same timestamp with policy,
+// or the policy of the series when empty. It replies an error when the
+// sample is too old for the retention or the policy blocks it.
+func (s *timeSeries) add(ts int64, value float64, policy string) Value {
+	n := len(s.samples)
+	if s.retention > 0 && n > 0 && ts < s.samples[n-1].ts-s.retention {
+		return Value{typ: "error", str: "ERR TSDB: Timestamp is older than retention"}
+	}
+	if policy == "" {
+		policy = s.duplicatePolicy
+	}
+	if policy == "" {
+		policy = tsDefaultDuplicatePolicy
+	}
+
+	i := s.search(ts)
+	if i < n && s.samples[i].ts == ts {
+		old := &s.samples[i].value
+		switch policy {
+		case "BLOCK":
+			return Value{typ: "error", str: "ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode"}
+		case "LAST":
+			*old = value
+		case "MIN":
+			*old = min(*old, value)
+		case "MAX":
+			*old = max(*old, value)
+		case "SUM":
+			*old += value
+		}
+		return Value{typ: "integer", num: int(ts)}
+	}
+	s.samples = slices.Insert(s.samples, i, tsSample{ts: ts, value: value})
+	s.trim()
+	return Value{typ: "integer", num: int(ts)}
+}
+
+// trim drops the samples older than the retention allows.
+func (s *timeSeries) trim() {
+	if s.retention == 0 || len(s.samples) == 0 {
+		return
+	}
+	oldest := s.samples[len(s.samples)-1].ts - s.retention
+	if i := s.search(oldest); i > 0 {
+		s.samples = slices.Delete(s.samples, 0, i)
+	}
+}
+
+// label returns the value of a label, false when the series does not have
+// it.
+func (s *timeSeries) label(name string) (string, bool) {
+	for _, l := range s.labels {
+		if l.name == name {
+			return l.value, true
+		}
+	}
+	return "", false
+}
+
+// size returns the number of bytes the samples and labels take.
+func (s *timeSeries) size() int {
+	size := len(s.samples) * 16
+	for _, l := range s.labels {
+		size += len(l.name) + len(l.value)
+	}
+	return size
+}
+
+// tsOptions are the options of TS.CREATE, TS.ALTER and TS.ADD.
+type tsOptions struct {
+	retention       int64
+	hasRetention    bool
+	duplicatePolicy string
+	onDuplicate     string
+	labels          []tsLabel
+	hasLabels       bool
+}
+
+// parseTSPolicy parses a duplicate policy.
+func parseTSPolicy(arg string) (string, Value, bool) {
+	policy := strings.ToUpper(arg)
+	if !slices.Contains(tsDuplicatePolicies, policy) {
+		return "", Value{typ: "error", str: "ERR TSDB: Unknown DUPLICATE_POLICY"}, false
+	}
+	return policy, Value{}, true
+}
+
+// parseTSOptions parses the options of TS.CREATE, TS.ALTER and, with add
+// set, TS.ADD. LABELS takes the rest of the arguments.
+func parseTSOptions(args []Value, add bool) (tsOptions, Value, bool) {
+	opts := tsOptions{}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		if option == "LABELS" {
+			rest := args[i+1:]
+			if len(rest)%2 != 0 {
+				return opts, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+			}
+			for j := 0; j < len(rest); j += 2 {
+				name, value := rest[j].bulk, rest[j+1].bulk
+				if name == "" || value == "" || strings.ContainsAny(name+value, "(),=") {
+					return opts, Value{typ: "error", str: "ERR TSDB: Invalid label"}, false
+				}
+				opts.labels = append(opts.labels, tsLabel{name: name, value: value})
+			}
+			opts.hasLabels = true
+			break
+		}
+		if i+1 == len(args) {
+			return opts, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+		}
+		arg := args[i+1].bulk
+		i++
+		switch {
+		case option == "RETENTION":
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || n < 0 {
+				return opts, Value{typ: "error", str: "ERR TSDB: Couldn't parse RETENTION"}, false
+			}
+			opts.retention, opts.hasRetention = n, true
+		case option == "DUPLICATE_POLICY":
+			policy, errReply, ok := parseTSPolicy(arg)
+			if !ok {
+				return opts, errReply, false
+			}
+			opts.duplicatePolicy = policy
+		case option == "ON_DUPLICATE" && add:
+			policy, errReply, ok := parseTSPolicy(arg)
+			if !ok {
+				return opts, errReply, false
+			}
+			opts.onDuplicate = policy
+		default:
+			return opts, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+		}
+	}
+	return opts, Value{}, true
+}
+
+// newTimeSeries returns an empty series with the options.
+func newTimeSeries(opts tsOptions) *timeSeries {
+	return &timeSeries{retention: opts.retention, duplicatePolicy: opts.duplicatePolicy, labels: opts.labels}
+}
+
+// parseTSTimestamp parses the timestamp of a new sample, * for now.
+func parseTSTimestamp(arg string) (int64, Value, bool) {
+	if arg == "*" {
+		return nowMs(), Value{}, true
+	}
+	ts, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, Value{typ: "error", str: "ERR TSDB: invalid timestamp, must be a nonnegative integer"}, false
+	}
+	return ts, Value{}, true
+}
+
+// parseTSValue parses the value of a new sample.
+func parseTSValue(arg string) (float64, Value, bool) {
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, Value{typ: "error", str: "ERR TSDB: invalid value"}, false
+	}
+	return value, Value{}, true
+}
+
+// tsCreate handles TS.CREATE key [RETENTION retention] [DUPLICATE_POLICY
+// policy] [LABELS label value ...].
+func tsCreate(args []Value) Value {
+	key := args[0].bulk
+	opts, errReply, ok := parseTSOptions(args[1:], false)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	if _, exists := keyspace[key]; exists {
+		return Value{typ: "error", str: "ERR TSDB: key already exists"}
+	}
+	setKey(key, object{typ: timeSeriesObject, value: newTimeSeries(opts)})
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// tsAlter handles TS.ALTER key [RETENTION retention] [DUPLICATE_POLICY
+// policy] [LABELS label value ...], replacing the options given.
+func tsAlter(args []Value) Value {
+	opts, errReply, ok := parseTSOptions(args[1:], false)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	s, errReply, ok := readTimeSeries(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if s == nil {
+		return tsNoKeyError
+	}
+	if opts.hasRetention {
+		s.retention = opts.retention
+		s.trim()
+	}
+	if opts.duplicatePolicy != "" {
+		s.duplicatePolicy = opts.duplicatePolicy
+	}
+	if opts.hasLabels {
+		s.labels = opts.labels
+	}
+	markKeyspaceChanged()
+	return Value{typ: "string", str: "OK"}
+}
+
+// tsAdd handles TS.ADD key timestamp|* value [options], replying the
+// timestamp of the sample.
+func tsAdd(args []Value) Value {
+	key := args[0].bulk
+	ts, errReply, ok := parseTSTimestamp(args[1].bulk)
+	if !ok {
+		return errReply
+	}
+	value, errReply, ok := parseTSValue(args[2].bulk)
+	if !ok {
+		return errReply
+	}
+	opts, errReply, ok := parseTSOptions(args[3:], true)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	s, errReply, ok := readTimeSeries(key)
+	if !ok {
+		return errReply
+	}
+	if s == nil {
+		s = newTimeSeries(opts)
+		setKey(key, object{typ: timeSeriesObject, value: s})
+		markKeyspaceChanged()
+	}
+	reply := s.add(ts, value, opts.onDuplicate)
+	if reply.typ != "error" {
+		markKeyspaceChanged()
+	}
+	return reply
+}
+
+// tsMadd handles TS.MADD key timestamp|* value [key timestamp|* value
+// ...], replying the timestamp of every sample or why it was not added.
+// Unlike TS.ADD, it does not create missing series.
+func tsMadd(args []Value) Value {
+	if len(args)%3 != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'ts.madd' command"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	reply := Value{typ: "array", array: make([]Value, 0, len(args)/3)}
+	for i := 0; i < len(args); i += 3 {
+		reply.array = append(reply.array, tsMaddOne(args[i].bulk, args[i+1].bulk, args[i+2].bulk))
+	}
+	return reply
+}
+
+// tsMaddOne adds one sample of TS.MADD. keyspaceMu must be held for
+// writing.
+func tsMaddOne(key, timestamp, number string) Value {
+	ts, errReply, ok := parseTSTimestamp(timestamp)
+	if !ok {
+		return errReply
+	}
+	value, errReply, ok := parseTSValue(number)
+	if !ok {
+		return errReply
+	}
+	s, errReply, ok := readTimeSeries(key)
+	if !ok {
+		return errReply
+	}
+	if s == nil {
+		return tsNoKeyError
+	}
+	reply := s.add(ts, value, "")
+	if reply.typ != "error" {
+		markKeyspaceChanged()
+	}
+	return reply
+}
+
+// tsAddPropagate persists TS.ADD with the timestamp it added the sample
+// at, in place of *.
+func tsAddPropagate(value Value, result Value) Value {
+	if result.typ != "integer" {
+		return Value{}
+	}
+	rewritten := Value{typ: "array", array: slices.Clone(value.array)}
+	rewritten.array[2] = Value{typ: "bulk", bulk: strconv.Itoa(result.num)}
+	return rewritten
+}
+
+// tsMaddPropagate persists the samples TS.MADD added, with the timestamp
+// they were added at in place of *.
+func tsMaddPropagate(value Value, result Value) Value {
+	rewritten := Value{typ: "array", array: []Value{value.array[0]}}
+	for i, r := range result.array {
+		if r.typ != "integer" {
+			continue
+		}
+		sample := value.array[1+i*3 : 4+i*3]
+		rewritten.array = append(rewritten.array, sample[0], Value{typ: "bulk", bulk: strconv.Itoa(r.num)}, sample[2])
+	}
+	if len(rewritten.array) == 1 {
+		return Value{}
+	}
+	return rewritten
+}
+
+// tsSampleValue returns the reply for a sample.
+func tsSampleValue(sample tsSample) Value {
+	return Value{typ: "array", array: []Value{
+		{typ: "integer", num: int(sample.ts)},
+		{typ: "bulk", bulk: formatScore(sample.value)},
+	}}
+}
+
+// tsGet handles TS.GET key, replying the newest sample, or an empty array
+// for an empty series.
+func tsGet(args []Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	s, errReply, ok := readTimeSeries(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if s == nil {
+		return tsNoKeyError
+	}
+	if len(s.samples) == 0 {
+		return Value{typ: "array", array: []Value{}}
+	}
+	return tsSampleValue(s.samples[len(s.samples)-1])
+}
+
+// parseTSRangeBound parses the from or to of a range, - and + standing
+// for the smallest and greatest timestamps.
+func parseTSRangeBound(arg string) (int64, bool) {
+	switch arg {
+	case "-":
+		return 0, true
+	case "+":
+		return math.MaxInt64, true
+	}
+	ts, err := strconv.ParseInt(arg, 10, 64)
+	return ts, err == nil && ts >= 0
+}
+
+// tsAggregators are the valid aggregators of TS.RANGE.
+var tsAggregators = []string{"avg", "sum", "min", "max", "range", "count", "first", "last", "std.p", "std.s", "var.p", "var.s"}
+
+// tsRange are the arguments of TS.RANGE and TS.REVRANGE.
+type tsRange struct {
+	from, to int64
+	// timestamps are the FILTER_BY_TS timestamps, nil without the filter
+	timestamps []int64
+	// minValue and maxValue are the FILTER_BY_VALUE bounds
+	minValue, maxValue float64
+	byValue            bool
+	// count is the COUNT limit, 0 for none
+	count int
+	// aggregator is the aggregator, empty without AGGREGATION
+	aggregator string
+	bucket     int64
+	align      int64
+	// bucketTimestamp is how far into its bucket a bucket is reported: 0
+	// for its start, 1 for its middle and 2 for its end
+	bucketTimestamp int64
+}
+
+// parseTSRange parses the arguments of TS.RANGE and TS.REVRANGE after the
+// key.
+func parseTSRange(args []Value) (tsRange, Value, bool) {
+	r := tsRange{}
+	var ok bool
+	if r.from, ok = parseTSRangeBound(args[0].bulk); !ok {
+		return r, Value{typ: "error", str: "ERR TSDB: wrong fromTimestamp"}, false
+	}
+	if r.to, ok = parseTSRangeBound(args[1].bulk); !ok {
+		return r, Value{typ: "error", str: "ERR TSDB: wrong toTimestamp"}, false
+	}
+	align, aligned := "", false
+	bucketTimestamp := ""
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		switch option {
+		case "FILTER_BY_TS":
+			r.timestamps = []int64{}
+			for i+1 < len(args) {
+				ts, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+				if err != nil {
+					break
+				}
+				r.timestamps = append(r.timestamps, ts)
+				i++
+			}
+			if len(r.timestamps) == 0 {
+				return r, Value{typ: "error", str: "ERR TSDB: FILTER_BY_TS one or more arguments are missing"}, false
+			}
+		case "FILTER_BY_VALUE":
+			if i+2 >= len(args) {
+				return r, Value{typ: "error", str: "ERR TSDB: FILTER_BY_VALUE one or more arguments are missing"}, false
+			}
+			minValue, err1 := strconv.ParseFloat(args[i+1].bulk, 64)
+			maxValue, err2 := strconv.ParseFloat(args[i+2].bulk, 64)
+			if err1 != nil || err2 != nil {
+				return r, Value{typ: "error", str: "ERR TSDB: Couldn't parse MIN or MAX"}, false
+			}
+			r.minValue, r.maxValue, r.byValue = minValue, maxValue, true
+			i += 2
+		case "COUNT":
+			if i+1 == len(args) {
+				return r, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+			}
+			n, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil || n <= 0 {
+				return r, Value{typ: "error", str: "ERR TSDB: Couldn't parse COUNT"}, false
+			}
+			r.count = n
+			i++
+		case "ALIGN":
+			if i+1 == len(args) {
+				return r, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+			}
+			align, aligned = args[i+1].bulk, true
+			i++
+		case "AGGREGATION":
+			if i+2 >= len(args) {
+				return r, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+			}
+			r.aggregator = strings.ToLower(args[i+1].bulk)
+			if !slices.Contains(tsAggregators, r.aggregator) {
+				return r, Value{typ: "error", str: "ERR TSDB: Unknown aggregation type"}, false
+			}
+			bucket, err := strconv.ParseInt(args[i+2].bulk, 10, 64)
+			if err != nil || bucket <= 0 {
+				return r, Value{typ: "error", str: "ERR TSDB: bucketDuration must be greater than zero"}, false
+			}
+			r.bucket = bucket
+			i += 2
+		case "BUCKETTIMESTAMP":
+			if i+1 == len(args) {
+				return r, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+			}
+			bucketTimestamp = args[i+1].bulk
+			i++
+		default:
+			return r, Value{typ: "error", str: "ERR TSDB: wrong parameters"}, false
+		}
+	}
+
+	if aligned {
+		if r.aggregator == "" {
+			return r, Value{typ: "error", str: "ERR TSDB: ALIGN parameter can only be used with AGGREGATION"}, false
+		}
+		switch strings.ToLower(align) {
+		case "start", "-":
+			r.align = r.from
+		case "end", "+":
+			r.align = r.to
+		default:
+			n, err := strconv.ParseInt(align, 10, 64)
+			if err != nil {
+				return r, Value{typ: "error", str: "ERR TSDB: unknown ALIGN parameter"}, false
+			}
+			r.align = n
+		}
+	}
+	if bucketTimestamp != "" {
+		if r.aggregator == "" {
+			return r, Value{typ: "error", str: "ERR TSDB: BUCKETTIMESTAMP parameter can only be used with AGGREGATION"}, false
+		}
+		switch strings.ToLower(bucketTimestamp) {
+		case "start", "-":
+			r.bucketTimestamp = 0
+		case "mid", "~":
+			r.bucketTimestamp = 1
+		case "end", "+":
+			r.bucketTimestamp = 2
+		default:
+			return r, Value{typ: "error", str: "ERR TSDB: unknown BUCKETTIMESTAMP parameter"}, false
+		}
+	}
+	return r, Value{}, true
+}
+
+// selected reports whether a sample passes the filters of the range.
+func (r *tsRange) selected(sample tsSample) bool {
+	if r.timestamps != nil && !slices.Contains(r.timestamps, sample.ts) {
+		return false
+	}
+	return !r.byValue || sample.value >= r.minValue && sample.value <= r.maxValue
+}
+
+// bucketStart returns the start of the bucket holding ts.
+func (r *tsRange) bucketStart(ts int64) int64 {
+	offset := (ts - r.align) % r.bucket
+	if offset < 0 {
+		offset += r.bucket
+	}
+	return ts - offset
+}
+
+// tsAggregation accumulates the samples of one bucket.
+type tsAggregation struct {
+	start                      int64
+	count                      int64
+	sum, min, max, first, last float64
+	// mean and squares are the running mean and sum of squared differences
+	// from it, for the variance
+	mean, squares float64
+}
+
+// add adds a value to the bucket.
+func (a *tsAggregation) add(value float64) {
+	if a.count == 0 {
+		a.min, a.max, a.first = value, value, value
+	}
+	a.count++
+	a.sum += value
+	a.min = min(a.min, value)
+	a.max = max(a.max, value)
+	a.last = value
+	delta := value - a.mean
+	a.mean += delta / float64(a.count)
+	a.squares += delta * (value - a.mean)
+}
+
+// value returns the aggregator of the bucket.
+func (a *tsAggregation) value(aggregator string) float64 {
+	switch aggregator {
+	case "avg":
+		return a.sum / float64(a.count)
+	case "sum":
+		return a.sum
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "range":
+		return a.max - a.min
+	case "count":
+		return float64(a.count)
+	case "first":
+		return a.first
+	case "last":
+		return a.last
+	case "var.p":
+		return a.squares / float64(a.count)
+	case "std.p":
+		return math.Sqrt(a.squares / float64(a.count))
+	}
+	if a.count == 1 {
+		return 0
+	}
+	if aggregator == "var.s" {
+		return a.squares / float64(a.count-1)
+	}
+	return math.Sqrt(a.squares / float64(a.count-1))
+}
+
+// samples returns the samples of s the range replies, oldest first unless
+// reverse is set.
+func (r *tsRange) samples(s *timeSeries, reverse bool) []tsSample {
+	selected := []tsSample{}
+	for _, sample := range s.between(r.from, r.to) {
+		if r.selected(sample) {
+			selected = append(selected, sample)
+		}
+	}
+
+	if r.aggregator != "" {
+		buckets := []tsSample{}
+		var current *tsAggregation
+		flush := func() {
+			if current != nil {
+				ts := current.start + r.bucketTimestamp*r.bucket/2
+				buckets = append(buckets, tsSample{ts: ts, value: current.value(r.aggregator)})
+			}
+		}
+		for _, sample := range selected {
+			if start := r.bucketStart(sample.ts); current == nil || start != current.start {
+				flush()
+				current = &tsAggregation{start: start}
+			}
+			current.add(sample.value)
+		}
+		flush()
+		selected = buckets
+	}
+
+	if reverse {
+		slices.Reverse(selected)
+	}
+	if r.count > 0 && r.count < len(selected) {
+		selected = selected[:r.count]
+	}
+	return selected
+}
+
+// tsRangeCommand handles TS.RANGE and, with reverse set, TS.REVRANGE.
+func tsRangeCommand(reverse bool) func(args []Value) Value {
+	return func(args []Value) Value {
+		r, errReply, ok := parseTSRange(args[1:])
+		if !ok {
+			return errReply
+		}
+
+		keyspaceMu.RLock()
+		defer keyspaceMu.RUnlock()
+
+		s, errReply, ok := readTimeSeries(args[0].bulk)
+		if !ok {
+			return errReply
+		}
+		if s == nil {
+			return tsNoKeyError
+		}
+		reply := Value{typ: "array", array: []Value{}}
+		for _, sample := range r.samples(s, reverse) {
+			reply.array = append(reply.array, tsSampleValue(sample))
+		}
+		return reply
+	}
+}
+
+// tsDel handles TS.DEL key from to, replying the number of samples
+// deleted.
+func tsDel(args []Value) Value {
+	from, ok := parseTSRangeBound(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR TSDB: wrong fromTimestamp"}
+	}
+	to, ok := parseTSRangeBound(args[2].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR TSDB: wrong toTimestamp"}
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	s, errReply, ok := readTimeSeries(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if s == nil {
+		return tsNoKeyError
+	}
+	if from > to {
+		return Value{typ: "integer", num: 0}
+	}
+	i, j := s.search(from), s.searchAfter(to)
+	s.samples = slices.Delete(s.samples, i, j)
+	if j > i {
+		markKeyspaceChanged()
+	}
+	return Value{typ: "integer", num: j - i}
+}
+
+// tsInfo handles TS.INFO key.
+func tsInfo(args []Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	s, errReply, ok := readTimeSeries(args[0].bulk)
+	if !ok {
+		return errReply
+	}
+	if s == nil {
+		return tsNoKeyError
+	}
+	first, last := 0, 0
+	if n := len(s.samples); n > 0 {
+		first, last = int(s.samples[0].ts), int(s.samples[n-1].ts)
+	}
+	policy := Value{typ: "null"}
+	if s.duplicatePolicy != "" {
+		policy = Value{typ: "bulk", bulk: strings.ToLower(s.duplicatePolicy)}
+	}
+	labels := Value{typ: "array", array: []Value{}}
+	for _, l := range s.labels {
+		labels.array = append(labels.array, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: l.name}, {typ: "bulk", bulk: l.value},
+		}})
+	}
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "totalSamples"}, {typ: "integer", num: len(s.samples)},
+		{typ: "bulk", bulk: "memoryUsage"}, {typ: "integer", num: s.size()},
+		{typ: "bulk", bulk: "firstTimestamp"}, {typ: "integer", num: first},
+		{typ: "bulk", bulk: "lastTimestamp"}, {typ: "integer", num: last},
+		{typ: "bulk", bulk: "retentionTime"}, {typ: "integer", num: int(s.retention)},
+		{typ: "bulk", bulk: "duplicatePolicy"}, policy,
+		{typ: "bulk", bulk: "labels"}, labels,
+		{typ: "bulk", bulk: "sourceKey"}, {typ: "null"},
+		{typ: "bulk", bulk: "rules"}, {typ: "array", array: []Value{}},
+	}}
+}
+
+// tsFilter is a filter of TS.QUERYINDEX.
+type tsFilter struct {
+	label string
+	// negated is set for != filters
+	negated bool
+	// values are the values the label is compared to, none for a filter
+	// on whether the series has the label
+	values []string
+}
+
+// parseTSFilter parses a TS.QUERYINDEX filter.
+func parseTSFilter(arg string) (tsFilter, bool) {
+	i := strings.Index(arg, "=")
+	if i <= 0 {
+		return tsFilter{}, false
+	}
+	f := tsFilter{label: arg[:i]}
+	if strings.HasSuffix(f.label, "!") {
+		f.label, f.negated = f.label[:len(f.label)-1], true
+	}
+	value := arg[i+1:]
+	if f.label == "" {
+		return tsFilter{}, false
+	}
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		for _, v := range strings.Split(value[1:len(value)-1], ",") {
+			if v != "" {
+				f.values = append(f.values, v)
+			}
+		}
+	} else if value != "" {
+		f.values = []string{value}
+	}
+	return f, true
+}
+
+// matches reports whether the series passes the filter.
+func (f tsFilter) matches(s *timeSeries) bool {
+	value, ok := s.label(f.label)
+	if len(f.values) == 0 {
+		// label= selects the series without the label, label!= the others
+		return ok == f.negated
+	}
+	return (ok && slices.Contains(f.values, value)) != f.negated
+}
+
+// tsQueryIndex handles TS.QUERYINDEX filter [filter ...], replying the
+// keys of the series passing every filter, sorted.
+func tsQueryIndex(args []Value) Value {
+	filters := []tsFilter{}
+	selecting := false
+	for _, arg := range args {
+		f, ok := parseTSFilter(arg.bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR TSDB: failed parsing labels"}
+		}
+		if !f.negated && len(f.values) > 0 {
+			selecting = true
+		}
+		filters = append(filters, f)
+	}
+	if !selecting {
+		return Value{typ: "error", str: "ERR TSDB: please provide at least one matcher"}
+	}
+
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	keys := []string{}
+	for key, obj := range keyspace {
+		if obj.typ != timeSeriesObject || expired(key) {
+			continue
+		}
+		matched := true
+		for _, f := range filters {
+			if !f.matches(obj.timeSeries()) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	reply := Value{typ: "array", array: make([]Value, 0, len(keys))}
+	for _, key := range keys {
+		reply.array = append(reply.array, Value{typ: "bulk", bulk: key})
+	}
+	return reply
+}
+
+// timeSeriesCommands returns the commands that rebuild the series at key:
+// TS.CREATE with its options and TS.MADD with its samples, a batch at a
+// time.
+func timeSeriesCommands(key string, s *timeSeries) []Value {
+	create := []string{"TS.CREATE", key, "RETENTION", strconv.FormatInt(s.retention, 10)}
+	if s.duplicatePolicy != "" {
+		create = append(create, "DUPLICATE_POLICY", s.duplicatePolicy)
+	}
+	if len(s.labels) > 0 {
+		create = append(create, "LABELS")
+		for _, l := range s.labels {
+			create = append(create, l.name, l.value)
+		}
+	}
+	commands := []Value{commandValue(create...)}
+	for i := 0; i < len(s.samples); i += 100 {
+		madd := []string{"TS.MADD"}
+		for _, sample := range s.samples[i:min(i+100, len(s.samples))] {
+			madd = append(madd, key, strconv.FormatInt(sample.ts, 10), formatScore(sample.value))
+		}
+		commands = append(commands, commandValue(madd...))
+	}
+	return commands
+}
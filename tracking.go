@@ -0,0 +1,612 @@
+// Client-side caching.
+//
+// A client may keep the values it read in a local cache and have the server
+// tell it when they change, like with the client-side caching of Redis:
+//
+//	HELLO [protover [AUTH username password] [SETNAME clientname]]
+//	CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+//	CLIENT CACHING YES|NO
+//	CLIENT GETREDIR
+//	CLIENT TRACKINGINFO
+//
+// HELLO 3 switches the connection to RESP3, where the server may send push
+// messages in between the replies. Once tracking is on the client is sent
+// an invalidate push message naming the keys that were written, deleted or
+// expired, or a null instead of the keys when the dataset was flushed.
+//
+// In the default mode the server remembers the keys every client read and
+// sends an invalidation once per key read, the next time the key changes;
+// the client has to read the key again to be told again. With OPTIN only
+// the keys read by the command right after CLIENT CACHING YES are
+// remembered, with OPTOUT every key but those read right after CLIENT
+// CACHING NO. In BCAST mode nothing is remembered: the client is told about
+// every key changed that starts with one of its prefixes, about every key
+// without PREFIX. NOLOOP leaves out the keys the client changed itself.
+//
+// A RESP2 connection can not take push messages. It may REDIRECT its
+// invalidations to another connection instead, which gets them as pub/sub
+// messages once it subscribed to __redis__:invalidate, or as push messages
+// when it speaks RESP3.
+//
+// Invalidations are queued like pub/sub messages, so one may reach the
+// client before the reply of a read that raced with the write, which is
+// what clients of Redis already expect with REDIRECT: a value read while an
+// invalidation of its key arrived is not cached. Like in Redis, the keys
+// remembered for a client are only dropped once they change, even when
+// the client turned tracking off or disconnected meanwhile.
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// trackingChannel is the pub/sub channel invalidations are sent on to a
+// RESP2 client that tracking clients redirect to.
+const trackingChannel = "__redis__:invalidate"
+
+// tracking holds the CLIENT TRACKING options of a client.
+type tracking struct {
+	// redirect is the id of the client the invalidations are sent to, 0
+	// to send them to the tracking client itself
+	redirect int64
+	// broken is set once the client redirected to disconnected
+	broken bool
+	bcast  bool
+	optin  bool
+	optout bool
+	noloop bool
+	// prefixes are the BCAST prefixes, the empty prefix for every key
+	prefixes []string
+	// caching is the CLIENT CACHING answer, "yes" or "no", that applies
+	// to the next command only
+	caching string
+}
+
+// trackedKeys maps every key read by a client in the default tracking mode
+// to the ids of the clients that read it.
+var trackedKeys = map[string]map[int64]bool{}
+
+// trackingPrefixes maps every BCAST prefix to the ids of the clients that
+// registered it.
+var trackingPrefixes = map[string]map[int64]bool{}
+
+// trackingClients counts the clients with tracking on, so that commands
+// skip the bookkeeping while nobody tracks keys.
+var trackingClients atomic.Int64
+
+// trackingMu guards trackedKeys, trackingPrefixes and the tracking options
+// of every client. It may be taken with keyspaceMu and searchMu held, and
+// is taken before ClientsMu and pubsubMu.
+var trackingMu = sync.Mutex{}
+
+// protocol returns the protocol version the client speaks, 2 or 3.
+func (c *Client) protocol() int {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	return c.resp
+}
+
+// mapReply returns the alternating fields and values of pairs as a map to
+// a RESP3 client and as a flat array to a RESP2 client.
+func mapReply(c *Client, pairs []Value) Value {
+	if c.protocol() == 3 {
+		return Value{typ: "map", array: pairs}
+	}
+	return Value{typ: "array", array: pairs}
+}
+
+// hello handles HELLO [protover [AUTH username password] [SETNAME
+// clientname]], switching the connection to protover and describing the
+// server. With AUTH it authenticates the client too, the only way HELLO
+// may be called before authenticating.
+func hello(c *Client, args []Value) Value {
+	proto := c.protocol()
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0].bulk)
+		if err != nil {
+			return Value{typ: "error", str: "ERR Protocol version is not an integer or out of range"}
+		}
+		if n != 2 && n != 3 {
+			return Value{typ: "error", str: "NOPROTO unsupported protocol version"}
+		}
+		proto = n
+	}
+
+	authenticated, name, setname := false, "", false
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return Value{typ: "error", str: "ERR Syntax error in HELLO option '" + args[i].bulk + "'"}
+			}
+			// the default user takes any password while requirepass is
+			// not set, like in Redis
+			pass := getRequirepass()
+			if args[i+1].bulk != "default" || pass != "" && !passwordMatches(args[i+2].bulk, pass) {
+				return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
+			}
+			authenticated = true
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				return Value{typ: "error", str: "ERR Syntax error in HELLO option '" + args[i].bulk + "'"}
+			}
+			if !validClientField(args[i+1].bulk) {
+				return Value{typ: "error", str: "ERR Client names cannot contain spaces, newlines or special characters."}
+			}
+			name, setname = args[i+1].bulk, true
+			i++
+		default:
+			return Value{typ: "error", str: "ERR Syntax error in HELLO option '" + args[i].bulk + "'"}
+		}
+	}
+	if !authenticated && !c.authorized() {
+		return Value{typ: "error", str: "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"}
+	}
+	if authenticated {
+		c.authenticated = true
+	}
+
+	c.infoMu.Lock()
+	c.resp = proto
+	if setname {
+		c.name = name
+	}
+	c.infoMu.Unlock()
+
+	return mapReply(c, []Value{
+		{typ: "bulk", bulk: "server"}, {typ: "bulk", bulk: "redis"},
+		{typ: "bulk", bulk: "version"}, {typ: "bulk", bulk: getCompatVersion()},
+		{typ: "bulk", bulk: "proto"}, {typ: "integer", num: proto},
+		{typ: "bulk", bulk: "id"}, {typ: "integer", num: int(c.id)},
+		{typ: "bulk", bulk: "mode"}, {typ: "bulk", bulk: "standalone"},
+		{typ: "bulk", bulk: "role"}, {typ: "bulk", bulk: "master"},
+		{typ: "bulk", bulk: "modules"}, {typ: "array"},
+	})
+}
+
+// clientTracking implements CLIENT TRACKING ON|OFF [REDIRECT client-id]
+// [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP].
+// Turning tracking on again keeps the mode and adds the prefixes given.
+func clientTracking(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|tracking' command"}
+	}
+
+	var on bool
+	switch strings.ToUpper(args[0].bulk) {
+	case "ON":
+		on = true
+	case "OFF":
+		on = false
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	t := &tracking{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "REDIRECT":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			if t.redirect != 0 {
+				return Value{typ: "error", str: "ERR A client can only redirect to a single other client"}
+			}
+			id, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			t.redirect = id
+			i++
+		case "PREFIX":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			t.prefixes = append(t.prefixes, args[i+1].bulk)
+			i++
+		case "BCAST":
+			t.bcast = true
+		case "OPTIN":
+			t.optin = true
+		case "OPTOUT":
+			t.optout = true
+		case "NOLOOP":
+			t.noloop = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	if !on {
+		c.stopTracking()
+		return Value{typ: "string", str: "OK"}
+	}
+
+	if t.redirect != 0 && clientByID(t.redirect) == nil {
+		return Value{typ: "error", str: "ERR The client ID you want redirect to does not exist"}
+	}
+	if len(t.prefixes) > 0 && !t.bcast {
+		return Value{typ: "error", str: "ERR PREFIX option requires BCAST mode to be enabled"}
+	}
+	if t.bcast && (t.optin || t.optout) {
+		return Value{typ: "error", str: "ERR OPTIN and OPTOUT are not compatible with BCAST"}
+	}
+	if t.optin && t.optout {
+		return Value{typ: "error", str: "ERR You can't use both OPTIN and OPTOUT"}
+	}
+
+	old := c.tracking
+	if old != nil {
+		if old.bcast != t.bcast {
+			return Value{typ: "error", str: "ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."}
+		}
+		if old.optin != t.optin || old.optout != t.optout {
+			return Value{typ: "error", str: "ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode."}
+		}
+	}
+
+	// prefixes may not overlap, or a key would be invalidated twice. A
+	// prefix given again is only registered once
+	prefixes := []string{}
+	if old != nil {
+		prefixes = append(prefixes, old.prefixes...)
+	}
+	added := 0
+	for _, prefix := range t.prefixes {
+		known := false
+		for _, other := range prefixes {
+			if prefix == other {
+				known = true
+			} else if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				return Value{typ: "error", str: "ERR Prefix '" + prefix + "' overlaps with an existing prefix '" + other + "'. Prefixes for a single client must not overlap."}
+			}
+		}
+		if !known {
+			prefixes = append(prefixes, prefix)
+			added++
+		}
+	}
+	// BCAST without any prefix covers every key
+	if t.bcast && len(prefixes) == 0 {
+		prefixes = append(prefixes, "")
+		added++
+	}
+	for _, prefix := range prefixes[len(prefixes)-added:] {
+		if trackingPrefixes[prefix] == nil {
+			trackingPrefixes[prefix] = map[int64]bool{}
+		}
+		trackingPrefixes[prefix][c.id] = true
+	}
+	t.prefixes = prefixes
+
+	if old == nil {
+		trackingClients.Add(1)
+	}
+	c.tracking = t
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// stopTracking turns tracking off for c. It must be called with trackingMu
+// held.
+func (c *Client) stopTracking() {
+	t := c.tracking
+	if t == nil {
+		return
+	}
+
+	for _, prefix := range t.prefixes {
+		delete(trackingPrefixes[prefix], c.id)
+		if len(trackingPrefixes[prefix]) == 0 {
+			delete(trackingPrefixes, prefix)
+		}
+	}
+	c.tracking = nil
+	trackingClients.Add(-1)
+}
+
+// unregisterTracking turns tracking off for c when it disconnects.
+func unregisterTracking(c *Client) {
+	trackingMu.Lock()
+	c.stopTracking()
+	trackingMu.Unlock()
+}
+
+// clientCaching implements CLIENT CACHING YES|NO, choosing whether the keys
+// read by the next command are remembered in the OPTIN and OPTOUT modes.
+func clientCaching(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|caching' command"}
+	}
+
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	t := c.tracking
+	if t == nil || !t.optin && !t.optout {
+		return Value{typ: "error", str: "ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled"}
+	}
+	switch strings.ToUpper(args[0].bulk) {
+	case "YES":
+		if !t.optin {
+			return Value{typ: "error", str: "ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode."}
+		}
+		t.caching = "yes"
+	case "NO":
+		if !t.optout {
+			return Value{typ: "error", str: "ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode."}
+		}
+		t.caching = "no"
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// clientGetRedir implements CLIENT GETREDIR, returning the id of the client
+// the invalidations are redirected to, 0 when they are not redirected and
+// -1 when tracking is off.
+func clientGetRedir(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|getredir' command"}
+	}
+
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	if c.tracking == nil {
+		return Value{typ: "integer", num: -1}
+	}
+	return Value{typ: "integer", num: int(c.tracking.redirect)}
+}
+
+// clientTrackingInfo implements CLIENT TRACKINGINFO, describing the
+// tracking flags, redirect and prefixes of the client.
+func clientTrackingInfo(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'client|trackinginfo' command"}
+	}
+
+	trackingMu.Lock()
+	t := c.tracking
+	flags := []Value{}
+	redirect := -1
+	prefixes := []Value{}
+	if t == nil {
+		flags = append(flags, Value{typ: "bulk", bulk: "off"})
+	} else {
+		flags = append(flags, Value{typ: "bulk", bulk: "on"})
+		for _, flag := range []struct {
+			set  bool
+			name string
+		}{
+			{t.bcast, "bcast"},
+			{t.optin, "optin"},
+			{t.optout, "optout"},
+			{t.caching == "yes", "caching-yes"},
+			{t.caching == "no", "caching-no"},
+			{t.noloop, "noloop"},
+			{t.broken, "broken_redirect"},
+		} {
+			if flag.set {
+				flags = append(flags, Value{typ: "bulk", bulk: flag.name})
+			}
+		}
+		redirect = int(t.redirect)
+		for _, prefix := range t.prefixes {
+			prefixes = append(prefixes, Value{typ: "bulk", bulk: prefix})
+		}
+	}
+	trackingMu.Unlock()
+
+	return mapReply(c, []Value{
+		{typ: "bulk", bulk: "flags"}, {typ: "array", array: flags},
+		{typ: "bulk", bulk: "redirect"}, {typ: "integer", num: redirect},
+		{typ: "bulk", bulk: "prefixes"}, {typ: "array", array: prefixes},
+	})
+}
+
+// trackReads remembers the keys the command is about to read when c tracks
+// keys in the default mode, and uses up the CLIENT CACHING answer given for
+// it. The keys are remembered before they are read, so that a write racing
+// with the read is never missed.
+func trackReads(c *Client, command string, args []Value) {
+	if trackingClients.Load() == 0 {
+		return
+	}
+
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	t := c.tracking
+	if t == nil {
+		return
+	}
+	// CLIENT CACHING applies to the command that follows it
+	if command == "CLIENT" && len(args) > 0 && strings.EqualFold(args[0].bulk, "CACHING") {
+		return
+	}
+	caching := t.caching
+	t.caching = ""
+
+	if t.bcast || isWriteCommand(command) || t.optin && caching != "yes" || t.optout && caching == "no" {
+		return
+	}
+	for _, key := range commandKeys(command, args) {
+		if trackedKeys[key] == nil {
+			trackedKeys[key] = map[int64]bool{}
+		}
+		trackedKeys[key][c.id] = true
+	}
+}
+
+// invalidateKeys tells the clients caching keys that they changed. origin
+// is the id of the client that changed them, which is left out when it
+// asked for NOLOOP, or 0 when they expired.
+func invalidateKeys(keys []string, origin int64) {
+	if trackingClients.Load() == 0 || len(keys) == 0 {
+		return
+	}
+
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	// gather the keys of every client, so that each gets a single message
+	pending := map[int64][]string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		ids := trackedKeys[key]
+		delete(trackedKeys, key)
+		for prefix, clients := range trackingPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				if ids == nil {
+					ids = map[int64]bool{}
+				}
+				for id := range clients {
+					ids[id] = true
+				}
+			}
+		}
+		for id := range ids {
+			pending[id] = append(pending[id], key)
+		}
+	}
+
+	for id, keys := range pending {
+		c := clientByID(id)
+		if c == nil || c.tracking == nil || id == origin && c.tracking.noloop {
+			continue
+		}
+		c.invalidate(keys)
+	}
+}
+
+// trackingFlush tells every tracking client that all of its keys changed,
+// once the dataset was emptied, and forgets the keys remembered.
+func trackingFlush() {
+	ClientsMu.RLock()
+	clients := make([]*Client, 0, len(Clients))
+	for _, c := range Clients {
+		clients = append(clients, c)
+	}
+	ClientsMu.RUnlock()
+
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	trackedKeys = map[string]map[int64]bool{}
+	for _, c := range clients {
+		if c.tracking != nil {
+			c.invalidate(nil)
+		}
+	}
+}
+
+// invalidate sends the invalidation of keys, of every key when keys is nil,
+// to c or to the client it redirects to. It must be called with trackingMu
+// held.
+func (c *Client) invalidate(keys []string) {
+	t := c.tracking
+	target := c
+	if t.redirect != 0 {
+		if target = clientByID(t.redirect); target == nil {
+			// the invalidations are lost from now on, which a RESP3
+			// client is told once
+			if !t.broken {
+				t.broken = true
+				c.sendPush(Value{typ: "array", array: []Value{
+					{typ: "bulk", bulk: "tracking-redir-broken"},
+					{typ: "integer", num: int(t.redirect)},
+				}})
+			}
+			return
+		}
+	}
+
+	payload := Value{typ: "null"}
+	if keys != nil {
+		payload = Value{typ: "array"}
+		for _, key := range keys {
+			payload.array = append(payload.array, Value{typ: "bulk", bulk: key})
+		}
+	}
+
+	if target.sendPush(Value{typ: "array", array: []Value{{typ: "bulk", bulk: "invalidate"}, payload}}) {
+		return
+	}
+	// a RESP2 client only gets them once subscribed to trackingChannel
+	pubsubMu.RLock()
+	if target.subscriptions[trackingChannel] {
+		target.pushMessage(Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "message"},
+			{typ: "bulk", bulk: trackingChannel},
+			payload,
+		}})
+	}
+	pubsubMu.RUnlock()
+}
+
+// sendPush queues v as a push message for c when c speaks RESP3, and
+// reports whether it did.
+func (c *Client) sendPush(v Value) bool {
+	if c.protocol() != 3 {
+		return false
+	}
+
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	if !c.startMessages() {
+		return false
+	}
+	c.pushMessage(v)
+	return true
+}
+
+// trackingArgs returns the CLIENT TRACKING arguments that turn tracking on
+// with the options of c, or none when tracking is off.
+func (c *Client) trackingArgs() []string {
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	t := c.tracking
+	if t == nil {
+		return nil
+	}
+	args := []string{"ON"}
+	if t.redirect != 0 {
+		args = append(args, "REDIRECT", strconv.FormatInt(t.redirect, 10))
+	}
+	if t.bcast {
+		args = append(args, "BCAST")
+	}
+	for _, prefix := range t.prefixes {
+		if prefix != "" {
+			args = append(args, "PREFIX", prefix)
+		}
+	}
+	if t.optin {
+		args = append(args, "OPTIN")
+	}
+	if t.optout {
+		args = append(args, "OPTOUT")
+	}
+	if t.noloop {
+		args = append(args, "NOLOOP")
+	}
+	return args
+}
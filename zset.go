@@ -0,0 +1,1236 @@
+// Sorted sets.
+//
+// A sorted set maps distinct members to floating point scores and keeps
+// them ordered by score, members with equal scores ordered bytewise, the
+// building block of leaderboards and schedulers:
+//
+//	ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+//	ZSCORE key member                 ZCARD key
+//	ZMSCORE key member [member ...]   ZCOUNT key min max
+//	ZINCRBY key increment member      ZREM key member [member ...]
+//	ZREMRANGEBYRANK key start stop    ZREMRANGEBYSCORE key min max
+//	ZRANK key member [WITHSCORE]      ZREVRANK key member [WITHSCORE]
+//	ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+//	ZRANGESTORE destination source start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+//	ZREVRANGE key start stop [WITHSCORES]
+//	ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+//	ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+//	ZRANGEBYLEX key min max [LIMIT offset count]
+//	ZREVRANGEBYLEX key max min [LIMIT offset count]
+//	ZLEXCOUNT key min max             ZREMRANGEBYLEX key min max
+//	ZSCAN key cursor [MATCH pattern] [COUNT count]
+//	ZRANDMEMBER key [count [WITHSCORES]]
+//	ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+//	ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+//	ZDIFF numkeys key [key ...] [WITHSCORES]
+//	ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+//	ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+//	ZDIFFSTORE destination numkeys key [key ...]
+//
+// NX only adds new members and XX only updates existing ones. GT and LT
+// only update a member when its new score is greater or less than the
+// current one, they do not stop new members from being added. ZADD replies
+// the number of members added, or added and changed with CH. With INCR it
+// behaves like ZINCRBY for a single pair and replies the new score, or null
+// when a flag prevented the update. ZINCRBY adds to the score of a member,
+// adding the member with the increment as its score when it is missing.
+//
+// Ranks count from 0 for the lowest score, or the highest in reverse
+// queries, and may be negative to count from the other end like list
+// indexes. Score ranges are inclusive, a bound prefixed with "(" is
+// exclusive, and -inf and +inf stand for the lowest and highest score.
+// Lexicographic ranges, for members sharing a score, take "[member" or
+// "(member" bounds and "-" and "+" for the first and last member. Adding
+// words with score 0 and querying ZRANGEBYLEX words [pre "(pre\xff" is
+// the usual autocomplete index. Reverse
+// queries by score or lex name the upper bound first.
+//
+// ZUNION and ZINTER multiply the scores of every input by its weight, 1 by
+// default, and combine the scores of a member found in several inputs by
+// their sum, minimum or maximum. ZDIFF keeps the members of the first input
+// found in none of the others with their scores. Sets are accepted as
+// inputs, their members scoring 1, and missing keys count as empty. A
+// sorted set is deleted once its last member is removed.
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// zsetEntry is a member of a sorted set and its score.
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+// zsetLess reports whether a sorts before b.
+func zsetLess(a, b zsetEntry) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.member < b.member
+}
+
+// zsetCompare orders entries for the binary searches of the slices package.
+func zsetCompare(a, b zsetEntry) int {
+	switch {
+	case zsetLess(a, b):
+		return -1
+	case zsetLess(b, a):
+		return 1
+	}
+	return 0
+}
+
+// zsetValue holds the members of a sorted set, in one of the two encodings
+// described in skiplist.go. The read methods treat a nil sorted set as
+// empty.
+type zsetValue struct {
+	// entries holds the members in ascending order while the sorted set
+	// is compact
+	entries []zsetEntry
+	// scores maps every member to its score and list orders them once the
+	// sorted set was converted, list being nil until then
+	scores map[string]float64
+	list   *skiplist
+}
+
+// newZsetValue builds a sorted set from its members and their scores.
+func newZsetValue(scores map[string]float64) *zsetValue {
+	z := &zsetValue{entries: make([]zsetEntry, 0, len(scores))}
+	long := false
+	for member, score := range scores {
+		z.entries = append(z.entries, zsetEntry{member: member, score: score})
+		long = long || int64(len(member)) > zsetMaxListpackValue.Load()
+	}
+	slices.SortFunc(z.entries, zsetCompare)
+	if long || int64(len(z.entries)) > zsetMaxListpackEntries.Load() {
+		z.convert()
+	}
+	return z
+}
+
+// convert moves the members of a compact sorted set to a skiplist and a
+// map.
+func (z *zsetValue) convert() {
+	z.scores = make(map[string]float64, len(z.entries))
+	z.list = newSkiplist()
+	for _, e := range z.entries {
+		z.scores[e.member] = e.score
+		z.list.insert(e)
+	}
+	z.entries = nil
+}
+
+// len returns the number of members.
+func (z *zsetValue) len() int {
+	switch {
+	case z == nil:
+		return 0
+	case z.list != nil:
+		return z.list.length
+	}
+	return len(z.entries)
+}
+
+// find returns the position of a member of a compact sorted set, or -1.
+func (z *zsetValue) find(member string) int {
+	return slices.IndexFunc(z.entries, func(e zsetEntry) bool {
+		return e.member == member
+	})
+}
+
+// score returns the score of a member.
+func (z *zsetValue) score(member string) (float64, bool) {
+	switch {
+	case z == nil:
+		return 0, false
+	case z.list != nil:
+		score, ok := z.scores[member]
+		return score, ok
+	}
+	if i := z.find(member); i >= 0 {
+		return z.entries[i].score, true
+	}
+	return 0, false
+}
+
+// set sets the score of a member, reporting whether the member is new. The
+// sorted set is converted first when the member would not fit the compact
+// encoding.
+func (z *zsetValue) set(member string, score float64) bool {
+	old, exists := z.score(member)
+	if exists {
+		if old == score {
+			return false
+		}
+		z.remove(member)
+	}
+	if z.list == nil && (int64(len(z.entries)) >= zsetMaxListpackEntries.Load() || int64(len(member)) > zsetMaxListpackValue.Load()) {
+		z.convert()
+	}
+
+	entry := zsetEntry{member: member, score: score}
+	if z.list != nil {
+		z.list.insert(entry)
+		z.scores[member] = score
+		return !exists
+	}
+	i, _ := slices.BinarySearchFunc(z.entries, entry, zsetCompare)
+	z.entries = slices.Insert(z.entries, i, entry)
+	return !exists
+}
+
+// remove removes a member, reporting whether it existed.
+func (z *zsetValue) remove(member string) bool {
+	if z == nil {
+		return false
+	}
+	if z.list == nil {
+		i := z.find(member)
+		if i < 0 {
+			return false
+		}
+		z.entries = slices.Delete(z.entries, i, i+1)
+		return true
+	}
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	z.list.remove(zsetEntry{member: member, score: score})
+	delete(z.scores, member)
+	return true
+}
+
+// rank returns the position of a member in ascending order.
+func (z *zsetValue) rank(member string) (int, bool) {
+	if z == nil {
+		return 0, false
+	}
+	if z.list == nil {
+		i := z.find(member)
+		return i, i >= 0
+	}
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+	entry := zsetEntry{member: member, score: score}
+	return z.list.firstIndex(func(e zsetEntry) bool { return !zsetLess(e, entry) }), true
+}
+
+// removeRange removes the entries from position start up to end,
+// exclusive.
+func (z *zsetValue) removeRange(start, end int) {
+	if z.list == nil {
+		z.entries = slices.Delete(z.entries, start, end)
+		return
+	}
+	for _, e := range z.list.removeRange(start, end) {
+		delete(z.scores, e.member)
+	}
+}
+
+// each calls fn with every member and its score in ascending order.
+func (z *zsetValue) each(fn func(member string, score float64)) {
+	switch {
+	case z == nil:
+	case z.list != nil:
+		for x := z.list.header.levels[0].forward; x != nil; x = x.levels[0].forward {
+			fn(x.entry.member, x.entry.score)
+		}
+	default:
+		for _, e := range z.entries {
+			fn(e.member, e.score)
+		}
+	}
+}
+
+// at returns the entry at a position, which must be below the length.
+func (z *zsetValue) at(position int) zsetEntry {
+	if z.list != nil {
+		return z.list.at(position).entry
+	}
+	return z.entries[position]
+}
+
+// slice returns a copy of the entries from position start up to end,
+// exclusive, which must be within the sorted set.
+func (z *zsetValue) slice(start, end int) []zsetEntry {
+	if start >= end {
+		return nil
+	}
+	if z.list == nil {
+		return slices.Clone(z.entries[start:end])
+	}
+	entries := make([]zsetEntry, 0, end-start)
+	for x := z.list.at(start); len(entries) < end-start; x = x.levels[0].forward {
+		entries = append(entries, x.entry)
+	}
+	return entries
+}
+
+// firstIndex returns the position of the first entry for which pred is
+// true, or the length when there is none. pred must be false for a prefix
+// of the entries and true for the rest.
+func (z *zsetValue) firstIndex(pred func(zsetEntry) bool) int {
+	switch {
+	case z == nil:
+		return 0
+	case z.list != nil:
+		return z.list.firstIndex(pred)
+	}
+	i, _ := slices.BinarySearchFunc(z.entries, pred, func(e zsetEntry, pred func(zsetEntry) bool) int {
+		if pred(e) {
+			return 1
+		}
+		return -1
+	})
+	return i
+}
+
+// encoding returns the name of the encoding as OBJECT ENCODING reports it.
+func (z *zsetValue) encoding() string {
+	if z.list != nil {
+		return "skiplist"
+	}
+	return "listpack"
+}
+
+// scoreRange is a range of scores with inclusive or exclusive bounds.
+type scoreRange struct {
+	min, max     float64
+	minEx, maxEx bool
+}
+
+// aboveMin reports whether score satisfies the lower bound.
+func (r scoreRange) aboveMin(score float64) bool {
+	if r.minEx {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+// belowMax reports whether score satisfies the upper bound.
+func (r scoreRange) belowMax(score float64) bool {
+	if r.maxEx {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// parseScoreBound parses a bound of a score range, "(" making it
+// exclusive.
+func parseScoreBound(arg string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseScore(arg)
+	return score, exclusive, ok
+}
+
+// parseScoreRange parses the min and max arguments of ZCOUNT and the range
+// commands.
+func parseScoreRange(min, max string) (scoreRange, Value, bool) {
+	var r scoreRange
+	var okMin, okMax bool
+	r.min, r.minEx, okMin = parseScoreBound(min)
+	r.max, r.maxEx, okMax = parseScoreBound(max)
+	if !okMin || !okMax {
+		return r, Value{typ: "error", str: "ERR min or max is not a float"}, false
+	}
+	return r, Value{}, true
+}
+
+// rangeIndexes returns the positions of the first entry within r and of
+// the first entry past it.
+func (z *zsetValue) rangeIndexes(r scoreRange) (int, int) {
+	start := z.firstIndex(func(e zsetEntry) bool { return r.aboveMin(e.score) })
+	end := z.firstIndex(func(e zsetEntry) bool { return !r.belowMax(e.score) })
+	return start, max(start, end)
+}
+
+// parseScore parses a score. Like Redis it accepts inf and -inf but not
+// NaN or numbers out of the float range.
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// formatScore renders a score the way Redis replies it: the shortest
+// representation that reads back as the same number, and inf or -inf.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// notFloatError is the reply to a score that is not a number.
+var notFloatError = Value{typ: "error", str: "ERR value is not a valid float"}
+
+// writableZset returns the members of the sorted set stored at key,
+// creating an empty one when the key does not exist, or false when the key
+// holds another type. A sorted set created this way must get a member
+// before keyspaceMu, which must be held for writing, is released.
+func writableZset(key string) (*zsetValue, bool) {
+	obj, ok := keyspace[key]
+	if ok {
+		return obj.zset(), obj.typ == zsetObject
+	}
+	obj = object{typ: zsetObject, value: &zsetValue{}}
+	setKey(key, obj)
+	markKeyspaceChanged()
+	return obj.zset(), true
+}
+
+// readZset calls read with the members of the sorted set stored at key,
+// nil when the key does not exist, while holding keyspaceMu for reading.
+// It replies WRONGTYPE instead when the key holds another type.
+func readZset(key string, read func(z *zsetValue) Value) Value {
+	keyspaceMu.RLock()
+	defer keyspaceMu.RUnlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != zsetObject {
+		return wrongTypeError
+	}
+	return read(obj.zset())
+}
+
+// zaddFlags are the options of ZADD.
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// parseZaddFlags parses the flags following the key of ZADD, returning
+// them and the position of the first score.
+func parseZaddFlags(args []Value) (zaddFlags, int) {
+	var flags zaddFlags
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			flags.ch = true
+		case "INCR":
+			flags.incr = true
+		default:
+			break options
+		}
+	}
+	return flags, i
+}
+
+// zadd handles ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score
+// member ...]. All scores are parsed before the sorted set is touched, so
+// a bad score changes nothing.
+func zadd(args []Value) Value {
+	key := args[0].bulk
+	flags, first := parseZaddFlags(args)
+	rest := args[first:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	if flags.nx && flags.xx {
+		return Value{typ: "error", str: "ERR XX and NX options at the same time are not compatible"}
+	}
+	if flags.gt && flags.lt || (flags.gt || flags.lt) && flags.nx {
+		return Value{typ: "error", str: "ERR GT, LT, and/or NX options at the same time are not compatible"}
+	}
+	if flags.incr && len(rest) > 2 {
+		return Value{typ: "error", str: "ERR INCR option supports a single increment-element pair"}
+	}
+	scores := make([]float64, 0, len(rest)/2)
+	for j := 0; j < len(rest); j += 2 {
+		score, ok := parseScore(rest[j].bulk)
+		if !ok {
+			return notFloatError
+		}
+		scores = append(scores, score)
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[key]
+	if exists && obj.typ != zsetObject {
+		return wrongTypeError
+	}
+	if !exists && flags.xx {
+		// nothing to update, and XX never creates the key
+		if flags.incr {
+			return Value{typ: "null"}
+		}
+		return Value{typ: "integer", num: 0}
+	}
+	z, _ := writableZset(key)
+
+	added, changed := 0, 0
+	for j, score := range scores {
+		member := rest[2*j+1].bulk
+		old, found := z.score(member)
+		if found && flags.nx || !found && flags.xx {
+			continue
+		}
+		if flags.incr {
+			if found {
+				score += old
+			}
+			if math.IsNaN(score) {
+				dropIfEmpty(key)
+				return Value{typ: "error", str: "ERR resulting score is not a number (NaN)"}
+			}
+		}
+		if found && (flags.gt && score <= old || flags.lt && score >= old) {
+			continue
+		}
+		if z.set(member, score) {
+			added++
+		} else if score != old {
+			changed++
+		}
+		if flags.incr {
+			return Value{typ: "bulk", bulk: formatScore(score)}
+		}
+	}
+	dropIfEmpty(key)
+
+	if flags.incr {
+		// NX, XX, GT or LT prevented the increment
+		return Value{typ: "null"}
+	}
+	if flags.ch {
+		return Value{typ: "integer", num: added + changed}
+	}
+	return Value{typ: "integer", num: added}
+}
+
+// zincrby handles ZINCRBY key increment member, replying the new score.
+// Like in Redis it is ZADD key INCR increment member.
+func zincrby(args []Value) Value {
+	return zadd([]Value{args[0], {typ: "bulk", bulk: "INCR"}, args[1], args[2]})
+}
+
+// zscore handles ZSCORE key member, replying the score of member or null.
+func zscore(args []Value) Value {
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		score, ok := z.score(args[1].bulk)
+		if !ok {
+			return Value{typ: "null"}
+		}
+		return Value{typ: "bulk", bulk: formatScore(score)}
+	})
+}
+
+// zmscore handles ZMSCORE key member [member ...], replying the score of
+// every member, null for those not in the sorted set.
+func zmscore(args []Value) Value {
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		scores := make([]Value, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if score, ok := z.score(arg.bulk); ok {
+				scores = append(scores, Value{typ: "bulk", bulk: formatScore(score)})
+			} else {
+				scores = append(scores, Value{typ: "null"})
+			}
+		}
+		return Value{typ: "array", array: scores}
+	})
+}
+
+// zrankCommand returns the handler of ZRANK, or ZREVRANK when rev is set,
+// key member [WITHSCORE]. It replies the rank of member, with WITHSCORE
+// followed by its score, or null when member is not in the sorted set.
+func zrankCommand(rev bool) func([]Value) Value {
+	return func(args []Value) Value {
+		withScore := false
+		switch {
+		case len(args) == 3 && strings.EqualFold(args[2].bulk, "WITHSCORE"):
+			withScore = true
+		case len(args) > 2:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+
+		return readZset(args[0].bulk, func(z *zsetValue) Value {
+			rank, ok := z.rank(args[1].bulk)
+			if !ok {
+				if withScore {
+					return Value{typ: "nullarray"}
+				}
+				return Value{typ: "null"}
+			}
+			if rev {
+				rank = z.len() - 1 - rank
+			}
+			if !withScore {
+				return Value{typ: "integer", num: rank}
+			}
+			score, _ := z.score(args[1].bulk)
+			return Value{typ: "array", array: []Value{{typ: "integer", num: rank}, {typ: "bulk", bulk: formatScore(score)}}}
+		})
+	}
+}
+
+// zcard handles ZCARD key, replying the number of members, 0 for a missing
+// sorted set.
+func zcard(args []Value) Value {
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		return Value{typ: "integer", num: z.len()}
+	})
+}
+
+// zcount handles ZCOUNT key min max, replying the number of members with a
+// score within the range.
+func zcount(args []Value) Value {
+	r, errReply, ok := parseScoreRange(args[1].bulk, args[2].bulk)
+	if !ok {
+		return errReply
+	}
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		start, end := z.rangeIndexes(r)
+		return Value{typ: "integer", num: end - start}
+	})
+}
+
+// zrangeBy is what the bounds of a range query are.
+type zrangeBy int
+
+const (
+	byRank zrangeBy = iota
+	byScore
+	byLex
+)
+
+// lexBound is a bound of a lexicographic range: a member, inclusive or
+// exclusive, or "-" and "+", which sort before and after every member.
+type lexBound struct {
+	member    string
+	exclusive bool
+	// inf is -1 for "-" and 1 for "+"
+	inf int
+}
+
+// lexRange is a range of members compared bytewise.
+type lexRange struct {
+	min, max lexBound
+}
+
+// aboveMin reports whether member satisfies the lower bound.
+func (r lexRange) aboveMin(member string) bool {
+	switch {
+	case r.min.inf != 0:
+		return r.min.inf < 0
+	case r.min.exclusive:
+		return member > r.min.member
+	}
+	return member >= r.min.member
+}
+
+// belowMax reports whether member satisfies the upper bound.
+func (r lexRange) belowMax(member string) bool {
+	switch {
+	case r.max.inf != 0:
+		return r.max.inf > 0
+	case r.max.exclusive:
+		return member < r.max.member
+	}
+	return member <= r.max.member
+}
+
+// parseLexBound parses "[member", "(member", "-" or "+".
+func parseLexBound(arg string) (lexBound, bool) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, true
+	case arg == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(arg, "["):
+		return lexBound{member: arg[1:]}, true
+	case strings.HasPrefix(arg, "("):
+		return lexBound{member: arg[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// parseLexRange parses the min and max arguments of a lexicographic range.
+func parseLexRange(min, max string) (lexRange, Value, bool) {
+	var r lexRange
+	var okMin, okMax bool
+	r.min, okMin = parseLexBound(min)
+	r.max, okMax = parseLexBound(max)
+	if !okMin || !okMax {
+		return r, Value{typ: "error", str: "ERR min or max not valid string range item"}, false
+	}
+	return r, Value{}, true
+}
+
+// lexIndexes returns the positions of the first entry within r and of the
+// first entry past it. Like in Redis the result is only meaningful when
+// all members have the same score, which is how lexicographic indexes are
+// built.
+func (z *zsetValue) lexIndexes(r lexRange) (int, int) {
+	start := z.firstIndex(func(e zsetEntry) bool { return r.aboveMin(e.member) })
+	end := z.firstIndex(func(e zsetEntry) bool { return !r.belowMax(e.member) })
+	return start, max(start, end)
+}
+
+// zrangeQuery is a parsed range query.
+type zrangeQuery struct {
+	by zrangeBy
+	// rev lists the members from the highest down
+	rev bool
+	// start and stop are the ranks of a query by rank
+	start, stop int
+	scores      scoreRange
+	lex         lexRange
+	// offset and count are the LIMIT of a query by score or lex, count
+	// is negative without a limit
+	offset, count int
+	withScores    bool
+}
+
+// parseZrange parses the bounds and options of a range query, args
+// starting with the two bounds. q holds the kind and direction of the
+// query. unified selects the options of ZRANGE and ZRANGESTORE, which
+// change them with BYSCORE, BYLEX and REV, and store those of ZRANGESTORE,
+// which does not take WITHSCORES.
+func parseZrange(args []Value, q zrangeQuery, unified, store bool) (zrangeQuery, Value, bool) {
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	notInteger := Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	q.count = -1
+	limited := false
+
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].bulk); {
+		case option == "WITHSCORES" && !store && (unified || q.by != byLex):
+			q.withScores = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil {
+				return q, notInteger, false
+			}
+			count, err := strconv.Atoi(args[i+2].bulk)
+			if err != nil {
+				return q, notInteger, false
+			}
+			q.offset, q.count, limited = offset, count, true
+			i += 2
+		case option == "BYSCORE" && unified:
+			q.by = byScore
+		case option == "BYLEX" && unified:
+			q.by = byLex
+		case option == "REV" && unified:
+			q.rev = true
+		default:
+			return q, syntaxError, false
+		}
+	}
+	if limited && q.by == byRank {
+		return q, Value{typ: "error", str: "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"}, false
+	}
+	if q.withScores && q.by == byLex {
+		return q, Value{typ: "error", str: "ERR syntax error, WITHSCORES not supported in combination with BYLEX"}, false
+	}
+
+	// reversed queries by score or lex name the upper bound first
+	min, max := args[0].bulk, args[1].bulk
+	if q.rev && q.by != byRank {
+		min, max = max, min
+	}
+	var errReply Value
+	ok := true
+	switch q.by {
+	case byRank:
+		var err1, err2 error
+		q.start, err1 = strconv.Atoi(min)
+		q.stop, err2 = strconv.Atoi(max)
+		if err1 != nil || err2 != nil {
+			return q, notInteger, false
+		}
+	case byScore:
+		q.scores, errReply, ok = parseScoreRange(min, max)
+	case byLex:
+		q.lex, errReply, ok = parseLexRange(min, max)
+	}
+	return q, errReply, ok
+}
+
+// queryIndexes returns the positions of the first entry within the bounds
+// of a range query and of the first entry past them, ignoring its LIMIT.
+func (z *zsetValue) queryIndexes(q zrangeQuery) (int, int) {
+	switch q.by {
+	case byScore:
+		return z.rangeIndexes(q.scores)
+	case byLex:
+		return z.lexIndexes(q.lex)
+	}
+	n := z.len()
+	first, last, ok := listRange(q.start, q.stop, n)
+	if !ok {
+		return 0, 0
+	}
+	// ranks of a reversed query count from the highest member
+	if q.rev {
+		first, last = n-1-last, n-1-first
+	}
+	return first, last + 1
+}
+
+// selectRange returns the entries a range query selects, in the order it
+// lists them.
+func (z *zsetValue) selectRange(q zrangeQuery) []zsetEntry {
+	start, end := z.queryIndexes(q)
+	if start >= end {
+		return nil
+	}
+	selected := z.slice(start, end)
+	if q.rev {
+		slices.Reverse(selected)
+	}
+
+	if q.by != byRank {
+		if q.offset < 0 || q.offset >= len(selected) {
+			return nil
+		}
+		selected = selected[q.offset:]
+		if q.count >= 0 && q.count < len(selected) {
+			selected = selected[:q.count]
+		}
+	}
+	return selected
+}
+
+// entriesReply lists members, each followed by its score with withScores.
+func entriesReply(entries []zsetEntry, withScores bool) Value {
+	reply := []Value{}
+	for _, e := range entries {
+		reply = append(reply, Value{typ: "bulk", bulk: e.member})
+		if withScores {
+			reply = append(reply, Value{typ: "bulk", bulk: formatScore(e.score)})
+		}
+	}
+	return Value{typ: "array", array: reply}
+}
+
+// zlexcount handles ZLEXCOUNT key min max, replying the number of members
+// within the lexicographic range.
+func zlexcount(args []Value) Value {
+	r, errReply, ok := parseLexRange(args[1].bulk, args[2].bulk)
+	if !ok {
+		return errReply
+	}
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		start, end := z.lexIndexes(r)
+		return Value{typ: "integer", num: end - start}
+	})
+}
+
+// zrangeCommand returns the handler of a range query of the given kind and
+// direction. unified selects ZRANGE key start stop [BYSCORE|BYLEX] [REV]
+// [LIMIT offset count] [WITHSCORES], the older commands take the bounds
+// they are named after followed by WITHSCORES and, for scores, LIMIT.
+func zrangeCommand(by zrangeBy, rev, unified bool) func([]Value) Value {
+	return func(args []Value) Value {
+		q, errReply, ok := parseZrange(args[1:], zrangeQuery{by: by, rev: rev}, unified, false)
+		if !ok {
+			return errReply
+		}
+		return readZset(args[0].bulk, func(z *zsetValue) Value {
+			return entriesReply(z.selectRange(q), q.withScores)
+		})
+	}
+}
+
+// zrangestore handles ZRANGESTORE destination source min max
+// [BYSCORE|BYLEX] [REV] [LIMIT offset count], storing the members ZRANGE
+// would list at destination and replying their number. An empty result
+// deletes destination.
+func zrangestore(args []Value) Value {
+	destination, source := args[0].bulk, args[1].bulk
+	q, errReply, ok := parseZrange(args[2:], zrangeQuery{}, true, true)
+	if !ok {
+		return errReply
+	}
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, exists := keyspace[source]
+	if exists && obj.typ != zsetObject {
+		return wrongTypeError
+	}
+	selected := obj.zset().selectRange(q)
+	deleteKey(destination)
+	if len(selected) > 0 {
+		z, _ := writableZset(destination)
+		for _, e := range selected {
+			z.set(e.member, e.score)
+		}
+	}
+	return Value{typ: "integer", num: len(selected)}
+}
+
+// zrem handles ZREM key member [member ...], replying how many of the
+// members were in the sorted set.
+func zrem(args []Value) Value {
+	key := args[0].bulk
+
+	keyspaceMu.Lock()
+	defer keyspaceMu.Unlock()
+
+	obj, ok := keyspace[key]
+	if ok && obj.typ != zsetObject {
+		return wrongTypeError
+	}
+	removed := 0
+	for _, arg := range args[1:] {
+		if obj.zset().remove(arg.bulk) {
+			removed++
+		}
+	}
+	dropIfEmpty(key)
+	return Value{typ: "integer", num: removed}
+}
+
+// zremrangeCommand returns the handler of ZREMRANGEBYRANK key start stop,
+// ZREMRANGEBYSCORE key min max or ZREMRANGEBYLEX key min max, removing the members within the range
+// and replying their number.
+func zremrangeCommand(by zrangeBy) func([]Value) Value {
+	return func(args []Value) Value {
+		key := args[0].bulk
+		q, errReply, ok := parseZrange(args[1:], zrangeQuery{by: by}, false, true)
+		if !ok {
+			return errReply
+		}
+
+		keyspaceMu.Lock()
+		defer keyspaceMu.Unlock()
+
+		obj, exists := keyspace[key]
+		if exists && obj.typ != zsetObject {
+			return wrongTypeError
+		}
+		if !exists {
+			return Value{typ: "integer", num: 0}
+		}
+		start, end := obj.zset().queryIndexes(q)
+		if start >= end {
+			return Value{typ: "integer", num: 0}
+		}
+		obj.zset().removeRange(start, end)
+		dropIfEmpty(key)
+		return Value{typ: "integer", num: end - start}
+	}
+}
+
+// zsetSource is an input of ZUNION, ZINTER and ZDIFF: a sorted set, or a
+// set whose members all score 1 like in Redis.
+type zsetSource struct {
+	z *zsetValue
+	s *setValue
+}
+
+// len returns the number of members.
+func (src zsetSource) len() int {
+	if src.s != nil {
+		return src.s.len()
+	}
+	return src.z.len()
+}
+
+// score returns the score of a member.
+func (src zsetSource) score(member string) (float64, bool) {
+	if src.s != nil {
+		return 1, src.s.has(member)
+	}
+	return src.z.score(member)
+}
+
+// each calls fn with every member and its score.
+func (src zsetSource) each(fn func(member string, score float64)) {
+	if src.s != nil {
+		src.s.each(func(member string) {
+			fn(member, 1)
+		})
+		return
+	}
+	src.z.each(fn)
+}
+
+// zsetAggregate is how ZUNION and ZINTER combine the scores of a member
+// found in several inputs.
+type zsetAggregate int
+
+const (
+	aggregateSum zsetAggregate = iota
+	aggregateMin
+	aggregateMax
+)
+
+// combine combines two scores. Like in Redis a sum of opposite infinities
+// is 0 rather than NaN.
+func (how zsetAggregate) combine(a, b float64) float64 {
+	switch how {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// weighted multiplies a score by a weight, 0 times an infinity being 0.
+func weighted(score, weight float64) float64 {
+	if product := score * weight; !math.IsNaN(product) {
+		return product
+	}
+	return 0
+}
+
+// zsetOpArgs are the parsed arguments of ZUNION, ZINTER, ZDIFF and their
+// STORE variants.
+type zsetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  zsetAggregate
+	withScores bool
+}
+
+// parseZsetOpArgs parses numkeys key [key ...] and the options following
+// the keys: WEIGHTS and AGGREGATE unless op is setDiff, WITHSCORES unless
+// store is set. name is the command name for errors.
+func parseZsetOpArgs(name string, args []Value, op setOperation, store bool) (zsetOpArgs, Value, bool) {
+	var parsed zsetOpArgs
+	syntaxError := Value{typ: "error", str: "ERR syntax error"}
+	numKeys, err := strconv.Atoi(args[0].bulk)
+	if err != nil {
+		return parsed, Value{typ: "error", str: "ERR value is not an integer or out of range"}, false
+	}
+	if numKeys < 1 {
+		return parsed, Value{typ: "error", str: "ERR at least 1 input key is needed for '" + name + "' command"}, false
+	}
+	if numKeys >= len(args) {
+		return parsed, syntaxError, false
+	}
+	for _, arg := range args[1 : numKeys+1] {
+		parsed.keys = append(parsed.keys, arg.bulk)
+		parsed.weights = append(parsed.weights, 1)
+	}
+
+	options := args[numKeys+1:]
+	for i := 0; i < len(options); i++ {
+		switch option := strings.ToUpper(options[i].bulk); {
+		case option == "WEIGHTS" && op != setDiff && i+numKeys < len(options):
+			for j := range parsed.weights {
+				weight, ok := parseScore(options[i+1+j].bulk)
+				if !ok {
+					return parsed, Value{typ: "error", str: "ERR weight value is not a float"}, false
+				}
+				parsed.weights[j] = weight
+			}
+			i += numKeys
+		case option == "AGGREGATE" && op != setDiff && i+1 < len(options):
+			switch strings.ToUpper(options[i+1].bulk) {
+			case "SUM":
+				parsed.aggregate = aggregateSum
+			case "MIN":
+				parsed.aggregate = aggregateMin
+			case "MAX":
+				parsed.aggregate = aggregateMax
+			default:
+				return parsed, syntaxError, false
+			}
+			i++
+		case option == "WITHSCORES" && !store:
+			parsed.withScores = true
+		default:
+			return parsed, syntaxError, false
+		}
+	}
+	return parsed, Value{}, true
+}
+
+// zsetSources returns the inputs stored at keys, empty for missing keys,
+// or false when one of the keys holds neither a sorted set nor a set.
+// keyspaceMu must be held.
+func zsetSources(keys []string) ([]zsetSource, bool) {
+	sources := make([]zsetSource, len(keys))
+	for i, key := range keys {
+		obj, ok := keyspace[key]
+		switch {
+		case !ok:
+		case obj.typ == zsetObject:
+			sources[i].z = obj.zset()
+		case obj.typ == setObject:
+			sources[i].s = obj.set()
+		default:
+			return nil, false
+		}
+	}
+	return sources, true
+}
+
+// combineZsets computes the union, intersection or difference of sorted
+// sets. Scores are weighted and aggregated for unions and intersections,
+// the difference keeps the scores of the first input.
+func combineZsets(op setOperation, sources []zsetSource, parsed zsetOpArgs) *zsetValue {
+	scores := map[string]float64{}
+	switch op {
+	case setUnion:
+		for i, src := range sources {
+			src.each(func(member string, score float64) {
+				score = weighted(score, parsed.weights[i])
+				if old, ok := scores[member]; ok {
+					score = parsed.aggregate.combine(old, score)
+				}
+				scores[member] = score
+			})
+		}
+	case setInter:
+		// walk the smallest input, the others are only looked up
+		smallest := 0
+		for i, src := range sources {
+			if src.len() < sources[smallest].len() {
+				smallest = i
+			}
+		}
+		sources[smallest].each(func(member string, _ float64) {
+			var total float64
+			for i, src := range sources {
+				score, ok := src.score(member)
+				if !ok {
+					return
+				}
+				score = weighted(score, parsed.weights[i])
+				if i == 0 {
+					total = score
+				} else {
+					total = parsed.aggregate.combine(total, score)
+				}
+			}
+			scores[member] = total
+		})
+	case setDiff:
+		sources[0].each(func(member string, score float64) {
+			for _, src := range sources[1:] {
+				if _, ok := src.score(member); ok {
+					return
+				}
+			}
+			scores[member] = score
+		})
+	}
+	return newZsetValue(scores)
+}
+
+// zsetOpCommand returns the handler of ZUNION, ZINTER or ZDIFF numkeys
+// key [key ...] followed by their options, which reply the members of the
+// result, or with store of ZUNIONSTORE, ZINTERSTORE or ZDIFFSTORE
+// destination numkeys key [key ...], which store it at destination and
+// reply its number of members. name is the command name for errors.
+func zsetOpCommand(name string, op setOperation, store bool) func([]Value) Value {
+	return func(args []Value) Value {
+		destination := ""
+		if store {
+			destination, args = args[0].bulk, args[1:]
+		}
+		parsed, errReply, ok := parseZsetOpArgs(name, args, op, store)
+		if !ok {
+			return errReply
+		}
+
+		if !store {
+			keyspaceMu.RLock()
+			defer keyspaceMu.RUnlock()
+		} else {
+			keyspaceMu.Lock()
+			defer keyspaceMu.Unlock()
+		}
+
+		sources, ok := zsetSources(parsed.keys)
+		if !ok {
+			return wrongTypeError
+		}
+		result := combineZsets(op, sources, parsed)
+		if !store {
+			return entriesReply(result.slice(0, result.len()), parsed.withScores)
+		}
+		// the destination may be one of the inputs, so it is only
+		// replaced once the result is computed
+		deleteKey(destination)
+		if result.len() > 0 {
+			setKey(destination, object{typ: zsetObject, value: result})
+			markKeyspaceChanged()
+		}
+		return Value{typ: "integer", num: result.len()}
+	}
+}
+
+// zrandmember handles ZRANDMEMBER key [count [WITHSCORES]]. Without a
+// count it replies one random member, or null for a missing key. A
+// positive count replies up to count distinct members, a negative one
+// exactly -count members that may repeat.
+func zrandmember(args []Value) Value {
+	if len(args) == 1 {
+		return readZset(args[0].bulk, func(z *zsetValue) Value {
+			if z.len() == 0 {
+				return Value{typ: "null"}
+			}
+			return Value{typ: "bulk", bulk: z.at(rand.IntN(z.len())).member}
+		})
+	}
+	count, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	withScores := false
+	switch {
+	case len(args) == 3 && strings.EqualFold(args[2].bulk, "WITHSCORES"):
+		withScores = true
+	case len(args) > 2:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	return readZset(args[0].bulk, func(z *zsetValue) Value {
+		n := z.len()
+		picked := []zsetEntry{}
+		switch {
+		case n == 0 || count == 0:
+		case count < 0:
+			for len(picked) < -count {
+				picked = append(picked, z.at(rand.IntN(n)))
+			}
+		case count >= n:
+			picked = z.slice(0, n)
+		default:
+			for _, i := range rand.Perm(n)[:count] {
+				picked = append(picked, z.at(i))
+			}
+		}
+		return entriesReply(picked, withScores)
+	})
+}